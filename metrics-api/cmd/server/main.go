@@ -10,15 +10,23 @@ import (
 	"time"
 
 	"metrics-api/internal/api"
+	"metrics-api/internal/api/middleware"
 	"metrics-api/internal/cache"
+	"metrics-api/internal/collector"
 	"metrics-api/internal/config"
 	"metrics-api/internal/prometheus"
 	"metrics-api/internal/service"
 	"metrics-api/pkg/logger"
+	"metrics-api/pkg/openapi"
 
+	promclient "github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
 	"golang.org/x/sync/errgroup"
 )
 
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
 func main() {
 	// Initialize logger
 	log := logger.NewLogger()
@@ -28,6 +36,14 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
+
+	// Rebuild the logger now that the logging configuration is known
+	log = logger.NewLogger(
+		logger.WithLevel(cfg.Logging.Level),
+		logger.WithOutputType(cfg.Logging.Format),
+		logger.WithCaller(cfg.Logging.Caller),
+		logger.WithStacktraceLevel(cfg.Logging.StacktraceLevel),
+	)
 	
 	// Create context that listens for termination signals
 	ctx, cancel := context.WithCancel(context.Background())
@@ -44,36 +60,202 @@ func main() {
 	}()
 	
 	// Initialize cache
+	cacheHooks := cache.PrometheusHooks(promclient.DefaultRegisterer)
 	cacheOptions := cache.Options{
 		DefaultExpiration: time.Duration(cfg.Cache.TTLSeconds) * time.Second,
 		CleanupInterval:   time.Duration(cfg.Cache.TTLSeconds/2) * time.Second,
 		MaxItems:          cfg.Cache.MaxSizeItems,
+		StatsEnabled:      true,
+		OnSet:             cacheHooks.OnSet,
+		OnEviction:        cacheHooks.OnEviction,
+		OnExpiry:          cacheHooks.OnExpiry,
+		OnDelete:          cacheHooks.OnDelete,
 	}
 	cacheInstance := cache.New(cacheOptions)
+	if path := cfg.Cache.PersistPath; path != "" {
+		if err := cacheInstance.LoadFromFile(path); err != nil && !os.IsNotExist(err) {
+			// Starting with a cold cache only costs extra queries
+			log.Warnf("Failed to load cache from %s: %v", path, err)
+		} else if err == nil {
+			log.Infof("Loaded %d cached items from %s", cacheInstance.Count(), path)
+		}
+	}
 	
 	// Initialize Prometheus client
-	promClient, err := prometheus.NewClient(
-		cfg.Prometheus.URL,
-		log,
-		cacheInstance,
-	)
+	userAgent := cfg.Prometheus.UserAgent
+	if userAgent == "" {
+		userAgent = prometheus.UserAgent(version)
+	}
+	// Headers were already validated by config.Load
+	promHeaders, err := cfg.Prometheus.ParseHeaders()
+	if err != nil {
+		log.Fatalf("Invalid Prometheus headers: %v", err)
+	}
+	retryStatusCodes, err := cfg.Prometheus.ParseRetryStatusCodes()
+	if err != nil {
+		log.Fatalf("Invalid Prometheus retry status codes: %v", err)
+	}
+	promAuth := prometheus.Auth{
+		BearerToken: cfg.Prometheus.BearerToken,
+		Username:    cfg.Prometheus.Username,
+		Password:    cfg.Prometheus.Password,
+		Headers:     promHeaders,
+	}
+	promTLS := prometheus.TLSConfig{
+		CAFile:             cfg.Prometheus.TLSCAFile,
+		CertFile:           cfg.Prometheus.TLSCertFile,
+		KeyFile:            cfg.Prometheus.TLSKeyFile,
+		InsecureSkipVerify: cfg.Prometheus.TLSInsecureSkipVerify,
+	}
+	promRetry := prometheus.RetryConfig{
+		MaxAttempts:          cfg.Prometheus.RetryMaxAttempts,
+		InitialBackoff:       cfg.Prometheus.RetryInitialBackoff,
+		MaxBackoff:           cfg.Prometheus.RetryMaxBackoff,
+		RetryableStatusCodes: retryStatusCodes,
+	}
+	promCircuitBreaker := prometheus.CircuitBreakerConfig{
+		FailureThreshold: cfg.Prometheus.CircuitBreakerThreshold,
+		SuccessThreshold: cfg.Prometheus.CircuitBreakerSuccesses,
+		HalfOpenTimeout:  cfg.Prometheus.CircuitBreakerCooldown,
+	}
+	promOptions := []prometheus.ClientOption{
+		prometheus.WithUserAgent(userAgent),
+		prometheus.WithErrorHistory(cfg.Prometheus.ErrorHistory),
+		prometheus.WithTransportRetries(cfg.Prometheus.TransportRetries, cfg.Prometheus.TransportRetryBackoff),
+		prometheus.WithTracer(otel.Tracer(api.TracerName)),
+	}
+	if cfg.Cache.Backend == string(cache.BackendRedis) {
+		// Prometheus responses are shared with the other instances
+		redisOptions := cacheOptions
+		redisOptions.RedisAddr = cfg.Cache.RedisAddr
+		redisOptions.RedisPassword = cfg.Cache.RedisPassword
+		redisOptions.RedisPrefix = cfg.Cache.RedisPrefix
+		redisCache, err := cache.NewRedis(redisOptions)
+		if err != nil {
+			log.Fatalf("Failed to create Redis cache: %v", err)
+		}
+		defer redisCache.Close()
+		promOptions = append(promOptions, prometheus.WithStore(redisCache))
+		log.Infof("Caching Prometheus responses in Redis at %s", cfg.Cache.RedisAddr)
+	}
+
+	var promClient *prometheus.Client
+	if len(cfg.Prometheus.URLs) > 1 {
+		// Interchangeable servers fail over between each other, so the
+		// additional fan-out targets do not apply
+		endpoints := make([]prometheus.Config, 0, len(cfg.Prometheus.URLs))
+		for _, address := range cfg.Prometheus.URLs {
+			endpoints = append(endpoints, prometheus.Config{
+				URL:            address,
+				Logger:         log,
+				Cache:          cacheInstance,
+				Auth:           promAuth,
+				TLS:            promTLS,
+				CircuitBreaker: promCircuitBreaker,
+				Retry:          promRetry,
+			})
+		}
+		multiClient, err := prometheus.NewMultiClient(endpoints,
+			prometheus.Strategy(cfg.Prometheus.FailoverStrategy), cfg.Prometheus.FailoverCooldown, promOptions...)
+		if err != nil {
+			log.Fatalf("Failed to create Prometheus client: %v", err)
+		}
+		promClient = multiClient.Client
+	} else {
+		promClient, err = prometheus.NewClient(cfg.Prometheus.URL, log, cacheInstance, append(promOptions,
+			prometheus.WithAdditionalTargets(cfg.Prometheus.AdditionalURLs...),
+			prometheus.WithRetry(promRetry),
+			prometheus.WithCircuitBreakerConfig(promCircuitBreaker),
+			prometheus.WithAuth(promAuth),
+			prometheus.WithTLS(promTLS),
+		)...)
+		if err != nil {
+			log.Fatalf("Failed to create Prometheus client: %v", err)
+		}
+	}
+
+	// Further backends selected per request share the cache, whose keys
+	// are scoped by source name
+	sourceClients := map[string]*prometheus.Client{cfg.Prometheus.DefaultSource: promClient}
+	for name, address := range cfg.Prometheus.Sources {
+		sourceClients[name], err = prometheus.NewClient(address, log, cacheInstance, append(promOptions,
+			prometheus.WithRetry(promRetry),
+			prometheus.WithCircuitBreakerConfig(promCircuitBreaker),
+			prometheus.WithAuth(promAuth),
+			prometheus.WithTLS(promTLS),
+		)...)
+		if err != nil {
+			log.Fatalf("Failed to create Prometheus client for source %s: %v", name, err)
+		}
+	}
+	promSources, err := prometheus.NewSources(cfg.Prometheus.DefaultSource, sourceClients)
 	if err != nil {
-		log.Fatalf("Failed to create Prometheus client: %v", err)
+		log.Fatalf("Failed to set up Prometheus sources: %v", err)
+	}
+	promClients := make([]*prometheus.Client, 0, len(sourceClients))
+	for _, client := range sourceClients {
+		client.WithTimeout(cfg.Prometheus.GetPrometheusTimeout())
+		promClients = append(promClients, client)
 	}
 	
+	// Patterns were already validated by config.Load
+	hiddenMetrics, err := cfg.Metrics.CompileHiddenPatterns()
+	if err != nil {
+		log.Fatalf("Invalid hidden metric patterns: %v", err)
+	}
+
 	// Initialize services
-	metricsSvc := service.NewMetricsService(promClient, log)
-	queriesSvc := service.NewQueriesService(promClient, log)
+	metricsSvc := service.NewMetricsService(promClient, log).
+		WithHiddenPatterns(hiddenMetrics).
+		WithStalenessThreshold(cfg.Metrics.StalenessThreshold).
+		WithScrapeInterval(cfg.Metrics.ScrapeInterval).
+		WithAnomalyWindow(cfg.Metrics.AnomalyWindow).
+		WithCardinalityAlert(service.CardinalityAlertConfig{
+			MaxSeriesPerMetric: cfg.Metrics.MaxSeriesPerMetric,
+			AlertCallback: func(metricName string, count int) {
+				log.Warnf("Metric %s has %d series, above the limit of %d", metricName, count, cfg.Metrics.MaxSeriesPerMetric)
+			},
+		})
+	queriesSvc := service.NewQueriesService(promClient, log).
+		WithMaxPoints(cfg.Prometheus.MaxQueryPoints).
+		WithCostWarnThreshold(cfg.Prometheus.QueryCostWarnThreshold).
+		WithMaxLabelValueLength(cfg.Prometheus.MaxLabelValueLength).
+		WithMaxBatchQueries(cfg.Server.MaxBatchQueries).
+		WithBatchConcurrency(cfg.Server.BatchMaxConcurrency).
+		WithHistory(service.NewQueryHistory(cfg.Server.QueryHistorySize)).
+		WithMinStep(cfg.Prometheus.MinStep, cfg.Prometheus.MinStepPolicy).
+		WithHiddenPatterns(hiddenMetrics)
 	alertsSvc := service.NewAlertsService(promClient, log)
+	exportSvc := service.NewExportService(promClient, log)
 	
 	// Create router with all handlers
+	drainer := middleware.NewDrainer()
+	spec := openapi.NewBuilder(api.OpenAPITitle, version)
 	router := api.NewRouter(
 		api.WithLogger(log),
+		api.WithPrometheusClient(promClient),
+		api.WithPrometheusSources(promSources),
 		api.WithMetricsService(metricsSvc),
 		api.WithQueriesService(queriesSvc),
 		api.WithAlertsService(alertsSvc),
+		api.WithExportService(exportSvc),
+		api.WithCache(cacheInstance),
 		api.WithConfig(cfg),
+		api.WithVersion(version),
+		api.WithDrainer(drainer),
+		api.WithOpenAPI(spec),
+		api.WithRegisterer(promclient.DefaultRegisterer),
 	)
+	if cfg.Server.GenerateOpenAPI {
+		body, err := spec.YAML()
+		if err != nil {
+			log.Fatalf("Failed to render OpenAPI spec: %v", err)
+		}
+		if err := os.WriteFile("openapi.yaml", body, 0o644); err != nil {
+			log.Fatalf("Failed to write OpenAPI spec: %v", err)
+		}
+		log.Infof("Wrote OpenAPI spec to openapi.yaml")
+	}
 	
 	// Create HTTP server
 	server := &http.Server{
@@ -95,18 +277,66 @@ func main() {
 		return nil
 	})
 	
+	// Reload the live settings of the configuration on SIGHUP
+	configReloader := &reloader{
+		config:  cfg,
+		load:    config.Load,
+		log:     log,
+		cache:   cacheInstance,
+		clients: promClients,
+	}
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	g.Go(func() error {
+		for {
+			select {
+			case <-gCtx.Done():
+				return nil
+			case <-hupCh:
+				log.Info("Received SIGHUP, reloading configuration")
+				if err := configReloader.reload(); err != nil {
+					log.Errorf("Config reload failed, keeping the current configuration: %v", err)
+				}
+			}
+		}
+	})
+
+	// Export alert counts on /metrics
+	alertsCollector := collector.NewAlertsCollector(alertsSvc, log, promclient.DefaultRegisterer)
+	g.Go(func() error {
+		return alertsCollector.Run(gCtx)
+	})
+	
 	g.Go(func() error {
 		<-gCtx.Done()
 		log.Info("Shutting down server...")
 		
-		// Create shutdown context with timeout
-		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+		// Turn new requests away, end the streams and let the in-flight
+		// requests finish before closing the listener. Draining gets its own
+		// deadline, so requests that outlast it still leave Shutdown time to
+		// close the connections.
+		drainCtx, drainCancel := context.WithTimeout(context.Background(), cfg.Server.DrainTimeout)
+		defer drainCancel()
+		if err := drainer.Drain(drainCtx); err != nil {
+			log.Warnf("Stopped waiting for in-flight requests: %v", err)
+		}
+
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
 		defer shutdownCancel()
-		
-		if err := server.Shutdown(shutdownCtx); err != nil {
-			return fmt.Errorf("server shutdown error: %w", err)
+		shutdownErr := server.Shutdown(shutdownCtx)
+
+		// Keep the cache for the next start even if connections were cut
+		if path := cfg.Cache.PersistPath; path != "" {
+			if err := cacheInstance.SaveToFile(path); err != nil {
+				log.Warnf("Failed to save cache to %s: %v", path, err)
+			} else {
+				log.Infof("Saved cache to %s", path)
+			}
+		}
+
+		if shutdownErr != nil {
+			return fmt.Errorf("server shutdown error: %w", shutdownErr)
 		}
-		
 		log.Info("Server shut down gracefully")
 		return nil
 	})
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"metrics-api/internal/cache"
+	"metrics-api/internal/config"
+	"metrics-api/internal/prometheus"
+	"metrics-api/pkg/logger"
+)
+
+// reloader applies a reloaded configuration to the running server. Only
+// the settings config.Reloadable reports as live are applied; the others
+// are logged and keep their values until a restart.
+type reloader struct {
+	config  *config.Config
+	load    func() (*config.Config, error)
+	log     logger.Logger
+	cache   *cache.Cache
+	clients []*prometheus.Client
+}
+
+// reload loads the configuration and applies its live settings. A
+// configuration that fails to load or validate leaves everything as it was.
+func (r *reloader) reload() error {
+	next, err := r.load()
+	if err != nil {
+		return err
+	}
+
+	live, restart := r.config.Reloadable(next)
+	for _, setting := range restart {
+		r.log.Warnf("Config reload ignored %s, which only changes on restart", setting)
+	}
+	if len(live) == 0 {
+		r.log.Info("Config reloaded with no live settings changed")
+		return nil
+	}
+
+	if err := logger.Reconfigure(r.log,
+		logger.WithLevel(next.Logging.Level),
+		logger.WithOutputType(next.Logging.Format),
+	); err != nil {
+		return err
+	}
+	if r.cache != nil {
+		r.cache.SetDefaultExpiration(time.Duration(next.Cache.TTLSeconds) * time.Second)
+	}
+	for _, client := range r.clients {
+		client.WithTimeout(next.Prometheus.GetPrometheusTimeout())
+	}
+
+	// Adopt only what was applied, so ignored settings are reported again
+	// by later reloads
+	applied := *r.config
+	applied.Logging.Level = next.Logging.Level
+	applied.Logging.Format = next.Logging.Format
+	applied.Cache.TTLSeconds = next.Cache.TTLSeconds
+	applied.Prometheus.TimeoutSeconds = next.Prometheus.TimeoutSeconds
+	r.config = &applied
+
+	r.log.Infof("Config reloaded, applied %s", strings.Join(live, ", "))
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"metrics-api/internal/cache"
+	"metrics-api/internal/config"
+	"metrics-api/internal/prometheus"
+	"metrics-api/pkg/logger"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReload(t *testing.T) {
+	var buf bytes.Buffer
+	log := logger.NewLogger(logger.WithOutput(&buf), logger.WithLevel("info"))
+	cacheInstance := cache.New(cache.Options{DefaultExpiration: time.Minute})
+	client, err := prometheus.NewClient("http://prometheus:9090", log, cacheInstance)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("logging:\n  level: info\n"), 0o600))
+	current, err := config.LoadFromFile(path)
+	require.NoError(t, err)
+
+	var next *config.Config
+	r := &reloader{
+		config:  current,
+		load:    func() (*config.Config, error) { return next, nil },
+		log:     log,
+		cache:   cacheInstance,
+		clients: []*prometheus.Client{client},
+	}
+
+	reloaded := *current
+	reloaded.Logging.Level = "debug"
+	reloaded.Cache.TTLSeconds = 120
+	reloaded.Prometheus.TimeoutSeconds = 10
+	reloaded.Server.Port = 9000
+	next = &reloaded
+	require.NoError(t, r.reload())
+
+	buf.Reset()
+	log.Debug("debug after reload")
+	assert.Contains(t, buf.String(), "debug after reload", "the log level should have been lowered")
+	assert.Equal(t, 2*time.Minute, cacheInstance.DefaultExpiration())
+	assert.Equal(t, 10*time.Second, client.Timeout())
+	assert.Equal(t, 8080, r.config.Server.Port, "the listen port only changes on restart")
+
+	// The ignored port change is reported again on the next reload
+	buf.Reset()
+	require.NoError(t, r.reload())
+	assert.Contains(t, buf.String(), "Config reload ignored server.port")
+
+	// A configuration that fails to load changes nothing
+	r.load = func() (*config.Config, error) { return nil, errors.New("invalid config") }
+	assert.Error(t, r.reload())
+	assert.Equal(t, "debug", r.config.Logging.Level)
+}
@@ -1,32 +1,117 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"metrics-api/internal/models"
 	"metrics-api/internal/service"
 	"metrics-api/pkg/logger"
+	"metrics-api/pkg/openapi"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
 )
 
 // AlertsHandler handles alert-related HTTP requests
 type AlertsHandler struct {
-	service service.AlertsService
-	logger  logger.Logger
+	service      service.AlertsService
+	logger       logger.Logger
+	pollInterval time.Duration
+	upgrader     *websocket.Upgrader
+	shutdown     context.Context
 }
 
+// DefaultAlertPollInterval is how often the live alert feed checks
+// Prometheus by default
+const DefaultAlertPollInterval = 15 * time.Second
+
 // NewAlertsHandler creates a new alerts handler
 func NewAlertsHandler(service *service.AlertsService, logger logger.Logger) *AlertsHandler {
 	return &AlertsHandler{
-		service: *service,
-		logger:  logger,
+		service:      *service,
+		logger:       logger,
+		pollInterval: DefaultAlertPollInterval,
+		upgrader:     newUpgrader(nil),
+		shutdown:     context.Background(),
 	}
 }
 
+// WithPollInterval sets how often the live alert feed checks Prometheus
+func (h *AlertsHandler) WithPollInterval(interval time.Duration) *AlertsHandler {
+	h.pollInterval = interval
+	return h
+}
+
+// WithAllowedOrigins sets the origins, besides the server's own, that may
+// open the live alert feed; "*" allows any
+func (h *AlertsHandler) WithAllowedOrigins(origins []string) *AlertsHandler {
+	h.upgrader = newUpgrader(origins)
+	return h
+}
+
+// WithShutdown sets a context whose cancellation closes the live alert
+// feeds, so shutdown does not wait for their clients to leave
+func (h *AlertsHandler) WithShutdown(ctx context.Context) *AlertsHandler {
+	h.shutdown = ctx
+	return h
+}
+
 // RegisterRoutes registers the handler routes
 func (h *AlertsHandler) RegisterRoutes(r *mux.Router) {
 	r.HandleFunc("/alerts", h.GetAlerts).Methods("GET")
 	r.HandleFunc("/alerts/summary", h.GetAlertSummary).Methods("GET")
 	r.HandleFunc("/alerts/groups", h.GetAlertGroups).Methods("GET")
+	r.HandleFunc("/alerts/flapping", h.GetFlappingAlerts).Methods("GET")
+	r.HandleFunc("/alerts/ws", h.StreamAlerts).Methods("GET")
+}
+
+// DescribeRoutes documents the handler routes
+func (h *AlertsHandler) DescribeRoutes(b *openapi.Builder) {
+	b.Add(
+		openapi.Route{
+			Method: "GET", Path: "/alerts", Tag: "alerts",
+			Summary: "List the current alerts",
+			Response: struct {
+				Alerts []models.Alert `json:"alerts"`
+				Count  int            `json:"count"`
+			}{},
+		},
+		openapi.Route{
+			Method: "GET", Path: "/alerts/summary", Tag: "alerts",
+			Summary:  "Count the current alerts by state and severity",
+			Response: models.AlertSummary{},
+		},
+		openapi.Route{
+			Method: "GET", Path: "/alerts/groups", Tag: "alerts",
+			Summary: "Group the current alerts",
+			Query:   []openapi.Param{{Name: "by", Description: "Label to group by, severity by default"}},
+			Response: struct {
+				Groups []models.AlertGroup `json:"groups"`
+				Count  int                 `json:"count"`
+				By     string              `json:"by"`
+			}{},
+		},
+		openapi.Route{
+			Method: "GET", Path: "/alerts/flapping", Tag: "alerts",
+			Summary: "List the alerts that changed state repeatedly",
+			Query: []openapi.Param{
+				{Name: "window", Description: "Duration to look back over, 1h by default"},
+				{Name: "min_transitions", Type: "integer", Description: "State changes within the window, 4 by default"},
+			},
+			Response: struct {
+				Alerts []models.FlappingAlert `json:"alerts"`
+				Count  int                    `json:"count"`
+			}{},
+		},
+		openapi.Route{
+			Method: "GET", Path: "/alerts/ws", Tag: "alerts",
+			Summary: "Stream snapshots and changes of the alerts over a websocket",
+			Status:  http.StatusSwitchingProtocols,
+		},
+	)
 }
 
 // GetAlerts returns all current alerts
@@ -81,4 +166,118 @@ func (h *AlertsHandler) GetAlertGroups(w http.ResponseWriter, r *http.Request) {
 		"count":  len(groups),
 		"by":     groupBy,
 	})
-}
\ No newline at end of file
+}
+
+// GetFlappingAlerts returns alerts that changed state at least ?min_transitions=
+// times (default 4) within ?window= (default 1h)
+func (h *AlertsHandler) GetFlappingAlerts(w http.ResponseWriter, r *http.Request) {
+	window := time.Hour
+	if windowStr := r.URL.Query().Get("window"); windowStr != "" {
+		parsed, err := time.ParseDuration(windowStr)
+		if err != nil || parsed <= 0 {
+			RespondWithError(w, http.StatusBadRequest, "Invalid window parameter")
+			return
+		}
+		window = parsed
+	}
+
+	minTransitions := 4
+	if minStr := r.URL.Query().Get("min_transitions"); minStr != "" {
+		parsed, err := strconv.Atoi(minStr)
+		if err != nil || parsed <= 0 {
+			RespondWithError(w, http.StatusBadRequest, "Invalid min_transitions parameter")
+			return
+		}
+		minTransitions = parsed
+	}
+
+	alerts, err := h.service.GetFlappingAlerts(r.Context(), window, minTransitions)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidQuery) {
+			RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		h.logger.Errorf("Failed to get flapping alerts: %v", err)
+		RespondWithError(w, http.StatusInternalServerError, "Failed to get flapping alerts")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"alerts": alerts,
+		"count":  len(alerts),
+	})
+}
+
+// StreamAlerts serves a live alert feed over a websocket. It sends a
+// snapshot of the current alerts, then an update whenever alerts are added,
+// removed or change state, checking Prometheus every poll interval.
+func (h *AlertsHandler) StreamAlerts(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgradeWebSocket(h.upgrader, w, r)
+	if err != nil {
+		h.logger.Warnf("Failed to open alerts feed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	// A hijacked request's context is not cancelled when the client goes
+	// away, so the reader cancels it instead
+	ctx, cancel := untilShutdown(r.Context(), h.shutdown)
+	defer cancel()
+	go func() {
+		defer cancel()
+		readWebSocket(conn)
+	}()
+
+	send := func(message models.AlertFeedMessage) error {
+		message.Timestamp = time.Now()
+		conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		return conn.WriteJSON(message)
+	}
+
+	var previous []models.Alert
+	snapshot := true
+	poll := func() error {
+		alerts, err := h.service.GetAlerts(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			h.logger.Warnf("Alerts feed failed to get alerts: %v", err)
+			return send(models.AlertFeedMessage{Type: "error", Error: "Failed to get alerts"})
+		}
+
+		if snapshot {
+			snapshot = false
+			previous = alerts
+			return send(models.AlertFeedMessage{Type: "snapshot", Alerts: alerts})
+		}
+
+		diff := service.DiffAlerts(previous, alerts)
+		previous = alerts
+		if len(diff.Added) == 0 && len(diff.Removed) == 0 && len(diff.Changed) == 0 {
+			return nil
+		}
+		return send(models.AlertFeedMessage{Type: "update", AlertDiff: diff})
+	}
+
+	ticker := time.NewTicker(h.pollInterval)
+	defer ticker.Stop()
+	ping := time.NewTicker(wsPingPeriod)
+	defer ping.Stop()
+
+	err = poll()
+	for err == nil {
+		select {
+		case <-ctx.Done():
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(closeCode(h.shutdown), ""),
+				time.Now().Add(wsWriteWait))
+			return
+		case <-ticker.C:
+			err = poll()
+		case <-ping.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait))
+		}
+	}
+	h.logger.Debugf("Alerts feed ended: %v", err)
+}
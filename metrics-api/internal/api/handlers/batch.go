@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"metrics-api/internal/models"
+	"metrics-api/internal/service"
+	"metrics-api/pkg/errutil"
+	"metrics-api/pkg/logger"
+	"metrics-api/pkg/openapi"
+
+	"github.com/gorilla/mux"
+)
+
+// BatchQueriesHandler handles batches of range queries, such as all panels
+// of a dashboard, run in parallel in one request
+type BatchQueriesHandler struct {
+	service *service.QueriesService
+	logger  logger.Logger
+}
+
+// NewBatchQueriesHandler creates a new batch queries handler
+func NewBatchQueriesHandler(service *service.QueriesService, logger logger.Logger) *BatchQueriesHandler {
+	return &BatchQueriesHandler{
+		service: service,
+		logger:  logger,
+	}
+}
+
+// RegisterRoutes registers the handler routes
+func (h *BatchQueriesHandler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/queries/batch", h.RangeBatch).Methods("POST")
+}
+
+// DescribeRoutes documents the handler routes
+func (h *BatchQueriesHandler) DescribeRoutes(b *openapi.Builder) {
+	b.Add(openapi.Route{
+		Method: "POST", Path: "/queries/batch", Tag: "queries",
+		Summary: "Run several range queries",
+		Query:   queryCacheParams,
+		Request: models.RangeBatchParams{},
+		Response: struct {
+			Results []models.RangeBatchResult `json:"results"`
+			Count   int                       `json:"count"`
+		}{},
+	})
+}
+
+// RangeBatch runs a batch of range queries and reports each one's outcome in
+// request order. Failed queries do not fail the batch.
+func (h *BatchQueriesHandler) RangeBatch(w http.ResponseWriter, r *http.Request) {
+	var params models.RangeBatchParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	bypassCache := cacheBypassRequested(r)
+	keepName := keepNameRequested(r)
+	for i := range params.Queries {
+		params.Queries[i].BypassCache = bypassCache
+		params.Queries[i].KeepName = keepName
+	}
+
+	responses, err := h.service.ExecuteRangeBatch(r.Context(), params.Queries)
+	partial, isPartial := errutil.AsMulti(err)
+	if err != nil && !isPartial {
+		switch {
+		case errors.Is(err, models.ErrInvalidQuery), errors.Is(err, models.ErrBatchTooLarge):
+			RespondWithError(w, http.StatusBadRequest, err.Error())
+		default:
+			h.logger.Errorf("Failed to execute range query batch: %v", err)
+			RespondWithUpstreamError(w, err, "Failed to execute range query batch")
+		}
+		return
+	}
+
+	var failures map[string]string
+	if isPartial {
+		failures = errorDetails(w, partial)
+	}
+
+	results := make([]models.RangeBatchResult, len(params.Queries))
+	for i, query := range params.Queries {
+		results[i] = models.RangeBatchResult{ID: query.ID, Status: "success", Data: responses[i]}
+		if responses[i] == nil {
+			results[i].Status = "error"
+			results[i].Error = failures[query.ID]
+		}
+	}
+
+	markCacheBypass(w, bypassCache)
+	RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"results": results,
+		"count":   len(results),
+	})
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"net/http"
+
+	"metrics-api/internal/cache"
+	"metrics-api/pkg/logger"
+	"metrics-api/pkg/openapi"
+
+	"github.com/gorilla/mux"
+)
+
+// CacheHandler handles requests about the query cache
+type CacheHandler struct {
+	cache  *cache.Cache
+	logger logger.Logger
+}
+
+// NewCacheHandler creates a new cache handler
+func NewCacheHandler(cache *cache.Cache, logger logger.Logger) *CacheHandler {
+	return &CacheHandler{
+		cache:  cache,
+		logger: logger,
+	}
+}
+
+// RegisterRoutes registers the handler routes on a router mounted at /admin
+func (h *CacheHandler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/cache/recommendations", h.GetRecommendations).Methods("GET")
+}
+
+// DescribeRoutes documents the handler routes
+func (h *CacheHandler) DescribeRoutes(b *openapi.Builder) {
+	b.Add(openapi.Route{
+		Method: "GET", Path: "/admin/cache/recommendations", Tag: "admin",
+		Summary: "Report cache statistics and tuning advice",
+		Response: struct {
+			Hits            int64    `json:"hits"`
+			Misses          int64    `json:"misses"`
+			Evictions       int64    `json:"evictions"`
+			HitRatio        float64  `json:"hit_ratio"`
+			Size            int      `json:"size"`
+			MaxItems        int      `json:"max_items"`
+			Recommendations []string `json:"recommendations"`
+		}{},
+	})
+}
+
+// GetRecommendations returns the live cache statistics and tuning advice derived from them
+func (h *CacheHandler) GetRecommendations(w http.ResponseWriter, r *http.Request) {
+	stats := h.cache.GetStats()
+
+	var hitRatio float64
+	if lookups := stats.Hits + stats.Misses; lookups > 0 {
+		hitRatio = float64(stats.Hits) / float64(lookups)
+	}
+
+	RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"hits":            stats.Hits,
+		"misses":          stats.Misses,
+		"evictions":       stats.Evictions,
+		"hit_ratio":       hitRatio,
+		"size":            h.cache.Count(),
+		"max_items":       h.cache.MaxItems(),
+		"recommendations": h.cache.Recommendations(),
+	})
+}
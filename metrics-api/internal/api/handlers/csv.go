@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"metrics-api/internal/models"
+)
+
+// RespondWithCSV sends records as a CSV attachment named filename
+func RespondWithCSV(w http.ResponseWriter, filename string, records [][]string) {
+	var buf bytes.Buffer
+	if err := csv.NewWriter(&buf).WriteAll(records); err != nil {
+		log.Printf("CSV encoding error: %v", err)
+		RespondWithError(w, http.StatusInternalServerError, "Failed to encode CSV response")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// queryCSV lays out an instant query result with one row per series, a
+// column for each label seen across all series, then value and timestamp
+func queryCSV(points []models.DataPoint) [][]string {
+	refs := make([]models.SeriesRef, 0, len(points))
+	for _, point := range points {
+		refs = append(refs, models.SeriesRef{MetricName: point.MetricName, Labels: point.Labels})
+	}
+	named, labelNames := labelColumns(refs)
+
+	records := make([][]string, 0, len(points)+1)
+	records = append(records, csvHeader(named, labelNames, "value", "timestamp"))
+	for i, point := range points {
+		record := csvLabels(refs[i], named, labelNames)
+		record = append(record, formatCSVValue(point.Value), formatCSVTime(point.Timestamp))
+		records = append(records, record)
+	}
+	return records
+}
+
+// rangeQueryCSV lays out a range query result with one row per data point,
+// grouped by series, each prefixed with its series' labels
+func rangeQueryCSV(series []models.TimeSeries) [][]string {
+	refs := make([]models.SeriesRef, 0, len(series))
+	rows := 0
+	for _, s := range series {
+		refs = append(refs, models.SeriesRef{MetricName: s.MetricName, Labels: s.Labels})
+		rows += len(s.DataPoints)
+	}
+	named, labelNames := labelColumns(refs)
+
+	records := make([][]string, 0, rows+1)
+	records = append(records, csvHeader(named, labelNames, "timestamp", "value"))
+	for i, s := range series {
+		labels := csvLabels(refs[i], named, labelNames)
+		for _, point := range s.DataPoints {
+			record := make([]string, 0, len(labels)+2)
+			record = append(record, labels...)
+			records = append(records, append(record, formatCSVTime(point.Timestamp), formatCSVValue(point.Value)))
+		}
+	}
+	return records
+}
+
+// exportLabelNames returns the sorted label names of the first series, which
+// make up the label columns of a CSV export
+func exportLabelNames(series []models.TimeSeries) []string {
+	if len(series) == 0 {
+		return nil
+	}
+	labelNames := make([]string, 0, len(series[0].Labels))
+	for name := range series[0].Labels {
+		labelNames = append(labelNames, name)
+	}
+	sort.Strings(labelNames)
+	return labelNames
+}
+
+// streamSeriesCSV writes series as a CSV attachment named filename with one
+// timestamp,label...,value row per data point. The label columns come from
+// the first series; other series leave labels it lacks empty. Rows are
+// flushed after each series, so large exports go out chunked instead of
+// being held in memory.
+func streamSeriesCSV(w http.ResponseWriter, filename string, series []models.TimeSeries) error {
+	labelNames := exportLabelNames(series)
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.WriteHeader(http.StatusOK)
+
+	writer := csv.NewWriter(w)
+	controller := http.NewResponseController(w)
+
+	header := make([]string, 0, len(labelNames)+2)
+	header = append(header, "timestamp")
+	header = append(header, labelNames...)
+	if err := writer.Write(append(header, "value")); err != nil {
+		return err
+	}
+
+	record := make([]string, len(labelNames)+2)
+	for _, s := range series {
+		for i, name := range labelNames {
+			record[i+1] = s.Labels[name]
+		}
+		for _, point := range s.DataPoints {
+			record[0] = formatCSVTime(point.Timestamp)
+			record[len(record)-1] = formatCSVValue(point.Value)
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return err
+		}
+		// Writers that cannot flush still get every row
+		controller.Flush()
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// csvHeader returns the label columns followed by trailing
+func csvHeader(named bool, labelNames []string, trailing ...string) []string {
+	header := make([]string, 0, len(labelNames)+len(trailing)+1)
+	if named {
+		header = append(header, "__name__")
+	}
+	header = append(header, labelNames...)
+	return append(header, trailing...)
+}
+
+// csvLabels returns the label columns of a series
+func csvLabels(ref models.SeriesRef, named bool, labelNames []string) []string {
+	record := make([]string, 0, len(labelNames)+3)
+	if named {
+		record = append(record, ref.MetricName)
+	}
+	for _, name := range labelNames {
+		record = append(record, ref.Labels[name])
+	}
+	return record
+}
+
+// formatCSVValue formats a sample value the way Prometheus does, including
+// NaN and ±Inf
+func formatCSVValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// formatCSVTime formats a sample timestamp as RFC 3339
+func formatCSVTime(ts time.Time) string {
+	return ts.UTC().Format(time.RFC3339Nano)
+}
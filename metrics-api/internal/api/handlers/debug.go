@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/pprof"
+	runtimepprof "runtime/pprof"
+	"strconv"
+	"time"
+
+	"metrics-api/pkg/logger"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// DefaultProfileSeconds is how long a CPU profile runs without ?seconds=
+	DefaultProfileSeconds = 30
+	// MaxProfileSeconds caps how long a CPU profile may run
+	MaxProfileSeconds = 30
+)
+
+// DebugHandler serves the Go profiler. It exposes internals of the process,
+// so the router only mounts it for admins and when enabled in config.
+type DebugHandler struct {
+	logger logger.Logger
+}
+
+// NewDebugHandler creates a new debug handler
+func NewDebugHandler(logger logger.Logger) *DebugHandler {
+	return &DebugHandler{
+		logger: logger,
+	}
+}
+
+// RegisterRoutes registers the handler routes on a router mounted at /debug
+func (h *DebugHandler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/pprof/cmdline", pprof.Cmdline)
+	r.HandleFunc("/pprof/profile", pprof.Profile)
+	r.HandleFunc("/pprof/symbol", pprof.Symbol)
+	r.HandleFunc("/pprof/trace", pprof.Trace)
+	// Index also serves the named profiles, such as /debug/pprof/heap
+	r.PathPrefix("/pprof/").HandlerFunc(pprof.Index)
+	r.HandleFunc("/profile", h.GetCPUProfile).Methods("GET")
+}
+
+// GetCPUProfile records a CPU profile for ?seconds= seconds and streams it
+// to the client in the pprof format
+func (h *DebugHandler) GetCPUProfile(w http.ResponseWriter, r *http.Request) {
+	seconds := DefaultProfileSeconds
+	if s := r.URL.Query().Get("seconds"); s != "" {
+		var err error
+		seconds, err = strconv.Atoi(s)
+		if err != nil || seconds <= 0 || seconds > MaxProfileSeconds {
+			RespondWithError(w, http.StatusBadRequest, "seconds must be a whole number from 1 to "+strconv.Itoa(MaxProfileSeconds))
+			return
+		}
+	}
+	duration := time.Duration(seconds) * time.Second
+
+	// The profile takes longer than the server's usual write timeout
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(duration + 10*time.Second))
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="cpu.pprof"`)
+	if err := runtimepprof.StartCPUProfile(w); err != nil {
+		// Only one CPU profile can run at a time
+		w.Header().Del("Content-Disposition")
+		RespondWithError(w, http.StatusConflict, "Could not start CPU profile: "+err.Error())
+		return
+	}
+	h.logger.Infof("Recording a %ds CPU profile", seconds)
+
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+	runtimepprof.StopCPUProfile()
+}
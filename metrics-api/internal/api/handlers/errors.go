@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"net/http"
+
+	"metrics-api/pkg/errutil"
+	"metrics-api/pkg/logger"
+)
+
+// Error detail modes: full passes upstream error text through to clients,
+// sanitized replaces it with a generic message and the request ID
+const (
+	ErrorDetailFull      = "full"
+	ErrorDetailSanitized = "sanitized"
+)
+
+// sanitizedErrorMessage stands in for upstream error text in sanitized mode
+const sanitizedErrorMessage = "Internal error; quote the request ID when reporting it"
+
+// sanitizingResponseWriter marks a response whose upstream error details
+// must be logged rather than returned
+type sanitizingResponseWriter struct {
+	http.ResponseWriter
+	logger logger.Logger
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (w *sanitizingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// SanitizeErrors keeps upstream error details, which can include internal
+// URLs and query text, out of responses. They are logged with the request ID
+// set by the RequestID middleware, which is returned to the client instead.
+func SanitizeErrors(log logger.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(&sanitizingResponseWriter{ResponseWriter: w, logger: log}, r)
+		})
+	}
+}
+
+// errorSanitizer returns the sanitizing writer wrapping w, or nil when
+// errors are returned in full
+func errorSanitizer(w http.ResponseWriter) *sanitizingResponseWriter {
+	for {
+		if sw, ok := w.(*sanitizingResponseWriter); ok {
+			return sw
+		}
+		unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return nil
+		}
+		w = unwrapper.Unwrap()
+	}
+}
+
+// logSanitized records an error hidden from the client under its request ID
+func (w *sanitizingResponseWriter) logSanitized(context string, err interface{}) {
+	w.logger.WithFields(map[string]interface{}{
+		"request_id": w.Header().Get("X-Request-ID"),
+	}).Errorf("%s: %v", context, err)
+}
+
+// errorMessage returns err's text for a response, or a generic message in
+// sanitized mode
+func errorMessage(w http.ResponseWriter, err error) string {
+	sw := errorSanitizer(w)
+	if sw == nil {
+		return err.Error()
+	}
+	sw.logSanitized("error hidden from response", err)
+	return sanitizedErrorMessage
+}
+
+// errorDetails renders partial failures for an "errors" response field,
+// replacing each message with a generic one in sanitized mode
+func errorDetails(w http.ResponseWriter, partial *errutil.Multi) map[string]string {
+	details := partial.Errors()
+	sw := errorSanitizer(w)
+	if sw == nil {
+		return details
+	}
+	for key, message := range details {
+		sw.logSanitized("partial failure hidden from response: "+key, message)
+		details[key] = sanitizedErrorMessage
+	}
+	return details
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"metrics-api/internal/models"
+	"metrics-api/internal/service"
+	"metrics-api/pkg/logger"
+	"metrics-api/pkg/openapi"
+
+	"github.com/gorilla/mux"
+)
+
+// ExportHandler handles background range export jobs
+type ExportHandler struct {
+	service *service.ExportService
+	logger  logger.Logger
+}
+
+// NewExportHandler creates a new export handler
+func NewExportHandler(service *service.ExportService, logger logger.Logger) *ExportHandler {
+	return &ExportHandler{
+		service: service,
+		logger:  logger,
+	}
+}
+
+// RegisterRoutes registers the handler routes
+func (h *ExportHandler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/export/jobs", h.StartExport).Methods("POST")
+	r.HandleFunc("/export/jobs/{id}", h.GetExport).Methods("GET")
+	r.HandleFunc("/export/jobs/{id}", h.CancelExport).Methods("DELETE")
+	r.HandleFunc("/export/jobs/{id}/download", h.DownloadExport).Methods("GET", "HEAD")
+}
+
+// DescribeRoutes documents the handler routes
+func (h *ExportHandler) DescribeRoutes(b *openapi.Builder) {
+	b.Add(
+		openapi.Route{
+			Method: "POST", Path: "/export/jobs", Tag: "export",
+			Summary:  "Start exporting a range query in the background",
+			Request:  models.RangeQueryParams{},
+			Response: models.ExportJob{},
+			Status:   http.StatusAccepted,
+		},
+		openapi.Route{
+			Method: "GET", Path: "/export/jobs/{id}", Tag: "export",
+			Summary:  "Get the progress of an export",
+			Response: models.ExportJob{},
+		},
+		openapi.Route{
+			Method: "DELETE", Path: "/export/jobs/{id}", Tag: "export",
+			Summary:  "Cancel an export",
+			Response: models.ExportJob{},
+		},
+		openapi.Route{
+			Method: "GET", Path: "/export/jobs/{id}/download", Tag: "export",
+			Summary:  "Download the result of a completed export",
+			Response: models.RangeQueryResponse{},
+		},
+		openapi.Route{
+			Method: "HEAD", Path: "/export/jobs/{id}/download", Tag: "export",
+			Summary: "Get the size of a completed export",
+		},
+	)
+}
+
+// StartExport starts a range export and returns its job handle
+func (h *ExportHandler) StartExport(w http.ResponseWriter, r *http.Request) {
+	var params models.RangeQueryParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	job, err := h.service.StartExport(r.Context(), params)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidQuery):
+			RespondWithError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, models.ErrInvalidTimeRange):
+			RespondWithError(w, http.StatusBadRequest, "Invalid time range")
+		case errors.Is(err, models.ErrTooManyDataPoints):
+			RespondWithError(w, http.StatusBadRequest, "Export would return too many data points")
+		case errors.Is(err, models.ErrTooManyExports):
+			RespondWithError(w, http.StatusTooManyRequests, "Too many exports are running; try again later")
+		default:
+			h.logger.Errorf("Failed to start export: %v", err)
+			RespondWithError(w, http.StatusInternalServerError, "Failed to start export")
+		}
+		return
+	}
+
+	w.Header().Set("Location", r.URL.Path+"/"+job.ID)
+	RespondWithJSON(w, http.StatusAccepted, job)
+}
+
+// GetExport returns the status, progress and, once finished, the result of a job
+func (h *ExportHandler) GetExport(w http.ResponseWriter, r *http.Request) {
+	job, err := h.service.GetExport(mux.Vars(r)["id"])
+	if err != nil {
+		h.respondJobError(w, err)
+		return
+	}
+
+	h.respondJob(w, job)
+}
+
+// CancelExport cancels a running job
+func (h *ExportHandler) CancelExport(w http.ResponseWriter, r *http.Request) {
+	job, err := h.service.CancelExport(mux.Vars(r)["id"])
+	if err != nil {
+		h.respondJobError(w, err)
+		return
+	}
+
+	h.respondJob(w, job)
+}
+
+// DownloadExport serves the result of a completed job as a JSON file.
+// Range requests are honoured with 206 Partial Content so clients can resume
+// interrupted downloads of large exports.
+func (h *ExportHandler) DownloadExport(w http.ResponseWriter, r *http.Request) {
+	job, err := h.service.GetExport(mux.Vars(r)["id"])
+	if err != nil {
+		h.respondJobError(w, err)
+		return
+	}
+
+	if job.Status != models.ExportJobCompleted || job.Result == nil {
+		RespondWithError(w, http.StatusConflict, "Export job has not completed")
+		return
+	}
+
+	// A finished result never changes, so byte offsets stay valid across requests
+	payload, err := json.Marshal(job.Result)
+	if err != nil {
+		h.logger.Errorf("Failed to encode export %s: %v", job.ID, err)
+		RespondWithError(w, http.StatusInternalServerError, "Failed to encode export")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="export-`+job.ID+`.json"`)
+	w.Header().Set("ETag", `"`+job.ID+`"`)
+	http.ServeContent(w, r, "", *job.FinishedAt, bytes.NewReader(payload))
+}
+
+// respondJob writes job, passing the error of a failed job through
+// errorMessage so sanitized mode hides it
+func (h *ExportHandler) respondJob(w http.ResponseWriter, job models.ExportJob) {
+	if job.Err != nil {
+		job.Error = errorMessage(w, job.Err)
+	}
+	RespondWithJSON(w, http.StatusOK, job)
+}
+
+// respondJobError maps job lookup errors to responses
+func (h *ExportHandler) respondJobError(w http.ResponseWriter, err error) {
+	if errors.Is(err, models.ErrExportJobNotFound) {
+		RespondWithError(w, http.StatusNotFound, "Export job not found")
+		return
+	}
+	h.logger.Errorf("Failed to get export job: %v", err)
+	RespondWithError(w, http.StatusInternalServerError, "Failed to get export job")
+}
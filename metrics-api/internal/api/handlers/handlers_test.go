@@ -1,19 +1,37 @@
 package handlers
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/csv"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"metrics-api/internal/api/middleware"
+	"metrics-api/internal/cache"
 	"metrics-api/internal/models"
 	"metrics-api/internal/prometheus"
+	"metrics-api/internal/service"
+	"metrics-api/pkg/health"
+	"metrics-api/pkg/logger"
+	"metrics-api/pkg/openapi"
 
+	"github.com/getkin/kin-openapi/openapi3"
 	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -29,7 +47,7 @@ type MetricsServiceInterface interface {
 type QueriesServiceInterface interface {
 	ExecuteInstantQuery(ctx context.Context, params models.InstantQueryParams) (*models.QueryResponse, error)
 	ExecuteRangeQuery(ctx context.Context, params models.RangeQueryParams) (*models.RangeQueryResponse, error)
-	ValidateQuery(ctx context.Context, query string) (*models.QueryValidation, error)
+	ValidateQuery(ctx context.Context, query string, dryRun bool) (*models.QueryValidation, error)
 	GetQuerySuggestions(ctx context.Context, prefix string, limit int) ([]string, error)
 }
 
@@ -91,8 +109,8 @@ func (m *MockQueriesService) ExecuteRangeQuery(ctx context.Context, params model
 	return args.Get(0).(*models.RangeQueryResponse), args.Error(1)
 }
 
-func (m *MockQueriesService) ValidateQuery(ctx context.Context, query string) (*models.QueryValidation, error) {
-	args := m.Called(ctx, query)
+func (m *MockQueriesService) ValidateQuery(ctx context.Context, query string, dryRun bool) (*models.QueryValidation, error) {
+	args := m.Called(ctx, query, dryRun)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
@@ -457,4 +475,3644 @@ func TestInstantQuery(t *testing.T) {
 	assert.Equal(t, 1, len(response.Data))
 
 	mockService.AssertExpectations(t)
-}
\ No newline at end of file
+}
+// fakePrometheus serves a vector result that tests can change between requests
+type fakePrometheus struct {
+	server *httptest.Server
+	hits   int64
+	result atomic.Value
+}
+
+func newFakePrometheus(t *testing.T, result string) *fakePrometheus {
+	fp := &fakePrometheus{}
+	fp.result.Store(result)
+	fp.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&fp.hits, 1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":%s}}`, fp.result.Load().(string))
+	}))
+	t.Cleanup(fp.server.Close)
+	return fp
+}
+
+// newFakePrometheusByQuery serves a different vector result for each query
+func newFakePrometheusByQuery(t *testing.T, results map[string]string) *fakePrometheus {
+	fp := &fakePrometheus{}
+	fp.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&fp.hits, 1)
+		result, ok := results[r.FormValue("query")]
+		if !ok {
+			result = "[]"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":%s}}`, result)
+	}))
+	t.Cleanup(fp.server.Close)
+	return fp
+}
+
+// upResult builds a single-series vector result for the up metric
+func upResult(value string) string {
+	return fmt.Sprintf(`[{"metric":{"__name__":"up","job":"prometheus"},"value":[1609746000,"%s"]}]`, value)
+}
+
+// Hits returns the number of requests that reached the fake server
+func (fp *fakePrometheus) Hits() int64 {
+	return atomic.LoadInt64(&fp.hits)
+}
+
+// newTestQueriesRouter wires a real QueriesHandler to the fake Prometheus server
+func newTestQueriesRouter(t *testing.T, fp *fakePrometheus) *mux.Router {
+	client, err := prometheus.NewClient(fp.server.URL, logger.NewTestLogger(), cache.New(cache.DefaultOptions()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	NewQueriesHandler(service.NewQueriesService(client, logger.NewTestLogger()), logger.NewTestLogger()).RegisterRoutes(router)
+	return router
+}
+
+// Test that nocache=true bypasses the cache read and refreshes the entry
+func TestInstantQueryCacheBypass(t *testing.T) {
+	fp := newFakePrometheus(t, upResult("1"))
+	router := newTestQueriesRouter(t, fp)
+	payload := `{"query": "up", "time": "2021-01-04T07:40:00Z"}`
+
+	doQuery := func(target string, headers map[string]string) (*httptest.ResponseRecorder, models.QueryResponse) {
+		req := httptest.NewRequest("POST", target, strings.NewReader(payload))
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var response models.QueryResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		return rr, response
+	}
+
+	// Populate the cache
+	rr, response := doQuery("/query", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, int64(1), fp.Hits())
+	assert.Equal(t, 1.0, response.Data[0].Value)
+	assert.Empty(t, rr.Header().Get("X-Cache"))
+
+	// Upstream value changes but the cached value is still served
+	fp.result.Store(upResult("2"))
+	_, response = doQuery("/query", nil)
+	assert.Equal(t, int64(1), fp.Hits())
+	assert.Equal(t, 1.0, response.Data[0].Value)
+
+	// nocache=true reaches Prometheus even though a cached value exists
+	rr, response = doQuery("/query?nocache=true", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, int64(2), fp.Hits())
+	assert.Equal(t, 2.0, response.Data[0].Value)
+	assert.Equal(t, "BYPASS", rr.Header().Get("X-Cache"))
+
+	// The fresh result replaced the cache entry
+	_, response = doQuery("/query", nil)
+	assert.Equal(t, int64(2), fp.Hits())
+	assert.Equal(t, 2.0, response.Data[0].Value)
+
+	// Cache-Control: no-cache behaves the same way
+	fp.result.Store(upResult("3"))
+	rr, response = doQuery("/query", map[string]string{"Cache-Control": "no-cache"})
+	assert.Equal(t, int64(3), fp.Hits())
+	assert.Equal(t, 3.0, response.Data[0].Value)
+	assert.Equal(t, "BYPASS", rr.Header().Get("X-Cache"))
+}
+
+// Test that a poll with since_version returns only the series that changed
+func TestInstantQueryDiffSinceVersion(t *testing.T) {
+	fp := newFakePrometheus(t, `[
+		{"metric":{"__name__":"up","job":"a"},"value":[1609746000,"1"]},
+		{"metric":{"__name__":"up","job":"b"},"value":[1609746000,"1"]},
+		{"metric":{"__name__":"up","job":"c"},"value":[1609746000,"1"]}
+	]`)
+	router := newTestQueriesRouter(t, fp)
+
+	poll := func(payload string) models.QueryResponse {
+		req := httptest.NewRequest("POST", "/query", strings.NewReader(payload))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response models.QueryResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	// First poll returns everything along with a version token
+	first := poll(`{"query": "up", "time": "2021-01-04T07:40:00Z", "diff": true}`)
+	assert.Len(t, first.Data, 3)
+	assert.False(t, first.Delta)
+	assert.NotEmpty(t, first.Version)
+
+	// b changes, c disappears, d appears, a stays the same
+	fp.result.Store(`[
+		{"metric":{"__name__":"up","job":"a"},"value":[1609746015,"1"]},
+		{"metric":{"__name__":"up","job":"b"},"value":[1609746015,"0"]},
+		{"metric":{"__name__":"up","job":"d"},"value":[1609746015,"1"]}
+	]`)
+
+	second := poll(fmt.Sprintf(`{"query": "up", "time": "2021-01-04T07:40:15Z", "since_version": %q}`, first.Version))
+	assert.True(t, second.Delta)
+	assert.NotEmpty(t, second.Version)
+	assert.NotEqual(t, first.Version, second.Version)
+
+	changed := make(map[string]float64)
+	for _, dp := range second.Data {
+		changed[dp.Labels["job"]] = dp.Value
+	}
+	assert.Equal(t, map[string]float64{"b": 0, "d": 1}, changed)
+
+	if assert.Len(t, second.Removed, 1) {
+		assert.Equal(t, "up", second.Removed[0].MetricName)
+		assert.Equal(t, "c", second.Removed[0].Labels["job"])
+	}
+
+	// An unknown version falls back to a full result
+	full := poll(`{"query": "up", "time": "2021-01-04T07:40:30Z", "since_version": "expired"}`)
+	assert.False(t, full.Delta)
+	assert.Len(t, full.Data, 3)
+	assert.NotEmpty(t, full.Version)
+}
+
+// Test that the preview endpoint shows injected label matchers
+func TestPreviewQuery(t *testing.T) {
+	router := newTestQueriesRouter(t, newFakePrometheus(t, upResult("1")))
+
+	tests := []struct {
+		name     string
+		payload  string
+		wantCode int
+		want     string
+	}{
+		{
+			name:     "bare metric",
+			payload:  `{"query": "up", "labels": {"tenant": "acme", "env": "prod"}}`,
+			wantCode: http.StatusOK,
+			want:     `up{env="prod",tenant="acme"}`,
+		},
+		{
+			name:     "selector bearing query",
+			payload:  `{"query": "sum(rate(http_requests_total{code=\"200\"}[5m])) / sum(rate(http_requests_total[5m]))", "labels": {"tenant": "acme"}}`,
+			wantCode: http.StatusOK,
+			want:     `sum(rate(http_requests_total{code="200",tenant="acme"}[5m])) / sum(rate(http_requests_total{tenant="acme"}[5m]))`,
+		},
+		{
+			name:     "invalid promql",
+			payload:  `{"query": "sum(rate(up[5m]", "labels": {"tenant": "acme"}}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "invalid label name",
+			payload:  `{"query": "up", "labels": {"bad-name": "x"}}`,
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/query/preview", strings.NewReader(tt.payload))
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.wantCode, rr.Code)
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var response map[string]interface{}
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, tt.want, response["preview"])
+		})
+	}
+}
+
+// Test that unsafe or oversized label values are rejected before injection
+func TestPreviewQueryLabelValueValidation(t *testing.T) {
+	router := newTestQueriesRouter(t, newFakePrometheus(t, upResult("1")))
+
+	tests := []struct {
+		name     string
+		value    string
+		wantCode int
+	}{
+		{name: "normal value", value: "acme-prod.eu-west-1", wantCode: http.StatusOK},
+		{name: "over length", value: strings.Repeat("a", 257), wantCode: http.StatusBadRequest},
+		{name: "quote", value: `acme"} or vector(1) #`, wantCode: http.StatusBadRequest},
+		{name: "backslash", value: `acme\`, wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(map[string]interface{}{
+				"query":  "up",
+				"labels": map[string]string{"tenant": tt.value},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest("POST", "/query/preview", strings.NewReader(string(body)))
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.wantCode, rr.Code)
+		})
+	}
+}
+
+// slowCheck reports up after delay unless its context expires first
+func slowCheck(delay time.Duration) health.Check {
+	return func(ctx context.Context) (health.Status, map[string]interface{}, error) {
+		select {
+		case <-time.After(delay):
+			return health.StatusUp, nil, nil
+		case <-ctx.Done():
+			return health.StatusDown, nil, ctx.Err()
+		}
+	}
+}
+
+// Test that each health endpoint honours its own configured timeout
+func TestHealthTimeouts(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	const short = 10 * time.Millisecond
+	const long = time.Second
+
+	tests := []struct {
+		name      string
+		path      string
+		timeouts  HealthTimeouts
+		wantCode  int
+		wantCheck string
+	}{
+		{
+			name:      "detailed within timeout",
+			path:      "/health/detailed",
+			timeouts:  HealthTimeouts{Detailed: long, Readiness: short, Check: long},
+			wantCode:  http.StatusOK,
+			wantCheck: "up",
+		},
+		{
+			name:      "detailed timeout exceeded",
+			path:      "/health/detailed",
+			timeouts:  HealthTimeouts{Detailed: short, Readiness: long, Check: long},
+			wantCode:  http.StatusServiceUnavailable,
+			wantCheck: "down",
+		},
+		{
+			name:      "check timeout exceeded",
+			path:      "/health/detailed",
+			timeouts:  HealthTimeouts{Detailed: long, Readiness: long, Check: short},
+			wantCode:  http.StatusServiceUnavailable,
+			wantCheck: "down",
+		},
+		{
+			name:     "readiness within timeout",
+			path:     "/ready",
+			timeouts: HealthTimeouts{Detailed: short, Readiness: long, Check: long},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "readiness timeout exceeded",
+			path:     "/ready",
+			timeouts: HealthTimeouts{Detailed: long, Readiness: short, Check: long},
+			wantCode: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewHealthHandler(nil, logger.NewTestLogger(), "test").WithTimeouts(tt.timeouts)
+			handler.AddCheck("slow", slowCheck(delay))
+
+			router := mux.NewRouter()
+			handler.RegisterRoutes(router)
+
+			req := httptest.NewRequest("GET", tt.path, nil)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.wantCode, rr.Code)
+			if tt.wantCheck == "" {
+				return
+			}
+
+			var response models.HealthStatus
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, tt.wantCheck, response.Checks["slow"])
+		})
+	}
+}
+
+// Test that the detailed health status aggregates the checks, answering 503
+// only when the service is down
+func TestDetailedHealthStatus(t *testing.T) {
+	lenient := HealthThresholds{Memory: 1, Goroutines: 1000000}
+	downCheck := func(ctx context.Context) (health.Status, map[string]interface{}, error) {
+		return health.StatusDown, nil, fmt.Errorf("unreachable")
+	}
+
+	tests := []struct {
+		name        string
+		thresholds  HealthThresholds
+		extra       health.Check
+		wantCode    int
+		wantStatus  string
+		wantChecks  map[string]string
+		wantWarning string
+	}{
+		{
+			name:       "up",
+			thresholds: lenient,
+			wantCode:   http.StatusOK,
+			wantStatus: "up",
+			wantChecks: map[string]string{"prometheus": "up", "memory": "up", "cpu": "up"},
+		},
+		{
+			name:        "degraded",
+			thresholds:  HealthThresholds{Memory: 1, Goroutines: 1},
+			wantCode:    http.StatusOK,
+			wantStatus:  "degraded",
+			wantChecks:  map[string]string{"prometheus": "up", "memory": "up", "cpu": "degraded"},
+			wantWarning: "degraded health checks: cpu",
+		},
+		{
+			name:       "down",
+			thresholds: HealthThresholds{Memory: 1, Goroutines: 1},
+			extra:      downCheck,
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: "down",
+			wantChecks: map[string]string{"prometheus": "up", "memory": "up", "cpu": "degraded", "extra": "down"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fp := newFakePrometheus(t, upResult("1"))
+			client, err := prometheus.NewClient(fp.server.URL, logger.NewTestLogger(), cache.New(cache.DefaultOptions()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			handler := NewHealthHandler(client, logger.NewTestLogger(), "test").WithThresholds(tt.thresholds)
+			if tt.extra != nil {
+				handler.AddCheck("extra", tt.extra)
+			}
+
+			router := mux.NewRouter()
+			handler.RegisterRoutes(router)
+
+			req := httptest.NewRequest("GET", "/health/detailed", nil)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.wantCode, rr.Code)
+
+			var response models.HealthStatus
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, tt.wantStatus, response.Status)
+			assert.Equal(t, tt.wantChecks, response.Checks)
+			assert.Equal(t, tt.wantWarning, response.Warning)
+			assert.Contains(t, response.Details["prometheus"], "circuit_state")
+			assert.NotEmpty(t, response.SystemInfo)
+		})
+	}
+}
+
+// Test that with periodic checks the probes report the last results instead
+// of running the checks themselves
+func TestPeriodicHealthChecks(t *testing.T) {
+	var runs atomic.Int32
+	handler := NewHealthHandler(nil, logger.NewTestLogger(), "test")
+	handler.AddCheck("counted", func(ctx context.Context) (health.Status, map[string]interface{}, error) {
+		runs.Add(1)
+		return health.StatusUp, nil, nil
+	})
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+	serve := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		return rr
+	}
+
+	assert.Error(t, handler.StartPeriodicChecks(context.Background(), 0), "a zero interval should be rejected")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := handler.StartPeriodicChecks(ctx, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	assert.Eventually(t, func() bool {
+		return serve("/ready").Code == http.StatusOK
+	}, time.Second, 10*time.Millisecond)
+	ran := runs.Load()
+
+	rr := serve("/health/detailed")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response models.HealthStatus
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "up", response.Checks["counted"])
+
+	assert.Equal(t, http.StatusOK, serve("/ready").Code)
+	assert.Equal(t, ran, runs.Load(), "the probes should not run the checks")
+}
+
+// Test combining two instant queries series by series for each operator
+func TestCombineQuery(t *testing.T) {
+	fp := newFakePrometheusByQuery(t, map[string]string{
+		"errors": `[
+			{"metric":{"__name__":"errors","job":"api"},"value":[1609746000,"6"]},
+			{"metric":{"__name__":"errors","job":"web"},"value":[1609746000,"2"]},
+			{"metric":{"__name__":"errors","job":"db"},"value":[1609746000,"1"]}
+		]`,
+		"requests": `[
+			{"metric":{"__name__":"requests","job":"api"},"value":[1609746000,"3"]},
+			{"metric":{"__name__":"requests","job":"web"},"value":[1609746000,"8"]},
+			{"metric":{"__name__":"requests","job":"cache"},"value":[1609746000,"5"]}
+		]`,
+	})
+	router := newTestQueriesRouter(t, fp)
+
+	type point struct {
+		Labels map[string]string `json:"labels"`
+		Value  *float64          `json:"value"`
+	}
+	combine := func(op string, includeUnmatched bool) (int, []point) {
+		payload := fmt.Sprintf(`{"query_a": "errors", "query_b": "requests", "op": %q, "time": "2021-01-04T07:40:00Z", "include_unmatched": %t}`, op, includeUnmatched)
+		req := httptest.NewRequest("POST", "/query/combine", strings.NewReader(payload))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var response struct {
+			Data []point `json:"data"`
+		}
+		if rr.Code == http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rr.Code, response.Data
+	}
+
+	tests := []struct {
+		op  string
+		api float64
+		web float64
+	}{
+		{op: "add", api: 9, web: 10},
+		{op: "sub", api: 3, web: -6},
+		{op: "mul", api: 18, web: 16},
+		{op: "div", api: 2, web: 0.25},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.op, func(t *testing.T) {
+			code, data := combine(tt.op, false)
+			assert.Equal(t, http.StatusOK, code)
+			if assert.Len(t, data, 2) {
+				assert.Equal(t, "api", data[0].Labels["job"])
+				assert.Equal(t, tt.api, *data[0].Value)
+				assert.Equal(t, "web", data[1].Labels["job"])
+				assert.Equal(t, tt.web, *data[1].Value)
+			}
+		})
+	}
+
+	t.Run("include unmatched", func(t *testing.T) {
+		code, data := combine("add", true)
+		assert.Equal(t, http.StatusOK, code)
+		if assert.Len(t, data, 4) {
+			assert.Equal(t, "db", data[2].Labels["job"])
+			assert.Nil(t, data[2].Value)
+			assert.Equal(t, "cache", data[3].Labels["job"])
+			assert.Nil(t, data[3].Value)
+		}
+	})
+
+	t.Run("unsupported operator", func(t *testing.T) {
+		code, _ := combine("pow", false)
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+}
+
+// Test that keep_name=true retains __name__ in the label map
+func TestInstantQueryKeepName(t *testing.T) {
+	router := newTestQueriesRouter(t, newFakePrometheus(t, upResult("1")))
+	payload := `{"query": "up", "time": "2021-01-04T07:40:00Z"}`
+
+	tests := []struct {
+		name     string
+		target   string
+		wantName bool
+	}{
+		{name: "default strips name", target: "/query", wantName: false},
+		{name: "keep name", target: "/query?keep_name=true", wantName: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.target, strings.NewReader(payload))
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			assert.Equal(t, http.StatusOK, rr.Code)
+
+			var response models.QueryResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if !assert.Len(t, response.Data, 1) {
+				return
+			}
+
+			point := response.Data[0]
+			assert.Equal(t, "up", point.MetricName)
+			assert.Equal(t, "prometheus", point.Labels["job"])
+			name, ok := point.Labels["__name__"]
+			assert.Equal(t, tt.wantName, ok)
+			if tt.wantName {
+				assert.Equal(t, "up", name)
+			}
+		})
+	}
+}
+
+// Test that GetJobs aggregates up/down targets per job, least healthy first
+func TestGetJobs(t *testing.T) {
+	fp := newFakePrometheus(t, `[
+		{"metric":{"__name__":"up","job":"api","instance":"a:9100"},"value":[1609746000,"1"]},
+		{"metric":{"__name__":"up","job":"api","instance":"b:9100"},"value":[1609746000,"0"]},
+		{"metric":{"__name__":"up","job":"node","instance":"c:9100"},"value":[1609746000,"1"]},
+		{"metric":{"__name__":"up","job":"node","instance":"d:9100"},"value":[1609746000,"1"]},
+		{"metric":{"__name__":"up","job":"db","instance":"e:9100"},"value":[1609746000,"0"]}
+	]`)
+	client, err := prometheus.NewClient(fp.server.URL, logger.NewTestLogger(), cache.New(cache.DefaultOptions()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	NewMetricsHandler(service.NewMetricsService(client, logger.NewTestLogger()), logger.NewTestLogger()).RegisterRoutes(router)
+
+	req := httptest.NewRequest("GET", "/jobs", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var response struct {
+		Jobs  []models.JobHealth `json:"jobs"`
+		Count int                `json:"count"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 3, response.Count)
+	assert.Equal(t, []models.JobHealth{
+		{Job: "db", Targets: 1, Up: 0, Down: 1},
+		{Job: "api", Targets: 2, Up: 1, Down: 1},
+		{Job: "node", Targets: 2, Up: 2, Down: 0},
+	}, response.Jobs)
+}
+
+// Test that pretty=true indents JSON responses while the default stays compact
+func TestPrettyJSON(t *testing.T) {
+	handler := PrettyJSON(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		RespondWithJSON(w, http.StatusOK, map[string]interface{}{"status": "up"})
+	}))
+
+	tests := []struct {
+		name   string
+		target string
+		want   string
+	}{
+		{name: "default compact", target: "/health", want: `{"status":"up"}`},
+		{name: "pretty", target: "/health?pretty=true", want: "{\n  \"status\": \"up\"\n}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.target, nil)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, http.StatusOK, rr.Code)
+			assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+			assert.Equal(t, tt.want, rr.Body.String())
+		})
+	}
+}
+
+// Test that metric summaries carry type, help and unit from metadata when present
+func TestGetMetricSummaryMetadata(t *testing.T) {
+	promMux := http.NewServeMux()
+	promMux.HandleFunc("/api/v1/labels", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status":"success","data":["__name__","job"]}`)
+	})
+	promMux.HandleFunc("/api/v1/query", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"job":"api"},"value":[1609746000,"3"]}]}}`)
+	})
+	promMux.HandleFunc("/api/v1/metadata", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("metric") != "http_requests_total" {
+			fmt.Fprint(w, `{"status":"success","data":{}}`)
+			return
+		}
+		fmt.Fprint(w, `{"status":"success","data":{"http_requests_total":[{"type":"counter","help":"Total HTTP requests.","unit":"requests"}]}}`)
+	})
+	server := httptest.NewServer(promMux)
+	t.Cleanup(server.Close)
+
+	client, err := prometheus.NewClient(server.URL, logger.NewTestLogger(), cache.New(cache.DefaultOptions()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	NewMetricsHandler(service.NewMetricsService(client, logger.NewTestLogger()), logger.NewTestLogger()).RegisterRoutes(router)
+
+	tests := []struct {
+		metric string
+		want   models.MetricSummary
+	}{
+		{
+			metric: "http_requests_total",
+			want:   models.MetricSummary{Name: "http_requests_total", Type: "counter", Help: "Total HTTP requests.", Unit: "requests"},
+		},
+		{
+			metric: "custom_gauge",
+			want:   models.MetricSummary{Name: "custom_gauge"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.metric, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/metrics/"+tt.metric, nil)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			assert.Equal(t, http.StatusOK, rr.Code)
+
+			var summary models.MetricSummary
+			if err := json.Unmarshal(rr.Body.Bytes(), &summary); err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, tt.want.Name, summary.Name)
+			assert.Equal(t, tt.want.Type, summary.Type)
+			assert.Equal(t, tt.want.Help, summary.Help)
+			assert.Equal(t, tt.want.Unit, summary.Unit)
+			assert.Equal(t, 3, summary.Cardinality)
+			assert.Len(t, summary.Samples, 1)
+		})
+	}
+}
+
+// Test that a counter's summary warns about its raw values and suggests a rate
+// query while a gauge's does not
+func TestGetMetricSummaryCounterWarning(t *testing.T) {
+	promMux := http.NewServeMux()
+	promMux.HandleFunc("/api/v1/labels", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status":"success","data":["__name__","job"]}`)
+	})
+	promMux.HandleFunc("/api/v1/query", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"job":"api"},"value":[1609746000,"1024"]}]}}`)
+	})
+	promMux.HandleFunc("/api/v1/metadata", func(w http.ResponseWriter, r *http.Request) {
+		metricType := map[string]string{"http_requests_total": "counter", "memory_bytes": "gauge"}[r.FormValue("metric")]
+		fmt.Fprintf(w, `{"status":"success","data":{%q:[{"type":%q,"help":"","unit":""}]}}`, r.FormValue("metric"), metricType)
+	})
+	server := httptest.NewServer(promMux)
+	t.Cleanup(server.Close)
+
+	client, err := prometheus.NewClient(server.URL, logger.NewTestLogger(), cache.New(cache.DefaultOptions()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := service.NewMetricsService(client, logger.NewTestLogger()).WithScrapeInterval(30 * time.Second)
+
+	counter, err := svc.GetMetricSummary(context.Background(), "http_requests_total")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, counter.Warnings, 1) {
+		assert.Contains(t, counter.Warnings[0], "counter")
+	}
+	assert.Equal(t, "rate(http_requests_total[2m])", counter.SuggestedQuery)
+
+	gauge, err := svc.GetMetricSummary(context.Background(), "memory_bytes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "gauge", gauge.Type)
+	assert.Empty(t, gauge.Warnings)
+	assert.Empty(t, gauge.SuggestedQuery)
+}
+
+// Test that concurrent requests for a summary that is not cached share one
+// set of queries, and that the result is then served from the cache
+func TestGetMetricSummaryConcurrent(t *testing.T) {
+	var labelHits atomic.Int64
+	promMux := http.NewServeMux()
+	promMux.HandleFunc("/api/v1/labels", func(w http.ResponseWriter, r *http.Request) {
+		labelHits.Add(1)
+		// Slow enough for every request to arrive while the first is running
+		time.Sleep(100 * time.Millisecond)
+		fmt.Fprint(w, `{"status":"success","data":["__name__","job"]}`)
+	})
+	promMux.HandleFunc("/api/v1/query", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"job":"api"},"value":[1609746000,"3"]}]}}`)
+	})
+	promMux.HandleFunc("/api/v1/metadata", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status":"success","data":{}}`)
+	})
+	server := httptest.NewServer(promMux)
+	t.Cleanup(server.Close)
+
+	// Without a client cache every call would reach Prometheus
+	client, err := prometheus.NewClient(server.URL, logger.NewTestLogger(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := service.NewMetricsService(client, logger.NewTestLogger())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			summary, err := svc.GetMetricSummary(context.Background(), "node_load1")
+			if assert.NoError(t, err) {
+				assert.Equal(t, 3, summary.Cardinality)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(1), labelHits.Load(), "concurrent requests should share one set of queries")
+
+	_, err = svc.GetMetricSummary(context.Background(), "node_load1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), labelHits.Load(), "the summary should be served from the cache")
+}
+
+// Test that upstream timeouts map to 504 while other upstream failures stay 500
+func TestQueryUpstreamErrorMapping(t *testing.T) {
+	tests := []struct {
+		name     string
+		upstream http.HandlerFunc
+		wantCode int
+	}{
+		{
+			name: "deadline exceeded",
+			upstream: func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-time.After(200 * time.Millisecond):
+				case <-r.Context().Done():
+				}
+			},
+			wantCode: http.StatusGatewayTimeout,
+		},
+		{
+			name: "upstream error",
+			upstream: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprint(w, `{"status":"error","errorType":"internal","error":"boom"}`)
+			},
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.upstream)
+			t.Cleanup(server.Close)
+
+			client, err := prometheus.NewClient(server.URL, logger.NewTestLogger(), cache.New(cache.DefaultOptions()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			router := mux.NewRouter()
+			NewQueriesHandler(service.NewQueriesService(client, logger.NewTestLogger()), logger.NewTestLogger()).RegisterRoutes(router)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+
+			req := httptest.NewRequest("POST", "/query", strings.NewReader(`{"query": "up"}`)).WithContext(ctx)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.wantCode, rr.Code)
+		})
+	}
+}
+
+// Test that hidden metrics are left out of listings and suggestions but can still be queried
+func TestHiddenMetrics(t *testing.T) {
+	promMux := http.NewServeMux()
+	promMux.HandleFunc("/api/v1/label/__name__/values", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status":"success","data":["go_goroutines","go_gc_duration_seconds","http_requests_total","up"]}`)
+	})
+	promMux.HandleFunc("/api/v1/query", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"__name__":%q},"value":[1609746000,"7"]}]}}`, r.FormValue("query"))
+	})
+	server := httptest.NewServer(promMux)
+	t.Cleanup(server.Close)
+
+	client, err := prometheus.NewClient(server.URL, logger.NewTestLogger(), cache.New(cache.DefaultOptions()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hidden := []*regexp.Regexp{regexp.MustCompile("^(?:go_.*)$")}
+
+	router := mux.NewRouter()
+	NewMetricsHandler(service.NewMetricsService(client, logger.NewTestLogger()).WithHiddenPatterns(hidden), logger.NewTestLogger()).RegisterRoutes(router)
+	NewQueriesHandler(service.NewQueriesService(client, logger.NewTestLogger()).WithHiddenPatterns(hidden), logger.NewTestLogger()).RegisterRoutes(router)
+
+	t.Run("listing", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response struct {
+			Metrics []string `json:"metrics"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, []string{"http_requests_total", "up"}, response.Metrics)
+	})
+
+	t.Run("suggestions", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/query/suggestions?prefix=go_&limit=20", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.NotContains(t, rr.Body.String(), "go_goroutines")
+		assert.NotContains(t, rr.Body.String(), "go_gc_duration_seconds")
+	})
+
+	t.Run("direct query", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/query", strings.NewReader(`{"query": "go_goroutines"}`)))
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response models.QueryResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if assert.Len(t, response.Data, 1) {
+			assert.Equal(t, "go_goroutines", response.Data[0].MetricName)
+		}
+	})
+}
+
+// Test searching metric names by regular expression
+func TestSearchMetrics(t *testing.T) {
+	var hits int
+	promMux := http.NewServeMux()
+	promMux.HandleFunc("/api/v1/label/__name__/values", func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		fmt.Fprint(w, `{"status":"success","data":["http_requests_total","http_request_duration_seconds","node_cpu_seconds_total","go_goroutines","up"]}`)
+	})
+	server := httptest.NewServer(promMux)
+	t.Cleanup(server.Close)
+
+	client, err := prometheus.NewClient(server.URL, logger.NewTestLogger(), cache.New(cache.DefaultOptions()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hidden := []*regexp.Regexp{regexp.MustCompile("^(?:go_.*)$")}
+
+	router := mux.NewRouter()
+	NewMetricsHandler(service.NewMetricsService(client, logger.NewTestLogger()).WithHiddenPatterns(hidden), logger.NewTestLogger()).RegisterRoutes(router)
+
+	search := func(t *testing.T, query string) (*httptest.ResponseRecorder, []string) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics?"+query, nil))
+
+		var response struct {
+			Metrics []string `json:"metrics"`
+			Count   int      `json:"count"`
+		}
+		if rr.Code == http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, len(response.Metrics), response.Count)
+		}
+		return rr, response.Metrics
+	}
+
+	t.Run("pattern", func(t *testing.T) {
+		rr, metrics := search(t, "search="+url.QueryEscape("^http_.*"))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, []string{"http_request_duration_seconds", "http_requests_total"}, metrics)
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		rr, metrics := search(t, "search=total&limit=1")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, []string{"http_requests_total"}, metrics)
+	})
+
+	t.Run("hidden", func(t *testing.T) {
+		rr, metrics := search(t, "search=go_")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.NotNil(t, metrics)
+		assert.Empty(t, metrics)
+	})
+
+	t.Run("invalid pattern", func(t *testing.T) {
+		rr, _ := search(t, "search="+url.QueryEscape("http_(requests"))
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+		var response ErrorResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		assert.Contains(t, response.Message, "missing closing )")
+	})
+
+	t.Run("invalid limit", func(t *testing.T) {
+		for _, limit := range []string{"0", "-1", "many"} {
+			rr, _ := search(t, "search=up&limit="+limit)
+			assert.Equal(t, http.StatusBadRequest, rr.Code, "limit %q", limit)
+		}
+	})
+
+	// Every search above was served from one fetch of the metric list
+	assert.Equal(t, 1, hits)
+}
+
+// Test saving, listing and running named queries
+func TestSavedQueries(t *testing.T) {
+	router := newTestQueriesRouter(t, newFakePrometheus(t, upResult("1")))
+
+	save := func(payload string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/queries/saved", strings.NewReader(payload)))
+		return rr
+	}
+
+	t.Run("save then run", func(t *testing.T) {
+		rr := save(`{"name": "targets-up", "query": "up", "description": "Scrape target health"}`)
+		assert.Equal(t, http.StatusCreated, rr.Code)
+
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/queries/saved", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var list struct {
+			Queries []models.SavedQuery `json:"queries"`
+			Count   int                 `json:"count"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil {
+			t.Fatal(err)
+		}
+		if assert.Equal(t, 1, list.Count) {
+			assert.Equal(t, "targets-up", list.Queries[0].Name)
+			assert.Equal(t, "Scrape target health", list.Queries[0].Description)
+		}
+
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/queries/saved/targets-up/run", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var response models.QueryResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "up", response.Query)
+		assert.Len(t, response.Data, 1)
+	})
+
+	t.Run("get records last use", func(t *testing.T) {
+		get := func() models.SavedQuery {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", "/queries/saved/targets-up", nil))
+			assert.Equal(t, http.StatusOK, rr.Code)
+			var saved models.SavedQuery
+			if err := json.Unmarshal(rr.Body.Bytes(), &saved); err != nil {
+				t.Fatal(err)
+			}
+			return saved
+		}
+
+		// Run by the previous subtest
+		saved := get()
+		assert.Equal(t, "up", saved.Query)
+		if assert.NotNil(t, saved.LastUsed) {
+			assert.False(t, saved.LastUsed.Before(saved.CreatedAt))
+		}
+
+		rr := save(`{"name": "never-run", "query": "up"}`)
+		assert.Equal(t, http.StatusCreated, rr.Code)
+		assert.NotContains(t, rr.Body.String(), "last_used")
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		save(`{"name": "doomed", "query": "up"}`)
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("DELETE", "/queries/saved/doomed", nil))
+		assert.Equal(t, http.StatusNoContent, rr.Code)
+
+		for _, method := range []string{"GET", "DELETE"} {
+			rr = httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest(method, "/queries/saved/doomed", nil))
+			assert.Equal(t, http.StatusNotFound, rr.Code, method)
+		}
+	})
+
+	t.Run("unknown name", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/queries/saved/missing/run", nil))
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("invalid query", func(t *testing.T) {
+		rr := save(`{"name": "broken", "query": "sum(rate(up[5m]"}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("invalid name", func(t *testing.T) {
+		rr := save(`{"name": "bad/name", "query": "up"}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
+
+// Test saving a summary baseline and comparing the current summary against it
+func TestMetricSummaryBaseline(t *testing.T) {
+	var current atomic.Value
+	current.Store("10")
+
+	promMux := http.NewServeMux()
+	promMux.HandleFunc("/api/v1/labels", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status":"success","data":["__name__","instance"]}`)
+	})
+	promMux.HandleFunc("/api/v1/metadata", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status":"success","data":{}}`)
+	})
+	promMux.HandleFunc("/api/v1/query", func(w http.ResponseWriter, r *http.Request) {
+		value := current.Load().(string)
+		query := r.FormValue("query")
+		switch {
+		case strings.HasPrefix(query, "count("):
+			value = "4"
+			if current.Load().(string) != "10" {
+				value = "6"
+			}
+		case strings.HasPrefix(query, "min_over_time("):
+			value = "1"
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"instance":"a"},"value":[1609746000,%q]}]}}`, value)
+	})
+	server := httptest.NewServer(promMux)
+	t.Cleanup(server.Close)
+
+	client, err := prometheus.NewClient(server.URL, logger.NewTestLogger(), cache.New(cache.DefaultOptions()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	NewMetricsHandler(service.NewMetricsService(client, logger.NewTestLogger()).WithCacheTTL(0), logger.NewTestLogger()).RegisterRoutes(router)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/metrics/summary/baselines", strings.NewReader(`{"name": "before-launch", "metric": "node_memory_used_bytes"}`)))
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	current.Store("25")
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics/summary/vs/before-launch", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var comparison models.BaselineComparison
+	if err := json.Unmarshal(rr.Body.Bytes(), &comparison); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "node_memory_used_bytes", comparison.Metric)
+	assert.Equal(t, 4, comparison.Baseline.Cardinality)
+	assert.Equal(t, 6, comparison.Current.Cardinality)
+	assert.Equal(t, models.MetricStats{Min: 1, Max: 10, Avg: 10}, comparison.Baseline.Stats)
+	assert.Equal(t, models.MetricStats{Min: 1, Max: 25, Avg: 25}, comparison.Current.Stats)
+	assert.Equal(t, models.MetricSummaryDelta{Cardinality: 2, Min: 0, Max: 15, Avg: 15}, comparison.Delta)
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics/summary/vs/missing", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+// Test that export jobs run in chunks, report progress and can be cancelled
+func TestExportJobs(t *testing.T) {
+	newRouter := func(t *testing.T, upstream http.HandlerFunc) *mux.Router {
+		promMux := http.NewServeMux()
+		promMux.HandleFunc("/api/v1/query_range", upstream)
+		server := httptest.NewServer(promMux)
+		t.Cleanup(server.Close)
+
+		client, err := prometheus.NewClient(server.URL, logger.NewTestLogger(), cache.New(cache.DefaultOptions()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router := mux.NewRouter()
+		NewExportHandler(service.NewExportService(client, logger.NewTestLogger()).WithChunkPoints(10), logger.NewTestLogger()).RegisterRoutes(router)
+		return router
+	}
+
+	getJob := func(t *testing.T, router *mux.Router, id string) models.ExportJob {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/export/jobs/"+id, nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var job models.ExportJob
+		if err := json.Unmarshal(rr.Body.Bytes(), &job); err != nil {
+			t.Fatal(err)
+		}
+		return job
+	}
+
+	// 0s to 3000s at a 60s step is 51 points, so 10-point chunks need 5 requests
+	body := `{"query": "up", "start": "1970-01-01T00:00:00Z", "end": "1970-01-01T00:50:00Z", "step": "60s"}`
+
+	t.Run("completes", func(t *testing.T) {
+		var hits int64
+		router := newRouter(t, func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&hits, 1)
+			start := r.FormValue("start")
+			fmt.Fprintf(w, `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"__name__":"up","job":"api"},"values":[[%s,"1"]]}]}}`, start)
+		})
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/export/jobs", strings.NewReader(body)))
+		assert.Equal(t, http.StatusAccepted, rr.Code)
+
+		var started models.ExportJob
+		if err := json.Unmarshal(rr.Body.Bytes(), &started); err != nil {
+			t.Fatal(err)
+		}
+		assert.NotEmpty(t, started.ID)
+		assert.Equal(t, 5, started.ChunksTotal)
+
+		assert.Eventually(t, func() bool {
+			return getJob(t, router, started.ID).Status == models.ExportJobCompleted
+		}, 2*time.Second, 10*time.Millisecond)
+
+		job := getJob(t, router, started.ID)
+		assert.Equal(t, 1.0, job.Progress)
+		assert.Equal(t, 5, job.ChunksDone)
+		assert.EqualValues(t, 5, atomic.LoadInt64(&hits))
+		if assert.NotNil(t, job.Result) && assert.Len(t, job.Result.Series, 1) {
+			assert.Len(t, job.Result.Series[0].DataPoints, 5)
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		router := newRouter(t, func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		})
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/export/jobs", strings.NewReader(body)))
+		assert.Equal(t, http.StatusAccepted, rr.Code)
+
+		var started models.ExportJob
+		if err := json.Unmarshal(rr.Body.Bytes(), &started); err != nil {
+			t.Fatal(err)
+		}
+
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("DELETE", "/export/jobs/"+started.ID, nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		job := getJob(t, router, started.ID)
+		assert.Equal(t, models.ExportJobCancelled, job.Status)
+		assert.Nil(t, job.Result)
+	})
+
+	t.Run("download", func(t *testing.T) {
+		router := newRouter(t, func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"__name__":"up","job":"api"},"values":[[%s,"1"]]}]}}`, r.FormValue("start"))
+		})
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/export/jobs", strings.NewReader(body)))
+		var started models.ExportJob
+		if err := json.Unmarshal(rr.Body.Bytes(), &started); err != nil {
+			t.Fatal(err)
+		}
+		assert.Eventually(t, func() bool {
+			return getJob(t, router, started.ID).Status == models.ExportJobCompleted
+		}, 2*time.Second, 10*time.Millisecond)
+		url := "/export/jobs/" + started.ID + "/download"
+
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", url, nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "bytes", rr.Header().Get("Accept-Ranges"))
+		assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+		full := rr.Body.Bytes()
+		var result models.RangeQueryResponse
+		if err := json.Unmarshal(full, &result); err != nil {
+			t.Fatal(err)
+		}
+		assert.Len(t, result.Series, 1)
+
+		req := httptest.NewRequest("GET", url, nil)
+		req.Header.Set("Range", "bytes=10-29")
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusPartialContent, rr.Code)
+		assert.Equal(t, fmt.Sprintf("bytes 10-29/%d", len(full)), rr.Header().Get("Content-Range"))
+		assert.Equal(t, full[10:30], rr.Body.Bytes())
+
+		// Resuming from an offset returns the rest of the file
+		req = httptest.NewRequest("GET", url, nil)
+		req.Header.Set("Range", "bytes=100-")
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusPartialContent, rr.Code)
+		assert.Equal(t, full[100:], rr.Body.Bytes())
+	})
+
+	t.Run("download before completion", func(t *testing.T) {
+		router := newRouter(t, func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		})
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/export/jobs", strings.NewReader(body)))
+		var started models.ExportJob
+		if err := json.Unmarshal(rr.Body.Bytes(), &started); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/export/jobs/"+started.ID, nil))
+		})
+
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/export/jobs/"+started.ID+"/download", nil))
+		assert.Equal(t, http.StatusConflict, rr.Code)
+	})
+
+	t.Run("failure is sanitized", func(t *testing.T) {
+		router := newRouter(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			fmt.Fprint(w, `{"status":"error","errorType":"execution","error":"read from db-7.internal:9090 failed"}`)
+		})
+		var logs bytes.Buffer
+		sanitized := SanitizeErrors(logger.NewLogger(logger.WithOutput(&logs)))(router)
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/export/jobs", strings.NewReader(body)))
+		var started models.ExportJob
+		if err := json.Unmarshal(rr.Body.Bytes(), &started); err != nil {
+			t.Fatal(err)
+		}
+		assert.Eventually(t, func() bool {
+			return getJob(t, router, started.ID).Status == models.ExportJobFailed
+		}, 2*time.Second, 10*time.Millisecond)
+
+		// Full mode shows the cause, sanitized mode only logs it
+		assert.Contains(t, getJob(t, router, started.ID).Error, "db-7.internal")
+
+		rr = httptest.NewRecorder()
+		sanitized.ServeHTTP(rr, httptest.NewRequest("GET", "/export/jobs/"+started.ID, nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.NotContains(t, rr.Body.String(), "db-7.internal")
+		assert.Contains(t, rr.Body.String(), sanitizedErrorMessage)
+		assert.Contains(t, logs.String(), "db-7.internal")
+	})
+
+	t.Run("too many running", func(t *testing.T) {
+		router := newRouter(t, func(w http.ResponseWriter, r *http.Request) {
+			// The request context is only cancelled on disconnect once the
+			// body has been read
+			r.ParseForm()
+			<-r.Context().Done()
+		})
+		start := func() (int, models.ExportJob) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("POST", "/export/jobs", strings.NewReader(body)))
+			var job models.ExportJob
+			json.Unmarshal(rr.Body.Bytes(), &job)
+			if rr.Code == http.StatusAccepted {
+				t.Cleanup(func() {
+					router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/export/jobs/"+job.ID, nil))
+				})
+			}
+			return rr.Code, job
+		}
+
+		var jobs []models.ExportJob
+		for i := 0; i < service.MaxExportJobs; i++ {
+			code, job := start()
+			if code != http.StatusAccepted {
+				t.Fatalf("export %d: got status %d", i, code)
+			}
+			jobs = append(jobs, job)
+		}
+
+		// A new export would evict a running job, so it is rejected instead
+		code, _ := start()
+		assert.Equal(t, http.StatusTooManyRequests, code)
+		assert.Equal(t, models.ExportJobRunning, getJob(t, router, jobs[0].ID).Status)
+
+		// Once a job is cancelled there is room, but the job cache is full, so
+		// the oldest job is evicted and cancelled rather than left running
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("DELETE", "/export/jobs/"+jobs[len(jobs)-1].ID, nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Eventually(t, func() bool {
+			code, _ := start()
+			return code == http.StatusAccepted
+		}, 2*time.Second, 10*time.Millisecond)
+
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/export/jobs/"+jobs[0].ID, nil))
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		assert.Eventually(t, func() bool {
+			code, _ := start()
+			return code == http.StatusAccepted
+		}, 2*time.Second, 10*time.Millisecond, "the evicted job should stop running")
+	})
+
+	t.Run("unknown job", func(t *testing.T) {
+		router := newRouter(t, func(w http.ResponseWriter, r *http.Request) {})
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/export/jobs/missing", nil))
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+}
+
+// Test that metric health reports staleness and gaps from sample age and counts
+func TestGetMetricHealth(t *testing.T) {
+	tests := []struct {
+		name      string
+		age       string
+		samples   string
+		wantStale bool
+		wantGaps  bool
+	}{
+		{name: "fresh", age: "12", samples: "10", wantStale: false, wantGaps: false},
+		{name: "stale", age: "300", samples: "9", wantStale: true, wantGaps: false},
+		{name: "gappy", age: "12", samples: "7", wantStale: false, wantGaps: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fp := newFakePrometheusByQuery(t, map[string]string{
+				"count(node_load1)":                       upResult("2"),
+				"time() - max(timestamp(node_load1))":     upResult(tt.age),
+				"min(count_over_time(node_load1[2m30s]))": upResult(tt.samples),
+			})
+			client, err := prometheus.NewClient(fp.server.URL, logger.NewTestLogger(), cache.New(cache.DefaultOptions()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			svc := service.NewMetricsService(client, logger.NewTestLogger()).
+				WithStalenessThreshold(2 * time.Minute).
+				WithScrapeInterval(15 * time.Second)
+
+			router := mux.NewRouter()
+			NewMetricsHandler(svc, logger.NewTestLogger()).RegisterRoutes(router)
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics/node_load1/health", nil))
+			assert.Equal(t, http.StatusOK, rr.Code)
+
+			var health models.MetricHealth
+			if err := json.Unmarshal(rr.Body.Bytes(), &health); err != nil {
+				t.Fatal(err)
+			}
+			assert.True(t, health.Exists)
+			assert.Equal(t, tt.wantStale, health.IsStale)
+			assert.Equal(t, tt.wantGaps, health.HasGaps)
+			assert.False(t, health.LastScraped.IsZero())
+		})
+	}
+}
+
+// Test that quantiles are computed over the current samples, optionally per group
+func TestGetMetricQuantile(t *testing.T) {
+	fp := newFakePrometheusByQuery(t, map[string]string{
+		"node_load1": `[
+			{"metric":{"instance":"a","zone":"east"},"value":[1609746000,"1"]},
+			{"metric":{"instance":"b","zone":"east"},"value":[1609746000,"2"]},
+			{"metric":{"instance":"c","zone":"east"},"value":[1609746000,"3"]},
+			{"metric":{"instance":"d","zone":"west"},"value":[1609746000,"4"]},
+			{"metric":{"instance":"e","zone":"west"},"value":[1609746000,"5"]}
+		]`,
+	})
+	client, err := prometheus.NewClient(fp.server.URL, logger.NewTestLogger(), cache.New(cache.DefaultOptions()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	NewMetricsHandler(service.NewMetricsService(client, logger.NewTestLogger()), logger.NewTestLogger()).RegisterRoutes(router)
+
+	get := func(t *testing.T, url string) models.MetricQuantile {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", url, nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var quantile models.MetricQuantile
+		if err := json.Unmarshal(rr.Body.Bytes(), &quantile); err != nil {
+			t.Fatal(err)
+		}
+		return quantile
+	}
+
+	t.Run("all series", func(t *testing.T) {
+		quantile := get(t, "/metrics/node_load1/quantile?q=0.9")
+		assert.Equal(t, 0.9, quantile.Quantile)
+		if assert.Len(t, quantile.Results, 1) {
+			assert.InDelta(t, 4.6, quantile.Results[0].Value, 1e-9)
+			assert.Equal(t, 5, quantile.Results[0].Count)
+		}
+	})
+
+	t.Run("grouped", func(t *testing.T) {
+		quantile := get(t, "/metrics/node_load1/quantile?q=0.5&by=zone")
+		assert.Equal(t, []models.QuantileGroup{
+			{Labels: map[string]string{"zone": "east"}, Value: 2, Count: 3},
+			{Labels: map[string]string{"zone": "west"}, Value: 4.5, Count: 2},
+		}, quantile.Results)
+	})
+
+	for _, q := range []string{"0", "1", "1.5", "-0.1", "abc", ""} {
+		t.Run("invalid q "+q, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics/node_load1/quantile?q="+q, nil))
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+		})
+	}
+
+	// Anything but a metric name would run as arbitrary PromQL
+	for _, path := range []string{
+		"/metrics/" + url.PathEscape("sum(node_load1)") + "/quantile?q=0.5",
+		"/metrics/node_load1/quantile?q=0.5&by=" + url.QueryEscape("zone)"),
+	} {
+		t.Run("invalid name "+path, func(t *testing.T) {
+			hits := fp.Hits()
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+			assert.Equal(t, hits, fp.Hits(), "nothing should be sent to Prometheus")
+		})
+	}
+}
+
+// Test that ?fields= projects each data point onto the requested fields only
+func TestInstantQueryFields(t *testing.T) {
+	fp := newFakePrometheus(t, upResult("1"))
+	router := newTestQueriesRouter(t, fp)
+
+	query := func(t *testing.T, url string) map[string]interface{} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", url, strings.NewReader(`{"query": "up"}`)))
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response struct {
+			Query string                   `json:"query"`
+			Data  []map[string]interface{} `json:"data"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "up", response.Query)
+		if !assert.Len(t, response.Data, 1) {
+			t.FailNow()
+		}
+		return response.Data[0]
+	}
+
+	t.Run("default returns all fields", func(t *testing.T) {
+		point := query(t, "/query")
+		assert.Contains(t, point, "metric_name")
+		assert.Contains(t, point, "labels")
+		assert.Contains(t, point, "value")
+		assert.Contains(t, point, "timestamp")
+	})
+
+	t.Run("projection", func(t *testing.T) {
+		point := query(t, "/query?fields=value,labels")
+		assert.Equal(t, map[string]interface{}{
+			"value":  1.0,
+			"labels": map[string]interface{}{"job": "prometheus"},
+		}, point)
+	})
+}
+
+// Test that format=table asked for as JSON lays series with differing label sets out under the union of their labels
+func TestInstantQueryTable(t *testing.T) {
+	fp := newFakePrometheus(t, `[
+		{"metric":{"instance":"a:9100","job":"api"},"value":[1609746000,"1"]},
+		{"metric":{"job":"db","region":"eu"},"value":[1609746000,"2"]},
+		{"metric":{"instance":"b:9100"},"value":[1609746000,"3"]}
+	]`)
+	router := newTestQueriesRouter(t, fp)
+
+	req := httptest.NewRequest("POST", "/query?format=table", strings.NewReader(`{"query": "sum by (instance, job, region) (up)"}`))
+	req.Header.Set("Accept", "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var table models.QueryTable
+	if err := json.Unmarshal(rr.Body.Bytes(), &table); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"instance", "job", "region", "value"}, table.Columns)
+	assert.ElementsMatch(t, [][]interface{}{
+		{"a:9100", "api", "", 1.0},
+		{"", "db", "eu", 2.0},
+		{"b:9100", "", "", 3.0},
+	}, table.Rows)
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/query?format=xml", strings.NewReader(`{"query": "up"}`)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+// Test that format=csv writes instant query results with a column per label
+// followed by value and timestamp
+func TestInstantQueryCSV(t *testing.T) {
+	fp := newFakePrometheus(t, `[
+		{"metric":{"instance":"a:9100","job":"api"},"value":[1609746000,"1"]},
+		{"metric":{"job":"db","region":"eu"},"value":[1609746000,"2.5"]}
+	]`)
+	router := newTestQueriesRouter(t, fp)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/query?format=csv", strings.NewReader(`{"query": "sum by (instance, job, region) (up)"}`)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", rr.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="query.csv"`, rr.Header().Get("Content-Disposition"))
+
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, records, 3) {
+		assert.Equal(t, []string{"instance", "job", "region", "value", "timestamp"}, records[0])
+		assert.ElementsMatch(t, [][]string{
+			{"a:9100", "api", "", "1", "2021-01-04T07:40:00Z"},
+			{"", "db", "eu", "2.5", "2021-01-04T07:40:00Z"},
+		}, records[1:])
+	}
+}
+
+// Test that format=csv writes a range query as one row per data point,
+// grouped by series and prefixed with its labels
+func TestRangeQueryCSV(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"matrix","result":[
+			{"metric":{"job":"api"},"values":[[1609743600,"1"],[1609743660,"2"]]},
+			{"metric":{"job":"db","instance":"b:9100"},"values":[[1609743600,"3"]]}
+		]}}`)
+	}))
+	t.Cleanup(server.Close)
+	router := newTestQueriesRouter(t, &fakePrometheus{server: server})
+	body := `{"query": "up", "start": "2021-01-04T07:00:00Z", "end": "2021-01-04T07:01:00Z", "step": "1m"}`
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/query/range?format=csv", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", rr.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="query-range.csv"`, rr.Header().Get("Content-Disposition"))
+
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, [][]string{
+		{"instance", "job", "timestamp", "value"},
+		{"", "api", "2021-01-04T07:00:00Z", "1"},
+		{"", "api", "2021-01-04T07:01:00Z", "2"},
+		{"b:9100", "db", "2021-01-04T07:00:00Z", "3"},
+	}, records)
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/query/range?format=xml", strings.NewReader(body)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+// Test that format=table writes an instant query result as an aligned text
+// table with one row per series
+func TestInstantQueryText(t *testing.T) {
+	fp := newFakePrometheus(t, `[
+		{"metric":{"__name__":"up","instance":"a:9100","job":"api"},"value":[1609746000,"1"]},
+		{"metric":{"__name__":"up","job":"db"},"value":[1609746000,"0"]}
+	]`)
+	router := newTestQueriesRouter(t, fp)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/query?format=table", strings.NewReader(`{"query": "up"}`)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/plain; charset=utf-8", rr.Header().Get("Content-Type"))
+
+	lines := strings.Split(strings.TrimSuffix(rr.Body.String(), "\n"), "\n")
+	if assert.Len(t, lines, 3) {
+		assert.Equal(t, []string{"METRIC", "LABELS", "VALUE", "TIMESTAMP"}, strings.Fields(lines[0]))
+		assert.Contains(t, lines[1], `{instance="a:9100", job="api"}`)
+		assert.Contains(t, lines[2], `{job="db"}`)
+		// Columns line up across rows
+		assert.Equal(t, strings.Index(lines[0], "VALUE"), strings.Index(lines[1], "1 "))
+	}
+}
+
+// Test that format=table writes a range query result as one text block per
+// series with a row per data point
+func TestRangeQueryText(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"matrix","result":[
+			{"metric":{"job":"api"},"values":[[1609743600,"1"],[1609743660,"2"]]},
+			{"metric":{"job":"db","instance":"b:9100"},"values":[[1609743600,"3"]]}
+		]}}`)
+	}))
+	t.Cleanup(server.Close)
+	router := newTestQueriesRouter(t, &fakePrometheus{server: server})
+	body := `{"query": "up", "start": "2021-01-04T07:00:00Z", "end": "2021-01-04T07:01:00Z", "step": "1m"}`
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/query/range?format=table", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/plain; charset=utf-8", rr.Header().Get("Content-Type"))
+
+	blocks := strings.Split(strings.TrimSuffix(rr.Body.String(), "\n"), "\n\n")
+	if assert.Len(t, blocks, 2) {
+		api := strings.Split(blocks[0], "\n")
+		assert.Equal(t, `{job="api"}`, api[0])
+		assert.Equal(t, []string{"TIMESTAMP", "VALUE"}, strings.Fields(api[1]))
+		assert.Len(t, api[2:], 2)
+		assert.Equal(t, []string{"2021-01-04T07:01:00Z", "2"}, strings.Fields(api[3]))
+
+		db := strings.Split(blocks[1], "\n")
+		assert.Equal(t, `{instance="b:9100", job="db"}`, db[0])
+		assert.Len(t, db[2:], 1)
+	}
+}
+
+// streamRecorder is a flushable ResponseWriter that can be inspected while a
+// handler is still streaming to it
+type streamRecorder struct {
+	mu         sync.Mutex
+	header     http.Header
+	events     int
+	keepalives []time.Time
+}
+
+func (s *streamRecorder) Header() http.Header { return s.header }
+func (s *streamRecorder) WriteHeader(int)     {}
+func (s *streamRecorder) Flush()              {}
+
+func (s *streamRecorder) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case bytes.HasPrefix(b, []byte(": keepalive")):
+		s.keepalives = append(s.keepalives, time.Now())
+	case bytes.HasPrefix(b, []byte("event: ")):
+		s.events++
+	}
+	return len(b), nil
+}
+
+func (s *streamRecorder) counts() (int, []time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.events, append([]time.Time(nil), s.keepalives...)
+}
+
+// Test that a quiet watch stream sends keepalive comments at the heartbeat
+// interval and stops once the client goes away
+func TestWatchQueryHeartbeat(t *testing.T) {
+	fp := newFakePrometheus(t, upResult("1"))
+	client, err := prometheus.NewClient(fp.server.URL, logger.NewTestLogger(), cache.New(cache.DefaultOptions()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	heartbeat := 40 * time.Millisecond
+	router := mux.NewRouter()
+	NewQueriesHandler(service.NewQueriesService(client, logger.NewTestLogger()), logger.NewTestLogger()).
+		WithHeartbeatInterval(heartbeat).
+		RegisterRoutes(router)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rec := &streamRecorder{header: make(http.Header)}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// The result never changes within the hour-long poll interval
+		router.ServeHTTP(rec, httptest.NewRequest("GET", "/query/watch?query=up&interval=1h", nil).WithContext(ctx))
+	}()
+
+	time.Sleep(5*heartbeat + heartbeat/2)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("watch stream did not stop after the context was cancelled")
+	}
+
+	assert.Equal(t, "text/event-stream", rec.Header().Get("Content-Type"))
+	events, keepalives := rec.counts()
+	assert.Equal(t, 1, events, "only the initial result should be sent as an event")
+	assert.InDelta(t, 5, len(keepalives), 1)
+	for i := 1; i < len(keepalives); i++ {
+		gap := keepalives[i].Sub(keepalives[i-1])
+		assert.InDelta(t, float64(heartbeat), float64(gap), float64(heartbeat)/2, "keepalive %d came after %s", i, gap)
+	}
+
+	time.Sleep(2 * heartbeat)
+	_, after := rec.counts()
+	assert.Equal(t, len(keepalives), len(after), "no keepalives should be sent after cancellation")
+}
+
+// Test that a query stream sends a numbered metrics event per interval until
+// the client goes away, and ends by itself at the maximum duration
+func TestStreamQuery(t *testing.T) {
+	fp := newFakePrometheus(t, upResult("1"))
+	client, err := prometheus.NewClient(fp.server.URL, logger.NewTestLogger(), cache.New(cache.DefaultOptions()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newServer := func(t *testing.T, maxDuration time.Duration) *httptest.Server {
+		router := mux.NewRouter()
+		NewQueriesHandler(service.NewQueriesService(client, logger.NewTestLogger()), logger.NewTestLogger()).
+			WithMaxStreamDuration(maxDuration).
+			RegisterRoutes(router)
+		server := httptest.NewServer(router)
+		t.Cleanup(server.Close)
+		return server
+	}
+
+	type event struct {
+		id, name string
+		data     models.QueryResponse
+	}
+	// readEvent reads lines up to the blank line ending the next event
+	readEvent := func(t *testing.T, scanner *bufio.Scanner) (event, bool) {
+		var e event
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				if e.name != "" {
+					return e, true
+				}
+			case strings.HasPrefix(line, "id: "):
+				e.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				e.name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e.data); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		return e, false
+	}
+
+	t.Run("until the client disconnects", func(t *testing.T) {
+		server := newServer(t, time.Hour)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/query/stream?query=up&interval=1s", nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		scanner := bufio.NewScanner(resp.Body)
+		for i := 1; i <= 2; i++ {
+			e, ok := readEvent(t, scanner)
+			if !assert.True(t, ok, "event %d", i) {
+				return
+			}
+			assert.Equal(t, strconv.Itoa(i), e.id)
+			assert.Equal(t, "metrics", e.name)
+			if assert.Len(t, e.data.Data, 1) {
+				assert.Equal(t, 1.0, e.data.Data[0].Value)
+			}
+		}
+
+		hits := fp.Hits()
+		cancel()
+		time.Sleep(1500 * time.Millisecond)
+		assert.Equal(t, hits, fp.Hits(), "the query should stop running once the client is gone")
+	})
+
+	t.Run("until the maximum duration", func(t *testing.T) {
+		server := newServer(t, 100*time.Millisecond)
+
+		resp, err := http.Get(server.URL + "/query/stream?query=up&interval=1s")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		start := time.Now()
+		scanner := bufio.NewScanner(resp.Body)
+		_, ok := readEvent(t, scanner)
+		assert.True(t, ok)
+		_, ok = readEvent(t, scanner)
+		assert.False(t, ok, "the stream should end before a second event")
+		assert.Less(t, time.Since(start), time.Second)
+	})
+
+	t.Run("invalid interval", func(t *testing.T) {
+		resp, err := http.Get(newServer(t, time.Hour).URL + "/query/stream?query=up&interval=10ms")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+// Test that sanitized mode replaces upstream error text with a generic
+// message and the request ID while logging the full error
+func TestSanitizedErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.FormValue("query") == "broken" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			fmt.Fprint(w, `{"status":"error","errorType":"execution","error":"read from db-7.internal:9090 failed"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":%s}}`, upResult("1"))
+	}))
+	t.Cleanup(server.Close)
+
+	newRouter := func(t *testing.T, sanitize bool) (*mux.Router, *bytes.Buffer) {
+		var logs bytes.Buffer
+		log := logger.NewLogger(logger.WithOutput(&logs))
+		client, err := prometheus.NewClient(server.URL, logger.NewTestLogger(), cache.New(cache.DefaultOptions()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		router := mux.NewRouter()
+		router.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Request-ID", "req-123")
+				next.ServeHTTP(w, r)
+			})
+		})
+		if sanitize {
+			router.Use(SanitizeErrors(log))
+		}
+		NewQueriesHandler(service.NewQueriesService(client, logger.NewTestLogger()), log).RegisterRoutes(router)
+		return router, &logs
+	}
+	batch := `{"queries": [{"query": "up"}, {"query": "broken"}]}`
+
+	t.Run("full", func(t *testing.T) {
+		router, _ := newRouter(t, false)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/query/batch", strings.NewReader(batch)))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), "db-7.internal")
+	})
+
+	t.Run("sanitized partial failure", func(t *testing.T) {
+		router, logs := newRouter(t, true)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/query/batch", strings.NewReader(batch)))
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response struct {
+			Errors map[string]string `json:"errors"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, map[string]string{"1": sanitizedErrorMessage}, response.Errors)
+		assert.NotContains(t, rr.Body.String(), "db-7.internal")
+		assert.Contains(t, logs.String(), "db-7.internal")
+		assert.Contains(t, logs.String(), `"request_id":"req-123"`)
+	})
+
+	t.Run("sanitized upstream error", func(t *testing.T) {
+		router, logs := newRouter(t, true)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/query", strings.NewReader(`{"query": "broken"}`)))
+		assert.Equal(t, http.StatusInternalServerError, rr.Code)
+
+		var response ErrorResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "Failed to execute query", response.Message)
+		assert.Equal(t, "req-123", response.RequestID)
+		assert.NotContains(t, rr.Body.String(), "db-7.internal")
+		assert.Contains(t, logs.String(), "db-7.internal")
+		assert.Contains(t, logs.String(), `"request_id":"req-123"`)
+	})
+}
+
+// Test that steps below the minimum are raised with a warning in clamp mode
+// and rejected in reject mode
+func TestRangeQueryMinStep(t *testing.T) {
+	var upstreamStep atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamStep.Store(r.FormValue("step"))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"job":"api"},"values":[[1609746000,"1"]]}]}}`)
+	}))
+	t.Cleanup(server.Close)
+
+	newRouter := func(t *testing.T, policy string) *mux.Router {
+		client, err := prometheus.NewClient(server.URL, logger.NewTestLogger(), cache.New(cache.DefaultOptions()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		svc := service.NewQueriesService(client, logger.NewTestLogger()).WithMinStep(time.Minute, policy)
+		router := mux.NewRouter()
+		NewQueriesHandler(svc, logger.NewTestLogger()).RegisterRoutes(router)
+		return router
+	}
+	body := `{"query": "up", "start": "2021-01-04T06:00:00Z", "end": "2021-01-04T07:00:00Z", "step": "1s"}`
+
+	t.Run("clamp", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		newRouter(t, service.StepPolicyClamp).ServeHTTP(rr, httptest.NewRequest("POST", "/query/range?nocache=true", strings.NewReader(body)))
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response models.RangeQueryResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, time.Minute, response.Step)
+		assert.Equal(t, "60", upstreamStep.Load())
+		if assert.Len(t, response.Warnings, 1) {
+			assert.Contains(t, response.Warnings[0], "raised")
+		}
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		newRouter(t, service.StepPolicyReject).ServeHTTP(rr, httptest.NewRequest("POST", "/query/range?nocache=true", strings.NewReader(body)))
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), "step is below the minimum")
+	})
+
+	t.Run("steps at the minimum pass unchanged", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		atMinimum := strings.Replace(body, `"1s"`, `"1m"`, 1)
+		newRouter(t, service.StepPolicyReject).ServeHTTP(rr, httptest.NewRequest("POST", "/query/range?nocache=true", strings.NewReader(atMinimum)))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.NotContains(t, rr.Body.String(), "warnings")
+	})
+}
+
+// Test that the connectivity check reports every step against a working
+// server and a failure message for each step against an unreachable one
+func TestPrometheusConnectivityCheck(t *testing.T) {
+	type checkResponse struct {
+		Target  string                 `json:"target"`
+		Success bool                   `json:"success"`
+		Steps   []prometheus.CheckStep `json:"steps"`
+	}
+	check := func(t *testing.T, url string) checkResponse {
+		client, err := prometheus.NewClient(url, logger.NewTestLogger(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router := mux.NewRouter()
+		NewPrometheusHandler(client.WithTimeout(time.Second), logger.NewTestLogger()).RegisterAdminRoutes(router.PathPrefix("/admin").Subrouter())
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/prometheus/check", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response checkResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, url, response.Target)
+		if assert.Len(t, response.Steps, 3) {
+			for i, name := range []string{"buildinfo", "query", "labels"} {
+				assert.Equal(t, name, response.Steps[i].Name)
+			}
+		}
+		return response
+	}
+
+	t.Run("reachable", func(t *testing.T) {
+		promMux := http.NewServeMux()
+		promMux.HandleFunc("/api/v1/status/buildinfo", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"status":"success","data":{"version":"2.53.0","revision":"abc","branch":"HEAD","buildUser":"","buildDate":"","goVersion":"go1.22"}}`)
+		})
+		promMux.HandleFunc("/api/v1/query", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"scalar","result":[1609746000,"1"]}}`)
+		})
+		promMux.HandleFunc("/api/v1/labels", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"status":"success","data":["__name__","job"]}`)
+		})
+		server := httptest.NewServer(promMux)
+		t.Cleanup(server.Close)
+
+		response := check(t, server.URL)
+		assert.True(t, response.Success)
+		for _, step := range response.Steps {
+			assert.True(t, step.Success, "step %s", step.Name)
+			assert.Empty(t, step.Error)
+		}
+		assert.Equal(t, "Prometheus 2.53.0", response.Steps[0].Detail)
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+
+		response := check(t, server.URL)
+		assert.False(t, response.Success)
+		for _, step := range response.Steps {
+			assert.False(t, step.Success, "step %s", step.Name)
+			assert.Contains(t, step.Error, "connection refused", "step %s", step.Name)
+		}
+	})
+
+	t.Run("client without targets", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+		client, err := prometheus.NewQueryClient(server.URL, logger.NewTestLogger(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router := mux.NewRouter()
+		NewPrometheusHandler(client, logger.NewTestLogger()).RegisterAdminRoutes(router.PathPrefix("/admin").Subrouter())
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/prometheus/check", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.NotContains(t, rr.Body.String(), `"target"`)
+	})
+}
+
+// Test that only alerts that changed state often within the window are reported as flapping
+func TestGetFlappingAlerts(t *testing.T) {
+	flappy := models.Alert{Name: "DiskLatencyHigh", State: "firing", Severity: "warning", Labels: map[string]string{"instance": "db-1"}}
+	stable := models.Alert{Name: "InstanceDown", State: "firing", Severity: "critical", Labels: map[string]string{"instance": "web-1"}}
+
+	history := service.NewAlertHistory(time.Hour)
+	start := time.Now().Add(-10 * time.Minute)
+	history.Record([]models.Alert{stable}, start)
+	for i := 1; i <= 6; i++ {
+		snapshot := []models.Alert{stable}
+		if i%2 == 1 {
+			snapshot = append(snapshot, flappy)
+		}
+		history.Record(snapshot, start.Add(time.Duration(i)*time.Minute))
+	}
+
+	router := mux.NewRouter()
+	NewAlertsHandler(service.NewAlertsService(nil, logger.NewTestLogger()).WithHistory(history), logger.NewTestLogger()).RegisterRoutes(router)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/alerts/flapping?window=30m&min_transitions=4", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var response struct {
+		Alerts []models.FlappingAlert `json:"alerts"`
+		Count  int                    `json:"count"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if assert.Equal(t, 1, response.Count) {
+		assert.Equal(t, "DiskLatencyHigh", response.Alerts[0].Name)
+		assert.Equal(t, 6, response.Alerts[0].Transitions)
+		assert.Equal(t, "inactive", response.Alerts[0].State)
+	}
+
+	// Transitions outside the window are not counted
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/alerts/flapping?window=2m&min_transitions=4", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"count":0`)
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/alerts/flapping?min_transitions=0", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+// Test that ?debug=true adds a Server-Timing breakdown to query responses
+func TestQueryServerTiming(t *testing.T) {
+	promMux := http.NewServeMux()
+	promMux.HandleFunc("/api/v1/query", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":%s}}`, upResult("1"))
+	})
+	promMux.HandleFunc("/api/v1/query_range", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"__name__":"up"},"values":[[1609743600,"1"]]}]}}`)
+	})
+	server := httptest.NewServer(promMux)
+	t.Cleanup(server.Close)
+
+	client, err := prometheus.NewClient(server.URL, logger.NewTestLogger(), cache.New(cache.DefaultOptions()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	NewQueriesHandler(service.NewQueriesService(client, logger.NewTestLogger()), logger.NewTestLogger()).RegisterRoutes(router)
+
+	serverTiming := regexp.MustCompile(`^prometheus;dur=[0-9.]+, transform;dur=[0-9.]+, total;dur=[0-9.]+$`)
+
+	requests := map[string]string{
+		"/query":       `{"query": "up"}`,
+		"/query/range": `{"query": "up", "start": "2021-01-04T07:00:00Z", "end": "2021-01-04T08:00:00Z", "step": "1m"}`,
+	}
+	for path, body := range requests {
+		t.Run(path+" with debug", func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("POST", path+"?debug=true", strings.NewReader(body)))
+			assert.Equal(t, http.StatusOK, rr.Code)
+			assert.Regexp(t, serverTiming, rr.Header().Get("Server-Timing"))
+		})
+
+		t.Run(path+" without debug", func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("POST", path, strings.NewReader(body)))
+			assert.Equal(t, http.StatusOK, rr.Code)
+			assert.Empty(t, rr.Header().Get("Server-Timing"))
+		})
+	}
+}
+
+// Test that batches are capped in size and run with bounded concurrency
+func TestBatchQuery(t *testing.T) {
+	var inFlight, maxInFlight int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := atomic.AddInt64(&inFlight, 1)
+		defer atomic.AddInt64(&inFlight, -1)
+		for {
+			seen := atomic.LoadInt64(&maxInFlight)
+			if current <= seen || atomic.CompareAndSwapInt64(&maxInFlight, seen, current) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":%s}}`, upResult("1"))
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := prometheus.NewClient(server.URL, logger.NewTestLogger(), cache.New(cache.DefaultOptions()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := service.NewQueriesService(client, logger.NewTestLogger()).
+		WithMaxBatchQueries(20).
+		WithBatchConcurrency(3)
+	router := mux.NewRouter()
+	NewQueriesHandler(svc, logger.NewTestLogger()).RegisterRoutes(router)
+
+	batch := func(n int) string {
+		queries := make([]string, n)
+		for i := range queries {
+			queries[i] = fmt.Sprintf(`{"query": "up{instance=\"%d\"}"}`, i)
+		}
+		return `{"queries": [` + strings.Join(queries, ",") + `]}`
+	}
+
+	t.Run("at the limit", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/query/batch?nocache=true", strings.NewReader(batch(20))))
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response struct {
+			Results []models.QueryResponse `json:"results"`
+			Count   int                    `json:"count"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, 20, response.Count)
+		assert.Equal(t, `up{instance="7"}`, response.Results[7].Query)
+		assert.LessOrEqual(t, atomic.LoadInt64(&maxInFlight), int64(3))
+		assert.Greater(t, atomic.LoadInt64(&maxInFlight), int64(1))
+	})
+
+	t.Run("over the limit", func(t *testing.T) {
+		atomic.StoreInt64(&maxInFlight, 0)
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/query/batch", strings.NewReader(batch(21))))
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), "got 21 queries, the limit is 20")
+		assert.Zero(t, atomic.LoadInt64(&maxInFlight))
+	})
+}
+
+// Test that rule groups are listed, filtered by type, looked up by name and
+// cached between requests
+func TestRules(t *testing.T) {
+	var hits int64
+	promMux := http.NewServeMux()
+	promMux.HandleFunc("/api/v1/rules", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		fmt.Fprint(w, `{"status":"success","data":{"groups":[
+			{"name":"node","file":"/etc/prometheus/node.yml","interval":30,"rules":[
+				{"type":"alerting","name":"InstanceDown","query":"up == 0","duration":300,
+				 "labels":{"severity":"critical"},"annotations":{"summary":"Instance down"},
+				 "alerts":[{"labels":{"alertname":"InstanceDown"},"annotations":{},"state":"firing","activeAt":"2021-01-04T07:30:00Z","value":"0"}],
+				 "health":"ok","evaluationTime":0.001,"lastEvaluation":"2021-01-04T07:40:00Z","state":"firing"},
+				{"type":"recording","name":"job:up:sum","query":"sum by (job) (up)",
+				 "health":"ok","evaluationTime":0.002,"lastEvaluation":"2021-01-04T07:40:00Z"}
+			]},
+			{"name":"recordings","file":"/etc/prometheus/recordings.yml","interval":60,"rules":[
+				{"type":"recording","name":"instance:cpu:rate5m","query":"rate(cpu[5m])",
+				 "health":"err","lastError":"bad data","evaluationTime":0.003,"lastEvaluation":"2021-01-04T07:40:00Z"}
+			]}
+		]}}`)
+	})
+	server := httptest.NewServer(promMux)
+	t.Cleanup(server.Close)
+
+	client, err := prometheus.NewClient(server.URL, logger.NewTestLogger(), cache.New(cache.DefaultOptions()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	NewRulesHandler(client, logger.NewTestLogger()).RegisterRoutes(router)
+
+	type rulesResponse struct {
+		Groups []models.RuleGroup `json:"groups"`
+		Count  int                `json:"count"`
+	}
+	list := func(t *testing.T, target string) rulesResponse {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", target, nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response rulesResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	t.Run("all", func(t *testing.T) {
+		response := list(t, "/rules")
+		assert.Equal(t, 2, response.Count)
+		if assert.Len(t, response.Groups, 2) && assert.Len(t, response.Groups[0].Rules, 2) {
+			alerting := response.Groups[0].Rules[0]
+			assert.Equal(t, models.RuleTypeAlerting, alerting.Type)
+			assert.Equal(t, "InstanceDown", alerting.Name)
+			assert.Equal(t, "firing", alerting.State)
+			assert.Equal(t, 1, alerting.ActiveAlerts)
+			assert.Equal(t, "critical", alerting.Labels["severity"])
+			assert.Equal(t, models.RuleTypeRecording, response.Groups[0].Rules[1].Type)
+			assert.Equal(t, "bad data", response.Groups[1].Rules[0].LastError)
+		}
+	})
+
+	t.Run("by type", func(t *testing.T) {
+		response := list(t, "/rules?type=alerting")
+		if assert.Len(t, response.Groups, 1) {
+			assert.Equal(t, "node", response.Groups[0].Name)
+			assert.Len(t, response.Groups[0].Rules, 1)
+		}
+
+		response = list(t, "/rules?type=recording")
+		if assert.Len(t, response.Groups, 2) {
+			assert.Equal(t, "job:up:sum", response.Groups[0].Rules[0].Name)
+		}
+	})
+
+	t.Run("by group", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/rules/node?type=recording", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var group models.RuleGroup
+		if err := json.Unmarshal(rr.Body.Bytes(), &group); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "/etc/prometheus/node.yml", group.File)
+		if assert.Len(t, group.Rules, 1) {
+			assert.Equal(t, "job:up:sum", group.Rules[0].Name)
+		}
+
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/rules/missing", nil))
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("invalid type", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/rules?type=other", nil))
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	// Every request after the first was served from the cache
+	assert.Equal(t, int64(1), atomic.LoadInt64(&hits))
+}
+
+// Test that targets are listed and filtered by health for both a healthy and
+// a partially degraded set of targets
+func TestGetTargets(t *testing.T) {
+	target := func(instance, health, lastError string) string {
+		return fmt.Sprintf(`{"discoveredLabels":{"__address__":"%[1]s"},"labels":{"instance":"%[1]s","job":"node"},
+			"scrapePool":"node","scrapeUrl":"http://%[1]s/metrics","lastError":"%[3]s",
+			"lastScrape":"2021-01-04T07:40:00Z","lastScrapeDuration":0.01,"health":"%[2]s"}`, instance, health, lastError)
+	}
+	newRouter := func(t *testing.T, active ...string) *mux.Router {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"status":"success","data":{"activeTargets":[%s],"droppedTargets":[{"discoveredLabels":{"__address__":"c:9100"}}]}}`,
+				strings.Join(active, ","))
+		}))
+		t.Cleanup(server.Close)
+
+		client, err := prometheus.NewClient(server.URL, logger.NewTestLogger(), cache.New(cache.DefaultOptions()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router := mux.NewRouter()
+		NewPrometheusHandler(client, logger.NewTestLogger()).RegisterRoutes(router)
+		return router
+	}
+	get := func(t *testing.T, router *mux.Router, target string) models.TargetsResult {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", target, nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var result models.TargetsResult
+		if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	t.Run("healthy", func(t *testing.T) {
+		router := newRouter(t, target("a:9100", "up", ""), target("b:9100", "up", ""))
+
+		result := get(t, router, "/targets")
+		assert.Len(t, result.Active, 2)
+		if assert.Len(t, result.Dropped, 1) {
+			assert.Equal(t, "c:9100", result.Dropped[0].DiscoveredLabels["__address__"])
+		}
+		assert.Len(t, get(t, router, "/targets?state=up").Active, 2)
+
+		result = get(t, router, "/targets?state=down")
+		assert.Empty(t, result.Active)
+		assert.Empty(t, result.Dropped)
+	})
+
+	t.Run("degraded", func(t *testing.T) {
+		router := newRouter(t, target("a:9100", "up", ""), target("b:9100", "down", "connection refused"))
+
+		assert.Len(t, get(t, router, "/targets?state=any").Active, 2)
+		assert.Len(t, get(t, router, "/targets?state=up").Active, 1)
+
+		result := get(t, router, "/targets?state=down")
+		if assert.Len(t, result.Active, 1) {
+			down := result.Active[0]
+			assert.Equal(t, "b:9100", down.Labels["instance"])
+			assert.Equal(t, "connection refused", down.LastError)
+			assert.Equal(t, "http://b:9100/metrics", down.ScrapeURL)
+		}
+		assert.Empty(t, result.Dropped)
+	})
+
+	t.Run("invalid state", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		newRouter(t).ServeHTTP(rr, httptest.NewRequest("GET", "/targets?state=unknown", nil))
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
+
+// Test that the alerts feed sends a snapshot on connect and then an update
+// listing the alerts that were added, removed or changed state
+func TestStreamAlerts(t *testing.T) {
+	alert := func(name, state string) string {
+		return fmt.Sprintf(`{"labels":{"alertname":"%s","severity":"critical"},"annotations":{},"state":"%s","activeAt":"2021-01-04T07:30:00Z","value":"1e+00"}`, name, state)
+	}
+	var alerts atomic.Value
+	alerts.Store(alert("InstanceDown", "firing") + "," + alert("DiskFull", "pending"))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"status":"success","data":{"alerts":[%s]}}`, alerts.Load().(string))
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := prometheus.NewClient(server.URL, logger.NewTestLogger(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	NewAlertsHandler(service.NewAlertsService(client, logger.NewTestLogger()), logger.NewTestLogger()).
+		WithPollInterval(20 * time.Millisecond).
+		RegisterRoutes(router)
+	api := httptest.NewServer(router)
+	t.Cleanup(api.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(api.URL, "http")+"/alerts/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var snapshot models.AlertFeedMessage
+	if err := conn.ReadJSON(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "snapshot", snapshot.Type)
+	assert.Len(t, snapshot.Alerts, 2)
+
+	alerts.Store(alert("InstanceDown", "firing") + "," + alert("DiskFull", "firing") + "," + alert("HighLatency", "pending"))
+
+	var update models.AlertFeedMessage
+	if err := conn.ReadJSON(&update); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "update", update.Type)
+	assert.Empty(t, update.Alerts)
+	if assert.Len(t, update.Added, 1) {
+		assert.Equal(t, "HighLatency", update.Added[0].Name)
+	}
+	if assert.Len(t, update.Changed, 1) {
+		assert.Equal(t, "DiskFull", update.Changed[0].Name)
+		assert.Equal(t, "firing", update.Changed[0].State)
+	}
+	assert.Empty(t, update.Removed)
+
+	alerts.Store(alert("DiskFull", "firing") + "," + alert("HighLatency", "pending"))
+
+	var removal models.AlertFeedMessage
+	if err := conn.ReadJSON(&removal); err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, removal.Added)
+	assert.Empty(t, removal.Changed)
+	if assert.Len(t, removal.Removed, 1) {
+		assert.Equal(t, "InstanceDown", removal.Removed[0].Name)
+	}
+}
+
+// Test that the metric stream sends the query result on every tick, counts
+// its open connection and closes cleanly when the client does
+func TestStreamMetrics(t *testing.T) {
+	fp := newFakePrometheus(t, upResult("1"))
+	client, err := prometheus.NewClient(fp.server.URL, logger.NewTestLogger(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewMetricsStreamHandler(service.NewQueriesService(client, logger.NewTestLogger()), logger.NewTestLogger())
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+	api := httptest.NewServer(router)
+	t.Cleanup(api.Close)
+
+	t.Run("rejects invalid parameters", func(t *testing.T) {
+		for _, path := range []string{
+			"/ws/metrics",
+			"/ws/metrics?query=up&interval=500ms",
+			"/ws/metrics?query=up&interval=2m",
+			"/ws/metrics?query=up&interval=abc",
+		} {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+			assert.Equal(t, http.StatusBadRequest, rr.Code, path)
+		}
+	})
+
+	t.Run("streams results", func(t *testing.T) {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(api.URL, "http")+"/ws/metrics?query=up&interval=1s", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+		var first models.MetricStreamMessage
+		if err := conn.ReadJSON(&first); err != nil {
+			t.Fatal(err)
+		}
+		if assert.Len(t, first.Data, 1) {
+			assert.Equal(t, 1.0, first.Data[0].Value)
+		}
+		assert.False(t, first.Timestamp.IsZero())
+		assert.Equal(t, 1.0, testutil.ToFloat64(handler.connections))
+
+		fp.result.Store(upResult("0"))
+
+		var second models.MetricStreamMessage
+		if err := conn.ReadJSON(&second); err != nil {
+			t.Fatal(err)
+		}
+		if assert.Len(t, second.Data, 1) {
+			assert.Equal(t, 0.0, second.Data[0].Value)
+		}
+		assert.True(t, second.Timestamp.After(first.Timestamp))
+
+		// The server should answer the close and release the connection
+		err = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		assert.NoError(t, err)
+		for {
+			if _, _, err = conn.NextReader(); err != nil {
+				break
+			}
+		}
+		assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure), "unexpected error: %v", err)
+		assert.Eventually(t, func() bool {
+			return testutil.ToFloat64(handler.connections) == 0
+		}, time.Second, 10*time.Millisecond)
+	})
+}
+
+// Test that streams end when the server starts shutting down instead of
+// holding shutdown up until their clients leave
+func TestStreamsEndOnShutdown(t *testing.T) {
+	fp := newFakePrometheus(t, upResult("1"))
+	client, err := prometheus.NewClient(fp.server.URL, logger.NewTestLogger(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries := service.NewQueriesService(client, logger.NewTestLogger())
+
+	t.Run("watch", func(t *testing.T) {
+		shutdown, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		router := mux.NewRouter()
+		NewQueriesHandler(queries, logger.NewTestLogger()).
+			WithShutdown(shutdown).
+			RegisterRoutes(router)
+
+		rec := &streamRecorder{header: make(http.Header)}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			router.ServeHTTP(rec, httptest.NewRequest("GET", "/query/watch?query=up&interval=1h", nil))
+		}()
+		assert.Eventually(t, func() bool {
+			events, _ := rec.counts()
+			return events == 1
+		}, time.Second, 10*time.Millisecond)
+
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("watch stream did not stop on shutdown")
+		}
+	})
+
+	t.Run("websocket", func(t *testing.T) {
+		shutdown, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		router := mux.NewRouter()
+		NewMetricsStreamHandler(queries, logger.NewTestLogger()).
+			WithShutdown(shutdown).
+			RegisterRoutes(router)
+		api := httptest.NewServer(router)
+		t.Cleanup(api.Close)
+
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(api.URL, "http")+"/ws/metrics?query=up&interval=1m", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+		var first models.MetricStreamMessage
+		if err := conn.ReadJSON(&first); err != nil {
+			t.Fatal(err)
+		}
+
+		cancel()
+		for {
+			if _, _, err = conn.NextReader(); err != nil {
+				break
+			}
+		}
+		assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), "unexpected error: %v", err)
+	})
+}
+
+// Test that websockets can only be opened from the server's own origin and
+// the allowed ones, since browsers do not apply CORS to them
+func TestWebSocketOrigin(t *testing.T) {
+	fp := newFakePrometheus(t, upResult("1"))
+	client, err := prometheus.NewClient(fp.server.URL, logger.NewTestLogger(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries := service.NewQueriesService(client, logger.NewTestLogger())
+
+	dial := func(t *testing.T, allowed []string, origin string) int {
+		router := mux.NewRouter()
+		NewMetricsStreamHandler(queries, logger.NewTestLogger()).WithAllowedOrigins(allowed).RegisterRoutes(router)
+		api := httptest.NewServer(router)
+		t.Cleanup(api.Close)
+
+		header := http.Header{}
+		if origin != "" {
+			header.Set("Origin", strings.Replace(origin, "SELF", api.URL, 1))
+		}
+		conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(api.URL, "http")+"/ws/metrics?query=up", header)
+		if err == nil {
+			conn.Close()
+		}
+		if resp == nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	allowed := []string{"https://dashboard.example.com"}
+	tests := []struct {
+		name    string
+		allowed []string
+		origin  string
+		want    int
+	}{
+		{"allowed origin", allowed, "https://dashboard.example.com", http.StatusSwitchingProtocols},
+		{"same origin", allowed, "SELF", http.StatusSwitchingProtocols},
+		{"no origin", allowed, "", http.StatusSwitchingProtocols},
+		{"disallowed origin", allowed, "https://evil.example.com", http.StatusForbidden},
+		{"any origin", []string{"*"}, "https://evil.example.com", http.StatusSwitchingProtocols},
+		{"default is same origin only", nil, "https://dashboard.example.com", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, dial(t, tt.allowed, tt.origin))
+		})
+	}
+}
+
+// sseEvent is one Server-Sent Event read from a stream
+type sseEvent struct {
+	name string
+	data string
+}
+
+// readSSE sends the events of body on the returned channel, closing it when
+// the stream ends
+func readSSE(body io.Reader) <-chan sseEvent {
+	events := make(chan sseEvent)
+	go func() {
+		defer close(events)
+		var e sseEvent
+		scanner := bufio.NewScanner(body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				if e.name != "" {
+					events <- e
+				}
+				e = sseEvent{}
+			case strings.HasPrefix(line, "event: "):
+				e.name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				e.data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+	return events
+}
+
+// Test that a streamed range query sends one chunk event per sub-range,
+// together covering the range without gaps or repeats, then a done event
+func TestStreamRangeQuery(t *testing.T) {
+	var mu sync.Mutex
+	var ranges []string
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start, _ := strconv.ParseFloat(r.FormValue("start"), 64)
+		end, _ := strconv.ParseFloat(r.FormValue("end"), 64)
+		step, _ := strconv.ParseFloat(r.FormValue("step"), 64)
+		mu.Lock()
+		ranges = append(ranges, fmt.Sprintf("%.0f-%.0f", start, end))
+		mu.Unlock()
+
+		if r.FormValue("query") == "broken" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			fmt.Fprint(w, `{"status":"error","errorType":"execution","error":"query failed"}`)
+			return
+		}
+
+		var values []string
+		for ts := start; ts <= end; ts += step {
+			values = append(values, fmt.Sprintf(`[%.0f,"1"]`, ts))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"__name__":"up"},"values":[%s]}]}}`,
+			strings.Join(values, ","))
+	}))
+	defer prom.Close()
+
+	client, err := prometheus.NewClient(prom.URL, logger.NewTestLogger(), cache.New(cache.DefaultOptions()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	NewSSEHandler(service.NewQueriesService(client, logger.NewTestLogger()), logger.NewTestLogger()).
+		WithChunkDuration(10 * time.Minute).
+		RegisterRoutes(router)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	stream := func(t *testing.T, query string) (*http.Response, <-chan sseEvent) {
+		resp, err := http.Get(server.URL + "/query_range/stream?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp, readSSE(resp.Body)
+	}
+	next := func(t *testing.T, events <-chan sseEvent) (sseEvent, bool) {
+		select {
+		case e, ok := <-events:
+			return e, ok
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for an event")
+			return sseEvent{}, false
+		}
+	}
+
+	t.Run("chunks then done", func(t *testing.T) {
+		resp, events := stream(t, "query=up&start=1700000000&end=1700001500&step=1m")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		var timestamps []time.Time
+		chunks := 0
+		for {
+			e, ok := next(t, events)
+			if !assert.True(t, ok, "the stream ended without a done event") {
+				return
+			}
+			if e.name == "done" {
+				assert.Equal(t, "{}", e.data)
+				break
+			}
+			assert.Equal(t, "chunk", e.name)
+			chunks++
+
+			var chunk models.RangeQueryResponse
+			if err := json.Unmarshal([]byte(e.data), &chunk); err != nil {
+				t.Fatal(err)
+			}
+			if assert.Len(t, chunk.Series, 1) {
+				for _, point := range chunk.Series[0].DataPoints {
+					timestamps = append(timestamps, point.Timestamp)
+				}
+			}
+		}
+
+		// 25 minutes in sub-ranges of 10, each starting a step after the last
+		assert.Equal(t, 3, chunks)
+		mu.Lock()
+		assert.Equal(t, []string{"1700000000-1700000600", "1700000660-1700001260", "1700001320-1700001500"}, ranges)
+		ranges = nil
+		mu.Unlock()
+		if assert.Len(t, timestamps, 26) {
+			for i, ts := range timestamps {
+				assert.Equal(t, int64(1700000000+60*i), ts.Unix())
+			}
+		}
+		_, ok := next(t, events)
+		assert.False(t, ok, "nothing should follow the done event")
+	})
+
+	t.Run("error ends the stream", func(t *testing.T) {
+		_, events := stream(t, "query=broken&start=1700000000&end=1700001500&step=1m")
+		e, ok := next(t, events)
+		if assert.True(t, ok) {
+			assert.Equal(t, "error", e.name)
+		}
+		_, ok = next(t, events)
+		assert.False(t, ok, "no done event should follow an error")
+	})
+
+	t.Run("invalid parameters", func(t *testing.T) {
+		for _, query := range []string{
+			"start=1700000000&end=1700001500&step=1m",
+			"query=up&start=1700001500&end=1700000000&step=1m",
+			"query=up&start=1700000000&end=1700001500&step=soon",
+			"query=up&start=yesterday&step=1m",
+		} {
+			resp, _ := stream(t, query)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+		}
+	})
+
+	t.Run("too many sub-ranges", func(t *testing.T) {
+		mu.Lock()
+		ranges = nil
+		mu.Unlock()
+
+		resp, _ := stream(t, "query=up&start=0001-01-01T00:00:00Z&end=1700000000&step=1h")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		mu.Lock()
+		assert.Empty(t, ranges, "no sub-range should be queried")
+		mu.Unlock()
+	})
+}
+
+// Test that executed queries show up in the query history, newest first
+func TestQueryHistory(t *testing.T) {
+	router := newTestQueriesRouter(t, newFakePrometheus(t, upResult("1")))
+
+	for _, query := range []string{"up", "sum(up)"} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/query", strings.NewReader(fmt.Sprintf(`{"query": %q}`, query))))
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/queries/history?limit=1", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var response struct {
+		Queries []models.QueryHistoryEntry `json:"queries"`
+		Count   int                        `json:"count"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, response.Count)
+	if assert.Len(t, response.Queries, 1) {
+		assert.Equal(t, "sum(up)", response.Queries[0].Query)
+		assert.Equal(t, "instant", response.Queries[0].Type)
+		assert.Equal(t, 1, response.Queries[0].ResultCount)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", fmt.Sprintf("/queries/history?since=%d", time.Now().Add(time.Hour).Unix()), nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"count":0`)
+
+	for _, target := range []string{"/queries/history?limit=0", "/queries/history?since=yesterday"} {
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", target, nil))
+		assert.Equal(t, http.StatusBadRequest, rr.Code, target)
+	}
+}
+
+// Test that a dry run reports the estimated cost, over the whole range when
+// a step is given
+func TestValidateQueryCost(t *testing.T) {
+	// Every count() query reports the single up series
+	router := newTestQueriesRouter(t, newFakePrometheus(t, upResult("1")))
+
+	validate := func(payload string) models.QueryValidation {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/query/validate", strings.NewReader(payload)))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var validation models.QueryValidation
+		if err := json.Unmarshal(rr.Body.Bytes(), &validation); err != nil {
+			t.Fatal(err)
+		}
+		return validation
+	}
+
+	instant := validate(`{"query": "up", "dry_run": true}`)
+	assert.True(t, instant.Valid)
+	assert.Equal(t, int64(1), instant.EstimatedSeries)
+	assert.Equal(t, int64(1), instant.EstimatedPoints)
+
+	ranged := validate(`{"query": "up", "start": "2021-01-04T07:00:00Z", "end": "2021-01-04T08:00:00Z", "step": "15s", "dry_run": true}`)
+	assert.True(t, ranged.Valid)
+	assert.Equal(t, int64(1), ranged.EstimatedSeries)
+	assert.Equal(t, int64(241), ranged.EstimatedPoints)
+
+	assert.False(t, validate(`{"query": "up", "step": "soon", "dry_run": true}`).Valid)
+
+	// Without a dry run only the syntax is checked
+	static := validate(`{"query": "up"}`)
+	assert.True(t, static.Valid)
+	assert.Zero(t, static.EstimatedSeries)
+}
+
+// Test that syntax errors are a 400 locating the error, while a dry run
+// Prometheus cannot answer is an upstream error
+func TestValidateQuerySyntax(t *testing.T) {
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, `{"status":"error","errorType":"internal","error":"storage is down"}`)
+	}))
+	defer prom.Close()
+	router := newTestQueriesRouter(t, &fakePrometheus{server: prom})
+
+	validate := func(payload string) (*httptest.ResponseRecorder, models.QueryValidation) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/query/validate", strings.NewReader(payload)))
+		var validation models.QueryValidation
+		if err := json.Unmarshal(rr.Body.Bytes(), &validation); err != nil {
+			t.Fatal(err)
+		}
+		return rr, validation
+	}
+
+	rr, validation := validate(`{"query": "sum(rate(up[5m])"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.False(t, validation.Valid)
+	if assert.NotNil(t, validation.SyntaxError) {
+		assert.Equal(t, 1, validation.SyntaxError.Line)
+		assert.Equal(t, 17, validation.SyntaxError.Column)
+		assert.Equal(t, "unclosed left parenthesis", validation.SyntaxError.Message)
+	}
+
+	// Checked statically, so Prometheus being down does not matter
+	rr, validation = validate(`{"query": "sum(rate(up[5m]))"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, validation.Valid)
+
+	rr, _ = validate(`{"query": "sum(rate(up[5m]))", "dry_run": true}`)
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+}
+
+// Test that QueryRange reads and restores its body under the body size limit
+// and rejects a body just over it
+func TestQueryRangeBodyLimit(t *testing.T) {
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"__name__":"up"},"values":[[1609743600,"1"]]}]}}`)
+	}))
+	defer prom.Close()
+	client, err := prometheus.NewClient(prom.URL, logger.NewTestLogger(), cache.New(cache.DefaultOptions()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const limit = 256
+	router := mux.NewRouter()
+	router.Use(middleware.MaxBodyBytes(limit))
+	NewQueryHandler(service.NewQueriesService(client, logger.NewTestLogger()), logger.NewTestLogger()).RegisterRoutes(router)
+
+	// pad fills the JSON payload out to size bytes with trailing whitespace
+	pad := func(size int) string {
+		payload := `{"query": "up", "start": "2021-01-04T07:00:00Z", "end": "2021-01-04T08:00:00Z", "step": "60"}`
+		return payload + strings.Repeat(" ", size-len(payload))
+	}
+
+	tests := []struct {
+		name          string
+		size          int
+		unknownLength bool
+		expected      int
+	}{
+		{name: "just under the limit", size: limit - 1, expected: http.StatusOK},
+		{name: "just over the limit", size: limit + 1, expected: http.StatusRequestEntityTooLarge},
+		{name: "just over the limit without length", size: limit + 1, unknownLength: true, expected: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/query/range", strings.NewReader(pad(tt.size)))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.unknownLength {
+				req.ContentLength = -1
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expected, rr.Code, rr.Body.String())
+			if tt.expected == http.StatusOK {
+				var response models.RangeQueryResponse
+				if assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response)) {
+					assert.Len(t, response.Series, 1)
+				}
+			}
+		})
+	}
+}
+
+// Test that a range query batch reports each query's outcome in order and
+// that failed queries do not fail the batch
+func TestRangeBatch(t *testing.T) {
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.FormValue("query") == "broken" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			fmt.Fprint(w, `{"status":"error","errorType":"execution","error":"query failed"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"__name__":%q},"values":[[1609743600,"1"]]}]}}`,
+			r.FormValue("query"))
+	}))
+	defer prom.Close()
+	client, err := prometheus.NewClient(prom.URL, logger.NewTestLogger(), cache.New(cache.DefaultOptions()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	queries := service.NewQueriesService(client, logger.NewTestLogger()).WithMaxBatchQueries(3)
+	NewBatchQueriesHandler(queries, logger.NewTestLogger()).RegisterRoutes(router)
+
+	post := func(payload string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/queries/batch", strings.NewReader(payload)))
+		return rr
+	}
+	const window = `"start": "2021-01-04T07:00:00Z", "end": "2021-01-04T08:00:00Z", "step": "1m"`
+
+	t.Run("partial failure", func(t *testing.T) {
+		rr := post(`{"queries": [
+			{"id": "cpu", "query": "cpu", ` + window + `},
+			{"id": "errors", "query": "broken", ` + window + `},
+			{"query": "memory", ` + window + `}
+		]}`)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response struct {
+			Results []models.RangeBatchResult `json:"results"`
+			Count   int                       `json:"count"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, 3, response.Count)
+		if !assert.Len(t, response.Results, 3) {
+			return
+		}
+
+		cpu, failed, memory := response.Results[0], response.Results[1], response.Results[2]
+		assert.Equal(t, "cpu", cpu.ID)
+		assert.Equal(t, "success", cpu.Status)
+		if assert.NotNil(t, cpu.Data) && assert.Len(t, cpu.Data.Series, 1) {
+			assert.Equal(t, "cpu", cpu.Data.Series[0].MetricName)
+		}
+		assert.Empty(t, cpu.Error)
+
+		assert.Equal(t, "errors", failed.ID)
+		assert.Equal(t, "error", failed.Status)
+		assert.Nil(t, failed.Data)
+		assert.Contains(t, failed.Error, "query failed")
+
+		assert.Equal(t, "2", memory.ID, "queries without an ID are named by index")
+		assert.Equal(t, "success", memory.Status)
+	})
+
+	t.Run("invalid batches", func(t *testing.T) {
+		for name, payload := range map[string]string{
+			"empty":        `{"queries": []}`,
+			"duplicate id": `{"queries": [{"id": "a", "query": "up", ` + window + `}, {"id": "a", "query": "up", ` + window + `}]}`,
+			"too large":    `{"queries": [{"query": "a"}, {"query": "b"}, {"query": "c"}, {"query": "d"}]}`,
+			"malformed":    `{"queries": `,
+		} {
+			assert.Equal(t, http.StatusBadRequest, post(payload).Code, name)
+		}
+	})
+}
+
+// Test that anomalies are found in the range of a metric and reported with
+// their series
+func TestGetMetricAnomalies(t *testing.T) {
+	var rangesAsked int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&rangesAsked, 1)
+		w.Header().Set("Content-Type", "application/json")
+		if r.FormValue("query") != "node_load1" {
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"matrix","result":[]}}`)
+			return
+		}
+
+		start, _ := strconv.ParseFloat(r.FormValue("start"), 64)
+		end, _ := strconv.ParseFloat(r.FormValue("end"), 64)
+		step, _ := strconv.ParseFloat(r.FormValue("step"), 64)
+		var steady, spiky []string
+		for i, ts := 0, start; ts <= end; i, ts = i+1, ts+step {
+			value := 10 + i%2*2
+			steady = append(steady, fmt.Sprintf(`[%f,"%d"]`, ts, value))
+			if end-ts < 2*3600 && end-ts >= 2*3600-step {
+				// A spike two hours ago
+				value = 100
+			}
+			spiky = append(spiky, fmt.Sprintf(`[%f,"%d"]`, ts, value))
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"matrix","result":[`+
+			`{"metric":{"__name__":"node_load1","instance":"a"},"values":[%s]},`+
+			`{"metric":{"__name__":"node_load1","instance":"b"},"values":[%s]}]}}`,
+			strings.Join(steady, ","), strings.Join(spiky, ","))
+	}))
+	defer server.Close()
+
+	client, err := prometheus.NewClient(server.URL, logger.NewTestLogger(), cache.New(cache.DefaultOptions()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	NewMetricsHandler(service.NewMetricsService(client, logger.NewTestLogger()), logger.NewTestLogger()).RegisterRoutes(router)
+
+	get := func(url string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", url, nil))
+		return rr
+	}
+
+	rr := get("/metrics/node_load1/anomalies")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response struct {
+		Anomalies []models.Anomaly `json:"anomalies"`
+		Count     int              `json:"count"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if assert.Equal(t, 1, response.Count) {
+		anomaly := response.Anomalies[0]
+		assert.Equal(t, "b", anomaly.Labels["instance"])
+		assert.Equal(t, 100.0, anomaly.Value)
+		assert.InDelta(t, 11, anomaly.Expected, 0.1)
+		assert.Greater(t, anomaly.ZScore, 2.5)
+		assert.WithinDuration(t, time.Now().Add(-2*time.Hour), anomaly.Timestamp, 5*time.Minute)
+	}
+
+	// The spike is older than a one hour lookback
+	rr = get("/metrics/node_load1/anomalies?lookback=1h&threshold=3")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"anomalies":[],"count":0}`, rr.Body.String())
+
+	// Anomalies are not cached
+	before := atomic.LoadInt64(&rangesAsked)
+	get("/metrics/node_load1/anomalies")
+	assert.Equal(t, before+1, atomic.LoadInt64(&rangesAsked))
+
+	assert.Equal(t, http.StatusNotFound, get("/metrics/missing/anomalies").Code)
+	for _, query := range []string{"lookback=abc", "lookback=-1h", "threshold=0", "threshold=abc"} {
+		assert.Equal(t, http.StatusBadRequest, get("/metrics/node_load1/anomalies?"+query).Code, query)
+	}
+}
+
+// Test that metrics over the cardinality limit are reported to the alert
+// callback and listed by the high cardinality endpoint
+func TestHighCardinalityMetrics(t *testing.T) {
+	series := map[string]int{"http_requests_total": 5000, "up": 20, "node_cpu_seconds_total": 1200}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/api/v1/label/__name__/values" {
+			fmt.Fprint(w, `{"status":"success","data":["http_requests_total","node_cpu_seconds_total","up"]}`)
+			return
+		}
+
+		query := r.FormValue("query")
+		var samples []string
+		if threshold, ok := strings.CutPrefix(query, `count by (__name__) ({__name__=~".+"}) > `); ok {
+			limit, _ := strconv.Atoi(threshold)
+			for name, count := range series {
+				if count > limit {
+					samples = append(samples, fmt.Sprintf(`{"metric":{"__name__":%q},"value":[1609746000,"%d"]}`, name, count))
+				}
+			}
+		} else if name, ok := strings.CutPrefix(query, "count("); ok {
+			samples = append(samples, fmt.Sprintf(`{"metric":{},"value":[1609746000,"%d"]}`, series[strings.TrimSuffix(name, ")")]))
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[%s]}}`, strings.Join(samples, ","))
+	}))
+	defer server.Close()
+
+	client, err := prometheus.NewClient(server.URL, logger.NewTestLogger(), cache.New(cache.DefaultOptions()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alerted := make(map[string]int)
+	svc := service.NewMetricsService(client, logger.NewTestLogger()).
+		WithCardinalityAlert(service.CardinalityAlertConfig{
+			MaxSeriesPerMetric: 1000,
+			AlertCallback: func(metricName string, count int) {
+				alerted[metricName] = count
+			},
+		})
+	router := mux.NewRouter()
+	NewMetricsHandler(svc, logger.NewTestLogger()).RegisterRoutes(router)
+
+	t.Run("alert callback", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics/top?limit=1", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		// Metrics past the limit are reported too
+		assert.Equal(t, map[string]int{"http_requests_total": 5000, "node_cpu_seconds_total": 1200}, alerted)
+	})
+
+	t.Run("endpoint", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics/high-cardinality?threshold=100", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response struct {
+			Metrics []models.MetricCardinality `json:"metrics"`
+			Count   int                        `json:"count"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, 2, response.Count)
+		assert.Equal(t, []models.MetricCardinality{
+			{Name: "http_requests_total", Cardinality: 5000},
+			{Name: "node_cpu_seconds_total", Cardinality: 1200},
+		}, response.Metrics)
+	})
+
+	for _, threshold := range []string{"", "abc", "-1"} {
+		t.Run("invalid threshold "+threshold, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics/high-cardinality?threshold="+threshold, nil))
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+		})
+	}
+}
+
+// Test that metric exports take their CSV label columns from the first series
+// and fall back to the range query layout for JSON
+func TestExportMetric(t *testing.T) {
+	var asked url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		asked = r.Form
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"matrix","result":[
+			{"metric":{"__name__":"up","job":"api","instance":"a:9100"},"values":[[1609743600,"1"],[1609743660,"0"]]},
+			{"metric":{"__name__":"up","job":"db","zone":"east"},"values":[[1609743600,"1"]]}
+		]}}`)
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := prometheus.NewClient(server.URL, logger.NewTestLogger(), cache.New(cache.DefaultOptions()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	NewMetricsHandler(service.NewMetricsService(client, logger.NewTestLogger()), logger.NewTestLogger()).RegisterRoutes(router)
+
+	get := func(url string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", url, nil))
+		return rr
+	}
+
+	t.Run("csv", func(t *testing.T) {
+		rr := get("/metrics/up/export?format=csv&start=1609743600&end=1609743660&step=1m")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "text/csv; charset=utf-8", rr.Header().Get("Content-Type"))
+		assert.Equal(t, `attachment; filename="up.csv"`, rr.Header().Get("Content-Disposition"))
+		assert.Equal(t, "up", asked.Get("query"))
+		assert.Equal(t, "60", asked.Get("step"))
+
+		records, err := csv.NewReader(rr.Body).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// zone is not a label of the first series, so it gets no column
+		assert.Equal(t, [][]string{
+			{"timestamp", "instance", "job", "value"},
+			{"2021-01-04T07:00:00Z", "a:9100", "api", "1"},
+			{"2021-01-04T07:01:00Z", "a:9100", "api", "0"},
+			{"2021-01-04T07:00:00Z", "", "db", "1"},
+		}, records)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		rr := get("/metrics/up/export?start=1609743600&end=1609743660&step=1m")
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response models.RangeQueryResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "up", response.Query)
+		assert.Equal(t, time.Minute, response.Step)
+		if assert.Len(t, response.Series, 2) {
+			assert.Len(t, response.Series[0].DataPoints, 2)
+			assert.Equal(t, "east", response.Series[1].Labels["zone"])
+		}
+	})
+
+	for _, query := range []string{"format=xml", "step=0s", "step=abc", "start=1609743660&end=1609743600", "start=abc",
+		"start=1609743600&end=1609843600&step=1s"} {
+		t.Run("invalid "+query, func(t *testing.T) {
+			assert.Equal(t, http.StatusBadRequest, get("/metrics/up/export?"+query).Code)
+		})
+	}
+}
+
+func TestExportLabelNames(t *testing.T) {
+	assert.Nil(t, exportLabelNames(nil))
+	assert.Equal(t, []string{"instance", "job"}, exportLabelNames([]models.TimeSeries{
+		{Labels: map[string]string{"job": "api", "instance": "a"}},
+		{Labels: map[string]string{"zone": "east"}},
+	}))
+	assert.Empty(t, exportLabelNames([]models.TimeSeries{{MetricName: "up"}}))
+}
+
+// Test listing the values of a label on one metric
+func TestGetLabelValues(t *testing.T) {
+	var form url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.Form
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v1/label/job/values":
+			fmt.Fprint(w, `{"status":"success","data":["api","node"]}`)
+		case "/api/v1/label/pod/values":
+			fmt.Fprint(w, `{"status":"success","data":[]}`)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"status":"error","errorType":"internal","error":"unexpected request"}`)
+		}
+	}))
+	defer server.Close()
+
+	client, err := prometheus.NewClient(server.URL, logger.NewTestLogger(), cache.New(cache.DefaultOptions()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	NewMetricsHandler(service.NewMetricsService(client, logger.NewTestLogger()), logger.NewTestLogger()).RegisterRoutes(router)
+
+	get := func(target string) *httptest.ResponseRecorder {
+		form = nil
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", target, nil))
+		return rr
+	}
+
+	t.Run("values", func(t *testing.T) {
+		rr := get("/metrics/http_requests_total/labels/job/values?start=1700000000&end=2023-11-14T23:13:20Z")
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response struct {
+			Metric string   `json:"metric"`
+			Label  string   `json:"label"`
+			Values []string `json:"values"`
+			Count  int      `json:"count"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "http_requests_total", response.Metric)
+		assert.Equal(t, "job", response.Label)
+		assert.Equal(t, []string{"api", "node"}, response.Values)
+		assert.Equal(t, 2, response.Count)
+
+		assert.Equal(t, []string{`{__name__="http_requests_total"}`}, form["match[]"])
+		assert.Equal(t, "1700000000", form.Get("start"))
+		assert.Equal(t, "1700003600", form.Get("end"))
+	})
+
+	t.Run("no range", func(t *testing.T) {
+		rr := get("/metrics/up/labels/pod/values")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.JSONEq(t, `{"metric":"up","label":"pod","values":[],"count":0}`, rr.Body.String())
+		assert.Empty(t, form.Get("start"))
+	})
+
+	t.Run("bad requests", func(t *testing.T) {
+		for _, target := range []string{
+			"/metrics/up/labels/job/values?start=yesterday",
+			"/metrics/up/labels/job/values?start=1700003600&end=1700000000",
+			"/metrics/up/labels/1job/values",
+			"/metrics/up-time/labels/job/values",
+		} {
+			rr := get(target)
+			assert.Equal(t, http.StatusBadRequest, rr.Code, target)
+			assert.Nil(t, form, "%s should not reach Prometheus", target)
+		}
+	})
+
+	t.Run("upstream error", func(t *testing.T) {
+		rr := get("/metrics/up/labels/instance/values")
+		assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	})
+}
+
+func TestSelectSource(t *testing.T) {
+	us := newFakePrometheus(t, upResult("1"))
+	eu := newFakePrometheus(t, upResult("2"))
+	shared := cache.New(cache.DefaultOptions())
+	clients := make(map[string]*prometheus.Client)
+	for name, fp := range map[string]*fakePrometheus{"us": us, "eu": eu} {
+		client, err := prometheus.NewClient(fp.server.URL, logger.NewTestLogger(), shared)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients[name] = client
+	}
+	sources, err := prometheus.NewSources("us", clients)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	router.Use(SelectSource(sources))
+	NewQueriesHandler(service.NewQueriesService(sources.Default(), logger.NewTestLogger()), logger.NewTestLogger()).RegisterRoutes(router)
+
+	doQuery := func(target string) (*httptest.ResponseRecorder, models.QueryResponse) {
+		req := httptest.NewRequest("POST", target, strings.NewReader(`{"query": "up", "time": "2021-01-04T07:40:00Z"}`))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var response models.QueryResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		return rr, response
+	}
+
+	// Requests naming no source go to the default one
+	rr, response := doQuery("/query")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, 1.0, response.Data[0].Value)
+	assert.Equal(t, int64(1), us.Hits())
+
+	// The same query on another source is neither served from the default
+	// source's cache entry nor sent to the default source
+	rr, response = doQuery("/query?source=eu")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, 2.0, response.Data[0].Value)
+	assert.Equal(t, int64(1), eu.Hits())
+	assert.Equal(t, int64(1), us.Hits())
+
+	// Naming the default source shares its cache entries
+	_, response = doQuery("/query?source=us")
+	assert.Equal(t, 1.0, response.Data[0].Value)
+	assert.Equal(t, int64(1), us.Hits())
+
+	rr, _ = doQuery("/query?source=apac")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), `Unknown Prometheus source \"apac\"`)
+	assert.Equal(t, int64(1), us.Hits())
+	assert.Equal(t, int64(1), eu.Hits())
+}
+
+func TestDebugHandler(t *testing.T) {
+	const secret = "test-secret"
+	router := mux.NewRouter()
+	debugRouter := router.PathPrefix("/debug").Subrouter()
+	debugRouter.Use(middleware.JWTAuth(middleware.AuthConfig{JWTSecret: secret}, logger.NewNopLogger()))
+	debugRouter.Use(middleware.RoleAuth([]string{"admin"}))
+	NewDebugHandler(logger.NewNopLogger()).RegisterRoutes(debugRouter)
+
+	get := func(path string, roles ...string) *httptest.ResponseRecorder {
+		token, err := middleware.GenerateToken("user-1", "user@example.com", roles, secret, 5)
+		assert.NoError(t, err)
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("rejects users without the admin role", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, get("/debug/pprof/", "viewer").Code)
+		assert.Equal(t, http.StatusForbidden, get("/debug/profile?seconds=1", "viewer").Code)
+	})
+
+	t.Run("serves pprof to admins", func(t *testing.T) {
+		rr := get("/debug/pprof/", "admin")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), "goroutine")
+
+		assert.Equal(t, http.StatusOK, get("/debug/pprof/heap", "admin").Code)
+	})
+
+	t.Run("streams a CPU profile", func(t *testing.T) {
+		rr := get("/debug/profile?seconds=1", "admin")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "application/octet-stream", rr.Header().Get("Content-Type"))
+		assert.NotEmpty(t, rr.Body.Bytes())
+	})
+
+	t.Run("rejects profiles over the limit", func(t *testing.T) {
+		for _, seconds := range []string{"0", "31", "abc"} {
+			assert.Equal(t, http.StatusBadRequest, get("/debug/profile?seconds="+seconds, "admin").Code, seconds)
+		}
+	})
+}
+
+func TestDescribeRoutes(t *testing.T) {
+	type describer interface {
+		RegisterRoutes(r *mux.Router)
+		DescribeRoutes(b *openapi.Builder)
+	}
+	log := logger.NewNopLogger()
+	router := mux.NewRouter()
+	spec := openapi.NewBuilder("Metrics API", "test").WithErrorResponse(ErrorResponse{})
+	for _, h := range []describer{
+		NewMetricsHandler(nil, log),
+		NewQueriesHandler(nil, log),
+		NewBatchQueriesHandler(nil, log),
+		NewSSEHandler(nil, log),
+		NewMetricsStreamHandler(nil, log),
+		NewAlertsHandler(service.NewAlertsService(nil, log), log),
+		NewExportHandler(nil, log),
+		NewPrometheusHandler(nil, log),
+		NewRulesHandler(nil, log),
+		NewHealthHandler(nil, log, "test"),
+	} {
+		h.RegisterRoutes(router)
+		h.DescribeRoutes(spec)
+	}
+	adminRouter := router.PathPrefix("/admin").Subrouter()
+	cacheHandler := NewCacheHandler(nil, log)
+	cacheHandler.RegisterRoutes(adminRouter)
+	cacheHandler.DescribeRoutes(spec)
+	prometheusHandler := NewPrometheusHandler(nil, log)
+	prometheusHandler.RegisterAdminRoutes(adminRouter)
+	prometheusHandler.DescribeAdminRoutes(spec)
+
+	// Every registered route should be documented, and nothing more
+	var registered []string
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			// Subrouter prefixes have no methods
+			return nil
+		}
+		path = regexp.MustCompile(`\{([^}:]+):[^}]*\}`).ReplaceAllString(path, "{$1}")
+		for _, method := range methods {
+			registered = append(registered, method+" "+path)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, registered, spec.Operations())
+
+	body, err := spec.YAML()
+	assert.NoError(t, err)
+	doc, err := openapi3.NewLoader().LoadFromData(body)
+	if assert.NoError(t, err) {
+		assert.NoError(t, doc.Validate(context.Background()))
+	}
+}
+
+// Test that admin routes are served to admins only
+func TestAdminRoutes(t *testing.T) {
+	const secret = "test-secret"
+	router := mux.NewRouter()
+	adminRouter := router.PathPrefix("/admin").Subrouter()
+	adminRouter.Use(middleware.JWTAuth(middleware.AuthConfig{JWTSecret: secret}, logger.NewNopLogger()))
+	adminRouter.Use(middleware.RoleAuth([]string{"admin"}))
+	NewCacheHandler(cache.New(cache.DefaultOptions()), logger.NewNopLogger()).RegisterRoutes(adminRouter)
+
+	get := func(roles ...string) int {
+		req := httptest.NewRequest("GET", "/admin/cache/recommendations", nil)
+		if roles != nil {
+			token, err := middleware.GenerateToken("user-1", "user@example.com", roles, secret, 5)
+			assert.NoError(t, err)
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, get())
+	assert.Equal(t, http.StatusForbidden, get("viewer"))
+	assert.Equal(t, http.StatusOK, get("admin"))
+}
+
+func TestOpenAPIHandler(t *testing.T) {
+	spec := openapi.NewBuilder("Metrics API", "test")
+	NewHealthHandler(nil, logger.NewNopLogger(), "test").DescribeRoutes(spec)
+	router := mux.NewRouter()
+	NewOpenAPIHandler(spec, logger.NewNopLogger()).RegisterRoutes(router)
+
+	for path, contentType := range map[string]string{
+		"/openapi.yaml": "application/yaml",
+		"/openapi.json": "application/json",
+	} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+
+		assert.Equal(t, http.StatusOK, rr.Code, path)
+		assert.Equal(t, contentType, rr.Header().Get("Content-Type"), path)
+		assert.Contains(t, rr.Body.String(), "/health/detailed", path)
+	}
+}
@@ -2,33 +2,107 @@ package handlers
 
 import (
 	"context"
-	"fmt"
 	"net/http"
+	"sort"
+	"strings"
 	"time"
 
 	"metrics-api/internal/models"
 	"metrics-api/internal/prometheus"
+	"metrics-api/pkg/health"
 	"metrics-api/pkg/logger"
+	"metrics-api/pkg/openapi"
 
 	"github.com/gorilla/mux"
 )
 
+// HealthTimeouts bounds how long the health endpoints wait on their checks
+type HealthTimeouts struct {
+	Detailed  time.Duration
+	Readiness time.Duration
+	Check     time.Duration
+}
+
+// DefaultHealthTimeouts are used until WithTimeouts is called
+var DefaultHealthTimeouts = HealthTimeouts{
+	Detailed:  5 * time.Second,
+	Readiness: 2 * time.Second,
+	Check:     health.DefaultCheckTimeout,
+}
+
+// HealthThresholds sets when the resource checks report the service degraded
+type HealthThresholds struct {
+	// Memory is the share of the memory obtained from the OS that may be
+	// allocated
+	Memory float64
+	// Goroutines is the number of goroutines that may be running
+	Goroutines int
+}
+
+// DefaultHealthThresholds are used until WithThresholds is called
+var DefaultHealthThresholds = HealthThresholds{
+	Memory:     0.9,
+	Goroutines: 10000,
+}
+
 // HealthHandler handles health check requests
 type HealthHandler struct {
 	promClient *prometheus.Client
 	logger     logger.Logger
-	startTime  time.Time
 	version    string
+	checker    *health.Checker
+	timeouts   HealthTimeouts
+	// periodic is set once the checks run in the background, so the probes
+	// report their last results instead of running them
+	periodic bool
 }
 
 // NewHealthHandler creates a new health check handler
 func NewHealthHandler(promClient *prometheus.Client, logger logger.Logger, version string) *HealthHandler {
-	return &HealthHandler{
+	h := &HealthHandler{
 		promClient: promClient,
 		logger:     logger,
-		startTime:  time.Now(),
 		version:    version,
+		checker:    health.NewChecker(DefaultHealthTimeouts.Check),
+		timeouts:   DefaultHealthTimeouts,
+	}
+
+	if promClient != nil {
+		h.checker.AddCheck("prometheus", h.checkPrometheus(promClient))
 	}
+	h.WithThresholds(DefaultHealthThresholds)
+
+	return h
+}
+
+// WithTimeouts sets the detailed, readiness and per-check timeouts
+func (h *HealthHandler) WithTimeouts(timeouts HealthTimeouts) *HealthHandler {
+	h.timeouts = timeouts
+	h.checker.SetCheckTimeout(timeouts.Check)
+	return h
+}
+
+// WithThresholds sets the limits of the memory and CPU checks
+func (h *HealthHandler) WithThresholds(thresholds HealthThresholds) *HealthHandler {
+	h.checker.AddCheck("memory", health.MemoryCheck(thresholds.Memory))
+	h.checker.AddCheck("cpu", health.CPUCheck(thresholds.Goroutines))
+	return h
+}
+
+// StartPeriodicChecks runs the checks every interval until ctx is
+// cancelled, and has the detailed health and readiness probes report their
+// last results instead of waiting on them
+func (h *HealthHandler) StartPeriodicChecks(ctx context.Context, interval time.Duration) error {
+	if err := h.checker.StartPeriodic(ctx, interval); err != nil {
+		return err
+	}
+	h.periodic = true
+	return nil
+}
+
+// AddCheck registers an additional named health check
+func (h *HealthHandler) AddCheck(name string, check health.Check) {
+	h.checker.AddCheck(name, check)
 }
 
 // RegisterRoutes registers the handler routes
@@ -39,6 +113,36 @@ func (h *HealthHandler) RegisterRoutes(r *mux.Router) {
 	r.HandleFunc("/live", h.GetLiveness).Methods("GET")
 }
 
+// DescribeRoutes documents the handler routes
+func (h *HealthHandler) DescribeRoutes(b *openapi.Builder) {
+	b.Add(
+		openapi.Route{
+			Method: "GET", Path: "/health", Tag: "health",
+			Summary: "Report that the service is up",
+			Response: struct {
+				Status  string `json:"status" example:"up"`
+				Version string `json:"version"`
+				Time    string `json:"time"`
+			}{},
+		},
+		openapi.Route{
+			Method: "GET", Path: "/health/detailed", Tag: "health",
+			Summary:  "Run the health checks and report their results",
+			Response: models.HealthStatus{},
+		},
+		openapi.Route{
+			Method: "GET", Path: "/ready", Tag: "health",
+			Summary:     "Report whether the service can serve traffic",
+			ContentType: "text/plain",
+		},
+		openapi.Route{
+			Method: "GET", Path: "/live", Tag: "health",
+			Summary:     "Report that the process is alive",
+			ContentType: "text/plain",
+		},
+	)
+}
+
 // GetHealth returns the basic health status of the service
 func (h *HealthHandler) GetHealth(w http.ResponseWriter, r *http.Request) {
 	status := "up"
@@ -50,57 +154,52 @@ func (h *HealthHandler) GetHealth(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// GetDetailedHealth returns detailed health information including all checks
+// GetDetailedHealth returns detailed health information including all
+// checks. It answers 503 when the service is down and adds a warning naming
+// the failing checks when it is degraded.
 func (h *HealthHandler) GetDetailedHealth(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	
-	// Create a timeout context for health checks
-	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
-	
-	checks := make(map[string]string)
-	details := make(map[string]any)
-	overallStatus := "up"
-	
-	// Check Prometheus connection
-	promStatus, promDetails := h.checkPrometheusHealth(timeoutCtx)
-	checks["prometheus"] = promStatus
-	details["prometheus"] = promDetails
+	report := h.healthStatus(r.Context(), h.timeouts.Detailed, true)
 	
-	if promStatus != "up" {
-		overallStatus = "degraded"
+	details := make(map[string]any, len(report.CheckDetails))
+	var failing []string
+	for name, result := range report.CheckDetails {
+		details[name] = checkDetails(result)
+		if result.Status != health.StatusUp {
+			failing = append(failing, name)
+		}
 	}
-	
-	// Calculate uptime
-	uptime := time.Since(h.startTime)
-	uptimeStr := formatDuration(uptime)
-	
-	// Create the health status
+	sort.Strings(failing)
+
 	healthStatus := models.HealthStatus{
-		Status:    overallStatus,
-		Version:   h.version,
-		Uptime:    uptimeStr,
-		Timestamp: time.Now(),
-		Checks:    checks,
-		Details:   details,
+		Status:     string(report.Status),
+		Version:    report.Version,
+		Uptime:     report.Uptime,
+		Timestamp:  report.Timestamp,
+		Checks:     report.Checks,
+		Details:    details,
+		SystemInfo: report.SystemInfo,
 	}
 	
-	RespondWithJSON(w, http.StatusOK, healthStatus)
+	code := http.StatusOK
+	switch report.Status {
+	case health.StatusDown:
+		h.logger.Warnf("Service is down: failing health checks: %s", strings.Join(failing, ", "))
+		code = http.StatusServiceUnavailable
+	case health.StatusDegraded:
+		healthStatus.Warning = "degraded health checks: " + strings.Join(failing, ", ")
+	}
+	
+	RespondWithJSON(w, code, healthStatus)
 }
 
-// GetReadiness checks if the service is ready to receive traffic
+// GetReadiness checks if the service is ready to receive traffic. Only checks
+// that are down make it unready; a degraded service still serves requests.
 func (h *HealthHandler) GetReadiness(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	
-	// Create a short timeout context for readiness checks
-	timeoutCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
-	defer cancel()
-	
-	// Check if Prometheus is reachable
-	promStatus, _ := h.checkPrometheusHealth(timeoutCtx)
+	// Check if dependencies such as Prometheus are reachable
+	status := h.healthStatus(r.Context(), h.timeouts.Readiness, false).Status
 	
-	if promStatus != "up" {
-		h.logger.Warn("Service is not ready: Prometheus is down")
+	if status == health.StatusDown {
+		h.logger.Warn("Service is not ready: health checks are failing")
 		http.Error(w, "Service is not ready", http.StatusServiceUnavailable)
 		return
 	}
@@ -117,48 +216,44 @@ func (h *HealthHandler) GetLiveness(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("Service is alive"))
 }
 
-// checkPrometheusHealth checks if Prometheus is healthy
-func (h *HealthHandler) checkPrometheusHealth(ctx context.Context) (string, map[string]interface{}) {
-	details := make(map[string]interface{})
-	startTime := time.Now()
-	
-	// Try to execute a simple query
-	results, err := h.promClient.Query(ctx, "up", time.Now())
-	
-	responseTime := time.Since(startTime)
-	details["response_time_ms"] = responseTime.Milliseconds()
-	
-	if err != nil {
-		details["error"] = err.Error()
-		h.logger.Error("prometheus health check failed", "error", err)
-		return "down", details
-	}
-	
-	// Verify we got a response
-	if len(results) == 0 {
-		details["error"] = "no results returned"
-		return "degraded", details
+// healthStatus reports the last results of the periodic checks, or runs
+// the checks within timeout when they do not run in the background
+func (h *HealthHandler) healthStatus(ctx context.Context, timeout time.Duration, includeDetails bool) health.HealthStatus {
+	if h.periodic {
+		return health.GenerateHealthStatusCached(h.checker, h.version, includeDetails)
 	}
-	
-	details["error"] = nil
-	return "up", details
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return health.GenerateHealthStatusContext(timeoutCtx, h.checker, h.version, includeDetails)
 }
 
-// formatDuration converts a duration to a human-readable string
-func formatDuration(d time.Duration) string {
-	days := int(d.Hours() / 24)
-	hours := int(d.Hours()) % 24
-	minutes := int(d.Minutes()) % 60
-	seconds := int(d.Seconds()) % 60
-	
-	if days > 0 {
-		return fmt.Sprintf("%dd %dh %dm %ds", days, hours, minutes, seconds)
+// checkPrometheus returns a health check that queries Prometheus, reporting
+// the state of the client's circuit breaker and retries alongside the result
+func (h *HealthHandler) checkPrometheus(client *prometheus.Client) health.Check {
+	check := health.PrometheusCheck(func(ctx context.Context, query string) error {
+		_, err := client.Query(ctx, query, time.Now())
+		return err
+	})
+	return func(ctx context.Context) (health.Status, map[string]interface{}, error) {
+		status, details, err := check(ctx)
+		details["circuit_state"] = client.CircuitState()
+		details["retry_attempts"] = client.Stats().RetryAttempts
+		if err != nil {
+			h.logger.Error("prometheus health check failed", "error", err)
+		}
+		return status, details, err
 	}
-	if hours > 0 {
-		return fmt.Sprintf("%dh %dm %ds", hours, minutes, seconds)
+}
+
+// checkDetails returns the details reported by a check, including its error
+func checkDetails(result health.CheckResult) map[string]interface{} {
+	details := make(map[string]interface{}, len(result.Details)+1)
+	for k, v := range result.Details {
+		details[k] = v
 	}
-	if minutes > 0 {
-		return fmt.Sprintf("%dm %ds", minutes, seconds)
+	if _, ok := details["error"]; !ok && result.Error != nil {
+		details["error"] = result.Error.Error()
 	}
-	return fmt.Sprintf("%ds", seconds)
+	return details
 }
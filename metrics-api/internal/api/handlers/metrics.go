@@ -1,18 +1,39 @@
 package handlers
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"math"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"metrics-api/internal/models"
 	"metrics-api/internal/service"
+	"metrics-api/pkg/errutil"
 	"metrics-api/pkg/logger"
+	"metrics-api/pkg/openapi"
 
 	"github.com/gorilla/mux"
 )
 
+// Metric export parameters
+const (
+	// DefaultExportStep is the resolution of exports that do not set ?step=
+	DefaultExportStep = time.Minute
+	// MaxExportPoints is the most samples per series an export may hold,
+	// the limit Prometheus puts on range queries
+	MaxExportPoints = 11000
+)
+
+// Anomaly detection parameters used when the request leaves them out
+const (
+	defaultAnomalyLookback  = 24 * time.Hour
+	defaultAnomalyThreshold = 2.5
+)
+
 // MetricsHandler handles metrics-related HTTP requests
 type MetricsHandler struct {
 	service *service.MetricsService // Changed to pointer
@@ -31,14 +52,154 @@ func NewMetricsHandler(service *service.MetricsService, logger logger.Logger) *M
 func (h *MetricsHandler) RegisterRoutes(r *mux.Router) {
 	r.HandleFunc("/metrics", h.GetMetrics).Methods("GET")
 	r.HandleFunc("/metrics/top", h.GetTopMetrics).Methods("GET")
+	r.HandleFunc("/metrics/high-cardinality", h.GetHighCardinalityMetrics).Methods("GET")
 	r.HandleFunc("/metrics/{name}", h.GetMetricSummary).Methods("GET")
 	r.HandleFunc("/metrics/{name}/health", h.GetMetricHealth).Methods("GET")
+	r.HandleFunc("/metrics/{name}/quantile", h.GetMetricQuantile).Methods("GET")
+	r.HandleFunc("/metrics/{name}/anomalies", h.GetMetricAnomalies).Methods("GET")
+	r.HandleFunc("/metrics/{name}/export", h.ExportMetric).Methods("GET")
+	r.HandleFunc("/metrics/{name}/labels/{label}/values", h.GetLabelValues).Methods("GET")
+	r.HandleFunc("/jobs", h.GetJobs).Methods("GET")
+	r.HandleFunc("/metrics/summary/baselines", h.SaveBaseline).Methods("POST")
+	r.HandleFunc("/metrics/summary/vs/{baseline}", h.CompareWithBaseline).Methods("GET")
+}
+
+// DescribeRoutes documents the handler routes
+func (h *MetricsHandler) DescribeRoutes(b *openapi.Builder) {
+	timeRange := []openapi.Param{
+		{Name: "start", Description: "RFC 3339 or Unix time"},
+		{Name: "end", Description: "RFC 3339 or Unix time"},
+	}
+	b.Add(
+		openapi.Route{
+			Method: "GET", Path: "/metrics", Tag: "metrics",
+			Summary: "List the metric names",
+			Query: []openapi.Param{
+				{Name: "search", Description: "Regular expression the names must match"},
+				{Name: "limit", Type: "integer", Description: "Most names to return when searching"},
+			},
+			Response: struct {
+				Metrics []string `json:"metrics"`
+				Count   int      `json:"count"`
+			}{},
+		},
+		openapi.Route{
+			Method: "GET", Path: "/metrics/top", Tag: "metrics",
+			Summary: "List the metrics with the most series",
+			Query:   []openapi.Param{{Name: "limit", Type: "integer", Description: "Most metrics to return, 10 by default"}},
+			Response: struct {
+				Metrics []models.TopMetric `json:"metrics"`
+				Count   int                `json:"count"`
+				Errors  map[string]string  `json:"errors,omitempty"`
+			}{},
+		},
+		openapi.Route{
+			Method: "GET", Path: "/metrics/high-cardinality", Tag: "metrics",
+			Summary: "List the metrics with more series than a threshold",
+			Query:   []openapi.Param{{Name: "threshold", Type: "integer", Required: true}},
+			Response: struct {
+				Metrics   []models.MetricCardinality `json:"metrics"`
+				Threshold int                        `json:"threshold"`
+				Count     int                        `json:"count"`
+			}{},
+		},
+		openapi.Route{
+			Method: "GET", Path: "/metrics/{name}", Tag: "metrics",
+			Summary: "Summarize a metric",
+			Response: struct {
+				models.MetricSummary
+				Errors map[string]string `json:"errors,omitempty"`
+			}{},
+		},
+		openapi.Route{
+			Method: "GET", Path: "/metrics/{name}/health", Tag: "metrics",
+			Summary:  "Check whether a metric is fresh and gap free",
+			Response: models.MetricHealth{},
+		},
+		openapi.Route{
+			Method: "GET", Path: "/metrics/{name}/quantile", Tag: "metrics",
+			Summary: "Compute a quantile of a metric's current samples",
+			Query: []openapi.Param{
+				{Name: "q", Type: "number", Required: true, Description: "Quantile between 0 and 1"},
+				{Name: "by", Description: "Comma-separated labels to group by"},
+			},
+			Response: models.MetricQuantile{},
+		},
+		openapi.Route{
+			Method: "GET", Path: "/metrics/{name}/anomalies", Tag: "metrics",
+			Summary: "Find samples of a metric far from their rolling mean",
+			Query: []openapi.Param{
+				{Name: "lookback", Description: "Duration to look back over, 24h by default"},
+				{Name: "threshold", Type: "number", Description: "Z-score above which a sample is anomalous, 2.5 by default"},
+			},
+			Response: struct {
+				Anomalies []models.Anomaly `json:"anomalies"`
+				Count     int              `json:"count"`
+			}{},
+		},
+		openapi.Route{
+			Method: "GET", Path: "/metrics/{name}/export", Tag: "metrics",
+			Summary: "Download the raw samples of a metric",
+			Query: append([]openapi.Param{
+				{Name: "format", Description: "json or csv"},
+				{Name: "step", Description: "Resolution as a duration"},
+			}, timeRange...),
+			Response: models.RangeQueryResponse{},
+		},
+		openapi.Route{
+			Method: "GET", Path: "/metrics/{name}/labels/{label}/values", Tag: "metrics",
+			Summary: "List the values of a label on a metric's series",
+			Query:   timeRange,
+			Response: struct {
+				Metric string   `json:"metric"`
+				Label  string   `json:"label"`
+				Values []string `json:"values"`
+				Count  int      `json:"count"`
+			}{},
+		},
+		openapi.Route{
+			Method: "GET", Path: "/jobs", Tag: "metrics",
+			Summary: "Summarize the scrape targets of each job",
+			Response: struct {
+				Jobs  []models.JobHealth `json:"jobs"`
+				Count int                `json:"count"`
+			}{},
+		},
+		openapi.Route{
+			Method: "POST", Path: "/metrics/summary/baselines", Tag: "metrics",
+			Summary: "Save the current summary of a metric as a baseline",
+			Request: struct {
+				Name   string `json:"name"`
+				Metric string `json:"metric"`
+			}{},
+			Response: models.MetricBaseline{},
+			Status:   http.StatusCreated,
+		},
+		openapi.Route{
+			Method: "GET", Path: "/metrics/summary/vs/{baseline}", Tag: "metrics",
+			Summary: "Compare the current summary of a metric with a baseline",
+			Response: struct {
+				models.BaselineComparison
+				Errors map[string]string `json:"errors,omitempty"`
+			}{},
+		},
+	)
 }
 
-// GetMetrics returns a list of available metrics
+// DefaultMetricSearchLimit caps the names returned by ?search= unless the
+// request sets ?limit=
+const DefaultMetricSearchLimit = 100
+
+// GetMetrics returns a list of available metrics, or with ?search= those
+// matching a regular expression, up to ?limit=
 func (h *MetricsHandler) GetMetrics(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
+	if r.URL.Query().Has("search") {
+		h.searchMetrics(w, r)
+		return
+	}
+
 	metrics, err := h.service.GetMetrics(ctx)
 	if err != nil {
 		h.logger.Errorf("Failed to get metrics: %v", err)
@@ -52,6 +213,35 @@ func (h *MetricsHandler) GetMetrics(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// searchMetrics responds with the metric names matching ?search=
+func (h *MetricsHandler) searchMetrics(w http.ResponseWriter, r *http.Request) {
+	limit := DefaultMetricSearchLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsedLimit, err := strconv.Atoi(limitStr)
+		if err != nil || parsedLimit <= 0 {
+			RespondWithError(w, http.StatusBadRequest, "Invalid limit parameter")
+			return
+		}
+		limit = parsedLimit
+	}
+
+	metrics, err := h.service.SearchMetrics(r.Context(), r.URL.Query().Get("search"), limit)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidQuery) {
+			RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		h.logger.Errorf("Failed to search metrics: %v", err)
+		RespondWithUpstreamError(w, err, "Failed to search metrics")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"metrics": metrics,
+		"count":   len(metrics),
+	})
+}
+
 // GetTopMetrics returns the top metrics by cardinality
 func (h *MetricsHandler) GetTopMetrics(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -69,7 +259,8 @@ func (h *MetricsHandler) GetTopMetrics(w http.ResponseWriter, r *http.Request) {
 	}
 
 	topMetrics, err := h.service.GetTopMetrics(ctx, limit)
-	if err != nil {
+	partial, isPartial := errutil.AsMulti(err)
+	if err != nil && !isPartial {
 		h.logger.Errorf("Failed to get top metrics: %v", err)
 		RespondWithError(w, http.StatusInternalServerError, "Failed to get top metrics")
 		return
@@ -85,10 +276,14 @@ func (h *MetricsHandler) GetTopMetrics(w http.ResponseWriter, r *http.Request) {
 	response := struct {
 		Metrics []models.TopMetric `json:"metrics"`
 		Count   int               `json:"count"`
+		Errors  map[string]string `json:"errors,omitempty"`
 	}{
 		Metrics: topMetrics,
 		Count:   len(topMetrics),
 	}
+	if isPartial {
+		response.Errors = errorDetails(w, partial)
+	}
 
 	RespondWithJSON(w, http.StatusOK, response)
 }
@@ -105,6 +300,13 @@ func (h *MetricsHandler) GetMetricSummary(w http.ResponseWriter, r *http.Request
 	}
 
 	summary, err := h.service.GetMetricSummary(ctx, metricName)
+	if partial, ok := errutil.AsMulti(err); ok {
+		RespondWithJSON(w, http.StatusOK, struct {
+			*models.MetricSummary
+			Errors map[string]string `json:"errors"`
+		}{summary, errorDetails(w, partial)})
+		return
+	}
 	if err != nil {
 		if errors.Is(err, models.ErrMetricNotFound) {
 			RespondWithError(w, http.StatusNotFound, "Metric not found")
@@ -138,3 +340,290 @@ func (h *MetricsHandler) GetMetricHealth(w http.ResponseWriter, r *http.Request)
 
 	RespondWithJSON(w, http.StatusOK, health)
 }
+
+// GetMetricQuantile returns the ?q= quantile of a metric's current samples,
+// optionally grouped by the comma-separated ?by= labels
+func (h *MetricsHandler) GetMetricQuantile(w http.ResponseWriter, r *http.Request) {
+	metricName := mux.Vars(r)["name"]
+
+	q, err := strconv.ParseFloat(r.URL.Query().Get("q"), 64)
+	if err != nil || !(q > 0 && q < 1) {
+		RespondWithError(w, http.StatusBadRequest, "Parameter q must be a number between 0 and 1")
+		return
+	}
+
+	var by []string
+	for _, label := range strings.Split(r.URL.Query().Get("by"), ",") {
+		if label = strings.TrimSpace(label); label != "" {
+			by = append(by, label)
+		}
+	}
+
+	quantile, err := h.service.GetMetricQuantile(r.Context(), metricName, q, by)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidQuery):
+			RespondWithError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, models.ErrMetricNotFound):
+			RespondWithError(w, http.StatusNotFound, "Metric has no samples")
+		default:
+			h.logger.Errorf("Failed to compute quantile of %s: %v", metricName, err)
+			RespondWithUpstreamError(w, err, "Failed to compute quantile")
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, quantile)
+}
+
+// GetHighCardinalityMetrics returns the metrics with more than ?threshold=
+// series, most series first
+func (h *MetricsHandler) GetHighCardinalityMetrics(w http.ResponseWriter, r *http.Request) {
+	threshold, err := strconv.Atoi(r.URL.Query().Get("threshold"))
+	if err != nil || threshold < 0 {
+		RespondWithError(w, http.StatusBadRequest, "Parameter threshold must be a non-negative integer")
+		return
+	}
+
+	metrics, err := h.service.GetHighCardinalityMetrics(r.Context(), threshold)
+	if err != nil {
+		h.logger.Errorf("Failed to get high cardinality metrics: %v", err)
+		RespondWithUpstreamError(w, err, "Failed to get high cardinality metrics")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"metrics":   metrics,
+		"threshold": threshold,
+		"count":     len(metrics),
+	})
+}
+
+// ParseExportRequest reads a metric export from the {name} route variable
+// and the ?format=, ?start=, ?end= and ?step= parameters. The range defaults
+// to the last hour at DefaultExportStep, and the format to JSON.
+func ParseExportRequest(r *http.Request) (*models.ExportRequest, error) {
+	q := r.URL.Query()
+	req := &models.ExportRequest{
+		Metric: mux.Vars(r)["name"],
+		Format: strings.ToLower(q.Get("format")),
+		Step:   DefaultExportStep,
+	}
+	if req.Metric == "" {
+		return nil, fmt.Errorf("%w: metric name is required", models.ErrInvalidQuery)
+	}
+
+	switch req.Format {
+	case "":
+		req.Format = models.ExportFormatJSON
+	case models.ExportFormatJSON, models.ExportFormatCSV:
+	default:
+		return nil, fmt.Errorf("%w: format must be json or csv", models.ErrInvalidQuery)
+	}
+
+	var err error
+	if req.End, err = parseTime(q.Get("end")); err != nil {
+		return nil, fmt.Errorf("%w: invalid end parameter", models.ErrInvalidTimeRange)
+	}
+	req.Start = req.End.Add(-time.Hour)
+	if raw := q.Get("start"); raw != "" {
+		if req.Start, err = parseTime(raw); err != nil {
+			return nil, fmt.Errorf("%w: invalid start parameter", models.ErrInvalidTimeRange)
+		}
+	}
+	if req.Start.After(req.End) {
+		return nil, fmt.Errorf("%w: start is after end", models.ErrInvalidTimeRange)
+	}
+
+	if raw := q.Get("step"); raw != "" {
+		if req.Step, err = time.ParseDuration(raw); err != nil || req.Step <= 0 {
+			return nil, fmt.Errorf("%w: step must be a positive duration", models.ErrInvalidQuery)
+		}
+	}
+	if points := req.End.Sub(req.Start)/req.Step + 1; points > MaxExportPoints {
+		return nil, fmt.Errorf("%w: %d points per series, at most %d allowed", models.ErrTooManyDataPoints, points, MaxExportPoints)
+	}
+	return req, nil
+}
+
+// ExportMetric downloads the raw samples of a metric as JSON in the range
+// query layout, or as a CSV file streamed one series at a time
+func (h *MetricsHandler) ExportMetric(w http.ResponseWriter, r *http.Request) {
+	req, err := ParseExportRequest(r)
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	response, err := h.service.ExportMetric(r.Context(), *req)
+	if err != nil {
+		h.logger.Errorf("Failed to export %s: %v", req.Metric, err)
+		RespondWithUpstreamError(w, err, "Failed to export metric")
+		return
+	}
+
+	if req.Format == models.ExportFormatCSV {
+		if err := streamSeriesCSV(w, req.Metric+".csv", response.Series); err != nil {
+			h.logger.Debugf("Export of %s ended early: %v", req.Metric, err)
+		}
+		return
+	}
+	RespondWithJSON(w, http.StatusOK, response)
+}
+
+// GetMetricAnomalies returns the samples of a metric over ?lookback= (24h by
+// default) whose rolling z-score exceeds ?threshold= (2.5 by default)
+func (h *MetricsHandler) GetMetricAnomalies(w http.ResponseWriter, r *http.Request) {
+	metricName := mux.Vars(r)["name"]
+
+	lookback := defaultAnomalyLookback
+	if raw := r.URL.Query().Get("lookback"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed <= 0 {
+			RespondWithError(w, http.StatusBadRequest, "Parameter lookback must be a positive duration")
+			return
+		}
+		lookback = parsed
+	}
+
+	threshold := defaultAnomalyThreshold
+	if raw := r.URL.Query().Get("threshold"); raw != "" {
+		parsed, err := strconv.ParseFloat(raw, 64)
+		if err != nil || !(parsed > 0) || math.IsInf(parsed, 0) {
+			RespondWithError(w, http.StatusBadRequest, "Parameter threshold must be a positive number")
+			return
+		}
+		threshold = parsed
+	}
+
+	anomalies, err := h.service.DetectAnomalies(r.Context(), metricName, lookback, threshold)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidQuery):
+			RespondWithError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, models.ErrMetricNotFound):
+			RespondWithError(w, http.StatusNotFound, "Metric has no samples")
+		default:
+			h.logger.Errorf("Failed to detect anomalies of %s: %v", metricName, err)
+			RespondWithUpstreamError(w, err, "Failed to detect anomalies")
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"anomalies": anomalies,
+		"count":     len(anomalies),
+	})
+}
+
+// GetLabelValues returns the distinct values of a label on a metric's
+// series, limited to the optional ?start= and ?end= range
+func (h *MetricsHandler) GetLabelValues(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	metricName, label := vars["name"], vars["label"]
+
+	var start, end time.Time
+	var err error
+	if raw := r.URL.Query().Get("start"); raw != "" {
+		if start, err = parseTime(raw); err != nil {
+			RespondWithError(w, http.StatusBadRequest, "Invalid start parameter")
+			return
+		}
+	}
+	if raw := r.URL.Query().Get("end"); raw != "" {
+		if end, err = parseTime(raw); err != nil {
+			RespondWithError(w, http.StatusBadRequest, "Invalid end parameter")
+			return
+		}
+	}
+
+	values, err := h.service.GetLabelValues(r.Context(), metricName, label, start, end)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidQuery), errors.Is(err, models.ErrInvalidTimeRange):
+			RespondWithError(w, http.StatusBadRequest, err.Error())
+		default:
+			h.logger.Errorf("Failed to get values of %s for %s: %v", label, metricName, err)
+			RespondWithUpstreamError(w, err, "Failed to get label values")
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"metric": metricName,
+		"label":  label,
+		"values": values,
+		"count":  len(values),
+	})
+}
+
+// GetJobs returns the up/down target counts of every scrape job
+func (h *MetricsHandler) GetJobs(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	jobs, err := h.service.GetJobs(ctx)
+	if err != nil {
+		h.logger.Errorf("Failed to get jobs: %v", err)
+		RespondWithError(w, http.StatusInternalServerError, "Failed to get jobs")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"jobs":  jobs,
+		"count": len(jobs),
+	})
+}
+
+// SaveBaseline snapshots a metric summary under a name for later comparison
+func (h *MetricsHandler) SaveBaseline(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Name   string `json:"name"`
+		Metric string `json:"metric"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if payload.Name == "" || payload.Metric == "" {
+		RespondWithError(w, http.StatusBadRequest, "Baseline name and metric are required")
+		return
+	}
+
+	baseline, err := h.service.SaveBaseline(r.Context(), payload.Name, payload.Metric)
+	if err != nil {
+		h.logger.Errorf("Failed to save baseline %s: %v", payload.Name, err)
+		RespondWithUpstreamError(w, err, "Failed to save baseline")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusCreated, baseline)
+}
+
+// CompareWithBaseline returns the current summary alongside a saved baseline
+func (h *MetricsHandler) CompareWithBaseline(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["baseline"]
+
+	comparison, err := h.service.CompareWithBaseline(r.Context(), name)
+	partial, isPartial := errutil.AsMulti(err)
+	if err != nil && !isPartial {
+		if errors.Is(err, models.ErrBaselineNotFound) {
+			RespondWithError(w, http.StatusNotFound, "Baseline not found")
+			return
+		}
+		h.logger.Errorf("Failed to compare with baseline %s: %v", name, err)
+		RespondWithUpstreamError(w, err, "Failed to compare with baseline")
+		return
+	}
+
+	if !isPartial {
+		RespondWithJSON(w, http.StatusOK, comparison)
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, struct {
+		*models.BaselineComparison
+		Errors map[string]string `json:"errors"`
+	}{comparison, errorDetails(w, partial)})
+}
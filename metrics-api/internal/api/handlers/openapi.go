@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"net/http"
+
+	"metrics-api/pkg/logger"
+	"metrics-api/pkg/openapi"
+
+	"github.com/gorilla/mux"
+)
+
+// OpenAPIHandler serves the OpenAPI description of the routes the other
+// handlers document
+type OpenAPIHandler struct {
+	spec   *openapi.Builder
+	logger logger.Logger
+}
+
+// NewOpenAPIHandler creates a new OpenAPI handler
+func NewOpenAPIHandler(spec *openapi.Builder, logger logger.Logger) *OpenAPIHandler {
+	return &OpenAPIHandler{
+		spec:   spec,
+		logger: logger,
+	}
+}
+
+// RegisterRoutes registers the handler routes
+func (h *OpenAPIHandler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/openapi.yaml", h.GetYAML).Methods("GET")
+	r.HandleFunc("/openapi.json", h.GetJSON).Methods("GET")
+}
+
+// GetYAML handles GET /openapi.yaml
+func (h *OpenAPIHandler) GetYAML(w http.ResponseWriter, r *http.Request) {
+	body, err := h.spec.YAML()
+	if err != nil {
+		h.logger.Errorf("Failed to render OpenAPI spec: %v", err)
+		RespondWithError(w, http.StatusInternalServerError, "Failed to render OpenAPI spec")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/yaml")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// GetJSON handles GET /openapi.json
+func (h *OpenAPIHandler) GetJSON(w http.ResponseWriter, r *http.Request) {
+	body, err := h.spec.JSON()
+	if err != nil {
+		h.logger.Errorf("Failed to render OpenAPI spec: %v", err)
+		RespondWithError(w, http.StatusInternalServerError, "Failed to render OpenAPI spec")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"net/http"
+
+	"metrics-api/internal/models"
+	"metrics-api/internal/prometheus"
+	"metrics-api/pkg/logger"
+	"metrics-api/pkg/openapi"
+
+	"github.com/gorilla/mux"
+)
+
+// PrometheusHandler handles requests about the connected Prometheus server itself
+type PrometheusHandler struct {
+	client *prometheus.Client
+	logger logger.Logger
+}
+
+// NewPrometheusHandler creates a new Prometheus status handler
+func NewPrometheusHandler(client *prometheus.Client, logger logger.Logger) *PrometheusHandler {
+	return &PrometheusHandler{
+		client: client,
+		logger: logger,
+	}
+}
+
+// RegisterRoutes registers the handler routes
+func (h *PrometheusHandler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/prometheus/tsdb", h.GetTSDBStatus).Methods("GET")
+	r.HandleFunc("/targets", h.GetTargets).Methods("GET")
+}
+
+// RegisterAdminRoutes registers the handler's admin routes on a router
+// mounted at /admin
+func (h *PrometheusHandler) RegisterAdminRoutes(r *mux.Router) {
+	r.HandleFunc("/prometheus/errors", h.GetRecentErrors).Methods("GET")
+	r.HandleFunc("/prometheus/check", h.CheckConnectivity).Methods("GET")
+}
+
+// DescribeRoutes documents the handler routes
+func (h *PrometheusHandler) DescribeRoutes(b *openapi.Builder) {
+	b.Add(
+		openapi.Route{
+			Method: "GET", Path: "/prometheus/tsdb", Tag: "prometheus",
+			Summary:  "Report head block and cardinality statistics",
+			Response: models.TSDBStatus{},
+		},
+		openapi.Route{
+			Method: "GET", Path: "/targets", Tag: "prometheus",
+			Summary:  "List the scrape targets",
+			Query:    []openapi.Param{{Name: "state", Description: "up, down or any, the default"}},
+			Response: models.TargetsResult{},
+		},
+	)
+}
+
+// DescribeAdminRoutes documents the handler's admin routes
+func (h *PrometheusHandler) DescribeAdminRoutes(b *openapi.Builder) {
+	b.Add(
+		openapi.Route{
+			Method: "GET", Path: "/admin/prometheus/errors", Tag: "admin",
+			Summary: "List the most recent failed queries",
+			Response: struct {
+				Errors []prometheus.QueryError `json:"errors"`
+				Count  int                     `json:"count"`
+			}{},
+		},
+		openapi.Route{
+			Method: "GET", Path: "/admin/prometheus/check", Tag: "admin",
+			Summary: "Check that Prometheus can be reached and queried",
+			Response: struct {
+				Target  string                 `json:"target,omitempty"`
+				Success bool                   `json:"success"`
+				Steps   []prometheus.CheckStep `json:"steps"`
+			}{},
+		},
+	)
+}
+
+// clientFor returns the client of the Prometheus source r is directed at
+func (h *PrometheusHandler) clientFor(r *http.Request) *prometheus.Client {
+	return prometheus.FromContext(r.Context(), h.client)
+}
+
+// GetTSDBStatus returns head block statistics and top cardinalities
+func (h *PrometheusHandler) GetTSDBStatus(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	status, err := h.clientFor(r).TSDBStatus(ctx)
+	if err != nil {
+		h.logger.Errorf("Failed to get TSDB status: %v", err)
+		RespondWithError(w, http.StatusInternalServerError, "Failed to get TSDB status")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, status)
+}
+
+// GetTargets returns the scrape targets known to Prometheus. ?state=up or
+// down keeps only the active targets with that health; the default, any,
+// also includes the dropped targets.
+func (h *PrometheusHandler) GetTargets(w http.ResponseWriter, r *http.Request) {
+	state := r.URL.Query().Get("state")
+	switch state {
+	case "":
+		state = "any"
+	case "any", "up", "down":
+	default:
+		RespondWithError(w, http.StatusBadRequest, "Invalid state parameter, must be up, down or any")
+		return
+	}
+
+	targets, err := h.clientFor(r).GetTargets(r.Context())
+	if err != nil {
+		h.logger.Errorf("Failed to get targets: %v", err)
+		RespondWithError(w, http.StatusInternalServerError, "Failed to get targets")
+		return
+	}
+
+	if state == "any" {
+		RespondWithJSON(w, http.StatusOK, targets)
+		return
+	}
+
+	// Filter into a new result, the cached one is shared
+	filtered := &models.TargetsResult{Active: []models.Target{}, Dropped: []models.Target{}}
+	for _, target := range targets.Active {
+		if target.Health == state {
+			filtered.Active = append(filtered.Active, target)
+		}
+	}
+	RespondWithJSON(w, http.StatusOK, filtered)
+}
+
+// GetRecentErrors returns the most recent failed Prometheus queries, newest first
+func (h *PrometheusHandler) GetRecentErrors(w http.ResponseWriter, r *http.Request) {
+	errors := h.clientFor(r).RecentErrors()
+
+	RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"errors": errors,
+		"count":  len(errors),
+	})
+}
+
+// CheckConnectivity runs a one-shot check that Prometheus can be reached and
+// queried, reporting each step separately. Failures are part of the report,
+// so the response is 200 whenever the check itself ran.
+func (h *PrometheusHandler) CheckConnectivity(w http.ResponseWriter, r *http.Request) {
+	client := h.clientFor(r)
+	steps := client.CheckConnectivity(r.Context())
+
+	success := true
+	for _, step := range steps {
+		if !step.Success {
+			h.logger.Warnf("Prometheus connectivity check step %s failed: %s", step.Name, step.Error)
+			success = false
+		}
+	}
+
+	response := map[string]interface{}{
+		"success": success,
+		"steps":   steps,
+	}
+	// Clients built with NewQueryClient have no targets
+	if targets := client.Targets(); len(targets) > 0 {
+		response["target"] = targets[0]
+	}
+	RespondWithJSON(w, http.StatusOK, response)
+}
@@ -1,43 +1,233 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"math"
 	"net/http"
 	"strconv"
 	"time"
 
 	"metrics-api/internal/models"
 	"metrics-api/internal/service"
+	"metrics-api/pkg/errutil"
 	"metrics-api/pkg/logger"
+	"metrics-api/pkg/openapi"
 
 	"github.com/gorilla/mux"
 )
 
 // QueriesHandler handles query-related HTTP requests
 type QueriesHandler struct {
-	service service.QueriesService
-	logger  logger.Logger
+	service           *service.QueriesService
+	logger            logger.Logger
+	heartbeat         time.Duration
+	maxStreamDuration time.Duration
+	shutdown          context.Context
 }
 
 // NewQueriesHandler creates a new queries handler
 func NewQueriesHandler(service *service.QueriesService, logger logger.Logger) *QueriesHandler {
 	return &QueriesHandler{
-		service: *service,
-		logger:  logger,
+		service:           service,
+		logger:            logger,
+		heartbeat:         DefaultHeartbeatInterval,
+		maxStreamDuration: DefaultMaxStreamDuration,
+		shutdown:          context.Background(),
 	}
 }
 
+// WithHeartbeatInterval sets how long a streaming response may stay silent
+// before a keepalive comment is sent
+func (h *QueriesHandler) WithHeartbeatInterval(interval time.Duration) *QueriesHandler {
+	h.heartbeat = interval
+	return h
+}
+
+// WithMaxStreamDuration sets how long a streaming response may stay open
+// before the server ends it
+func (h *QueriesHandler) WithMaxStreamDuration(d time.Duration) *QueriesHandler {
+	h.maxStreamDuration = d
+	return h
+}
+
+// WithShutdown sets a context whose cancellation ends the handler's
+// streams, so shutdown does not wait for their clients to leave
+func (h *QueriesHandler) WithShutdown(ctx context.Context) *QueriesHandler {
+	h.shutdown = ctx
+	return h
+}
+
 // RegisterRoutes registers the handler routes
 func (h *QueriesHandler) RegisterRoutes(r *mux.Router) {
 	r.HandleFunc("/query", h.InstantQuery).Methods("POST")
 	r.HandleFunc("/query/range", h.RangeQuery).Methods("POST")
+	r.HandleFunc("/query/batch", h.BatchQuery).Methods("POST")
 	r.HandleFunc("/query/validate", h.ValidateQuery).Methods("POST")
+	r.HandleFunc("/query/preview", h.PreviewQuery).Methods("POST")
+	r.HandleFunc("/query/combine", h.CombineQuery).Methods("POST")
+	r.HandleFunc("/queries/saved", h.ListSavedQueries).Methods("GET")
+	r.HandleFunc("/queries/saved", h.SaveQuery).Methods("POST")
+	r.HandleFunc("/queries/saved/{name}", h.GetSavedQuery).Methods("GET")
+	r.HandleFunc("/queries/saved/{name}", h.DeleteSavedQuery).Methods("DELETE")
+	r.HandleFunc("/queries/saved/{name}/run", h.RunSavedQuery).Methods("GET")
+	r.HandleFunc("/queries/history", h.GetQueryHistory).Methods("GET")
 	r.HandleFunc("/query/suggestions", h.GetQuerySuggestions).Methods("GET")
+	r.HandleFunc("/query/watch", h.WatchQuery).Methods("GET")
+	r.HandleFunc("/query/stream", h.StreamQuery).Methods("GET")
+}
+
+// DescribeRoutes documents the handler routes
+func (h *QueriesHandler) DescribeRoutes(b *openapi.Builder) {
+	stream := []openapi.Param{
+		{Name: "query", Required: true},
+		{Name: "interval", Description: "Duration between runs, 15s by default"},
+	}
+	b.Add(
+		openapi.Route{
+			Method: "POST", Path: "/query", Tag: "queries",
+			Summary:  "Run an instant query",
+			Query:    instantQueryParams,
+			Request:  models.InstantQueryParams{},
+			Response: models.QueryResponse{},
+		},
+		openapi.Route{
+			Method: "POST", Path: "/query/range", Tag: "queries",
+			Summary:  "Run a range query",
+			Query:    append([]openapi.Param{{Name: "format", Description: "json, csv or table"}}, queryCacheParams...),
+			Request:  models.RangeQueryParams{},
+			Response: models.RangeQueryResponse{},
+		},
+		openapi.Route{
+			Method: "POST", Path: "/query/batch", Tag: "queries",
+			Summary: "Run several instant queries",
+			Query:   queryCacheParams,
+			Request: models.BatchQueryParams{},
+			Response: struct {
+				Results []models.QueryResponse `json:"results"`
+				Count   int                    `json:"count"`
+				Errors  map[string]string      `json:"errors,omitempty"`
+			}{},
+		},
+		openapi.Route{
+			Method: "POST", Path: "/query/validate", Tag: "queries",
+			Summary: "Check the syntax and estimate the cost of a query",
+			Request: struct {
+				models.RangeQueryParams
+				DryRun bool `json:"dry_run"`
+			}{},
+			Response: models.QueryValidation{},
+		},
+		openapi.Route{
+			Method: "POST", Path: "/query/preview", Tag: "queries",
+			Summary: "Show a query with label matchers added",
+			Request: struct {
+				Query  string            `json:"query"`
+				Labels map[string]string `json:"labels"`
+			}{},
+			Response: struct {
+				Query   string            `json:"query"`
+				Labels  map[string]string `json:"labels"`
+				Preview string            `json:"preview"`
+			}{},
+		},
+		openapi.Route{
+			Method: "POST", Path: "/query/combine", Tag: "queries",
+			Summary: "Apply an arithmetic operator to two instant queries",
+			Request: struct {
+				QueryA           string    `json:"query_a"`
+				QueryB           string    `json:"query_b"`
+				Op               string    `json:"op" example:"div"`
+				Time             time.Time `json:"time"`
+				IncludeUnmatched bool      `json:"include_unmatched"`
+			}{},
+			Response: struct {
+				QueryA string `json:"query_a"`
+				QueryB string `json:"query_b"`
+				Op     string `json:"op"`
+				Data   []struct {
+					Labels    map[string]string `json:"labels"`
+					Value     *float64          `json:"value"`
+					Timestamp time.Time         `json:"timestamp"`
+				} `json:"data"`
+			}{},
+		},
+		openapi.Route{
+			Method: "GET", Path: "/queries/saved", Tag: "queries",
+			Summary: "List the saved queries",
+			Response: struct {
+				Queries []models.SavedQuery `json:"queries"`
+				Count   int                 `json:"count"`
+			}{},
+		},
+		openapi.Route{
+			Method: "POST", Path: "/queries/saved", Tag: "queries",
+			Summary:  "Save a named query",
+			Request:  models.SavedQuery{},
+			Response: models.SavedQuery{},
+			Status:   http.StatusCreated,
+		},
+		openapi.Route{
+			Method: "GET", Path: "/queries/saved/{name}", Tag: "queries",
+			Summary:  "Get a saved query",
+			Response: models.SavedQuery{},
+		},
+		openapi.Route{
+			Method: "DELETE", Path: "/queries/saved/{name}", Tag: "queries",
+			Summary: "Delete a saved query",
+			Status:  http.StatusNoContent,
+		},
+		openapi.Route{
+			Method: "GET", Path: "/queries/saved/{name}/run", Tag: "queries",
+			Summary:  "Run a saved query as an instant query",
+			Query:    instantQueryParams,
+			Response: models.QueryResponse{},
+		},
+		openapi.Route{
+			Method: "GET", Path: "/queries/history", Tag: "queries",
+			Summary: "List the most recently executed queries",
+			Query: []openapi.Param{
+				{Name: "limit", Type: "integer", Description: "Most queries to return, 100 by default"},
+				{Name: "since", Description: "RFC 3339 or Unix time"},
+			},
+			Response: struct {
+				Queries []models.QueryHistoryEntry `json:"queries"`
+				Count   int                        `json:"count"`
+			}{},
+		},
+		openapi.Route{
+			Method: "GET", Path: "/query/suggestions", Tag: "queries",
+			Summary: "Suggest metric names for a prefix",
+			Query: []openapi.Param{
+				{Name: "prefix"},
+				{Name: "limit", Type: "integer", Description: "Most suggestions to return, 10 by default"},
+			},
+			Response: struct {
+				Suggestions []string `json:"suggestions"`
+				Count       int      `json:"count"`
+			}{},
+		},
+		openapi.Route{
+			Method: "GET", Path: "/query/watch", Tag: "queries",
+			Summary:     "Stream the result of an instant query whenever it changes",
+			Query:       stream,
+			ContentType: "text/event-stream",
+		},
+		openapi.Route{
+			Method: "GET", Path: "/query/stream", Tag: "queries",
+			Summary:     "Stream the result of an instant query at an interval",
+			Query:       stream,
+			ContentType: "text/event-stream",
+		},
+	)
 }
 
 // InstantQuery executes an instant query
 func (h *QueriesHandler) InstantQuery(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	r, timings := withTimings(r)
 	ctx := r.Context()
 
 	var params models.InstantQueryParams
@@ -51,6 +241,9 @@ func (h *QueriesHandler) InstantQuery(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	params.BypassCache = cacheBypassRequested(r)
+	params.KeepName = keepNameRequested(r)
+
 	response, err := h.service.ExecuteInstantQuery(ctx, params)
 	if err != nil {
 		if errors.Is(err, models.ErrInvalidQuery) {
@@ -58,17 +251,152 @@ func (h *QueriesHandler) InstantQuery(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		h.logger.Errorf("Failed to execute instant query: %v", err)
-		RespondWithError(w, http.StatusInternalServerError, "Failed to execute query")
+		RespondWithUpstreamError(w, err, "Failed to execute query")
 		return
 	}
 
-	RespondWithJSON(w, http.StatusOK, response)
+	writeServerTiming(w, timings, start)
+	markCacheBypass(w, params.BypassCache)
+	h.respondWithQueryResponse(w, r, response)
+}
+
+// minWatchInterval keeps watch streams from polling Prometheus too often
+const minWatchInterval = time.Second
+
+// WatchQuery streams an instant query as Server-Sent Events, re-running it
+// every ?interval= and sending a result event only when the data changed
+func (h *QueriesHandler) WatchQuery(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("query")
+	if query == "" {
+		RespondWithError(w, http.StatusBadRequest, "Query cannot be empty")
+		return
+	}
+
+	interval := 15 * time.Second
+	if s := r.URL.Query().Get("interval"); s != "" {
+		parsed, err := time.ParseDuration(s)
+		if err != nil || parsed < minWatchInterval {
+			RespondWithError(w, http.StatusBadRequest, "Invalid interval parameter")
+			return
+		}
+		interval = parsed
+	}
+
+	stream, err := newSSEStream(w, h.heartbeat)
+	if err != nil {
+		h.logger.Errorf("Failed to start query watch stream: %v", err)
+		return
+	}
+
+	var last []byte
+	poll := func(ctx context.Context) error {
+		response, err := h.service.ExecuteInstantQuery(ctx, models.InstantQueryParams{
+			Query:    query,
+			Time:     time.Now(),
+			KeepName: keepNameRequested(r),
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			h.logger.Warnf("Watched query failed: %v", err)
+			code := http.StatusBadGateway
+			if errors.Is(err, models.ErrInvalidQuery) {
+				code = http.StatusBadRequest
+			}
+			return stream.Event("error", ErrorResponse{Error: http.StatusText(code), Code: code, Message: errorMessage(w, err)})
+		}
+
+		data, err := json.Marshal(response.Data)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(data, last) {
+			return nil
+		}
+		last = data
+		return stream.Event("result", response)
+	}
+
+	ctx, cancel := untilShutdown(r.Context(), h.shutdown)
+	defer cancel()
+
+	if err := stream.Run(ctx, interval, poll); err != nil {
+		h.logger.Debugf("Query watch stream ended: %v", err)
+	}
+}
+
+// StreamQuery streams an instant query as Server-Sent Events, sending a
+// metrics event with the fresh result every ?interval= until the client
+// disconnects or the stream reaches its maximum duration
+func (h *QueriesHandler) StreamQuery(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("query")
+	if query == "" {
+		RespondWithError(w, http.StatusBadRequest, "Query cannot be empty")
+		return
+	}
+
+	interval := 15 * time.Second
+	if s := r.URL.Query().Get("interval"); s != "" {
+		parsed, err := time.ParseDuration(s)
+		if err != nil || parsed < minWatchInterval {
+			RespondWithError(w, http.StatusBadRequest, "Invalid interval parameter")
+			return
+		}
+		interval = parsed
+	}
+
+	stream, err := newSSEStream(w, h.heartbeat)
+	if err != nil {
+		h.logger.Errorf("Failed to start query stream: %v", err)
+		return
+	}
+
+	ctx, stop := untilShutdown(r.Context(), h.shutdown)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, h.maxStreamDuration)
+	defer cancel()
+
+	id := 0
+	poll := func(ctx context.Context) error {
+		response, err := h.service.ExecuteInstantQuery(ctx, models.InstantQueryParams{
+			Query:    query,
+			Time:     time.Now(),
+			KeepName: keepNameRequested(r),
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			h.logger.Warnf("Streamed query failed: %v", err)
+			code := http.StatusBadGateway
+			if errors.Is(err, models.ErrInvalidQuery) {
+				code = http.StatusBadRequest
+			}
+			return stream.Event("error", ErrorResponse{Error: http.StatusText(code), Code: code, Message: errorMessage(w, err)})
+		}
+
+		id++
+		return stream.EventWithID(strconv.Itoa(id), "metrics", response)
+	}
+
+	if err := stream.Run(ctx, interval, poll); err != nil {
+		h.logger.Debugf("Query stream ended: %v", err)
+	}
 }
 
 // RangeQuery executes a range query
 func (h *QueriesHandler) RangeQuery(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	r, timings := withTimings(r)
 	ctx := r.Context()
 
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" && format != "table" {
+		RespondWithError(w, http.StatusBadRequest, "Invalid format parameter")
+		return
+	}
+
 	var params models.RangeQueryParams
 	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
 		RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
@@ -80,6 +408,9 @@ func (h *QueriesHandler) RangeQuery(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	params.BypassCache = cacheBypassRequested(r)
+	params.KeepName = keepNameRequested(r)
+
 	response, err := h.service.ExecuteRangeQuery(ctx, params)
 	if err != nil {
 		switch {
@@ -92,22 +423,83 @@ func (h *QueriesHandler) RangeQuery(w http.ResponseWriter, r *http.Request) {
 		case errors.Is(err, models.ErrTooManyDataPoints):
 			RespondWithError(w, http.StatusBadRequest, "Query would return too many data points")
 			return
+		case errors.Is(err, models.ErrStepTooSmall):
+			RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
 		default:
 			h.logger.Errorf("Failed to execute range query: %v", err)
-			RespondWithError(w, http.StatusInternalServerError, "Failed to execute range query")
+			RespondWithUpstreamError(w, err, "Failed to execute range query")
 			return
 		}
 	}
 
+	writeServerTiming(w, timings, start)
+	markCacheBypass(w, params.BypassCache)
+	switch format {
+	case "csv":
+		RespondWithCSV(w, "query-range.csv", rangeQueryCSV(response.Series))
+	case "table":
+		RespondWithText(w, rangeQueryText(response.Series))
+	default:
+		RespondWithJSON(w, http.StatusOK, response)
+	}
+}
+
+// BatchQuery executes several instant queries in one request
+func (h *QueriesHandler) BatchQuery(w http.ResponseWriter, r *http.Request) {
+	var params models.BatchQueryParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	bypassCache := cacheBypassRequested(r)
+	keepName := keepNameRequested(r)
+	for i := range params.Queries {
+		params.Queries[i].BypassCache = bypassCache
+		params.Queries[i].KeepName = keepName
+	}
+
+	responses, err := h.service.ExecuteBatch(r.Context(), params.Queries)
+	partial, isPartial := errutil.AsMulti(err)
+	if err != nil && !isPartial {
+		switch {
+		case errors.Is(err, models.ErrInvalidQuery), errors.Is(err, models.ErrBatchTooLarge):
+			RespondWithError(w, http.StatusBadRequest, err.Error())
+		default:
+			h.logger.Errorf("Failed to execute batch query: %v", err)
+			RespondWithUpstreamError(w, err, "Failed to execute batch query")
+		}
+		return
+	}
+
+	response := struct {
+		Results []*models.QueryResponse `json:"results"`
+		Count   int                     `json:"count"`
+		Errors  map[string]string       `json:"errors,omitempty"`
+	}{
+		Results: responses,
+		Count:   len(responses),
+	}
+	if isPartial {
+		response.Errors = errorDetails(w, partial)
+	}
+
+	markCacheBypass(w, bypassCache)
 	RespondWithJSON(w, http.StatusOK, response)
 }
 
-// ValidateQuery validates a query without executing it
+// ValidateQuery checks the syntax of a query without executing it. With
+// "dry_run" it also has Prometheus estimate the query's cost. A syntax error
+// is a 400 locating the error; a dry run that cannot reach Prometheus is
+// reported as an upstream error.
 func (h *QueriesHandler) ValidateQuery(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
+	// A step makes it a range query, estimated over start to end
 	var payload struct {
-		Query string `json:"query"`
+		models.RangeQueryParams
+		DryRun bool `json:"dry_run"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
@@ -115,16 +507,297 @@ func (h *QueriesHandler) ValidateQuery(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	validation, err := h.service.ValidateQuery(ctx, payload.Query)
+	var validation *models.QueryValidation
+	var err error
+	if payload.Step != "" {
+		validation, err = h.service.ValidateRangeQuery(ctx, payload.RangeQueryParams, payload.DryRun)
+	} else {
+		validation, err = h.service.ValidateQuery(ctx, payload.Query, payload.DryRun)
+	}
 	if err != nil {
 		h.logger.Errorf("Failed to validate query: %v", err)
-		RespondWithError(w, http.StatusInternalServerError, "Failed to validate query")
+		RespondWithUpstreamError(w, err, "Failed to validate query")
 		return
 	}
 
+	if validation.SyntaxError != nil {
+		RespondWithJSON(w, http.StatusBadRequest, validation)
+		return
+	}
 	RespondWithJSON(w, http.StatusOK, validation)
 }
 
+// PreviewQuery returns the query with label filters injected, without executing it
+func (h *QueriesHandler) PreviewQuery(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Query  string            `json:"query"`
+		Labels map[string]string `json:"labels"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	preview, err := h.service.PreviewQuery(payload.Query, payload.Labels)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidQuery) || errors.Is(err, models.ErrInvalidLabelValue) {
+			RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		h.logger.Errorf("Failed to preview query: %v", err)
+		RespondWithError(w, http.StatusInternalServerError, "Failed to preview query")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"query":   payload.Query,
+		"labels":  payload.Labels,
+		"preview": preview,
+	})
+}
+
+// CombineQuery applies an arithmetic operator to two instant queries
+func (h *QueriesHandler) CombineQuery(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		QueryA           string    `json:"query_a"`
+		QueryB           string    `json:"query_b"`
+		Op               string    `json:"op"`
+		Time             time.Time `json:"time"`
+		IncludeUnmatched bool      `json:"include_unmatched"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	opts := []service.CombineOption{service.CombineIncludeUnmatched(payload.IncludeUnmatched)}
+	if !payload.Time.IsZero() {
+		opts = append(opts, service.CombineAt(payload.Time))
+	}
+
+	points, err := h.service.CombineInstant(r.Context(), payload.QueryA, payload.QueryB, payload.Op, opts...)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidQuery) {
+			RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		h.logger.Errorf("Failed to combine queries: %v", err)
+		RespondWithUpstreamError(w, err, "Failed to combine queries")
+		return
+	}
+
+	// NaN and Inf cannot be encoded as JSON, so report them as null
+	type combinedPoint struct {
+		Labels    map[string]string `json:"labels"`
+		Value     *float64          `json:"value"`
+		Timestamp time.Time         `json:"timestamp"`
+	}
+	data := make([]combinedPoint, 0, len(points))
+	for _, p := range points {
+		point := combinedPoint{Labels: p.Labels, Timestamp: p.Timestamp}
+		if !math.IsNaN(p.Value) && !math.IsInf(p.Value, 0) {
+			value := p.Value
+			point.Value = &value
+		}
+		data = append(data, point)
+	}
+
+	RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"query_a": payload.QueryA,
+		"query_b": payload.QueryB,
+		"op":      payload.Op,
+		"data":    data,
+	})
+}
+
+// SaveQuery registers a named query after validating its syntax
+func (h *QueriesHandler) SaveQuery(w http.ResponseWriter, r *http.Request) {
+	var payload models.SavedQuery
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	ctx := r.Context()
+	if err := h.service.SavedQueries().Save(ctx, payload.Name, payload.Query, payload.Description); err != nil {
+		if errors.Is(err, models.ErrInvalidQuery) {
+			RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		h.logger.Errorf("Failed to save query: %v", err)
+		RespondWithError(w, http.StatusInternalServerError, "Failed to save query")
+		return
+	}
+
+	saved, err := h.service.SavedQueries().Get(ctx, payload.Name)
+	if err != nil {
+		h.logger.Errorf("Failed to read back saved query %s: %v", payload.Name, err)
+		RespondWithError(w, http.StatusInternalServerError, "Failed to save query")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusCreated, saved)
+}
+
+// ListSavedQueries returns all saved queries
+func (h *QueriesHandler) ListSavedQueries(w http.ResponseWriter, r *http.Request) {
+	queries, err := h.service.SavedQueries().List(r.Context())
+	if err != nil {
+		h.logger.Errorf("Failed to list saved queries: %v", err)
+		RespondWithError(w, http.StatusInternalServerError, "Failed to list saved queries")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"queries": queries,
+		"count":   len(queries),
+	})
+}
+
+// GetSavedQuery returns a single saved query
+func (h *QueriesHandler) GetSavedQuery(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	saved, err := h.service.SavedQueries().Get(r.Context(), name)
+	if err != nil {
+		if errors.Is(err, models.ErrSavedQueryNotFound) {
+			RespondWithError(w, http.StatusNotFound, "Saved query not found")
+			return
+		}
+		h.logger.Errorf("Failed to get saved query %s: %v", name, err)
+		RespondWithError(w, http.StatusInternalServerError, "Failed to get saved query")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, saved)
+}
+
+// DeleteSavedQuery removes a saved query
+func (h *QueriesHandler) DeleteSavedQuery(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	if err := h.service.SavedQueries().Delete(r.Context(), name); err != nil {
+		if errors.Is(err, models.ErrSavedQueryNotFound) {
+			RespondWithError(w, http.StatusNotFound, "Saved query not found")
+			return
+		}
+		h.logger.Errorf("Failed to delete saved query %s: %v", name, err)
+		RespondWithError(w, http.StatusInternalServerError, "Failed to delete saved query")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RunSavedQuery executes a saved query as an instant query at the current time
+func (h *QueriesHandler) RunSavedQuery(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	ctx := r.Context()
+
+	saved, err := h.service.SavedQueries().Use(ctx, name)
+	if err != nil {
+		if errors.Is(err, models.ErrSavedQueryNotFound) {
+			RespondWithError(w, http.StatusNotFound, "Saved query not found")
+			return
+		}
+		h.logger.Errorf("Failed to get saved query %s: %v", name, err)
+		RespondWithError(w, http.StatusInternalServerError, "Failed to get saved query")
+		return
+	}
+
+	params := models.InstantQueryParams{
+		Query:       saved.Query,
+		BypassCache: cacheBypassRequested(r),
+		KeepName:    keepNameRequested(r),
+	}
+
+	response, err := h.service.ExecuteInstantQuery(ctx, params)
+	if err != nil {
+		h.logger.Errorf("Failed to run saved query %s: %v", name, err)
+		RespondWithUpstreamError(w, err, "Failed to execute query")
+		return
+	}
+
+	markCacheBypass(w, params.BypassCache)
+	h.respondWithQueryResponse(w, r, response)
+}
+
+// respondWithQueryResponse writes an instant query response, as a text table
+// (a JSON one when the client accepts JSON) or CSV when ?format=table or csv
+// is requested, otherwise reducing each data point to the ?fields= projection
+// when one is requested
+func (h *QueriesHandler) respondWithQueryResponse(w http.ResponseWriter, r *http.Request, response *models.QueryResponse) {
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+	case "table":
+		if prefersJSON(r) {
+			RespondWithJSON(w, http.StatusOK, queryTable(response.Data))
+			return
+		}
+		RespondWithText(w, queryText(response.Data))
+		return
+	case "csv":
+		RespondWithCSV(w, "query.csv", queryCSV(response.Data))
+		return
+	default:
+		RespondWithError(w, http.StatusBadRequest, "Invalid format parameter")
+		return
+	}
+
+	fields := requestedFields(r)
+	if fields == nil {
+		RespondWithJSON(w, http.StatusOK, response)
+		return
+	}
+
+	data, err := projectFields(response.Data, fields)
+	if err != nil {
+		h.logger.Errorf("Failed to project query response fields: %v", err)
+		RespondWithError(w, http.StatusInternalServerError, "Failed to encode query response")
+		return
+	}
+
+	// The outer Data field shadows the embedded one when encoded
+	RespondWithJSON(w, http.StatusOK, struct {
+		*models.QueryResponse
+		Data []map[string]json.RawMessage `json:"data"`
+	}{response, data})
+}
+
+// GetQueryHistory returns the most recently executed queries, newest first,
+// up to ?limit= and no older than ?since=
+func (h *QueriesHandler) GetQueryHistory(w http.ResponseWriter, r *http.Request) {
+	limitStr := r.URL.Query().Get("limit")
+	limit := 100 // Default
+
+	if limitStr != "" {
+		parsedLimit, err := strconv.Atoi(limitStr)
+		if err != nil || parsedLimit <= 0 {
+			RespondWithError(w, http.StatusBadRequest, "Invalid limit parameter")
+			return
+		}
+		limit = parsedLimit
+	}
+
+	var since time.Time
+	if sinceStr := r.URL.Query().Get("since"); sinceStr != "" {
+		parsed, err := parseTime(sinceStr)
+		if err != nil {
+			RespondWithError(w, http.StatusBadRequest, "Invalid since parameter")
+			return
+		}
+		since = parsed
+	}
+
+	history := h.service.History(limit, since)
+	RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"queries": history,
+		"count":   len(history),
+	})
+}
+
 // GetQuerySuggestions returns query suggestions based on a prefix
 func (h *QueriesHandler) GetQuerySuggestions(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
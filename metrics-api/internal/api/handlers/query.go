@@ -20,14 +20,14 @@ import (
 
 // QueryHandler handles query-related HTTP requests
 type QueryHandler struct {
-	service service.QueriesService
+	service *service.QueriesService
 	logger  logger.Logger
 }
 
 // NewQueryHandler creates a new query handler
 func NewQueryHandler(service *service.QueriesService, logger logger.Logger) *QueryHandler {
 	return &QueryHandler{
-		service: *service,
+		service: service,
 		logger:  logger,
 	}
 }
@@ -62,6 +62,11 @@ func (h *QueryHandler) QueryRange(w http.ResponseWriter, r *http.Request) {
 	
 	// Read the body for logging in case of error
 	body, err := io.ReadAll(r.Body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		RespondWithError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+		return
+	}
 	if err != nil {
 		h.logger.Error("failed to read request body", "error", err)
 		RespondWithError(w, http.StatusBadRequest, "Failed to read request body")
@@ -143,6 +148,8 @@ func (h *QueryHandler) QueryRange(w http.ResponseWriter, r *http.Request) {
 		End:   end,
 		Step:  fmt.Sprintf("%ds", stepInt),
 	}
+	params.BypassCache = cacheBypassRequested(r)
+	params.KeepName = keepNameRequested(r)
 
 	// Execute the query
 	response, err := h.service.ExecuteRangeQuery(r.Context(), params)
@@ -154,13 +161,16 @@ func (h *QueryHandler) QueryRange(w http.ResponseWriter, r *http.Request) {
 			RespondWithError(w, http.StatusBadRequest, "Invalid time range")
 		case errors.Is(err, models.ErrTooManyDataPoints):
 			RespondWithError(w, http.StatusBadRequest, "Query would return too many data points")
+		case errors.Is(err, models.ErrStepTooSmall):
+			RespondWithError(w, http.StatusBadRequest, err.Error())
 		default:
 			h.logger.Error("failed to execute range query", "error", err)
-			RespondWithError(w, http.StatusInternalServerError, "Failed to execute query")
+			RespondWithUpstreamError(w, err, "Failed to execute query")
 		}
 		return
 	}
 
+	markCacheBypass(w, params.BypassCache)
 	RespondWithJSON(w, http.StatusOK, response)
 }
 
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"net/http"
+
+	"metrics-api/internal/models"
+	"metrics-api/internal/prometheus"
+	"metrics-api/pkg/logger"
+	"metrics-api/pkg/openapi"
+
+	"github.com/gorilla/mux"
+)
+
+// RulesHandler handles requests for the alerting and recording rules loaded
+// by Prometheus
+type RulesHandler struct {
+	client *prometheus.Client
+	logger logger.Logger
+}
+
+// NewRulesHandler creates a new rules handler
+func NewRulesHandler(client *prometheus.Client, logger logger.Logger) *RulesHandler {
+	return &RulesHandler{
+		client: client,
+		logger: logger,
+	}
+}
+
+// RegisterRoutes registers the handler routes
+func (h *RulesHandler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/rules", h.GetRules).Methods("GET")
+	r.HandleFunc("/rules/{group}", h.GetRuleGroup).Methods("GET")
+}
+
+// DescribeRoutes documents the handler routes
+func (h *RulesHandler) DescribeRoutes(b *openapi.Builder) {
+	ruleType := []openapi.Param{{Name: "type", Description: "alerting or recording"}}
+	b.Add(
+		openapi.Route{
+			Method: "GET", Path: "/rules", Tag: "rules",
+			Summary: "List the rule groups",
+			Query:   ruleType,
+			Response: struct {
+				Groups []models.RuleGroup `json:"groups"`
+				Count  int                `json:"count"`
+			}{},
+		},
+		openapi.Route{
+			Method: "GET", Path: "/rules/{group}", Tag: "rules",
+			Summary:  "Get a rule group",
+			Query:    ruleType,
+			Response: models.RuleGroup{},
+		},
+	)
+}
+
+// GetRules returns all rule groups, keeping only rules of ?type= (alerting
+// or recording) when given
+func (h *RulesHandler) GetRules(w http.ResponseWriter, r *http.Request) {
+	ruleType, ok := parseRuleType(r)
+	if !ok {
+		RespondWithError(w, http.StatusBadRequest, "Invalid type parameter, must be alerting or recording")
+		return
+	}
+
+	groups, err := prometheus.FromContext(r.Context(), h.client).GetRules(r.Context())
+	if err != nil {
+		h.logger.Errorf("Failed to get rules: %v", err)
+		RespondWithError(w, http.StatusInternalServerError, "Failed to get rules")
+		return
+	}
+
+	filtered := make([]models.RuleGroup, 0, len(groups))
+	for _, group := range groups {
+		group = filterRules(group, ruleType)
+		if ruleType != "" && len(group.Rules) == 0 {
+			continue
+		}
+		filtered = append(filtered, group)
+	}
+
+	RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"groups": filtered,
+		"count":  len(filtered),
+	})
+}
+
+// GetRuleGroup returns the rule group named in the path, keeping only rules
+// of ?type= when given
+func (h *RulesHandler) GetRuleGroup(w http.ResponseWriter, r *http.Request) {
+	ruleType, ok := parseRuleType(r)
+	if !ok {
+		RespondWithError(w, http.StatusBadRequest, "Invalid type parameter, must be alerting or recording")
+		return
+	}
+
+	name := mux.Vars(r)["group"]
+
+	groups, err := prometheus.FromContext(r.Context(), h.client).GetRules(r.Context())
+	if err != nil {
+		h.logger.Errorf("Failed to get rules: %v", err)
+		RespondWithError(w, http.StatusInternalServerError, "Failed to get rules")
+		return
+	}
+
+	for _, group := range groups {
+		if group.Name == name {
+			RespondWithJSON(w, http.StatusOK, filterRules(group, ruleType))
+			return
+		}
+	}
+
+	RespondWithError(w, http.StatusNotFound, "Rule group not found: "+name)
+}
+
+// parseRuleType reads the ?type= filter, reporting false when it is not a
+// known rule type
+func parseRuleType(r *http.Request) (models.RuleType, bool) {
+	ruleType := models.RuleType(r.URL.Query().Get("type"))
+	switch ruleType {
+	case "", models.RuleTypeAlerting, models.RuleTypeRecording:
+		return ruleType, true
+	default:
+		return "", false
+	}
+}
+
+// filterRules returns a copy of group holding only its rules of ruleType, or
+// group itself when ruleType is empty. The cached group is never modified.
+func filterRules(group models.RuleGroup, ruleType models.RuleType) models.RuleGroup {
+	if ruleType == "" {
+		return group
+	}
+
+	rules := make([]models.Rule, 0, len(group.Rules))
+	for _, rule := range group.Rules {
+		if rule.Type == ruleType {
+			rules = append(rules, rule)
+		}
+	}
+	group.Rules = rules
+	return group
+}
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"metrics-api/internal/prometheus"
+)
+
+// SelectSource directs each request to the Prometheus source named by its
+// ?source= parameter, leaving requests without one on the default source.
+// An unknown source is rejected with 400.
+func SelectSource(sources *prometheus.Sources) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name := r.URL.Query().Get("source")
+			if name == "" || name == sources.DefaultName() {
+				// The default source is used without naming it, so its
+				// results share cache entries however it was selected
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			client, ok := sources.Get(name)
+			if !ok {
+				RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unknown Prometheus source %q", name))
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(prometheus.NewContext(r.Context(), name, client)))
+		})
+	}
+}
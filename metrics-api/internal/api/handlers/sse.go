@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"metrics-api/internal/models"
+	"metrics-api/internal/service"
+	"metrics-api/pkg/logger"
+	"metrics-api/pkg/openapi"
+
+	"github.com/gorilla/mux"
+)
+
+// DefaultStreamChunkDuration is the span of each sub-range a streamed range
+// query is split into
+const DefaultStreamChunkDuration = 6 * time.Hour
+
+// MaxStreamChunks bounds the number of sub-ranges, and so of Prometheus
+// queries, a single streamed range query may need
+const MaxStreamChunks = 1000
+
+// SSEHandler streams range query results as Server-Sent Events, one event
+// per sub-range, so large ranges reach the client piece by piece instead of
+// as one huge response
+type SSEHandler struct {
+	service       *service.QueriesService
+	logger        logger.Logger
+	chunkDuration time.Duration
+	shutdown      context.Context
+}
+
+// NewSSEHandler creates a new range query streaming handler
+func NewSSEHandler(service *service.QueriesService, logger logger.Logger) *SSEHandler {
+	return &SSEHandler{
+		service:       service,
+		logger:        logger,
+		chunkDuration: DefaultStreamChunkDuration,
+		shutdown:      context.Background(),
+	}
+}
+
+// WithChunkDuration sets the span of each streamed sub-range
+func (h *SSEHandler) WithChunkDuration(d time.Duration) *SSEHandler {
+	h.chunkDuration = d
+	return h
+}
+
+// WithShutdown sets a context whose cancellation ends the handler's
+// streams, so shutdown does not wait for them to send every sub-range
+func (h *SSEHandler) WithShutdown(ctx context.Context) *SSEHandler {
+	h.shutdown = ctx
+	return h
+}
+
+// RegisterRoutes registers the handler routes
+func (h *SSEHandler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/query_range/stream", h.StreamRangeQuery).Methods("GET")
+}
+
+// DescribeRoutes documents the handler routes
+func (h *SSEHandler) DescribeRoutes(b *openapi.Builder) {
+	b.Add(openapi.Route{
+		Method: "GET", Path: "/query_range/stream", Tag: "queries",
+		Summary: "Stream a range query one sub-range at a time",
+		Query: []openapi.Param{
+			{Name: "query", Required: true},
+			{Name: "start", Description: "RFC 3339 or Unix time, an hour before end by default"},
+			{Name: "end", Description: "RFC 3339 or Unix time, now by default"},
+			{Name: "step", Required: true, Description: "Resolution as a duration"},
+		},
+		ContentType: "text/event-stream",
+	})
+}
+
+// StreamRangeQuery runs the range query given by ?query=, ?start=, ?end= and
+// ?step= one sub-range at a time, sending a chunk event with the result of
+// each and a done event at the end. An error event ends the stream early.
+func (h *SSEHandler) StreamRangeQuery(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := q.Get("query")
+	if query == "" {
+		RespondWithError(w, http.StatusBadRequest, "Query cannot be empty")
+		return
+	}
+
+	end, err := parseTime(q.Get("end"))
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid end parameter")
+		return
+	}
+	start := end.Add(-time.Hour)
+	if s := q.Get("start"); s != "" {
+		if start, err = parseTime(s); err != nil {
+			RespondWithError(w, http.StatusBadRequest, "Invalid start parameter")
+			return
+		}
+	}
+	if start.After(end) {
+		RespondWithError(w, http.StatusBadRequest, "Invalid time range")
+		return
+	}
+
+	step, err := time.ParseDuration(q.Get("step"))
+	if err != nil || step <= 0 {
+		RespondWithError(w, http.StatusBadRequest, "Invalid step parameter")
+		return
+	}
+	if n := chunkCount(start, end, step, h.chunkDuration); n > MaxStreamChunks {
+		RespondWithError(w, http.StatusBadRequest,
+			fmt.Sprintf("Range needs %d sub-ranges, the limit is %d", n, MaxStreamChunks))
+		return
+	}
+
+	stream, err := newSSEStream(w, DefaultHeartbeatInterval)
+	if err != nil {
+		h.logger.Errorf("Failed to start range query stream: %v", err)
+		return
+	}
+
+	ctx, cancel := untilShutdown(r.Context(), h.shutdown)
+	defer cancel()
+	for _, chunk := range chunkRange(start, end, step, h.chunkDuration) {
+		response, err := h.service.ExecuteRangeQuery(ctx, models.RangeQueryParams{
+			Query:       query,
+			Start:       chunk[0],
+			End:         chunk[1],
+			Step:        step.String(),
+			BypassCache: cacheBypassRequested(r),
+			KeepName:    keepNameRequested(r),
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				// The client went away or the server is shutting down
+				return
+			}
+			h.logger.Warnf("Streamed range query failed: %v", err)
+			code := http.StatusBadGateway
+			if errors.Is(err, models.ErrInvalidQuery) || errors.Is(err, models.ErrTooManyDataPoints) ||
+				errors.Is(err, models.ErrStepTooSmall) {
+				code = http.StatusBadRequest
+			}
+			stream.Event("error", ErrorResponse{Error: http.StatusText(code), Code: code, Message: errorMessage(w, err)})
+			return
+		}
+
+		if err := stream.Event("chunk", response); err != nil {
+			h.logger.Debugf("Range query stream ended: %v", err)
+			return
+		}
+	}
+
+	stream.Event("done", struct{}{})
+}
+
+// chunkSpan returns the span of each sub-range: chunk rounded down to a
+// whole number of steps, and at least one step
+func chunkSpan(step, chunk time.Duration) time.Duration {
+	span := chunk - chunk%step
+	if span < step {
+		span = step
+	}
+	return span
+}
+
+// chunkCount returns how many sub-ranges chunkRange divides start to end
+// into, without building them
+func chunkCount(start, end time.Time, step, chunk time.Duration) int64 {
+	// Each sub-range starts a step after the previous one ends. Sub saturates
+	// for ranges too long for a Duration, which still counts as too many.
+	return int64(end.Sub(start)/(chunkSpan(step, chunk)+step)) + 1
+}
+
+// chunkRange divides start to end into consecutive sub-ranges spanning at
+// most chunk, rounded down to whole steps. Each sub-range starts a step after
+// the previous one ended, so no sample is returned twice.
+func chunkRange(start, end time.Time, step, chunk time.Duration) [][2]time.Time {
+	span := chunkSpan(step, chunk)
+
+	var chunks [][2]time.Time
+	for from := start; !from.After(end); {
+		to := from.Add(span)
+		if to.After(end) {
+			to = end
+		}
+		chunks = append(chunks, [2]time.Time{from, to})
+		from = to.Add(step)
+	}
+	return chunks
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// DefaultHeartbeatInterval is how long a stream may stay silent before a
+// keepalive comment is sent, short enough for common proxy idle timeouts
+const DefaultHeartbeatInterval = 15 * time.Second
+
+// DefaultMaxStreamDuration is how long a stream stays open by default before
+// the server ends it and the client has to reconnect
+const DefaultMaxStreamDuration = time.Hour
+
+// untilShutdown returns a context that is cancelled with parent or once
+// shutdown is done, so streams that would otherwise run until the client
+// leaves end when the server starts shutting down
+func untilShutdown(parent, shutdown context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	stop := context.AfterFunc(shutdown, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// sseStream writes Server-Sent Events to a client
+type sseStream struct {
+	w         http.ResponseWriter
+	rc        *http.ResponseController
+	heartbeat time.Duration
+	lastWrite time.Time
+}
+
+// newSSEStream sends the event stream headers and returns a stream that
+// keeps the connection alive with a comment every heartbeat of silence
+func newSSEStream(w http.ResponseWriter, heartbeat time.Duration) (*sseStream, error) {
+	if heartbeat <= 0 {
+		heartbeat = DefaultHeartbeatInterval
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	s := &sseStream{w: w, rc: http.NewResponseController(w), heartbeat: heartbeat}
+	// Streams outlive the server's write timeout, which would cut them off
+	_ = s.rc.SetWriteDeadline(time.Time{})
+	if err := s.rc.Flush(); err != nil {
+		return nil, fmt.Errorf("streaming not supported: %w", err)
+	}
+	s.lastWrite = time.Now()
+	return s, nil
+}
+
+// Event sends a data event with a JSON payload
+func (s *sseStream) Event(event string, data interface{}) error {
+	return s.EventWithID("", event, data)
+}
+
+// EventWithID sends a data event with a JSON payload and an id, which a
+// reconnecting EventSource reports back in the Last-Event-ID header
+func (s *sseStream) EventWithID(id, event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		if _, err := fmt.Fprintf(s.w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+		return err
+	}
+	s.lastWrite = time.Now()
+	return s.rc.Flush()
+}
+
+// keepalive sends a comment line, which EventSource clients ignore rather
+// than dispatching as an event
+func (s *sseStream) keepalive() error {
+	if _, err := fmt.Fprint(s.w, ": keepalive\n\n"); err != nil {
+		return err
+	}
+	s.lastWrite = time.Now()
+	return s.rc.Flush()
+}
+
+// Run calls poll immediately and then every interval, sending a keepalive
+// whenever no event has been sent for a heartbeat, until ctx is done or a
+// write fails
+func (s *sseStream) Run(ctx context.Context, interval time.Duration, poll func(context.Context) error) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	heartbeat := time.NewTimer(s.heartbeat)
+	defer heartbeat.Stop()
+
+	if err := poll(ctx); err != nil {
+		return err
+	}
+
+	for {
+		heartbeat.Reset(time.Until(s.lastWrite.Add(s.heartbeat)))
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := poll(ctx); err != nil {
+				return err
+			}
+		case <-heartbeat.C:
+			if err := s.keepalive(); err != nil {
+				return err
+			}
+		}
+	}
+}
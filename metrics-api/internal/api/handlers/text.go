@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"metrics-api/internal/models"
+)
+
+// RespondWithText sends the output of render as aligned plain text, which
+// reads far better than JSON in a terminal
+func RespondWithText(w http.ResponseWriter, render func(io.Writer)) {
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	render(tw)
+	tw.Flush()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// queryText renders an instant query result with one row per series
+func queryText(points []models.DataPoint) func(io.Writer) {
+	return func(w io.Writer) {
+		fmt.Fprintln(w, "METRIC\tLABELS\tVALUE\tTIMESTAMP")
+		for _, point := range points {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", point.MetricName, formatTextLabels(point.Labels),
+				formatCSVValue(point.Value), formatCSVTime(point.Timestamp))
+		}
+	}
+}
+
+// rangeQueryText renders a range query result as one block per series, a
+// line naming the series followed by its timestamp/value rows
+func rangeQueryText(series []models.TimeSeries) func(io.Writer) {
+	return func(w io.Writer) {
+		for i, s := range series {
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "%s%s\n", s.MetricName, formatTextLabels(s.Labels))
+			fmt.Fprintln(w, "TIMESTAMP\tVALUE")
+			for _, point := range s.DataPoints {
+				fmt.Fprintf(w, "%s\t%s\n", formatCSVTime(point.Timestamp), formatCSVValue(point.Value))
+			}
+		}
+	}
+}
+
+// formatTextLabels formats labels in PromQL selector syntax, sorted by name.
+// __name__ is left out as the metric name is shown on its own.
+func formatTextLabels(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		if name != "__name__" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", name, labels[name]))
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// prefersJSON reports whether the client explicitly asked for JSON, which
+// keeps ?format=table returning the structured table instead of text
+func prefersJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
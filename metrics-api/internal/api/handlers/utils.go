@@ -1,27 +1,71 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"metrics-api/internal/models"
+	"metrics-api/internal/prometheus"
+	"metrics-api/internal/timing"
+	"metrics-api/pkg/openapi"
 )
 
 // ErrorResponse represents an error response
 type ErrorResponse struct {
-	Error   string `json:"error"`
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+	Error     string `json:"error"`
+	Code      int    `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
 }
 
-// respondWithError sends an error response
+// respondWithError sends an error response, carrying the request ID for
+// correlation with the server logs when errors are sanitized
 func RespondWithError(w http.ResponseWriter, code int, message string) {
-	RespondWithJSON(w, code, ErrorResponse{
+	response := ErrorResponse{
 		Error:   http.StatusText(code),
 		Code:    code,
 		Message: message,
-	})
+	}
+	if errorSanitizer(w) != nil {
+		response.RequestID = w.Header().Get("X-Request-ID")
+	}
+	RespondWithJSON(w, code, response)
+}
+
+// StatusClientClosedRequest is the non-standard status reported when the
+// client cancels a request before the upstream query completes
+const StatusClientClosedRequest = 499
+
+// RespondWithUpstreamError maps query timeouts and client cancellations to
+// 504 and 499, an open circuit breaker to 503, and any other upstream
+// failure to a 500 with message
+func RespondWithUpstreamError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, prometheus.ErrCircuitOpen):
+		RespondWithError(w, http.StatusServiceUnavailable, "Prometheus is unavailable; requests are paused while it recovers")
+	case errors.Is(err, context.DeadlineExceeded):
+		RespondWithError(w, http.StatusGatewayTimeout, "Query timed out waiting for Prometheus")
+	case errors.Is(err, context.Canceled):
+		RespondWithError(w, StatusClientClosedRequest, "Request was cancelled by the client")
+	default:
+		if sw := errorSanitizer(w); sw != nil {
+			sw.logSanitized(message, err)
+		}
+		RespondWithError(w, http.StatusInternalServerError, message)
+	}
 }
 
+// maxPrettyJSONBytes is the largest response that will be indented on request
+const maxPrettyJSONBytes = 1 << 20
+
 // RespondWithJSON writes a JSON response with the given status code and payload
 func RespondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	response, err := json.Marshal(payload)
@@ -32,7 +76,186 @@ func RespondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 		return
 	}
 
+	// Indent only small responses so pretty output cannot double a huge payload
+	if pw, ok := w.(*prettyResponseWriter); ok && len(response) <= maxPrettyJSONBytes {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, response, "", "  "); err == nil {
+			response = indented.Bytes()
+		}
+		w = pw.ResponseWriter
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
 	w.Write(response)
 }
+
+// prettyResponseWriter marks a response whose JSON body should be indented
+type prettyResponseWriter struct {
+	http.ResponseWriter
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (w *prettyResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// PrettyJSON indents JSON responses for requests carrying ?pretty=true
+func PrettyJSON(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if pretty, _ := strconv.ParseBool(r.URL.Query().Get("pretty")); pretty {
+			w = &prettyResponseWriter{ResponseWriter: w}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// cacheBypassRequested reports whether the client asked for a fresh fetch,
+// either with ?nocache=true or a Cache-Control: no-cache request header
+func cacheBypassRequested(r *http.Request) bool {
+	if noCache, err := strconv.ParseBool(r.URL.Query().Get("nocache")); err == nil && noCache {
+		return true
+	}
+	return strings.Contains(strings.ToLower(r.Header.Get("Cache-Control")), "no-cache")
+}
+
+// keepNameRequested reports whether the client asked for __name__ to be kept
+// in the returned label maps via ?keep_name=true
+func keepNameRequested(r *http.Request) bool {
+	keep, _ := strconv.ParseBool(r.URL.Query().Get("keep_name"))
+	return keep
+}
+
+// requestedFields parses the comma-separated ?fields= projection, returning
+// nil when the client wants full objects
+func requestedFields(r *http.Request) []string {
+	var fields []string
+	for _, field := range strings.Split(r.URL.Query().Get("fields"), ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// queryCacheParams documents the query parameters read by
+// cacheBypassRequested and keepNameRequested
+var queryCacheParams = []openapi.Param{
+	{Name: "nocache", Type: "boolean", Description: "Bypass the query cache"},
+	{Name: "keep_name", Type: "boolean", Description: "Keep __name__ in the returned labels"},
+}
+
+// instantQueryParams documents the query parameters of endpoints answering
+// through respondWithQueryResponse
+var instantQueryParams = append([]openapi.Param{
+	{Name: "format", Description: "json, csv or table"},
+	{Name: "fields", Description: "Comma-separated data point fields to keep"},
+}, queryCacheParams...)
+
+// projectFields re-encodes each object in items keeping only the named
+// top-level JSON fields; unknown names are ignored
+func projectFields(items interface{}, fields []string) ([]map[string]json.RawMessage, error) {
+	encoded, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &objects); err != nil {
+		return nil, err
+	}
+
+	projected := make([]map[string]json.RawMessage, 0, len(objects))
+	for _, object := range objects {
+		kept := make(map[string]json.RawMessage, len(fields))
+		for _, field := range fields {
+			if value, ok := object[field]; ok {
+				kept[field] = value
+			}
+		}
+		projected = append(projected, kept)
+	}
+	return projected, nil
+}
+
+// queryTable lays data points out as rows under the sorted union of their
+// label names, leaving a blank where a series lacks a label. The metric name
+// gets a leading __name__ column when any point carries one.
+func queryTable(points []models.DataPoint) models.QueryTable {
+	refs := make([]models.SeriesRef, 0, len(points))
+	for _, point := range points {
+		refs = append(refs, models.SeriesRef{MetricName: point.MetricName, Labels: point.Labels})
+	}
+	named, labelNames := labelColumns(refs)
+
+	columns := make([]string, 0, len(labelNames)+2)
+	if named {
+		columns = append(columns, "__name__")
+	}
+	columns = append(columns, labelNames...)
+	columns = append(columns, "value")
+
+	rows := make([][]interface{}, 0, len(points))
+	for _, point := range points {
+		row := make([]interface{}, 0, len(columns))
+		if named {
+			row = append(row, point.MetricName)
+		}
+		for _, name := range labelNames {
+			row = append(row, point.Labels[name])
+		}
+		row = append(row, point.Value)
+		rows = append(rows, row)
+	}
+
+	return models.QueryTable{Columns: columns, Rows: rows}
+}
+
+// labelColumns reports whether any series has a metric name and returns the
+// sorted union of their label names, the leading columns of tabular results
+func labelColumns(series []models.SeriesRef) (bool, []string) {
+	named := false
+	labelSet := make(map[string]struct{})
+	for _, ref := range series {
+		if ref.MetricName != "" {
+			named = true
+		}
+		for name := range ref.Labels {
+			labelSet[name] = struct{}{}
+		}
+	}
+
+	labelNames := make([]string, 0, len(labelSet))
+	for name := range labelSet {
+		labelNames = append(labelNames, name)
+	}
+	sort.Strings(labelNames)
+	return named, labelNames
+}
+
+// withTimings attaches a request timer to the context when the client asked
+// for a latency breakdown with ?debug=true; the returned timings are nil otherwise
+func withTimings(r *http.Request) (*http.Request, *timing.Timings) {
+	if debug, _ := strconv.ParseBool(r.URL.Query().Get("debug")); !debug {
+		return r, nil
+	}
+	timings := timing.New()
+	return r.WithContext(timing.NewContext(r.Context(), timings)), timings
+}
+
+// writeServerTiming records the total handler time since start and sets the
+// Server-Timing header when timings were requested
+func writeServerTiming(w http.ResponseWriter, timings *timing.Timings, start time.Time) {
+	if timings == nil {
+		return
+	}
+	timings.Since("total", start)
+	w.Header().Set("Server-Timing", timings.Header())
+}
+
+// markCacheBypass sets the X-Cache header when the cache read was skipped
+func markCacheBypass(w http.ResponseWriter, bypass bool) {
+	if bypass {
+		w.Header().Set("X-Cache", "BYPASS")
+	}
+}
\ No newline at end of file
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Websocket keepalive timing: the server pings every wsPingPeriod and drops
+// a client that has not answered within wsPongWait
+const (
+	wsWriteWait  = 10 * time.Second
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = wsPongWait * 9 / 10
+)
+
+// closeCode is the close code a feed ends with: going away when the server
+// is shutting down, normal otherwise
+func closeCode(shutdown context.Context) int {
+	if shutdown.Err() != nil {
+		return websocket.CloseGoingAway
+	}
+	return websocket.CloseNormalClosure
+}
+
+// newUpgrader creates an upgrader that accepts connections from the
+// server's own origin, from clients that send no Origin, and from
+// allowedOrigins, where "*" allows any. Browsers do not apply CORS to
+// websockets, so without this check any site could open a feed with the
+// user's credentials.
+func newUpgrader(allowedOrigins []string) *websocket.Upgrader {
+	allowed := make(map[string]bool, len(allowedOrigins))
+	for _, origin := range allowedOrigins {
+		if origin == "*" {
+			return &websocket.Upgrader{
+				CheckOrigin: func(r *http.Request) bool { return true },
+			}
+		}
+		allowed[origin] = true
+	}
+
+	return &websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			if origin == "" || allowed[origin] {
+				return true
+			}
+			u, err := url.Parse(origin)
+			return err == nil && strings.EqualFold(u.Host, r.Host)
+		},
+	}
+}
+
+// hijackWriter exposes the connection to the upgrader, which needs an
+// http.Hijacker; the middleware wrappers only reach it through Unwrap
+type hijackWriter struct {
+	http.ResponseWriter
+}
+
+// Hijack implements http.Hijacker
+func (w hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// upgradeWebSocket switches the request to the websocket protocol. On
+// failure the upgrader has already sent an error response, 403 when the
+// origin is not allowed.
+func upgradeWebSocket(upgrader *websocket.Upgrader, w http.ResponseWriter, r *http.Request) (*websocket.Conn, error) {
+	return upgrader.Upgrade(hijackWriter{w}, r, nil)
+}
+
+// readWebSocket discards messages from the client, answering its pings and
+// extending the read deadline on every pong, until the client goes away or
+// stops answering pings
+func readWebSocket(conn *websocket.Conn) {
+	conn.SetReadLimit(4096)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	for {
+		if _, _, err := conn.NextReader(); err != nil {
+			return
+		}
+	}
+}
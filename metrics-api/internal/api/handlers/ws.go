@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"metrics-api/internal/models"
+	"metrics-api/internal/service"
+	"metrics-api/pkg/logger"
+	"metrics-api/pkg/openapi"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	promclient "github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// DefaultMetricsStreamInterval is how often a metric stream runs its
+	// query without ?interval=
+	DefaultMetricsStreamInterval = 5 * time.Second
+	// MinMetricsStreamInterval and MaxMetricsStreamInterval bound ?interval=
+	MinMetricsStreamInterval = time.Second
+	MaxMetricsStreamInterval = time.Minute
+)
+
+// MetricsStreamHandler streams the result of an instant query over a
+// websocket, so dashboards get fresh values without polling
+type MetricsStreamHandler struct {
+	service     *service.QueriesService
+	logger      logger.Logger
+	connections promclient.Gauge
+	upgrader    *websocket.Upgrader
+	shutdown    context.Context
+}
+
+// NewMetricsStreamHandler creates a new metric stream handler
+func NewMetricsStreamHandler(service *service.QueriesService, logger logger.Logger) *MetricsStreamHandler {
+	return &MetricsStreamHandler{
+		service: service,
+		logger:  logger,
+		connections: promclient.NewGauge(promclient.GaugeOpts{
+			Name: "dashboard_websocket_connections",
+			Help: "Number of open metric stream websockets",
+		}),
+		upgrader: newUpgrader(nil),
+		shutdown: context.Background(),
+	}
+}
+
+// WithAllowedOrigins sets the origins, besides the server's own, that may
+// open metric streams; "*" allows any
+func (h *MetricsStreamHandler) WithAllowedOrigins(origins []string) *MetricsStreamHandler {
+	h.upgrader = newUpgrader(origins)
+	return h
+}
+
+// WithShutdown sets a context whose cancellation closes the handler's
+// streams, so shutdown does not wait for their clients to leave
+func (h *MetricsStreamHandler) WithShutdown(ctx context.Context) *MetricsStreamHandler {
+	h.shutdown = ctx
+	return h
+}
+
+// WithRegisterer registers the gauge of open connections with reg, so it is
+// exported with the service's own metrics
+func (h *MetricsStreamHandler) WithRegisterer(reg promclient.Registerer) *MetricsStreamHandler {
+	reg.MustRegister(h.connections)
+	return h
+}
+
+// RegisterRoutes registers the handler routes
+func (h *MetricsStreamHandler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/ws/metrics", h.StreamMetrics).Methods("GET")
+}
+
+// DescribeRoutes documents the handler routes
+func (h *MetricsStreamHandler) DescribeRoutes(b *openapi.Builder) {
+	b.Add(openapi.Route{
+		Method: "GET", Path: "/ws/metrics", Tag: "queries",
+		Summary: "Stream the result of an instant query over a websocket",
+		Query: []openapi.Param{
+			{Name: "query", Required: true},
+			{Name: "interval", Description: "Duration between runs from 1s to 60s, 5s by default"},
+		},
+		Status: http.StatusSwitchingProtocols,
+	})
+}
+
+// StreamMetrics runs the ?query= instant query every ?interval= and sends
+// each result to the client as a websocket message, until the client closes
+// the connection
+func (h *MetricsStreamHandler) StreamMetrics(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("query")
+	if query == "" {
+		RespondWithError(w, http.StatusBadRequest, "Query cannot be empty")
+		return
+	}
+
+	interval := DefaultMetricsStreamInterval
+	if s := r.URL.Query().Get("interval"); s != "" {
+		parsed, err := time.ParseDuration(s)
+		if err != nil || parsed < MinMetricsStreamInterval || parsed > MaxMetricsStreamInterval {
+			RespondWithError(w, http.StatusBadRequest, "interval must be a duration from 1s to 60s")
+			return
+		}
+		interval = parsed
+	}
+
+	conn, err := upgradeWebSocket(h.upgrader, w, r)
+	if err != nil {
+		h.logger.Warnf("Failed to open metric stream: %v", err)
+		return
+	}
+	defer conn.Close()
+	h.connections.Inc()
+	defer h.connections.Dec()
+
+	// A hijacked request's context is not cancelled when the client goes
+	// away, so the reader cancels it instead
+	ctx, cancel := untilShutdown(r.Context(), h.shutdown)
+	defer cancel()
+	go func() {
+		defer cancel()
+		readWebSocket(conn)
+	}()
+
+	send := func(message models.MetricStreamMessage) error {
+		message.Timestamp = time.Now()
+		conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		return conn.WriteJSON(message)
+	}
+
+	poll := func() error {
+		response, err := h.service.ExecuteInstantQuery(ctx, models.InstantQueryParams{
+			Query:    query,
+			Time:     time.Now(),
+			KeepName: keepNameRequested(r),
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			h.logger.Warnf("Metric stream query failed: %v", err)
+			message := "Failed to execute query"
+			if errors.Is(err, models.ErrInvalidQuery) {
+				message = err.Error()
+			}
+			return send(models.MetricStreamMessage{Error: message})
+		}
+		return send(models.MetricStreamMessage{Data: response.Data})
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	ping := time.NewTicker(wsPingPeriod)
+	defer ping.Stop()
+
+	err = poll()
+	for err == nil {
+		select {
+		case <-ctx.Done():
+			// Answer the client's close, or tell it the stream is over
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(closeCode(h.shutdown), ""),
+				time.Now().Add(wsWriteWait))
+			return
+		case <-ticker.C:
+			err = poll()
+		case <-ping.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait))
+		}
+	}
+	h.logger.Debugf("Metric stream ended: %v", err)
+}
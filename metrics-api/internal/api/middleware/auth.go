@@ -2,22 +2,54 @@ package middleware
 
 import (
 	"context"
+	"crypto/subtle"
 	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
+	"metrics-api/internal/tenant"
 	"metrics-api/pkg/logger"
 
 	"github.com/dgrijalva/jwt-go"
 )
 
+// AuthType selects how AuthMiddleware authenticates requests
+type AuthType string
+
+const (
+	// AuthTypeAPIKey looks up a static key sent in a header
+	AuthTypeAPIKey AuthType = "api_key"
+	// AuthTypeJWT validates a bearer token, as JWTAuth does
+	AuthTypeJWT AuthType = "jwt"
+)
+
+// DefaultAPIKeyHeader is the header API keys are read from by default
+const DefaultAPIKeyHeader = "X-API-Key"
+
 // AuthConfig holds configuration for authentication middleware
 type AuthConfig struct {
 	JWTSecret      string   // Secret key for JWT validation
 	TokenExpiry    int      // Token expiry in minutes
 	AllowedOrigins []string // CORS allowed origins
 	DisableAuth    bool     // Flag to disable auth (for development)
+
+	// The following configure AuthMiddleware, which only checks requests
+	// when Enabled
+	Enabled bool
+	Type    AuthType // AuthTypeAPIKey unless set
+	// APIKeys maps each accepted API key to the user it authenticates
+	APIKeys      map[string]User
+	APIKeyHeader string // DefaultAPIKeyHeader unless set
+	// SkipAuthForPath lists path prefixes served without authentication,
+	// such as health checks
+	SkipAuthForPath []string
+}
+
+// User is the identity an API key authenticates as
+type User struct {
+	ID    string   `json:"id"`
+	Roles []string `json:"roles"`
 }
 
 // UserClaims represents the claims in a JWT token
@@ -25,13 +57,122 @@ type UserClaims struct {
 	UserID string   `json:"userId"`
 	Email  string   `json:"email"`
 	Roles  []string `json:"roles"`
+	OrgID  string   `json:"orgId,omitempty"`
 	jwt.StandardClaims
 }
 
+// TenantID returns the identifier used to isolate this user's data,
+// preferring the organization and falling back to the user ID
+func (c *UserClaims) TenantID() string {
+	if c.OrgID != "" {
+		return c.OrgID
+	}
+	return c.UserID
+}
+
 const (
 	userClaimsKey contextKey = "userClaims"
+	userKey       contextKey = "user"
 )
 
+// AuthMiddleware authenticates requests as configured, by API key or JWT.
+// Requests under a SkipAuthForPath prefix, and all requests when auth is not
+// Enabled, pass through unauthenticated.
+func AuthMiddleware(config AuthConfig, log logger.Logger) func(http.Handler) http.Handler {
+	var authenticate func(http.Handler) http.Handler
+	switch config.Type {
+	case AuthTypeJWT:
+		authenticate = JWTAuth(config, log)
+	default:
+		authenticate = apiKeyAuth(config, log)
+	}
+
+	return func(next http.Handler) http.Handler {
+		authenticated := authenticate(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !config.Enabled || skipAuth(r.URL.Path, config.SkipAuthForPath) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			authenticated.ServeHTTP(w, r)
+		})
+	}
+}
+
+// skipAuth reports whether path starts with one of the given prefixes
+func skipAuth(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// apiKeyAuth authenticates requests by the API key in the configured header,
+// storing the user it belongs to and their tenant in the context
+func apiKeyAuth(config AuthConfig, log logger.Logger) func(http.Handler) http.Handler {
+	header := config.APIKeyHeader
+	if header == "" {
+		header = DefaultAPIKeyHeader
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(header)
+			if key == "" {
+				log.Warn("No API key provided")
+				http.Error(w, "Unauthorized: No API key provided", http.StatusUnauthorized)
+				return
+			}
+
+			user, ok := lookupAPIKey(config.APIKeys, key)
+			if !ok {
+				log.Warn("Invalid API key")
+				http.Error(w, "Unauthorized: Invalid API key", http.StatusUnauthorized)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), userKey, user)
+			ctx = tenant.NewContext(ctx, user.ID)
+
+			r.Header.Set("X-User-ID", user.ID)
+			r.Header.Set("X-User-Roles", strings.Join(user.Roles, ","))
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// lookupAPIKey finds the user of key, comparing it against every configured
+// key in constant time so response times do not reveal how close a guess was
+func lookupAPIKey(keys map[string]User, key string) (*User, bool) {
+	var found *User
+	for candidate, user := range keys {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(key)) == 1 {
+			found = &user
+		}
+	}
+	return found, found != nil
+}
+
+// GetAPIKeyUser retrieves the user authenticated by API key from the context
+func GetAPIKeyUser(ctx context.Context) (*User, bool) {
+	user, ok := ctx.Value(userKey).(*User)
+	return user, ok
+}
+
+// userRoles returns the roles of the user authenticated by JWT or API key
+func userRoles(ctx context.Context) ([]string, bool) {
+	if claims, ok := GetUserFromContext(ctx); ok {
+		return claims.Roles, true
+	}
+	if user, ok := GetAPIKeyUser(ctx); ok {
+		return user.Roles, true
+	}
+	return nil, false
+}
+
 // JWTAuth middleware validates JWT tokens
 func JWTAuth(config AuthConfig, log logger.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -66,8 +207,9 @@ func JWTAuth(config AuthConfig, log logger.Logger) func(http.Handler) http.Handl
 				return
 			}
 
-			// Token is valid, store claims in context
+			// Token is valid, store claims and tenant in context
 			ctx := context.WithValue(r.Context(), userClaimsKey, claims)
+			ctx = tenant.NewContext(ctx, claims.TenantID())
 			
 			// Add auth-related headers for downstream services
 			r.Header.Set("X-User-ID", claims.UserID)
@@ -84,8 +226,8 @@ func JWTAuth(config AuthConfig, log logger.Logger) func(http.Handler) http.Handl
 func RoleAuth(requiredRoles []string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Get claims from context
-			claims, ok := r.Context().Value(userClaimsKey).(*UserClaims)
+			// Get the user's roles from context
+			roles, ok := userRoles(r.Context())
 			if !ok {
 				http.Error(w, "Forbidden: Authentication required", http.StatusForbidden)
 				return
@@ -99,7 +241,7 @@ func RoleAuth(requiredRoles []string) func(http.Handler) http.Handler {
 
 			// Check if user has any of the required roles
 			for _, requiredRole := range requiredRoles {
-				for _, userRole := range claims.Roles {
+				for _, userRole := range roles {
 					if requiredRole == userRole {
 						// User has the required role, proceed
 						next.ServeHTTP(w, r)
@@ -194,12 +336,12 @@ func GetUserFromContext(ctx context.Context) (*UserClaims, bool) {
 
 // HasRole checks if a user has a specific role
 func HasRole(ctx context.Context, role string) bool {
-	claims, ok := GetUserFromContext(ctx)
+	roles, ok := userRoles(ctx)
 	if !ok {
 		return false
 	}
 
-	for _, userRole := range claims.Roles {
+	for _, userRole := range roles {
 		if userRole == role {
 			return true
 		}
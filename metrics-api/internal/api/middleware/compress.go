@@ -0,0 +1,263 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultCompressibleTypes are the content types compressed when no
+// allowlist is configured; already-compressed formats such as images are left out
+var DefaultCompressibleTypes = []string{"application/json", "text/*"}
+
+// CompressionConfig configures response compression
+type CompressionConfig struct {
+	// Level is the gzip level from 1 (fastest) to 9 (smallest); anything
+	// else falls back to gzip.DefaultCompression
+	Level int
+	// ContentTypes lists the media types to compress, either exact
+	// ("application/json") or by top-level type ("text/*")
+	ContentTypes []string
+	// MinSize is the body size in bytes below which responses are sent
+	// uncompressed, as gzip saves little on them; zero compresses all
+	MinSize int
+}
+
+// GzipMiddleware gzips responses of the default compressible types whose
+// body is at least minSize bytes
+func GzipMiddleware(minSize int) func(http.Handler) http.Handler {
+	return CompressionMiddleware(CompressionConfig{MinSize: minSize})
+}
+
+// CompressionMiddleware gzips responses for clients that accept it when the
+// response content type is on the allowlist and the body reaches the minimum
+// size. Until it does, the body is held back so the decision is made before
+// any header is sent.
+func CompressionMiddleware(config CompressionConfig) func(http.Handler) http.Handler {
+	level := config.Level
+	if level < gzip.BestSpeed || level > gzip.BestCompression {
+		level = gzip.DefaultCompression
+	}
+
+	contentTypes := config.ContentTypes
+	if len(contentTypes) == 0 {
+		contentTypes = DefaultCompressibleTypes
+	}
+
+	// Writers are reused, a new one allocates several hundred kilobytes
+	writers := &sync.Pool{
+		New: func() interface{} {
+			// The level was validated above
+			gz, _ := gzip.NewWriterLevel(nil, level)
+			return gz
+		},
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			if r.Method == http.MethodHead || !acceptsGzip(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressResponseWriter{
+				ResponseWriter: w,
+				writers:        writers,
+				contentTypes:   contentTypes,
+				minSize:        config.MinSize,
+			}
+			defer cw.Close()
+
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// acceptsGzip reports whether the client listed gzip in Accept-Encoding
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(encoding), ";")
+		if strings.EqualFold(strings.TrimSpace(name), "gzip") && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
+
+// compressibleType reports whether contentType matches one of the allowed types
+func compressibleType(contentType string, allowed []string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, pattern := range allowed {
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+		} else if mediaType == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// compressResponseWriter decides on the first write whether to gzip the body
+type compressResponseWriter struct {
+	http.ResponseWriter
+	writers      *sync.Pool
+	contentTypes []string
+	minSize      int
+	decided      bool
+	gz           *gzip.Writer
+
+	// buffering is set while an eligible body is held back until it
+	// reaches minSize; status is the code to send once it is released
+	buffering bool
+	status    int
+	buf       []byte
+}
+
+// decide determines, before headers are sent, whether the response is
+// eligible for compression and whether its size is already known
+func (w *compressResponseWriter) decide(status int, body []byte) {
+	if w.decided {
+		return
+	}
+	w.decided = true
+
+	header := w.Header()
+	if status == http.StatusNoContent || status == http.StatusNotModified || header.Get("Content-Encoding") != "" {
+		return
+	}
+	// Byte ranges refer to the uncompressed body, so ranged content is sent as is
+	if status == http.StatusPartialContent || header.Get("Accept-Ranges") != "" {
+		return
+	}
+
+	contentType := header.Get("Content-Type")
+	if contentType == "" && body != nil {
+		contentType = http.DetectContentType(body)
+		header.Set("Content-Type", contentType)
+	}
+	if !compressibleType(contentType, w.contentTypes) {
+		return
+	}
+
+	if w.minSize <= 0 {
+		w.startGzip()
+		return
+	}
+	if length, err := strconv.Atoi(header.Get("Content-Length")); err == nil {
+		if length >= w.minSize {
+			w.startGzip()
+		}
+		return
+	}
+	w.buffering = true
+}
+
+// startGzip switches the response to gzip
+func (w *compressResponseWriter) startGzip() {
+	w.gz = w.writers.Get().(*gzip.Writer)
+	w.gz.Reset(w.ResponseWriter)
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Del("Content-Length")
+}
+
+// release sends the held back status and body, compressed or not
+func (w *compressResponseWriter) release(compress bool) error {
+	w.buffering = false
+	if compress {
+		w.startGzip()
+	}
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	buf := w.buf
+	w.buf = nil
+	if w.gz != nil {
+		_, err := w.gz.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+// WriteHeader implements http.ResponseWriter
+func (w *compressResponseWriter) WriteHeader(status int) {
+	w.decide(status, nil)
+	if w.buffering {
+		w.status = status
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter
+func (w *compressResponseWriter) Write(b []byte) (int, error) {
+	w.decide(http.StatusOK, b)
+	if w.buffering {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) >= w.minSize {
+			if err := w.release(true); err != nil {
+				return 0, err
+			}
+		}
+		return len(b), nil
+	}
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Close sends a body that stayed below the minimum size uncompressed, or
+// flushes any buffered compressed data
+func (w *compressResponseWriter) Close() error {
+	if w.buffering {
+		return w.release(false)
+	}
+	if w.gz == nil {
+		return nil
+	}
+
+	err := w.gz.Close()
+	w.writers.Put(w.gz)
+	w.gz = nil
+	return err
+}
+
+// FlushError sends buffered compressed data to the client so streamed
+// responses are not held back; http.ResponseController calls it on Flush.
+// A body held back for its size is compressed, as a streamed response
+// rarely stays small.
+func (w *compressResponseWriter) FlushError() error {
+	w.decide(http.StatusOK, nil)
+	if w.buffering {
+		if err := w.release(true); err != nil {
+			return err
+		}
+	}
+	if w.gz != nil {
+		if err := w.gz.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (w *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
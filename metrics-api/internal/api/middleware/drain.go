@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// Drainer tracks in-flight requests so shutdown can wait for them to finish.
+// Once draining starts, new requests get 503 while the ones already being
+// served complete. Readiness probes get the 503 too, so load balancers stop
+// routing to the server. Long-lived streams never finish on their own, so
+// they end when the drainer's Context is cancelled at the start of Drain.
+type Drainer struct {
+	// mu orders starting requests against Drain, so no request is added to
+	// inFlight once Drain waits on it
+	mu       sync.RWMutex
+	draining atomic.Bool
+	inFlight sync.WaitGroup
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+// NewDrainer creates a Drainer that serves requests until Drain is called
+func NewDrainer() *Drainer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Drainer{ctx: ctx, cancel: cancel}
+}
+
+// Middleware counts the requests it serves and turns new ones away once
+// draining has started
+func (d *Drainer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d.mu.RLock()
+		if d.draining.Load() {
+			d.mu.RUnlock()
+			w.Header().Set("Connection", "close")
+			http.Error(w, "server draining", http.StatusServiceUnavailable)
+			return
+		}
+		d.inFlight.Add(1)
+		d.mu.RUnlock()
+		defer d.inFlight.Done()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Draining reports whether Drain has been called
+func (d *Drainer) Draining() bool {
+	return d.draining.Load()
+}
+
+// Context returns a context that is cancelled when Drain is called, for
+// streaming handlers to end their streams on
+func (d *Drainer) Context() context.Context {
+	return d.ctx
+}
+
+// Drain stops accepting requests, ends the streams watching Context and
+// waits for the in-flight requests to finish, returning the context's error
+// if it is done first
+func (d *Drainer) Drain(ctx context.Context) error {
+	d.mu.Lock()
+	d.draining.Store(true)
+	d.mu.Unlock()
+	d.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		d.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -1,14 +1,21 @@
 package middleware
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"errors"
+	"io"
 	"net/http"
 	"runtime/debug"
+	"strconv"
+	"strings"
 	"time"
 
 	"metrics-api/pkg/logger"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type contextKey string
@@ -17,13 +24,19 @@ const (
 	requestIDKey contextKey = "requestID"
 )
 
-// RequestID middleware adds a unique identifier to each request
+// RequestID middleware adds a unique identifier to each request. Without an
+// X-Request-ID header the ID is the trace ID of the request's span, so logs
+// and traces of a request can be matched up.
 func RequestID(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Get request ID from header or generate new one
+		// Get request ID from header, the trace, or generate new one
 		requestID := r.Header.Get("X-Request-ID")
 		if requestID == "" {
-			requestID = uuid.New().String()
+			if span := trace.SpanContextFromContext(r.Context()); span.HasTraceID() {
+				requestID = span.TraceID().String()
+			} else {
+				requestID = uuid.New().String()
+			}
 		}
 		
 		// Add the request ID to the response header
@@ -66,38 +79,165 @@ func RecoveryMiddleware(log logger.Logger) func(http.Handler) http.Handler {
 	}
 }
 
-// CORSMiddleware adds CORS headers
-func CORSMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		origin := r.Header.Get("Origin")
-		if origin == "" {
-			origin = "*"
-		}
+// DefaultCORSMethods are the methods allowed when CORSConfig sets none
+var DefaultCORSMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
 
-		// Set CORS headers for all responses
-		w.Header().Set("Access-Control-Allow-Origin", origin)
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, Referer, User-Agent, sec-ch-ua, sec-ch-ua-mobile, sec-ch-ua-platform")
-		w.Header().Set("Access-Control-Expose-Headers", "Content-Type, X-Request-ID")
-		w.Header().Set("Access-Control-Max-Age", "3600")
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
-		
-		// Handle preflight requests
-		if r.Method == "OPTIONS" {
-			// Preflight request response
-			w.Header().Set("Content-Type", "text/plain")
-			w.Header().Set("Content-Length", "0")
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+// DefaultCORSHeaders are the request headers allowed when CORSConfig sets none
+var DefaultCORSHeaders = []string{"Accept", "Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token", "Authorization", DefaultAPIKeyHeader, "Referer", "User-Agent", "sec-ch-ua", "sec-ch-ua-mobile", "sec-ch-ua-platform"}
 
-		// Set content type for regular requests
-		if r.Method == "POST" {
-			w.Header().Set("Content-Type", "application/json")
+// CORSConfig configures cross-origin requests
+type CORSConfig struct {
+	// AllowedOrigins lists the origins allowed to make cross-origin
+	// requests; "*" allows every origin
+	AllowedOrigins []string
+	// AllowedMethods and AllowedHeaders fall back to DefaultCORSMethods and
+	// DefaultCORSHeaders when empty
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	AllowCredentials bool
+	// MaxAgeSecs is how long browsers may cache a preflight response; zero
+	// leaves the header out
+	MaxAgeSecs int
+}
+
+// CORSMiddleware adds CORS headers for allowed origins. The request's
+// Origin is echoed back rather than "*" so that credentials keep working.
+// Requests from other origins get no CORS headers, and their preflight
+// requests are rejected with 403.
+func CORSMiddleware(config CORSConfig) func(http.Handler) http.Handler {
+	allowAll := false
+	allowed := make(map[string]bool, len(config.AllowedOrigins))
+	for _, origin := range config.AllowedOrigins {
+		if origin == "*" {
+			allowAll = true
 		}
-		
-		next.ServeHTTP(w, r)
-	})
+		allowed[origin] = true
+	}
+
+	methods := config.AllowedMethods
+	if len(methods) == 0 {
+		methods = DefaultCORSMethods
+	}
+	headers := config.AllowedHeaders
+	if len(headers) == 0 {
+		headers = DefaultCORSHeaders
+	}
+	allowMethods := strings.Join(methods, ", ")
+	allowHeaders := strings.Join(headers, ", ")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if !allowAll {
+				// The response now depends on the Origin header
+				w.Header().Add("Vary", "Origin")
+			}
+
+			if allowAll || allowed[origin] {
+				if origin == "" {
+					origin = "*"
+				}
+
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Methods", allowMethods)
+				w.Header().Set("Access-Control-Allow-Headers", allowHeaders)
+				w.Header().Set("Access-Control-Expose-Headers", "Content-Type, X-Request-ID")
+				if config.MaxAgeSecs > 0 {
+					w.Header().Set("Access-Control-Max-Age", strconv.Itoa(config.MaxAgeSecs))
+				}
+				if config.AllowCredentials {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+			} else if origin != "" && r.Method == "OPTIONS" {
+				http.Error(w, "Origin not allowed", http.StatusForbidden)
+				return
+			}
+
+			// Handle preflight requests
+			if r.Method == "OPTIONS" {
+				// Preflight request response
+				w.Header().Set("Content-Type", "text/plain")
+				w.Header().Set("Content-Length", "0")
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+
+			// Set content type for regular requests
+			if r.Method == "POST" {
+				w.Header().Set("Content-Type", "application/json")
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// DefaultMaxDecompressedBytes caps the size of a decompressed request body
+const DefaultMaxDecompressedBytes = 10 << 20
+
+// DefaultMaxBodyBytes caps the size of a request body as sent
+const DefaultMaxBodyBytes = 1 << 20
+
+// MaxBodyBytes caps request bodies at limit bytes. Bodies declaring a larger
+// Content-Length are rejected with 413 before the handler runs; others are
+// cut off by http.MaxBytesReader, whose read error handlers can recognise as
+// *http.MaxBytesError.
+func MaxBodyBytes(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > limit {
+				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// GzipRequestMiddleware transparently decompresses request bodies sent with
+// Content-Encoding: gzip. Bodies that decompress to more than maxBytes are
+// rejected with 413 before the handler runs, so a small compressed payload
+// cannot expand without bound.
+func GzipRequestMiddleware(maxBytes int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.EqualFold(strings.TrimSpace(r.Header.Get("Content-Encoding")), "gzip") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			reader, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, "Invalid gzip request body", http.StatusBadRequest)
+				return
+			}
+			defer reader.Close()
+
+			// Read one byte past the limit to tell a full body from an oversized one
+			body, err := io.ReadAll(io.LimitReader(reader, maxBytes+1))
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			if err != nil {
+				http.Error(w, "Invalid gzip request body", http.StatusBadRequest)
+				return
+			}
+			if int64(len(body)) > maxBytes {
+				http.Error(w, "Decompressed request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.ContentLength = int64(len(body))
+			r.Header.Del("Content-Encoding")
+			r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
 // TimeoutMiddleware applies a timeout to the request
@@ -155,6 +295,11 @@ func (w *WrapResponseWriter) Write(b []byte) (int, error) {
 	return n, err
 }
 
+// Unwrap exposes the underlying writer to http.ResponseController
+func (w *WrapResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 // Status returns the status code
 func (w *WrapResponseWriter) Status() int {
 	return w.statusCode
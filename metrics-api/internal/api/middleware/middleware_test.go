@@ -1,21 +1,36 @@
 package middleware
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"metrics-api/internal/api/handlers"
+	"metrics-api/internal/tenant"
 	"metrics-api/pkg/logger"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // MockLogger is a mock implementation of the logger.Logger interface
@@ -710,3 +725,688 @@ func TestLogRequest(t *testing.T) {
 	assert.Equal(t, 200, mockLogger.Fields["status"])
 	assert.Equal(t, `{"status": "success"}`, mockLogger.Fields["response_body"])
 }
+
+// Test that JWTAuth scopes the request context to the caller's tenant
+func TestJWTAuthSetsTenant(t *testing.T) {
+	authConfig := AuthConfig{JWTSecret: "test-secret", TokenExpiry: 60}
+
+	var gotTenant string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTenant = tenant.FromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+
+	token, err := GenerateToken("test-user", "test@example.com", []string{"user"}, authConfig.JWTSecret, authConfig.TokenExpiry)
+	require.NoError(t, err)
+
+	req := createTestRequest("GET", "/test", map[string]string{
+		"Authorization": "Bearer " + token,
+	})
+	rr := httptest.NewRecorder()
+	JWTAuth(authConfig, NewMockLogger())(handler).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "test-user", gotTenant)
+
+	// The organization takes precedence over the user ID
+	claims := &UserClaims{UserID: "test-user", OrgID: "acme"}
+	assert.Equal(t, "acme", claims.TenantID())
+}
+
+func TestGzipRequestMiddleware(t *testing.T) {
+	compress := func(t *testing.T, body string) *bytes.Buffer {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, err := zw.Write([]byte(body))
+		require.NoError(t, err)
+		require.NoError(t, zw.Close())
+		return &buf
+	}
+
+	var got struct {
+		Queries []struct {
+			Query string `json:"query"`
+		} `json:"queries"`
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get("Content-Encoding"))
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	middleware := GzipRequestMiddleware(1024)(handler)
+
+	t.Run("decodes gzip body", func(t *testing.T) {
+		body := `{"queries": [{"query": "up"}, {"query": "rate(http_requests_total[5m])"}]}`
+		req := httptest.NewRequest("POST", "/query/batch", compress(t, body))
+		req.Header.Set("Content-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		middleware.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		if assert.Len(t, got.Queries, 2) {
+			assert.Equal(t, "up", got.Queries[0].Query)
+			assert.Equal(t, "rate(http_requests_total[5m])", got.Queries[1].Query)
+		}
+	})
+
+	t.Run("passes plain body through", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/query/batch", strings.NewReader(`{"queries": [{"query": "up"}]}`))
+		rr := httptest.NewRecorder()
+		middleware.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Len(t, got.Queries, 1)
+	})
+
+	t.Run("rejects oversized decompressed body", func(t *testing.T) {
+		body := `{"queries": [{"query": "` + strings.Repeat("a", 4096) + `"}]}`
+		req := httptest.NewRequest("POST", "/query/batch", compress(t, body))
+		req.Header.Set("Content-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		middleware.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	})
+
+	t.Run("rejects invalid gzip", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/query/batch", strings.NewReader("not gzip"))
+		req.Header.Set("Content-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		middleware.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
+
+func TestCompressionMiddleware(t *testing.T) {
+	body := strings.Repeat(`{"metric_name":"up","value":1}`, 100)
+
+	serve := func(config CompressionConfig, contentType string) *httptest.ResponseRecorder {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(body))
+		})
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
+		rr := httptest.NewRecorder()
+		CompressionMiddleware(config)(handler).ServeHTTP(rr, req)
+		return rr
+	}
+
+	compressed := func(t *testing.T, level int) []byte {
+		var buf bytes.Buffer
+		zw, err := gzip.NewWriterLevel(&buf, level)
+		require.NoError(t, err)
+		zw.Write([]byte(body))
+		require.NoError(t, zw.Close())
+		return buf.Bytes()
+	}
+
+	t.Run("compresses json at the configured level", func(t *testing.T) {
+		rr := serve(CompressionConfig{Level: gzip.BestSpeed}, "application/json")
+
+		assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+		assert.Equal(t, compressed(t, gzip.BestSpeed), rr.Body.Bytes())
+		assert.NotEqual(t, compressed(t, gzip.BestCompression), rr.Body.Bytes())
+
+		zr, err := gzip.NewReader(rr.Body)
+		require.NoError(t, err)
+		decoded, err := io.ReadAll(zr)
+		require.NoError(t, err)
+		assert.Equal(t, body, string(decoded))
+	})
+
+	t.Run("passes images through", func(t *testing.T) {
+		rr := serve(CompressionConfig{Level: gzip.BestSpeed}, "image/png")
+
+		assert.Empty(t, rr.Header().Get("Content-Encoding"))
+		assert.Equal(t, body, rr.Body.String())
+	})
+
+	t.Run("invalid level falls back to default", func(t *testing.T) {
+		rr := serve(CompressionConfig{Level: 42}, "text/csv; charset=utf-8")
+
+		assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+		assert.Equal(t, compressed(t, gzip.DefaultCompression), rr.Body.Bytes())
+	})
+
+	t.Run("skips clients without gzip", func(t *testing.T) {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(body))
+		})
+		rr := httptest.NewRecorder()
+		CompressionMiddleware(CompressionConfig{})(handler).ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+
+		assert.Empty(t, rr.Header().Get("Content-Encoding"))
+		assert.Equal(t, body, rr.Body.String())
+	})
+
+	t.Run("leaves ranged content alone", func(t *testing.T) {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, "export.json", time.Time{}, strings.NewReader(body))
+		})
+		req := httptest.NewRequest("GET", "/export", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		req.Header.Set("Range", "bytes=0-9")
+		rr := httptest.NewRecorder()
+		CompressionMiddleware(CompressionConfig{})(handler).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusPartialContent, rr.Code)
+		assert.Empty(t, rr.Header().Get("Content-Encoding"))
+		assert.Equal(t, body[:10], rr.Body.String())
+	})
+
+	t.Run("flush sends buffered data", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte(": keepalive\n\n"))
+			require.NoError(t, http.NewResponseController(w).Flush())
+
+			// Everything written so far must be readable before the handler returns
+			zr, err := gzip.NewReader(bytes.NewReader(rr.Body.Bytes()))
+			require.NoError(t, err)
+			decoded := make([]byte, len(": keepalive\n\n"))
+			_, err = io.ReadFull(zr, decoded)
+			require.NoError(t, err)
+			assert.Equal(t, ": keepalive\n\n", string(decoded))
+		})
+		req := httptest.NewRequest("GET", "/stream", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		CompressionMiddleware(CompressionConfig{})(handler).ServeHTTP(rr, req)
+
+		assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+		assert.True(t, rr.Flushed)
+	})
+
+	t.Run("sends small bodies uncompressed", func(t *testing.T) {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(body[:100]))
+			w.Write([]byte(body[100:]))
+		})
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		GzipMiddleware(len(body)+1)(handler).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusCreated, rr.Code)
+		assert.Empty(t, rr.Header().Get("Content-Encoding"))
+		assert.Equal(t, body, rr.Body.String())
+	})
+
+	t.Run("compresses bodies reaching the minimum size", func(t *testing.T) {
+		middleware := GzipMiddleware(len(body) / 2)
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			for i := 0; i < len(body); i += 100 {
+				w.Write([]byte(body[i : i+100]))
+			}
+		})
+
+		// Pooled writers must not carry state between responses
+		for i := 0; i < 2; i++ {
+			req := httptest.NewRequest("GET", "/metrics", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			rr := httptest.NewRecorder()
+			middleware(handler).ServeHTTP(rr, req)
+
+			assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+			assert.Equal(t, compressed(t, gzip.DefaultCompression), rr.Body.Bytes())
+		}
+	})
+
+	t.Run("uses a declared content length", func(t *testing.T) {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.Write([]byte(body[:10]))
+			require.NoError(t, http.NewResponseController(w).Flush())
+			w.Write([]byte(body[10:]))
+		})
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		GzipMiddleware(len(body)+1)(handler).ServeHTTP(rr, req)
+
+		assert.Empty(t, rr.Header().Get("Content-Encoding"))
+		assert.Equal(t, strconv.Itoa(len(body)), rr.Header().Get("Content-Length"))
+		assert.Equal(t, body, rr.Body.String())
+	})
+}
+
+// BenchmarkGzipMiddleware compresses a range query response of 1000 series
+// and reports the compressed size against the original
+func BenchmarkGzipMiddleware(b *testing.B) {
+	var series []string
+	for i := 0; i < 1000; i++ {
+		var values []string
+		for j := 0; j < 60; j++ {
+			values = append(values, fmt.Sprintf(`[%d,"%d.%d"]`, 1609746000+j*60, i%7, j))
+		}
+		series = append(series, fmt.Sprintf(`{"metric":{"__name__":"http_requests_total","instance":"node-%d:9100","job":"node"},"values":[%s]}`, i, strings.Join(values, ",")))
+	}
+	body := []byte(`{"status":"success","data":{"resultType":"matrix","result":[` + strings.Join(series, ",") + `]}}`)
+
+	handler := GzipMiddleware(1024)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}))
+	req := httptest.NewRequest("GET", "/api/v1/query_range", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	var compressedSize int
+	b.SetBytes(int64(len(body)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		compressedSize = rr.Body.Len()
+	}
+
+	b.ReportMetric(float64(len(body)), "original-bytes")
+	b.ReportMetric(float64(compressedSize), "compressed-bytes")
+	b.ReportMetric(float64(compressedSize)/float64(len(body)), "ratio")
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	const limit = 64
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		require.NoError(t, err)
+		w.Write(body)
+	})
+	middleware := MaxBodyBytes(limit)(handler)
+
+	tests := []struct {
+		name          string
+		size          int
+		unknownLength bool
+		expected      int
+	}{
+		{name: "just under the limit", size: limit - 1, expected: http.StatusOK},
+		{name: "at the limit", size: limit, expected: http.StatusOK},
+		{name: "just over the limit", size: limit + 1, expected: http.StatusRequestEntityTooLarge},
+		{name: "just over the limit without length", size: limit + 1, unknownLength: true, expected: http.StatusRequestEntityTooLarge},
+		{name: "under the limit without length", size: limit - 1, unknownLength: true, expected: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := strings.Repeat("x", tt.size)
+			req := httptest.NewRequest("POST", "/query", strings.NewReader(body))
+			if tt.unknownLength {
+				// As for a chunked request
+				req.ContentLength = -1
+			}
+			rr := httptest.NewRecorder()
+			middleware.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expected, rr.Code)
+			if tt.expected == http.StatusOK {
+				assert.Equal(t, body, rr.Body.String())
+			}
+		})
+	}
+}
+
+// Test API-key authentication through AuthMiddleware
+func TestAPIKeyAuthMiddleware(t *testing.T) {
+	mockLogger := NewMockLogger()
+
+	authConfig := AuthConfig{
+		Enabled: true,
+		Type:    AuthTypeAPIKey,
+		APIKeys: map[string]User{
+			"secret-key": {ID: "dashboard", Roles: []string{"viewer"}},
+			"admin-key":  {ID: "ops", Roles: []string{"admin"}},
+		},
+		SkipAuthForPath: []string{"/api/v1/health"},
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := GetAPIKeyUser(r.Context())
+		if !ok {
+			fmt.Fprint(w, "anonymous")
+			return
+		}
+		assert.Equal(t, user.ID, tenant.FromContext(r.Context()))
+		fmt.Fprintf(w, "%s %s", user.ID, strings.Join(user.Roles, ","))
+	})
+
+	tests := []struct {
+		name     string
+		config   func(AuthConfig) AuthConfig
+		path     string
+		headers  map[string]string
+		expected int
+		body     string
+	}{
+		{
+			name:     "valid key",
+			path:     "/api/v1/query",
+			headers:  map[string]string{"X-API-Key": "secret-key"},
+			expected: http.StatusOK,
+			body:     "dashboard viewer",
+		},
+		{
+			name:     "invalid key",
+			path:     "/api/v1/query",
+			headers:  map[string]string{"X-API-Key": "secret-kez"},
+			expected: http.StatusUnauthorized,
+			body:     "Invalid API key",
+		},
+		{
+			name:     "missing key",
+			path:     "/api/v1/query",
+			expected: http.StatusUnauthorized,
+			body:     "No API key provided",
+		},
+		{
+			name:     "skipped path",
+			path:     "/api/v1/health/ready",
+			expected: http.StatusOK,
+			body:     "anonymous",
+		},
+		{
+			name:     "disabled auth",
+			config:   func(c AuthConfig) AuthConfig { c.Enabled = false; return c },
+			path:     "/api/v1/query",
+			expected: http.StatusOK,
+			body:     "anonymous",
+		},
+		{
+			name:     "custom header",
+			config:   func(c AuthConfig) AuthConfig { c.APIKeyHeader = "X-Dashboard-Key"; return c },
+			path:     "/api/v1/query",
+			headers:  map[string]string{"X-Dashboard-Key": "admin-key"},
+			expected: http.StatusOK,
+			body:     "ops admin",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := authConfig
+			if tt.config != nil {
+				config = tt.config(config)
+			}
+			rr := httptest.NewRecorder()
+			AuthMiddleware(config, mockLogger)(handler).ServeHTTP(rr, createTestRequest("GET", tt.path, tt.headers))
+
+			assert.Equal(t, tt.expected, rr.Code)
+			assert.Contains(t, rr.Body.String(), tt.body)
+		})
+	}
+
+	// Roles of API-key users are checked by RoleAuth like those of JWT users
+	adminOnly := AuthMiddleware(authConfig, mockLogger)(RoleAuth([]string{"admin"})(handler))
+	for key, expected := range map[string]int{"admin-key": http.StatusOK, "secret-key": http.StatusForbidden} {
+		rr := httptest.NewRecorder()
+		adminOnly.ServeHTTP(rr, createTestRequest("GET", "/api/v1/query", map[string]string{"X-API-Key": key}))
+		assert.Equal(t, expected, rr.Code, key)
+	}
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+
+	var inner trace.SpanContext
+	router := mux.NewRouter()
+	router.Use(TracingMiddleware(tracer))
+	router.HandleFunc("/metrics/{name}", func(w http.ResponseWriter, r *http.Request) {
+		inner = trace.SpanContextFromContext(r.Context())
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	req := httptest.NewRequest("GET", "/metrics/up", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, "GET /metrics/{name}", span.Name())
+	assert.Equal(t, trace.SpanKindServer, span.SpanKind())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", span.Parent().SpanID().String())
+	assert.Equal(t, span.SpanContext().SpanID(), inner.SpanID(), "handlers should see the server span")
+	assert.Equal(t, codes.Error, span.Status().Code)
+	assert.Contains(t, span.Attributes(), attribute.Int("http.response.status_code", http.StatusBadGateway))
+}
+
+func TestRequestIDFromTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+	handler := TracingMiddleware(tracer)(RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(GetRequestID(r.Context())))
+	})))
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", rr.Header().Get("X-Request-ID"))
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", rr.Body.String())
+
+	req.Header.Set("X-Request-ID", "explicit-id")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, "explicit-id", rr.Header().Get("X-Request-ID"), "an explicit ID wins over the trace")
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	serve := func(config CORSConfig, method, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/metrics", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		rr := httptest.NewRecorder()
+		CORSMiddleware(config)(handler).ServeHTTP(rr, req)
+		return rr
+	}
+	allowlist := CORSConfig{
+		AllowedOrigins:   []string{"https://dashboard.example.com"},
+		AllowedMethods:   []string{"GET", "OPTIONS"},
+		AllowCredentials: true,
+		MaxAgeSecs:       600,
+	}
+
+	t.Run("echoes an allowed origin", func(t *testing.T) {
+		rr := serve(allowlist, "GET", "https://dashboard.example.com")
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "https://dashboard.example.com", rr.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "GET, OPTIONS", rr.Header().Get("Access-Control-Allow-Methods"))
+		assert.Equal(t, "true", rr.Header().Get("Access-Control-Allow-Credentials"))
+		assert.Equal(t, "600", rr.Header().Get("Access-Control-Max-Age"))
+		assert.Equal(t, "Origin", rr.Header().Get("Vary"))
+	})
+
+	t.Run("wildcard allows any origin", func(t *testing.T) {
+		rr := serve(CORSConfig{AllowedOrigins: []string{"*"}}, "OPTIONS", "https://other.example.com")
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "https://other.example.com", rr.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, strings.Join(DefaultCORSMethods, ", "), rr.Header().Get("Access-Control-Allow-Methods"))
+		assert.Empty(t, rr.Header().Get("Access-Control-Allow-Credentials"))
+		assert.Empty(t, rr.Header().Get("Access-Control-Max-Age"))
+	})
+
+	t.Run("omits headers for a disallowed origin", func(t *testing.T) {
+		rr := serve(allowlist, "GET", "https://evil.example.com")
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"))
+		assert.Empty(t, rr.Header().Get("Access-Control-Allow-Credentials"))
+	})
+
+	t.Run("rejects preflight from a disallowed origin", func(t *testing.T) {
+		rr := serve(allowlist, "OPTIONS", "https://evil.example.com")
+
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		assert.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("passes through requests without an origin", func(t *testing.T) {
+		rr := serve(allowlist, "GET", "")
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"))
+	})
+}
+
+func TestIPRateLimiterMiddleware(t *testing.T) {
+	// The bucket barely refills during the test, so each IP gets its burst
+	limited := IPRateLimiterMiddleware(0.01, 100, time.Minute, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	requests := map[string]int{"10.0.0.1": 150, "10.0.0.2": 50}
+	var mu sync.Mutex
+	throttled := make(map[string]int)
+	retryAfter := make(map[string]string)
+
+	var wg sync.WaitGroup
+	for ip, count := range requests {
+		for i := 0; i < count; i++ {
+			wg.Add(1)
+			go func(ip string) {
+				defer wg.Done()
+				req := httptest.NewRequest("GET", "/api/v1/metrics", nil)
+				req.RemoteAddr = ip + ":54321"
+				rr := httptest.NewRecorder()
+				limited.ServeHTTP(rr, req)
+
+				if rr.Code == http.StatusTooManyRequests {
+					mu.Lock()
+					throttled[ip]++
+					retryAfter[ip] = rr.Header().Get("Retry-After")
+					mu.Unlock()
+				}
+			}(ip)
+		}
+	}
+	wg.Wait()
+
+	assert.Equal(t, 50, throttled["10.0.0.1"], "requests beyond the burst should be throttled")
+	assert.NotEmpty(t, retryAfter["10.0.0.1"], "throttled responses should carry Retry-After")
+	assert.Zero(t, throttled["10.0.0.2"], "one client exhausting its quota should not throttle another")
+}
+
+func TestIPRateLimiterForwardedFor(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	limited := IPRateLimiterMiddleware(0.01, 1, time.Minute, trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(remoteAddr, forwardedFor string) int {
+		req := httptest.NewRequest("GET", "/api/v1/metrics", nil)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		rr := httptest.NewRecorder()
+		limited.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	t.Run("ignores X-Forwarded-For from untrusted clients", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve("203.0.113.1:1234", "198.51.100.1"))
+		assert.Equal(t, http.StatusTooManyRequests, serve("203.0.113.1:1234", "198.51.100.2"),
+			"a new spoofed address should not get a new quota")
+	})
+
+	t.Run("uses the right-most untrusted hop behind a trusted proxy", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve("10.0.0.1:1234", "1.1.1.1, 198.51.100.3, 10.0.0.2"))
+		assert.Equal(t, http.StatusTooManyRequests, serve("10.0.0.1:1234", "2.2.2.2, 198.51.100.3"),
+			"a different spoofed left-most hop should not get a new quota")
+		assert.Equal(t, http.StatusOK, serve("10.0.0.1:1234", "198.51.100.4"))
+	})
+
+	t.Run("handles IPv6 peers", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve("[2001:db8::1]:1234", ""))
+		assert.Equal(t, http.StatusTooManyRequests, serve("[2001:db8::1]:4321", ""))
+		assert.Equal(t, http.StatusOK, serve("[2001:db8::2]:1234", ""))
+	})
+}
+
+func TestDrainer(t *testing.T) {
+	drainer := NewDrainer()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := drainer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	inFlight := httptest.NewRecorder()
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		handler.ServeHTTP(inFlight, httptest.NewRequest("GET", "/slow", nil))
+	}()
+	<-started
+
+	drained := make(chan error, 1)
+	go func() {
+		drained <- drainer.Drain(context.Background())
+	}()
+	require.Eventually(t, drainer.Draining, time.Second, time.Millisecond)
+	select {
+	case <-drainer.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("Drain did not cancel the drainer's context")
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/fast", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Contains(t, rr.Body.String(), "server draining")
+
+	select {
+	case <-drained:
+		t.Fatal("Drain returned while a request was in flight")
+	default:
+	}
+
+	close(release)
+	<-served
+	assert.Equal(t, http.StatusOK, inFlight.Code, "the in-flight request should finish")
+	require.NoError(t, <-drained)
+
+	t.Run("gives up at the deadline", func(t *testing.T) {
+		drainer := NewDrainer()
+		block := make(chan struct{})
+		defer close(block)
+		started := make(chan struct{})
+		handler := drainer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-block
+		}))
+		go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		<-started
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, drainer.Drain(ctx), context.DeadlineExceeded)
+	})
+}
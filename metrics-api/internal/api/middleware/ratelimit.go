@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// DefaultRateLimitCleanupInterval is how long an idle client's limiter is
+// kept when no cleanup interval is given
+const DefaultRateLimitCleanupInterval = 10 * time.Minute
+
+// ipLimiter is the token bucket of one client IP
+type ipLimiter struct {
+	limiter *rate.Limiter
+	// lastSeen is the Unix nanosecond time of the client's latest request
+	lastSeen atomic.Int64
+}
+
+// IPRateLimiterMiddleware limits each client IP, as reported by
+// rateLimitIP, to requestsPerSecond with bursts of up to burst requests.
+// X-Forwarded-For is only believed from the trustedProxies. Requests over
+// the limit get 429 with a Retry-After header. Limiters of clients idle for
+// cleanupInterval are dropped by a background goroutine that runs for the
+// life of the process.
+func IPRateLimiterMiddleware(requestsPerSecond float64, burst int, cleanupInterval time.Duration, trustedProxies []netip.Prefix) func(http.Handler) http.Handler {
+	if cleanupInterval <= 0 {
+		cleanupInterval = DefaultRateLimitCleanupInterval
+	}
+
+	var limiters sync.Map
+	go func() {
+		ticker := time.NewTicker(cleanupInterval)
+		defer ticker.Stop()
+
+		for now := range ticker.C {
+			cutoff := now.Add(-cleanupInterval).UnixNano()
+			limiters.Range(func(key, value interface{}) bool {
+				if value.(*ipLimiter).lastSeen.Load() < cutoff {
+					limiters.Delete(key)
+				}
+				return true
+			})
+		}
+	}()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := rateLimitIP(r, trustedProxies)
+			value, ok := limiters.Load(ip)
+			if !ok {
+				value, _ = limiters.LoadOrStore(ip, &ipLimiter{
+					limiter: rate.NewLimiter(rate.Limit(requestsPerSecond), burst),
+				})
+			}
+			client := value.(*ipLimiter)
+
+			now := time.Now()
+			client.lastSeen.Store(now.UnixNano())
+
+			reservation := client.limiter.ReserveN(now, 1)
+			if !reservation.OK() {
+				// A burst of zero admits nothing, so there is no time to wait for
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+			if delay := reservation.DelayFrom(now); delay > 0 {
+				// Give the token back, the request is not waiting for it
+				reservation.CancelAt(now)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimitIP returns the IP a request is rate limited by. Clients can set
+// X-Forwarded-For to anything, so it is the peer's address unless the peer
+// is one of the trusted proxies; then it is the right-most X-Forwarded-For
+// hop that is not a trusted proxy itself.
+func rateLimitIP(r *http.Request, trustedProxies []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrustedProxy(host, trustedProxies) {
+		return host
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !isTrustedProxy(hop, trustedProxies) {
+			return hop
+		}
+		host = hop
+	}
+	// Every hop was a trusted proxy, so the left-most one is the closest
+	// thing to a client there is
+	return host
+}
+
+// isTrustedProxy reports whether ip is within one of the trusted proxies
+func isTrustedProxy(ip string, trustedProxies []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,30 +1,64 @@
 package api
 
 import (
+	"compress/gzip"
+	"context"
 	"net/http"
 	"time"
 
 	"metrics-api/internal/api/handlers"
 	"metrics-api/internal/api/middleware"
+	"metrics-api/internal/cache"
 	"metrics-api/internal/config"
+	"metrics-api/internal/prometheus"
 	"metrics-api/internal/service"
 	"metrics-api/pkg/logger"
+	"metrics-api/pkg/openapi"
 
 	"github.com/gorilla/mux"
+	promclient "github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
 )
 
+// TracerName names the tracer of the API's spans. Spans are recorded by the
+// global OpenTelemetry tracer provider, which discards them unless the
+// process installs one.
+const TracerName = "metrics-api"
+
+// OpenAPITitle is the title of the API's OpenAPI description
+const OpenAPITitle = "Metrics API"
+
 // RouterOption represents a function that configures a router
 type RouterOption func(*RouterConfig)
 
 // RouterConfig contains all dependencies needed for the router
 type RouterConfig struct {
-	Logger         logger.Logger
-	MetricsService *service.MetricsService
-	QueriesService *service.QueriesService
-	AlertsService  *service.AlertsService
-	Config         *config.Config
-	Version        string
+	Logger           logger.Logger
+	PrometheusClient *prometheus.Client
+	MetricsService   *service.MetricsService
+	QueriesService   *service.QueriesService
+	AlertsService    *service.AlertsService
+	ExportService    *service.ExportService
+	Cache            *cache.Cache
+	Config           *config.Config
+	Version          string
+
+	// PrometheusSources, when set, lets requests pick a Prometheus backend
+	// with ?source=; PrometheusClient should be its default client
+	PrometheusSources *prometheus.Sources
+
+	// Drainer, when set, tracks the API's in-flight requests and rejects
+	// new ones once shutdown starts draining
+	Drainer *middleware.Drainer
+
+	// OpenAPI, when set, collects the description of the mounted routes;
+	// the router creates its own otherwise
+	OpenAPI *openapi.Builder
+
+	// Registerer, when set, receives the handlers' own metrics, such as the
+	// number of open websockets
+	Registerer promclient.Registerer
 }
 
 // WithLogger sets the logger for the router
@@ -34,6 +68,42 @@ func WithLogger(logger logger.Logger) RouterOption {
 	}
 }
 
+// WithPrometheusClient sets the Prometheus client for the router
+func WithPrometheusClient(client *prometheus.Client) RouterOption {
+	return func(c *RouterConfig) {
+		c.PrometheusClient = client
+	}
+}
+
+// WithPrometheusSources sets the named Prometheus backends requests may select
+func WithPrometheusSources(sources *prometheus.Sources) RouterOption {
+	return func(c *RouterConfig) {
+		c.PrometheusSources = sources
+	}
+}
+
+// WithDrainer sets the drainer that tracks the API's in-flight requests
+func WithDrainer(drainer *middleware.Drainer) RouterOption {
+	return func(c *RouterConfig) {
+		c.Drainer = drainer
+	}
+}
+
+// WithOpenAPI sets the builder the router describes its routes to, so the
+// caller can also write the spec out
+func WithOpenAPI(spec *openapi.Builder) RouterOption {
+	return func(c *RouterConfig) {
+		c.OpenAPI = spec
+	}
+}
+
+// WithRegisterer sets the registry the handlers' metrics are registered with
+func WithRegisterer(reg promclient.Registerer) RouterOption {
+	return func(c *RouterConfig) {
+		c.Registerer = reg
+	}
+}
+
 // WithMetricsService sets the metrics service for the router
 func WithMetricsService(service *service.MetricsService) RouterOption {
 	return func(c *RouterConfig) {
@@ -55,6 +125,20 @@ func WithAlertsService(service *service.AlertsService) RouterOption {
 	}
 }
 
+// WithExportService sets the export service for the router
+func WithExportService(service *service.ExportService) RouterOption {
+	return func(c *RouterConfig) {
+		c.ExportService = service
+	}
+}
+
+// WithCache sets the query cache for the router
+func WithCache(cache *cache.Cache) RouterOption {
+	return func(c *RouterConfig) {
+		c.Cache = cache
+	}
+}
+
 // WithConfig sets the config for the router
 func WithConfig(config *config.Config) RouterOption {
 	return func(c *RouterConfig) {
@@ -69,6 +153,27 @@ func WithVersion(version string) RouterOption {
 	}
 }
 
+// apiKeyAuthConfig configures AuthMiddleware to require the API keys of auth
+// on /api/v1. Probes must work without a key, and admin routes are left to
+// their own JWT check.
+func apiKeyAuthConfig(auth config.AuthConfig) middleware.AuthConfig {
+	keys := make(map[string]middleware.User, len(auth.APIKeys))
+	for user, key := range auth.APIKeys {
+		keys[key] = middleware.User{ID: user}
+	}
+	return middleware.AuthConfig{
+		Enabled: true,
+		Type:    middleware.AuthTypeAPIKey,
+		APIKeys: keys,
+		SkipAuthForPath: []string{
+			"/api/v1/health",
+			"/api/v1/ready",
+			"/api/v1/live",
+			"/api/v1/admin",
+		},
+	}
+}
+
 // NewRouter creates a new router with all necessary routes and middleware
 func NewRouter(options ...RouterOption) *mux.Router {
 	// Create default config
@@ -81,43 +186,213 @@ func NewRouter(options ...RouterOption) *mux.Router {
 	for _, opt := range options {
 		opt(cfg)
 	}
+	if cfg.OpenAPI == nil {
+		cfg.OpenAPI = openapi.NewBuilder(OpenAPITitle, cfg.Version)
+	}
+	spec := cfg.OpenAPI.WithServer("/api/v1").WithErrorResponse(handlers.ErrorResponse{})
 	
+	maxBodyBytes := int64(middleware.DefaultMaxBodyBytes)
+	maxDecompressedBytes := int64(middleware.DefaultMaxDecompressedBytes)
+	compression := middleware.CompressionConfig{Level: gzip.DefaultCompression}
+	compressionEnabled := true
+	sanitizeErrors := false
+	cors := middleware.CORSConfig{
+		AllowedOrigins:   []string{"*"},
+		AllowCredentials: true,
+		MaxAgeSecs:       3600,
+	}
+	if cfg.Config != nil {
+		maxBodyBytes = cfg.Config.Server.MaxBodyBytes
+		maxDecompressedBytes = cfg.Config.Server.MaxDecompressedBodyBytes
+		compression = middleware.CompressionConfig{
+			Level:        cfg.Config.Compression.Level,
+			ContentTypes: cfg.Config.Compression.ContentTypes,
+			MinSize:      cfg.Config.Compression.MinSize,
+		}
+		compressionEnabled = cfg.Config.Compression.Enabled
+		sanitizeErrors = cfg.Config.Server.ErrorDetail == handlers.ErrorDetailSanitized
+		cors = middleware.CORSConfig{
+			AllowedOrigins:   cfg.Config.CORS.AllowedOrigins,
+			AllowedMethods:   cfg.Config.CORS.AllowedMethods,
+			AllowedHeaders:   cfg.Config.CORS.AllowedHeaders,
+			AllowCredentials: cfg.Config.CORS.AllowCredentials,
+			MaxAgeSecs:       cfg.Config.CORS.MaxAgeSecs,
+		}
+	}
+
 	// Create router
 	router := mux.NewRouter()
 	
 	// Apply CORS middleware at the root level
-	router.Use(middleware.CORSMiddleware)
+	router.Use(middleware.CORSMiddleware(cors))
 	
 	// Set up API routes
 	apiRouter := router.PathPrefix("/api/v1").Subrouter()
 	
+	// Streams end when draining starts, since they would otherwise hold it
+	// up until their clients leave
+	shutdown := context.Background()
+
 	// Add other middleware
+	if cfg.Drainer != nil {
+		apiRouter.Use(cfg.Drainer.Middleware)
+		shutdown = cfg.Drainer.Context()
+	}
+	apiRouter.Use(middleware.TracingMiddleware(otel.Tracer(TracerName)))
 	apiRouter.Use(middleware.RequestID)
 	apiRouter.Use(middleware.LogHTTPErrorMiddleware(cfg.Logger))
 	apiRouter.Use(middleware.RequestDurationMiddleware(cfg.Logger, 5*time.Second))
 	apiRouter.Use(middleware.LoggingMiddleware(cfg.Logger))
 	apiRouter.Use(middleware.RecoveryMiddleware(cfg.Logger))
+	if cfg.Config != nil && cfg.Config.RateLimit.Enabled {
+		rateLimit := cfg.Config.RateLimit
+		// Validated with the rest of the configuration
+		trustedProxies, _ := rateLimit.ParseTrustedProxies()
+		apiRouter.Use(middleware.IPRateLimiterMiddleware(rateLimit.RequestsPerSecond, rateLimit.Burst, rateLimit.CleanupInterval, trustedProxies))
+	}
+	if cfg.Config != nil && cfg.Config.Auth.Enabled {
+		apiRouter.Use(middleware.AuthMiddleware(apiKeyAuthConfig(cfg.Config.Auth), cfg.Logger))
+	}
+	apiRouter.Use(middleware.MaxBodyBytes(maxBodyBytes))
+	apiRouter.Use(middleware.GzipRequestMiddleware(maxDecompressedBytes))
+	if compressionEnabled {
+		apiRouter.Use(middleware.CompressionMiddleware(compression))
+	}
+	if sanitizeErrors {
+		apiRouter.Use(handlers.SanitizeErrors(cfg.Logger))
+	}
+	apiRouter.Use(handlers.PrettyJSON)
+	if cfg.PrometheusSources != nil {
+		apiRouter.Use(handlers.SelectSource(cfg.PrometheusSources))
+	}
 	
+	// Admin endpoints expose other callers' queries and errors, so they are
+	// served to admins only, and only when tokens can be verified
+	var adminRouter *mux.Router
+	if cfg.Config != nil && cfg.Config.Auth.JWTSecret != "" {
+		adminRouter = apiRouter.PathPrefix("/admin").Subrouter()
+		adminRouter.Use(middleware.JWTAuth(middleware.AuthConfig{JWTSecret: cfg.Config.Auth.JWTSecret}, cfg.Logger))
+		adminRouter.Use(middleware.RoleAuth([]string{"admin"}))
+	}
+
 	// Create handlers
 	if cfg.MetricsService != nil {
 		metricsHandler := handlers.NewMetricsHandler(cfg.MetricsService, cfg.Logger)
 		metricsHandler.RegisterRoutes(apiRouter)
+		metricsHandler.DescribeRoutes(spec)
 	}
 	
 	if cfg.QueriesService != nil {
-		queriesHandler := handlers.NewQueriesHandler(cfg.QueriesService, cfg.Logger)
+		queriesHandler := handlers.NewQueriesHandler(cfg.QueriesService, cfg.Logger).
+			WithShutdown(shutdown)
+		if cfg.Config != nil {
+			queriesHandler.WithHeartbeatInterval(cfg.Config.Server.StreamHeartbeatInterval)
+			queriesHandler.WithMaxStreamDuration(cfg.Config.Server.StreamMaxDuration)
+		}
 		queriesHandler.RegisterRoutes(apiRouter)
+		queriesHandler.DescribeRoutes(spec)
+	}
+
+	if cfg.QueriesService != nil {
+		batchHandler := handlers.NewBatchQueriesHandler(cfg.QueriesService, cfg.Logger)
+		batchHandler.RegisterRoutes(apiRouter)
+		batchHandler.DescribeRoutes(spec)
+	}
+
+	if cfg.QueriesService != nil {
+		sseHandler := handlers.NewSSEHandler(cfg.QueriesService, cfg.Logger).
+			WithShutdown(shutdown)
+		if cfg.Config != nil {
+			sseHandler.WithChunkDuration(cfg.Config.Server.StreamChunkDuration)
+		}
+		sseHandler.RegisterRoutes(apiRouter)
+		sseHandler.DescribeRoutes(spec)
+	}
+
+	if cfg.QueriesService != nil {
+		streamHandler := handlers.NewMetricsStreamHandler(cfg.QueriesService, cfg.Logger).
+			WithAllowedOrigins(cors.AllowedOrigins).
+			WithShutdown(shutdown)
+		if cfg.Registerer != nil {
+			streamHandler.WithRegisterer(cfg.Registerer)
+		}
+		streamHandler.RegisterRoutes(apiRouter)
+		streamHandler.DescribeRoutes(spec)
 	}
 	
 	if cfg.AlertsService != nil {
-		alertsHandler := handlers.NewAlertsHandler(cfg.AlertsService, cfg.Logger)
+		alertsHandler := handlers.NewAlertsHandler(cfg.AlertsService, cfg.Logger).
+			WithAllowedOrigins(cors.AllowedOrigins).
+			WithShutdown(shutdown)
+		if cfg.Config != nil {
+			alertsHandler.WithPollInterval(cfg.Config.Alerts.PollInterval)
+		}
 		alertsHandler.RegisterRoutes(apiRouter)
+		alertsHandler.DescribeRoutes(spec)
+	}
+	
+	if cfg.ExportService != nil {
+		exportHandler := handlers.NewExportHandler(cfg.ExportService, cfg.Logger)
+		exportHandler.RegisterRoutes(apiRouter)
+		exportHandler.DescribeRoutes(spec)
+	}
+
+	if cfg.Cache != nil && adminRouter != nil {
+		cacheHandler := handlers.NewCacheHandler(cfg.Cache, cfg.Logger)
+		cacheHandler.RegisterRoutes(adminRouter)
+		cacheHandler.DescribeRoutes(spec)
+	}
+
+	if cfg.PrometheusClient != nil {
+		prometheusHandler := handlers.NewPrometheusHandler(cfg.PrometheusClient, cfg.Logger)
+		prometheusHandler.RegisterRoutes(apiRouter)
+		prometheusHandler.DescribeRoutes(spec)
+		if adminRouter != nil {
+			prometheusHandler.RegisterAdminRoutes(adminRouter)
+			prometheusHandler.DescribeAdminRoutes(spec)
+		}
+	}
+
+	if cfg.PrometheusClient != nil {
+		rulesHandler := handlers.NewRulesHandler(cfg.PrometheusClient, cfg.Logger)
+		rulesHandler.RegisterRoutes(apiRouter)
+		rulesHandler.DescribeRoutes(spec)
 	}
 	
 	// Always register health handler
-	healthHandler := handlers.NewHealthHandler(nil, cfg.Logger, cfg.Version)
+	healthHandler := handlers.NewHealthHandler(cfg.PrometheusClient, cfg.Logger, cfg.Version)
+	if cfg.Config != nil {
+		healthHandler.WithTimeouts(handlers.HealthTimeouts{
+			Detailed:  cfg.Config.Health.DetailedTimeout,
+			Readiness: cfg.Config.Health.ReadinessTimeout,
+			Check:     cfg.Config.Health.CheckTimeout,
+		})
+		healthHandler.WithThresholds(handlers.HealthThresholds{
+			Memory:     cfg.Config.Health.MemoryThreshold,
+			Goroutines: cfg.Config.Health.GoroutineThreshold,
+		})
+		if interval := cfg.Config.Health.CheckInterval; interval > 0 {
+			if err := healthHandler.StartPeriodicChecks(shutdown, interval); err != nil {
+				cfg.Logger.Errorf("Failed to start periodic health checks: %v", err)
+			}
+		}
+	}
 	healthHandler.RegisterRoutes(apiRouter)
+	healthHandler.DescribeRoutes(spec)
 	
+	// Serve the profiler to admins only, and only when explicitly enabled
+	if cfg.Config != nil && cfg.Config.Server.EnablePprof {
+		debugRouter := router.PathPrefix("/debug").Subrouter()
+		debugRouter.Use(middleware.JWTAuth(middleware.AuthConfig{JWTSecret: cfg.Config.Auth.JWTSecret}, cfg.Logger))
+		debugRouter.Use(middleware.RoleAuth([]string{"admin"}))
+		debugHandler := handlers.NewDebugHandler(cfg.Logger)
+		debugHandler.RegisterRoutes(debugRouter)
+	}
+
+	// Serve the API description without authentication
+	openAPIHandler := handlers.NewOpenAPIHandler(spec, cfg.Logger)
+	openAPIHandler.RegisterRoutes(router)
+
 	// Add Prometheus metrics endpoint at /metrics (outside of /api/v1)
 	router.Handle("/metrics", promhttp.Handler())
 	
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"metrics-api/internal/config"
+	"metrics-api/internal/service"
+	"metrics-api/pkg/logger"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRouter builds a router from a YAML configuration
+func newTestRouter(t *testing.T, yaml string) *mux.Router {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(yaml), 0o600))
+	cfg, err := config.LoadFromFile(path)
+	require.NoError(t, err)
+
+	log := logger.NewTestLogger()
+	return NewRouter(
+		WithLogger(log),
+		WithConfig(cfg),
+		WithExportService(service.NewExportService(nil, log)),
+	)
+}
+
+// Test that API keys are required on /api/v1 when auth is enabled, except
+// for the probes and the API description
+func TestRouterAPIKeyAuth(t *testing.T) {
+	router := newTestRouter(t, `
+auth:
+  enabled: true
+  api_keys:
+    grafana: secret-key
+health:
+  check_interval: 0s
+`)
+	get := func(path, key string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, get("/api/v1/export/jobs/missing", ""))
+	assert.Equal(t, http.StatusUnauthorized, get("/api/v1/export/jobs/missing", "wrong-key"))
+	assert.Equal(t, http.StatusNotFound, get("/api/v1/export/jobs/missing", "secret-key"))
+
+	assert.Equal(t, http.StatusOK, get("/api/v1/live", ""))
+	assert.NotEqual(t, http.StatusUnauthorized, get("/api/v1/ready", ""))
+	assert.NotEqual(t, http.StatusUnauthorized, get("/api/v1/health", ""))
+	assert.Equal(t, http.StatusOK, get("/openapi.json", ""))
+}
+
+// Test that routes are open when auth is disabled
+func TestRouterAuthDisabled(t *testing.T) {
+	router := newTestRouter(t, `
+health:
+  check_interval: 0s
+`)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/export/jobs/missing", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
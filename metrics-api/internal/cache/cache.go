@@ -1,9 +1,13 @@
 package cache
 
 import (
+	"encoding/json"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
+
+	"golang.org/x/sync/singleflight"
 )
 
 // Item represents a cached item with value and expiration time
@@ -12,6 +16,13 @@ type Item struct {
 	Expiration int64
 	Created    int64
 	LastAccess int64
+	// AccessCount is the number of successful Gets, used by EvictLFU
+	AccessCount int64
+	// Size is the value's estimated size in bytes, counted against MaxBytes
+	Size int64
+	// SlidingWindow is added to the access time on every Get to push back
+	// Expiration, in nanoseconds; 0 means the expiration is fixed
+	SlidingWindow int64
 }
 
 // Expired checks if the item has expired
@@ -22,6 +33,16 @@ func (item Item) Expired() bool {
 	return time.Now().UnixNano() > item.Expiration
 }
 
+// touch records an access at now, sliding the expiration forward when the
+// item has a sliding window
+func (item *Item) touch(now int64) {
+	item.LastAccess = now
+	item.AccessCount++
+	if item.SlidingWindow > 0 {
+		item.Expiration = now + item.SlidingWindow
+	}
+}
+
 // keyExpiration is used for sorting during eviction
 type keyExpiration struct {
 	key   string
@@ -36,10 +57,20 @@ type Cache struct {
 	cleanupInterval   time.Duration
 	stopCleanup       chan bool
 	maxItems          int
+	maxBytes          int64
+	sizer             func(interface{}) int64
+	sizeBytes         int64
 	evictionPolicy    EvictionPolicy
 	onEviction        func(string, interface{})
+	onSet             func(string, time.Duration)
+	onExpiry          func(string, interface{})
+	onDelete          func(string)
 	statsEnabled      bool
 	stats             Stats
+	// pending holds hook events raised under mu, run by unlock
+	pending []event
+	// loads deduplicates concurrent GetOrSet computations per key
+	loads singleflight.Group
 }
 
 // EvictionPolicy defines strategies for removing items when the cache is full
@@ -63,6 +94,30 @@ type Stats struct {
 	ExpiredDeletions int64
 }
 
+// Backend selects where cached items are stored
+type Backend string
+
+const (
+	// BackendMemory keeps items in this process
+	BackendMemory Backend = "memory"
+	// BackendRedis keeps items in Redis, shared between instances
+	BackendRedis Backend = "redis"
+)
+
+// Store is the set of operations shared by every cache backend
+type Store interface {
+	Get(key string) (interface{}, bool)
+	Set(key string, value interface{}) error
+	SetWithExpiration(key string, value interface{}, duration time.Duration) error
+	Delete(key string)
+	Has(key string) bool
+	Flush()
+	Count() int
+	TTL(key string) (time.Duration, bool)
+	GetAllKeys() []string
+	GetStats() Stats
+}
+
 // Options contains configuration for a new cache
 type Options struct {
 	DefaultExpiration time.Duration
@@ -71,6 +126,24 @@ type Options struct {
 	EvictionPolicy    EvictionPolicy
 	OnEviction        func(string, interface{})
 	StatsEnabled      bool
+	// OnSet is called after a key is stored with its time to live, 0 when
+	// it never expires
+	OnSet func(key string, ttl time.Duration)
+	// OnExpiry is called when the cleanup loop removes an expired item;
+	// OnEviction only covers items removed to make room
+	OnExpiry func(key string, value interface{})
+	// OnDelete is called for each key removed by Delete or Flush
+	OnDelete func(key string)
+	// MaxBytes caps the total estimated size of values, 0 for no limit
+	MaxBytes int64
+	// Sizer estimates a value's size in bytes; DefaultSizer is used when nil
+	Sizer func(value interface{}) int64
+	// Backend is used by NewStore; New always builds an in-memory cache
+	Backend Backend
+	// RedisAddr, RedisPassword and RedisPrefix configure BackendRedis
+	RedisAddr     string
+	RedisPassword string
+	RedisPrefix   string
 }
 
 // DefaultOptions returns default cache options
@@ -82,19 +155,50 @@ func DefaultOptions() Options {
 		EvictionPolicy:    EvictLRU,
 		OnEviction:        nil,
 		StatsEnabled:      false,
+		Backend:           BackendMemory,
 	}
 }
 
+// DefaultSizer estimates the size of strings and byte slices by their length
+// and of other values, such as cached query results, by the length of their
+// JSON encoding. Values that cannot be encoded count as zero bytes.
+func DefaultSizer(value interface{}) int64 {
+	switch v := value.(type) {
+	case nil:
+		return 0
+	case string:
+		return int64(len(v))
+	case []byte:
+		return int64(len(v))
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return 0
+	}
+	return int64(len(data))
+}
+
 // New creates a new cache with the specified options
 func New(options Options) *Cache {
+	sizer := options.Sizer
+	if sizer == nil {
+		sizer = DefaultSizer
+	}
+
 	cache := &Cache{
 		items:             make(map[string]Item),
 		defaultExpiration: options.DefaultExpiration,
 		cleanupInterval:   options.CleanupInterval,
 		stopCleanup:       make(chan bool),
 		maxItems:          options.MaxItems,
+		maxBytes:          options.MaxBytes,
+		sizer:             sizer,
 		evictionPolicy:    options.EvictionPolicy,
 		onEviction:        options.OnEviction,
+		onSet:             options.OnSet,
+		onExpiry:          options.OnExpiry,
+		onDelete:          options.OnDelete,
 		statsEnabled:      options.StatsEnabled,
 	}
 
@@ -106,9 +210,37 @@ func New(options Options) *Cache {
 	return cache
 }
 
+// NewStore creates a cache on the backend selected by options.Backend,
+// returning a *Cache for BackendMemory and a *RedisCache for BackendRedis
+func NewStore(options Options) (Store, error) {
+	switch options.Backend {
+	case "", BackendMemory:
+		return New(options), nil
+	case BackendRedis:
+		return NewRedis(options)
+	default:
+		return nil, fmt.Errorf("unknown cache backend: %s", options.Backend)
+	}
+}
+
 // Set adds an item to the cache with the default expiration
 func (c *Cache) Set(key string, value interface{}) error {
-	return c.SetWithExpiration(key, value, c.defaultExpiration)
+	return c.SetWithExpiration(key, value, c.DefaultExpiration())
+}
+
+// DefaultExpiration returns the expiration of items added with Set
+func (c *Cache) DefaultExpiration() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.defaultExpiration
+}
+
+// SetDefaultExpiration changes the expiration of items added with Set from
+// now on; items already cached keep theirs
+func (c *Cache) SetDefaultExpiration(duration time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.defaultExpiration = duration
 }
 
 // SetWithExpiration adds an item to the cache with a specific expiration
@@ -120,28 +252,108 @@ func (c *Cache) SetWithExpiration(key string, value interface{}, duration time.D
 	}
 
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
 
-	// Check if cache is full and eviction is needed
-	if c.maxItems > 0 && len(c.items) >= c.maxItems && c.items[key].Value == nil {
-		if err := c.evict(1); err != nil {
+	return c.set(key, value, expiration, 0)
+}
+
+// SetWithSlidingExpiration adds an item that expires once it has gone
+// unread for window; every Get pushes its expiration back by window
+func (c *Cache) SetWithSlidingExpiration(key string, value interface{}, window time.Duration) error {
+	if window <= 0 {
+		return fmt.Errorf("sliding expiration window must be positive, got %s", window)
+	}
+
+	c.mu.Lock()
+	defer c.unlock()
+
+	return c.set(key, value, time.Now().Add(window).UnixNano(), int64(window))
+}
+
+// SetMany adds all items with the same expiration under a single lock,
+// evicting as Set would when MaxItems is reached
+func (c *Cache) SetMany(items map[string]interface{}, duration time.Duration) error {
+	var expiration int64
+
+	if duration > 0 {
+		expiration = time.Now().Add(duration).UnixNano()
+	}
+
+	c.mu.Lock()
+	defer c.unlock()
+
+	for key, value := range items {
+		if err := c.set(key, value, expiration, 0); err != nil {
 			return err
 		}
 	}
+	return nil
+}
+
+// set stores an item, evicting first if the cache is full; the caller holds c.mu
+func (c *Cache) set(key string, value interface{}, expiration, window int64) error {
+	size := c.sizer(value)
+	if c.maxBytes > 0 && size > c.maxBytes {
+		return fmt.Errorf("cache item %s of %d bytes exceeds the %d byte limit", key, size, c.maxBytes)
+	}
+
+	// Overwriting a key keeps its access count so LFU still sees it as hot.
+	// The old value is dropped first so eviction cannot pick the key itself.
+	previous, overwriting := c.items[key]
+	if overwriting {
+		c.remove(key)
+	}
+
+	if err := c.makeRoom(size); err != nil {
+		return err
+	}
 
 	// Get the current time in nanoseconds
 	now := time.Now().UnixNano()
 
 	c.items[key] = Item{
-		Value:      value,
-		Expiration: expiration,
-		Created:    now,
-		LastAccess: now,
+		Value:         value,
+		Expiration:    expiration,
+		Created:       now,
+		LastAccess:    now,
+		AccessCount:   previous.AccessCount,
+		Size:          size,
+		SlidingWindow: window,
 	}
+	c.sizeBytes += size
 
+	var ttl time.Duration
+	if expiration > 0 {
+		ttl = time.Duration(expiration - now)
+	}
+	c.notify(event{kind: eventSet, key: key, ttl: ttl})
+
+	return nil
+}
+
+// makeRoom evicts items until one more of size bytes fits under MaxItems
+// and MaxBytes; the caller holds c.mu
+func (c *Cache) makeRoom(size int64) error {
+	// Check if cache is full and eviction is needed
+	if c.maxItems > 0 && len(c.items) >= c.maxItems {
+		if err := c.evict(1); err != nil {
+			return err
+		}
+	}
+	for c.maxBytes > 0 && c.sizeBytes+size > c.maxBytes && len(c.items) > 0 {
+		if err := c.evict(1); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// remove deletes an item and releases its size; the caller holds c.mu
+func (c *Cache) remove(key string) {
+	c.sizeBytes -= c.items[key].Size
+	delete(c.items, key)
+}
+
 // Get retrieves an item from the cache
 // The second return value indicates whether the key was found
 func (c *Cache) Get(key string) (interface{}, bool) {
@@ -166,10 +378,10 @@ func (c *Cache) Get(key string) (interface{}, bool) {
 
 	c.mu.RUnlock()
 
-	// Update last access time
+	// Update last access time and frequency, sliding the expiration
 	c.mu.Lock()
 	if it, found := c.items[key]; found {
-		it.LastAccess = time.Now().UnixNano()
+		it.touch(time.Now().UnixNano())
 		c.items[key] = it
 	}
 	c.mu.Unlock()
@@ -181,31 +393,88 @@ func (c *Cache) Get(key string) (interface{}, bool) {
 	return item.Value, true
 }
 
+// GetMany retrieves every present, unexpired key with one read lock and then
+// records the accesses with one write lock. Missing keys are left out.
+func (c *Cache) GetMany(keys []string) map[string]interface{} {
+	values := make(map[string]interface{}, len(keys))
+
+	c.mu.RLock()
+	for _, key := range keys {
+		if item, found := c.items[key]; found && !item.Expired() {
+			values[key] = item.Value
+		}
+	}
+	c.mu.RUnlock()
+
+	// Update last access time and frequency, sliding expirations
+	now := time.Now().UnixNano()
+	c.mu.Lock()
+	for key := range values {
+		if it, found := c.items[key]; found {
+			it.touch(now)
+			c.items[key] = it
+		}
+	}
+	if c.statsEnabled {
+		c.stats.Hits += int64(len(values))
+		c.stats.Misses += int64(len(keys) - len(values))
+	}
+	c.mu.Unlock()
+
+	return values
+}
+
+// GetOrSet returns the cached value for key, or calls fn and caches its
+// result for ttl. Concurrent callers missing the same key share a single
+// call to fn, while other keys are not blocked. Errors from fn are returned
+// to every waiting caller and nothing is cached. A value that cannot be
+// cached, such as one over MaxBytes, is returned together with the error.
+func (c *Cache) GetOrSet(key string, ttl time.Duration, fn func() (interface{}, error)) (interface{}, error) {
+	if value, found := c.Get(key); found {
+		return value, nil
+	}
+
+	value, err, _ := c.loads.Do(key, func() (interface{}, error) {
+		// A call that finished while this caller was missing may have filled the key
+		if value, found := c.Get(key); found {
+			return value, nil
+		}
+
+		value, err := fn()
+		if err != nil {
+			return nil, err
+		}
+		if err := c.SetWithExpiration(key, value, ttl); err != nil {
+			return value, err
+		}
+		return value, nil
+	})
+	return value, err
+}
+
 // Delete removes an item from the cache
 func (c *Cache) Delete(key string) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
 
-	if item, found := c.items[key]; found && c.onEviction != nil {
-		c.onEviction(key, item.Value)
+	if _, found := c.items[key]; found {
+		c.notify(event{kind: eventDelete, key: key})
 	}
 
-	delete(c.items, key)
+	c.remove(key)
 }
 
 // Flush removes all items from the cache
 func (c *Cache) Flush() {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
 
-	// Call eviction callback if provided
-	if c.onEviction != nil {
-		for k, v := range c.items {
-			c.onEviction(k, v.Value)
-		}
+	for k := range c.items {
+		c.notify(event{kind: eventDelete, key: k})
 	}
 
 	c.items = make(map[string]Item)
+	c.sizeBytes = 0
 }
 
 // Count returns the number of items in the cache
@@ -297,6 +566,32 @@ func (c *Cache) TTL(key string) (time.Duration, bool) {
 	return time.Duration(item.Expiration - now), true
 }
 
+// ExpiringWithin returns the keys whose remaining time to live is under d,
+// soonest first, so they can be refreshed before they expire. Keys that never
+// expire or have already expired are left out.
+func (c *Cache) ExpiringWithin(d time.Duration) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := time.Now().UnixNano()
+	deadline := now + int64(d)
+
+	candidates := make([]keyExpiration, 0)
+	for k, item := range c.items {
+		if item.Expiration == 0 || item.Expiration < now || item.Expiration >= deadline {
+			continue
+		}
+		candidates = append(candidates, keyExpiration{key: k, value: item.Expiration})
+	}
+	sortByValue(candidates, true)
+
+	keys := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		keys = append(keys, candidate.key)
+	}
+	return keys
+}
+
 // startCleanupTimer starts a timer to periodically clean up expired items
 func (c *Cache) startCleanupTimer() {
 	ticker := time.NewTicker(c.cleanupInterval)
@@ -315,7 +610,7 @@ func (c *Cache) startCleanupTimer() {
 // deleteExpired deletes all expired items from the cache
 func (c *Cache) deleteExpired() {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
 
 	if c.statsEnabled {
 		c.stats.CleanupRuns++
@@ -324,13 +619,11 @@ func (c *Cache) deleteExpired() {
 	var expiredCount int
 
 	for k, v := range c.items {
-		// Delete if expired
+		// Delete if expired; Get keeps a sliding item's Expiration current,
+		// so only items left unread for their whole window are removed
 		if v.Expired() {
-			// Call eviction callback if provided
-			if c.onEviction != nil {
-				c.onEviction(k, v.Value)
-			}
-			delete(c.items, k)
+			c.notify(event{kind: eventExpiry, key: k, value: v.Value})
+			c.remove(k)
 			expiredCount++
 		}
 	}
@@ -382,8 +675,21 @@ func (c *Cache) evict(count int) error {
 		sortByValue(candidates, true)
 
 	case EvictLFU:
-		// Not implemented yet, fall back to LRU
-		return fmt.Errorf("EvictLFU policy not implemented, please use EvictLRU or EvictOldest")
+		// Evict least frequently used items, breaking ties by last access
+		// time and then key so the choice is deterministic
+		for k, v := range c.items {
+			candidates = append(candidates, keyExpiration{k, v.AccessCount})
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			if candidates[i].value != candidates[j].value {
+				return candidates[i].value < candidates[j].value
+			}
+			a, b := c.items[candidates[i].key], c.items[candidates[j].key]
+			if a.LastAccess != b.LastAccess {
+				return a.LastAccess < b.LastAccess
+			}
+			return candidates[i].key < candidates[j].key
+		})
 
 	default:
 		return fmt.Errorf("unknown eviction policy: %s", c.evictionPolicy)
@@ -393,11 +699,8 @@ func (c *Cache) evict(count int) error {
 	for i := 0; i < count && i < len(candidates); i++ {
 		key := candidates[i].key
 		if item, found := c.items[key]; found {
-			// Call eviction callback if provided
-			if c.onEviction != nil {
-				c.onEviction(key, item.Value)
-			}
-			delete(c.items, key)
+			c.notify(event{kind: eventEviction, key: key, value: item.Value})
+			c.remove(key)
 
 			if c.statsEnabled {
 				c.stats.Evictions++
@@ -475,4 +778,72 @@ func (c *Cache) ResetStats() {
 	c.mu.Lock()
 	c.stats = Stats{}
 	c.mu.Unlock()
-}
\ No newline at end of file
+}
+
+// SizeBytes returns the total estimated size of the cached values
+func (c *Cache) SizeBytes() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.sizeBytes
+}
+
+// MaxItems returns the configured item limit, or 0 when the cache is unbounded
+func (c *Cache) MaxItems() int {
+	return c.maxItems
+}
+
+// Thresholds used by Recommendations
+const (
+	// minLookupsForAdvice is how many lookups are needed before stats are trusted
+	minLookupsForAdvice = 100
+	// lowHitRatio is the hit ratio below which entries expire too soon to be reused
+	lowHitRatio = 0.5
+	// highEvictionRate is the evictions per lookup above which the cache is too small
+	highEvictionRate = 0.1
+	// nearlyFullRatio is the fill level at which evictions are about to start
+	nearlyFullRatio = 0.9
+)
+
+// Recommendations suggests changes to the cache settings based on its live statistics
+func (c *Cache) Recommendations() []string {
+	c.mu.RLock()
+	enabled, stats, size := c.statsEnabled, c.stats, len(c.items)
+	c.mu.RUnlock()
+
+	if !enabled {
+		return []string{"statistics are disabled, enable them to get recommendations"}
+	}
+	return recommend(stats, size, c.maxItems)
+}
+
+// recommend turns cache statistics into human-readable tuning advice
+func recommend(stats Stats, size, maxItems int) []string {
+	lookups := stats.Hits + stats.Misses
+	if lookups < minLookupsForAdvice {
+		return []string{fmt.Sprintf("only %d lookups recorded, not enough traffic to recommend changes", lookups)}
+	}
+
+	var recommendations []string
+
+	hitRatio := float64(stats.Hits) / float64(lookups)
+	if hitRatio < lowHitRatio {
+		recommendations = append(recommendations, fmt.Sprintf(
+			"hit ratio is %.0f%%, below %.0f%%, consider raising the TTL", hitRatio*100, lowHitRatio*100))
+	}
+
+	if maxItems > 0 {
+		evictionRate := float64(stats.Evictions) / float64(lookups)
+		if evictionRate > highEvictionRate {
+			recommendations = append(recommendations, fmt.Sprintf(
+				"high eviction rate of %.0f%% of lookups, raise MaxItems above %d", evictionRate*100, maxItems))
+		} else if float64(size) >= nearlyFullRatio*float64(maxItems) {
+			recommendations = append(recommendations, fmt.Sprintf(
+				"cache is %d of %d items full, consider raising MaxItems before evictions start", size, maxItems))
+		}
+	}
+
+	if len(recommendations) == 0 {
+		recommendations = append(recommendations, "no changes recommended")
+	}
+	return recommendations
+}
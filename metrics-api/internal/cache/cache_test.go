@@ -1,8 +1,12 @@
 package cache
 
 import (
+	"errors"
 	"fmt"
+	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -401,6 +405,319 @@ func TestCacheConcurrency(t *testing.T) {
 	}
 }
 
+func TestCacheGetOrSet(t *testing.T) {
+	cache := New(Options{DefaultExpiration: time.Hour})
+
+	var calls int32
+	compute := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		// Hold the call open so every goroutine arrives while it runs
+		time.Sleep(50 * time.Millisecond)
+		return "summary", nil
+	}
+
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			value, err := cache.GetOrSet("summary", time.Minute, compute)
+			if err != nil {
+				errs <- err
+			} else if value != "summary" {
+				errs <- fmt.Errorf("unexpected value %v", value)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("GetOrSet failed: %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("Expected fn to run once, ran %d times", n)
+	}
+	if ttl, found := cache.TTL("summary"); !found || ttl > time.Minute {
+		t.Errorf("Expected summary cached with a TTL of at most a minute, got %v (found %v)", ttl, found)
+	}
+}
+
+func TestCacheGetOrSetError(t *testing.T) {
+	cache := New(Options{DefaultExpiration: time.Hour})
+	errUpstream := errors.New("prometheus unavailable")
+
+	var calls int32
+	failing := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(20 * time.Millisecond)
+		return nil, errUpstream
+	}
+
+	var wg sync.WaitGroup
+	var failures int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cache.GetOrSet("summary", time.Minute, failing); errors.Is(err, errUpstream) {
+				atomic.AddInt32(&failures, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if failures != 10 {
+		t.Errorf("Expected every caller to see the error, got %d", failures)
+	}
+	if cache.Has("summary") {
+		t.Error("Failed computation should not be cached")
+	}
+
+	// A later call computes again
+	value, err := cache.GetOrSet("summary", time.Minute, func() (interface{}, error) { return "ok", nil })
+	if err != nil || value != "ok" {
+		t.Errorf("Expected retry to succeed, got %v, %v", value, err)
+	}
+}
+
+func TestCacheGetManySetMany(t *testing.T) {
+	cache := New(Options{
+		DefaultExpiration: time.Hour,
+		StatsEnabled:      true,
+	})
+
+	err := cache.SetMany(map[string]interface{}{
+		"min": 1.0,
+		"max": 9.0,
+		"avg": 5.0,
+	}, time.Minute)
+	if err != nil {
+		t.Fatalf("SetMany failed: %v", err)
+	}
+
+	if ttl, found := cache.TTL("max"); !found || ttl > time.Minute {
+		t.Errorf("Expected max to expire within a minute, got %v (found %v)", ttl, found)
+	}
+
+	cache.SetWithExpiration("stale", 0.0, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	values := cache.GetMany([]string{"min", "avg", "stale", "missing"})
+	if len(values) != 2 || values["min"] != 1.0 || values["avg"] != 5.0 {
+		t.Errorf("Expected min and avg only, got %v", values)
+	}
+
+	cache.mu.RLock()
+	minItem := cache.items["min"]
+	cache.mu.RUnlock()
+	if minItem.AccessCount != 1 {
+		t.Errorf("Expected GetMany to record one access to min, got %d", minItem.AccessCount)
+	}
+
+	if stats := cache.GetStats(); stats.Hits != 2 || stats.Misses != 2 {
+		t.Errorf("Expected 2 hits and 2 misses, got %+v", stats)
+	}
+}
+
+func TestCacheSetManyEviction(t *testing.T) {
+	evictedKeys := make([]string, 0)
+	cache := New(Options{
+		DefaultExpiration: time.Hour,
+		MaxItems:          3,
+		EvictionPolicy:    EvictLRU,
+		OnEviction: func(key string, value interface{}) {
+			evictedKeys = append(evictedKeys, key)
+		},
+	})
+
+	cache.Set("old", "value")
+	time.Sleep(time.Millisecond)
+
+	if err := cache.SetMany(map[string]interface{}{"a": 1, "b": 2, "c": 3}, 0); err != nil {
+		t.Fatalf("SetMany failed: %v", err)
+	}
+
+	if cache.Count() != 3 {
+		t.Errorf("Expected cache to stay at 3 items, got %d", cache.Count())
+	}
+	if len(evictedKeys) != 1 || evictedKeys[0] != "old" {
+		t.Errorf("Expected old to be evicted, got %v", evictedKeys)
+	}
+}
+
+func TestCacheMaxBytes(t *testing.T) {
+	evictedKeys := make([]string, 0)
+	cache := New(Options{
+		DefaultExpiration: time.Hour,
+		MaxBytes:          100,
+		EvictionPolicy:    EvictOldest,
+		OnEviction: func(key string, value interface{}) {
+			evictedKeys = append(evictedKeys, key)
+		},
+	})
+
+	cache.Set("small1", strings.Repeat("a", 10))
+	time.Sleep(time.Millisecond)
+	cache.Set("small2", []byte(strings.Repeat("b", 10)))
+	time.Sleep(time.Millisecond)
+	cache.Set("large1", strings.Repeat("c", 60))
+
+	if size := cache.SizeBytes(); size != 80 {
+		t.Errorf("Expected 80 bytes, got %d", size)
+	}
+	if len(evictedKeys) != 0 {
+		t.Errorf("Expected no evictions under the limit, got %v", evictedKeys)
+	}
+
+	// 80 + 40 exceeds the limit, so the two oldest small items go
+	time.Sleep(time.Millisecond)
+	cache.Set("large2", strings.Repeat("d", 40))
+
+	if size := cache.SizeBytes(); size != 100 {
+		t.Errorf("Expected 100 bytes, got %d", size)
+	}
+	if len(evictedKeys) != 2 || evictedKeys[0] != "small1" || evictedKeys[1] != "small2" {
+		t.Errorf("Expected small1 and small2 to be evicted, got %v", evictedKeys)
+	}
+
+	// Overwriting releases the old size rather than evicting
+	cache.Set("large1", strings.Repeat("e", 10))
+	if size := cache.SizeBytes(); size != 50 {
+		t.Errorf("Expected 50 bytes after overwrite, got %d", size)
+	}
+	if len(evictedKeys) != 2 {
+		t.Errorf("Expected no further evictions, got %v", evictedKeys)
+	}
+
+	// A value larger than the whole cache is rejected
+	if err := cache.Set("huge", strings.Repeat("f", 101)); err == nil {
+		t.Error("Expected error for a value over MaxBytes")
+	}
+	if cache.Has("huge") {
+		t.Error("huge should not be cached")
+	}
+
+	cache.Delete("large2")
+	if size := cache.SizeBytes(); size != 10 {
+		t.Errorf("Expected 10 bytes after delete, got %d", size)
+	}
+
+	cache.Flush()
+	if size := cache.SizeBytes(); size != 0 {
+		t.Errorf("Expected 0 bytes after flush, got %d", size)
+	}
+}
+
+func TestCacheMaxBytesCustomSizer(t *testing.T) {
+	cache := New(Options{
+		DefaultExpiration: time.Hour,
+		MaxBytes:          1000,
+		EvictionPolicy:    EvictLRU,
+		Sizer: func(value interface{}) int64 {
+			return int64(len(value.([]float64)) * 8)
+		},
+	})
+
+	// Each matrix is 400 bytes, so only two fit
+	for i := 0; i < 5; i++ {
+		cache.Set(fmt.Sprintf("matrix%d", i), make([]float64, 50))
+		time.Sleep(time.Millisecond)
+	}
+
+	if size := cache.SizeBytes(); size > 1000 {
+		t.Errorf("Expected size to stay within 1000 bytes, got %d", size)
+	}
+	if cache.Count() != 2 || !cache.Has("matrix3") || !cache.Has("matrix4") {
+		t.Errorf("Expected the two newest matrices to remain, got %v", cache.GetAllKeys())
+	}
+}
+
+func TestCacheMaxBytesStructs(t *testing.T) {
+	type sample struct {
+		Metric string  `json:"metric"`
+		Value  float64 `json:"value"`
+	}
+	series := make([]sample, 20)
+	for i := range series {
+		series[i] = sample{Metric: "node_load1", Value: float64(i)}
+	}
+
+	size := DefaultSizer(series)
+	if size <= 0 {
+		t.Fatalf("Expected a positive size for a slice of structs, got %d", size)
+	}
+
+	cache := New(Options{
+		DefaultExpiration: time.Hour,
+		MaxBytes:          2 * size,
+		EvictionPolicy:    EvictOldest,
+	})
+	for i := 0; i < 3; i++ {
+		cache.Set(fmt.Sprintf("series%d", i), series)
+		time.Sleep(time.Millisecond)
+	}
+
+	if got := cache.SizeBytes(); got != 2*size {
+		t.Errorf("Expected %d bytes, got %d", 2*size, got)
+	}
+	if cache.Count() != 2 || cache.Has("series0") {
+		t.Errorf("Expected the oldest series to be evicted, got %v", cache.GetAllKeys())
+	}
+}
+
+func TestCacheSlidingExpiration(t *testing.T) {
+	cache := New(Options{
+		DefaultExpiration: time.Hour,
+		CleanupInterval:   10 * time.Millisecond,
+	})
+	defer cache.StopCleanup()
+
+	if err := cache.SetWithSlidingExpiration("hot", "value", 60*time.Millisecond); err != nil {
+		t.Fatalf("SetWithSlidingExpiration failed: %v", err)
+	}
+	cache.SetWithSlidingExpiration("cold", "value", 60*time.Millisecond)
+	cache.SetWithExpiration("fixed", "value", 60*time.Millisecond)
+
+	// Reading hot and fixed every 20ms outlasts the 60ms window only for hot
+	for i := 0; i < 6; i++ {
+		time.Sleep(20 * time.Millisecond)
+		if _, found := cache.Get("hot"); !found {
+			t.Fatalf("hot should stay cached while read, expired after %d reads", i)
+		}
+		cache.Get("fixed")
+	}
+
+	if cache.Has("cold") {
+		t.Error("cold should have expired without reads")
+	}
+	if cache.Has("fixed") {
+		t.Error("fixed should expire on schedule despite reads")
+	}
+
+	item, _ := cache.GetItem("hot")
+	if item.SlidingWindow != int64(60*time.Millisecond) {
+		t.Errorf("Expected sliding window of 60ms, got %d", item.SlidingWindow)
+	}
+
+	// Once reads stop, hot expires and is cleaned up
+	time.Sleep(100 * time.Millisecond)
+	if _, found := cache.Get("hot"); found {
+		t.Error("hot should expire once it stops being read")
+	}
+	if cache.Count() != 0 {
+		t.Errorf("Expected cleanup to remove every expired item, got %v", cache.GetAllKeys())
+	}
+
+	if err := cache.SetWithSlidingExpiration("bad", "value", 0); err == nil {
+		t.Error("Expected error for a zero sliding window")
+	}
+}
+
 func TestCacheGetItem(t *testing.T) {
 	// Create a cache
 	cache := New(DefaultOptions())
@@ -485,6 +802,80 @@ func TestCacheOldestEviction(t *testing.T) {
 	}
 }
 
+func TestCacheLFUEviction(t *testing.T) {
+	// Track evicted keys
+	evictedKeys := make([]string, 0)
+	evictionCallback := func(key string, value interface{}) {
+		evictedKeys = append(evictedKeys, key)
+	}
+
+	// Create a cache with max items and LFU policy
+	cache := New(Options{
+		DefaultExpiration: 1 * time.Hour,
+		MaxItems:          3,
+		EvictionPolicy:    EvictLFU,
+		OnEviction:        evictionCallback,
+		StatsEnabled:      true,
+	})
+
+	cache.Set("hot", "value1")
+	cache.Set("warm", "value2")
+	cache.Set("cold", "value3")
+
+	// Access hot often and warm once; cold is never read
+	for i := 0; i < 3; i++ {
+		cache.Get("hot")
+	}
+	cache.Get("warm")
+
+	// Add another item, should evict the least frequently used (cold)
+	if err := cache.Set("key4", "value4"); err != nil {
+		t.Fatalf("Set should succeed with LFU eviction, got %v", err)
+	}
+
+	if len(evictedKeys) != 1 || evictedKeys[0] != "cold" {
+		t.Errorf("Expected cold to be evicted, got %v", evictedKeys)
+	}
+
+	if !cache.Has("hot") || !cache.Has("warm") || !cache.Has("key4") {
+		t.Error("hot, warm, and key4 should be in cache")
+	}
+
+	if stats := cache.GetStats(); stats.Evictions != 1 {
+		t.Errorf("Expected 1 eviction in stats, got %d", stats.Evictions)
+	}
+}
+
+func TestCacheLFUEvictionTieBreak(t *testing.T) {
+	evictedKeys := make([]string, 0)
+	cache := New(Options{
+		DefaultExpiration: 1 * time.Hour,
+		MaxItems:          2,
+		EvictionPolicy:    EvictLFU,
+		OnEviction: func(key string, value interface{}) {
+			evictedKeys = append(evictedKeys, key)
+		},
+	})
+
+	// Both keys are read once, key2 more recently
+	cache.Set("key1", "value1")
+	cache.Set("key2", "value2")
+	cache.Get("key1")
+	time.Sleep(10 * time.Millisecond)
+	cache.Get("key2")
+
+	if item, _ := cache.GetItem("key2"); item.AccessCount != 1 {
+		t.Errorf("Expected key2 to have 1 access, got %d", item.AccessCount)
+	}
+
+	// Equal counts fall back to least recently used
+	cache.Set("key3", "value3")
+
+	if len(evictedKeys) != 1 || evictedKeys[0] != "key1" {
+		t.Errorf("Expected key1 to be evicted, got %v", evictedKeys)
+	}
+}
+
 func TestCacheItemExpired(t *testing.T) {
 	// Create an item with expiration
 	now := time.Now().UnixNano()
@@ -599,4 +990,149 @@ func BenchmarkCacheConcurrent(b *testing.B) {
 			cache.Get(key)
 		}
 	})
-}
\ No newline at end of file
+}
+
+// batchKeys is the number of keys each operation touches in the batch benchmarks
+const batchKeys = 10
+
+// benchmarkConcurrency runs op across roughly 1000 goroutines
+func benchmarkConcurrency(b *testing.B, op func(id int)) {
+	parallelism := 1000 / runtime.GOMAXPROCS(0)
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	b.SetParallelism(parallelism)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		id := 0
+		for pb.Next() {
+			id++
+			op(id)
+		}
+	})
+}
+
+// Benchmark single Gets and Sets of a batch of keys from 1000 goroutines
+func BenchmarkCacheSingleOps(b *testing.B) {
+	cache := New(DefaultOptions())
+	keys := make([]string, batchKeys)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+	}
+
+	benchmarkConcurrency(b, func(id int) {
+		for _, key := range keys {
+			cache.Set(key, id)
+		}
+		for _, key := range keys {
+			cache.Get(key)
+		}
+	})
+}
+
+// Benchmark GetMany and SetMany of the same batch from 1000 goroutines
+func BenchmarkCacheBatchOps(b *testing.B) {
+	cache := New(DefaultOptions())
+	keys := make([]string, batchKeys)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+	}
+
+	benchmarkConcurrency(b, func(id int) {
+		items := make(map[string]interface{}, len(keys))
+		for _, key := range keys {
+			items[key] = id
+		}
+		cache.SetMany(items, cache.defaultExpiration)
+		cache.GetMany(keys)
+	})
+}
+func TestCacheRecommendations(t *testing.T) {
+	tests := []struct {
+		name     string
+		stats    Stats
+		size     int
+		maxItems int
+		want     []string
+	}{
+		{
+			name:     "low hit ratio and high evictions",
+			stats:    Stats{Hits: 30, Misses: 170, Evictions: 60},
+			size:     1000,
+			maxItems: 1000,
+			want: []string{
+				"hit ratio is 15%, below 50%, consider raising the TTL",
+				"high eviction rate of 30% of lookups, raise MaxItems above 1000",
+			},
+		},
+		{
+			name:     "nearly full",
+			stats:    Stats{Hits: 180, Misses: 20},
+			size:     950,
+			maxItems: 1000,
+			want:     []string{"cache is 950 of 1000 items full, consider raising MaxItems before evictions start"},
+		},
+		{
+			name:     "healthy",
+			stats:    Stats{Hits: 180, Misses: 20, Evictions: 5},
+			size:     100,
+			maxItems: 1000,
+			want:     []string{"no changes recommended"},
+		},
+		{
+			name:  "too little traffic",
+			stats: Stats{Hits: 1, Misses: 9},
+			want:  []string{"only 10 lookups recorded, not enough traffic to recommend changes"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := recommend(tt.stats, tt.size, tt.maxItems)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Expected recommendations %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	// Live stats are used once enabled
+	cache := New(Options{DefaultExpiration: time.Hour})
+	if got := cache.Recommendations(); len(got) != 1 || got[0] != "statistics are disabled, enable them to get recommendations" {
+		t.Errorf("Expected a recommendation to enable stats, got %q", got)
+	}
+
+	cache.EnableStats()
+	for i := 0; i < 100; i++ {
+		cache.Get(fmt.Sprintf("missing%d", i))
+	}
+	if got := cache.Recommendations(); len(got) != 1 || got[0] != "hit ratio is 0%, below 50%, consider raising the TTL" {
+		t.Errorf("Expected a TTL recommendation, got %q", got)
+	}
+}
+
+func TestCacheExpiringWithin(t *testing.T) {
+	cache := New(Options{DefaultExpiration: time.Hour})
+
+	cache.SetWithExpiration("in-30s", "a", 30*time.Second)
+	cache.SetWithExpiration("in-10s", "b", 10*time.Second)
+	cache.SetWithExpiration("in-5m", "c", 5*time.Minute)
+	cache.SetWithExpiration("forever", "d", 0)
+	cache.SetWithExpiration("expired", "e", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	keys := cache.ExpiringWithin(time.Minute)
+	want := []string{"in-10s", "in-30s"}
+	if len(keys) != len(want) {
+		t.Fatalf("Expected keys %v, got %v", want, keys)
+	}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Errorf("Expected keys %v soonest first, got %v", want, keys)
+		}
+	}
+
+	if keys := cache.ExpiringWithin(time.Second); len(keys) != 0 {
+		t.Errorf("Expected no keys expiring within a second, got %v", keys)
+	}
+}
@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// eventKind identifies which hook an event is delivered to
+type eventKind int
+
+const (
+	eventSet eventKind = iota
+	eventEviction
+	eventExpiry
+	eventDelete
+)
+
+// event is a hook call raised while the cache lock is held
+type event struct {
+	kind  eventKind
+	key   string
+	value interface{}
+	ttl   time.Duration
+}
+
+// notify queues e for delivery once the lock is released, skipping events
+// nobody listens for; the caller holds c.mu
+func (c *Cache) notify(e event) {
+	switch {
+	case e.kind == eventSet && c.onSet == nil,
+		e.kind == eventEviction && c.onEviction == nil,
+		e.kind == eventExpiry && c.onExpiry == nil,
+		e.kind == eventDelete && c.onDelete == nil:
+		return
+	}
+	c.pending = append(c.pending, e)
+}
+
+// unlock releases c.mu and then runs the hooks queued while it was held, so
+// a hook may call back into the cache without deadlocking
+func (c *Cache) unlock() {
+	events := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+
+	for _, e := range events {
+		switch e.kind {
+		case eventSet:
+			c.onSet(e.key, e.ttl)
+		case eventEviction:
+			c.onEviction(e.key, e.value)
+		case eventExpiry:
+			c.onExpiry(e.key, e.value)
+		case eventDelete:
+			c.onDelete(e.key)
+		}
+	}
+}
+
+// PrometheusHooks registers a dashboard_cache_events_total counter with reg
+// and returns Options holding hooks that count sets, evictions, expiries and
+// deletes under its event label. Copy the hooks into the options passed to New.
+func PrometheusHooks(reg prometheus.Registerer) Options {
+	events := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dashboard_cache_events_total",
+		Help: "Number of cache item events by type",
+	}, []string{"event"})
+	reg.MustRegister(events)
+
+	return Options{
+		OnSet: func(string, time.Duration) {
+			events.WithLabelValues("set").Inc()
+		},
+		OnEviction: func(string, interface{}) {
+			events.WithLabelValues("eviction").Inc()
+		},
+		OnExpiry: func(string, interface{}) {
+			events.WithLabelValues("expiry").Inc()
+		},
+		OnDelete: func(string) {
+			events.WithLabelValues("delete").Inc()
+		},
+	}
+}
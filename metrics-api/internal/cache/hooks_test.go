@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCacheHooks(t *testing.T) {
+	var sets, evictions, expiries, deletes []string
+	ttls := make(map[string]time.Duration)
+
+	var cache *Cache
+	cache = New(Options{
+		DefaultExpiration: time.Hour,
+		MaxItems:          2,
+		EvictionPolicy:    EvictOldest,
+		OnSet: func(key string, ttl time.Duration) {
+			sets = append(sets, key)
+			ttls[key] = ttl
+		},
+		OnEviction: func(key string, value interface{}) {
+			evictions = append(evictions, key)
+		},
+		OnExpiry: func(key string, value interface{}) {
+			expiries = append(expiries, key)
+		},
+		OnDelete: func(key string) {
+			// Hooks run outside the lock, so calling back in must not deadlock
+			if cache.Has(key) {
+				t.Errorf("%s should be gone before OnDelete runs", key)
+			}
+			deletes = append(deletes, key)
+		},
+	})
+
+	cache.SetWithExpiration("short", "value", time.Millisecond)
+	time.Sleep(time.Millisecond)
+	cache.SetWithExpiration("forever", "value", 0)
+
+	if len(sets) != 2 || sets[0] != "short" || sets[1] != "forever" {
+		t.Errorf("Expected OnSet for short and forever, got %v", sets)
+	}
+	if ttls["short"] <= 0 || ttls["short"] > time.Millisecond || ttls["forever"] != 0 {
+		t.Errorf("Unexpected TTLs passed to OnSet: %v", ttls)
+	}
+
+	// Capacity evictions fire OnEviction only
+	cache.Set("third", "value")
+	if len(evictions) != 1 || evictions[0] != "short" {
+		t.Errorf("Expected short to be evicted, got %v", evictions)
+	}
+
+	// Expired items removed by cleanup fire OnExpiry only
+	cache.SetWithExpiration("forever", "value", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	cache.deleteExpired()
+	if len(expiries) != 1 || expiries[0] != "forever" {
+		t.Errorf("Expected forever to expire, got %v", expiries)
+	}
+
+	// Delete and Flush fire OnDelete
+	cache.Set("fourth", "value")
+	cache.Delete("third")
+	cache.Delete("missing")
+	cache.Flush()
+	if len(deletes) != 2 || deletes[0] != "third" || deletes[1] != "fourth" {
+		t.Errorf("Expected deletes of third and fourth, got %v", deletes)
+	}
+
+	if len(evictions) != 1 || len(expiries) != 1 {
+		t.Errorf("Deletes should not fire other hooks: evictions %v, expiries %v", evictions, expiries)
+	}
+}
+
+func TestPrometheusHooks(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	hooks := PrometheusHooks(reg)
+
+	cache := New(Options{
+		DefaultExpiration: time.Hour,
+		MaxItems:          1,
+		EvictionPolicy:    EvictLRU,
+		OnSet:             hooks.OnSet,
+		OnEviction:        hooks.OnEviction,
+		OnExpiry:          hooks.OnExpiry,
+		OnDelete:          hooks.OnDelete,
+	})
+
+	cache.Set("first", "value")
+	cache.SetWithExpiration("second", "value", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	cache.deleteExpired()
+	cache.Set("third", "value")
+	cache.Delete("third")
+
+	expected := `
+# HELP dashboard_cache_events_total Number of cache item events by type
+# TYPE dashboard_cache_events_total counter
+dashboard_cache_events_total{event="delete"} 1
+dashboard_cache_events_total{event="eviction"} 1
+dashboard_cache_events_total{event="expiry"} 1
+dashboard_cache_events_total{event="set"} 3
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "dashboard_cache_events_total"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,107 @@
+package cache
+
+import (
+	"strings"
+	"time"
+)
+
+// namespaceSeparator joins a namespace prefix to the keys stored under it
+const namespaceSeparator = ":"
+
+// NamespacedCache is a view of a Cache that stores every key under a prefix,
+// letting several services share one cache without key collisions
+type NamespacedCache struct {
+	cache  *Cache
+	prefix string
+}
+
+// Namespace returns a view that prepends prefix + ":" to every key
+func (c *Cache) Namespace(prefix string) *NamespacedCache {
+	return &NamespacedCache{cache: c, prefix: prefix + namespaceSeparator}
+}
+
+// FlushNamespace removes only the items stored under prefix
+func (c *Cache) FlushNamespace(prefix string) {
+	prefix += namespaceSeparator
+
+	c.mu.Lock()
+	defer c.unlock()
+
+	for k := range c.items {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		c.notify(event{kind: eventDelete, key: k})
+		c.remove(k)
+	}
+}
+
+// Get retrieves an item from the namespace
+func (n *NamespacedCache) Get(key string) (interface{}, bool) {
+	return n.cache.Get(n.prefix + key)
+}
+
+// Set adds an item to the namespace with the default expiration
+func (n *NamespacedCache) Set(key string, value interface{}) error {
+	return n.cache.Set(n.prefix+key, value)
+}
+
+// SetWithExpiration adds an item to the namespace with a specific expiration
+func (n *NamespacedCache) SetWithExpiration(key string, value interface{}, duration time.Duration) error {
+	return n.cache.SetWithExpiration(n.prefix+key, value, duration)
+}
+
+// GetOrSet returns the cached value for key in the namespace, or calls fn
+// and caches its result for ttl, as Cache.GetOrSet does
+func (n *NamespacedCache) GetOrSet(key string, ttl time.Duration, fn func() (interface{}, error)) (interface{}, error) {
+	return n.cache.GetOrSet(n.prefix+key, ttl, fn)
+}
+
+// Delete removes an item from the namespace
+func (n *NamespacedCache) Delete(key string) {
+	n.cache.Delete(n.prefix + key)
+}
+
+// Has checks if a key exists in the namespace and is not expired
+func (n *NamespacedCache) Has(key string) bool {
+	return n.cache.Has(n.prefix + key)
+}
+
+// TTL returns the time to live for an item in the namespace
+// The second return value indicates whether the key was found
+func (n *NamespacedCache) TTL(key string) (time.Duration, bool) {
+	return n.cache.TTL(n.prefix + key)
+}
+
+// GetAllKeys returns the keys in the namespace, without the prefix
+func (n *NamespacedCache) GetAllKeys() []string {
+	n.cache.mu.RLock()
+	defer n.cache.mu.RUnlock()
+
+	keys := make([]string, 0)
+	for k := range n.cache.items {
+		if key, ok := strings.CutPrefix(k, n.prefix); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Count returns the number of items in the namespace
+func (n *NamespacedCache) Count() int {
+	n.cache.mu.RLock()
+	defer n.cache.mu.RUnlock()
+
+	count := 0
+	for k := range n.cache.items {
+		if strings.HasPrefix(k, n.prefix) {
+			count++
+		}
+	}
+	return count
+}
+
+// Flush removes every item in the namespace, leaving the rest of the cache alone
+func (n *NamespacedCache) Flush() {
+	n.cache.FlushNamespace(strings.TrimSuffix(n.prefix, namespaceSeparator))
+}
@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestNamespacedCache(t *testing.T) {
+	shared := New(Options{DefaultExpiration: time.Hour})
+	metrics := shared.Namespace("metrics")
+	queries := shared.Namespace("queries")
+
+	metrics.Set("up", "metrics value")
+	queries.Set("up", "queries value")
+	metrics.SetWithExpiration("cpu", "cpu value", time.Minute)
+	shared.Set("plain", "plain value")
+
+	// The same key in two namespaces does not collide
+	if value, found := metrics.Get("up"); !found || value != "metrics value" {
+		t.Errorf("Expected metrics value, got %v", value)
+	}
+	if value, found := queries.Get("up"); !found || value != "queries value" {
+		t.Errorf("Expected queries value, got %v", value)
+	}
+	if !shared.Has("metrics:up") || !shared.Has("queries:up") {
+		t.Error("Expected prefixed keys in the shared cache")
+	}
+
+	if !metrics.Has("cpu") || queries.Has("cpu") {
+		t.Error("cpu should exist only in the metrics namespace")
+	}
+	if ttl, found := metrics.TTL("cpu"); !found || ttl > time.Minute {
+		t.Errorf("Expected cpu TTL within a minute, got %v (found %v)", ttl, found)
+	}
+
+	keys := metrics.GetAllKeys()
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != "cpu" || keys[1] != "up" {
+		t.Errorf("Expected [cpu up], got %v", keys)
+	}
+	if metrics.Count() != 2 || queries.Count() != 1 || shared.Count() != 4 {
+		t.Errorf("Unexpected counts: metrics %d, queries %d, shared %d", metrics.Count(), queries.Count(), shared.Count())
+	}
+
+	metrics.Delete("up")
+	if metrics.Has("up") || !queries.Has("up") {
+		t.Error("Delete should only remove the key from its own namespace")
+	}
+
+	// Flushing a namespace leaves other namespaces and plain keys alone
+	shared.FlushNamespace("metrics")
+	if metrics.Count() != 0 {
+		t.Errorf("Expected metrics namespace to be empty, got %d", metrics.Count())
+	}
+	if !queries.Has("up") || !shared.Has("plain") {
+		t.Error("FlushNamespace removed keys outside the namespace")
+	}
+
+	queries.Flush()
+	if queries.Count() != 0 || shared.Count() != 1 {
+		t.Errorf("Expected only plain to remain, got %v", shared.GetAllKeys())
+	}
+}
@@ -0,0 +1,135 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// persistedItem is an item as written by SaveToFile. The value is encoded
+// on its own so one value that cannot be encoded or decoded only loses that
+// item, not the whole file.
+type persistedItem struct {
+	Key           string
+	Value         []byte
+	Expiration    int64
+	Created       int64
+	LastAccess    int64
+	AccessCount   int64
+	SlidingWindow int64
+}
+
+// persistedValue carries a value through gob as an interface, so its
+// concrete type must be registered with gob.Register
+type persistedValue struct {
+	Value interface{}
+}
+
+// SaveToFile writes the unexpired items to path using gob, replacing the
+// file atomically. Items whose values gob cannot encode, such as types not
+// registered with gob.Register, are skipped.
+func (c *Cache) SaveToFile(path string) error {
+	c.mu.RLock()
+	items := make([]persistedItem, 0, len(c.items))
+	for key, item := range c.items {
+		if item.Expired() {
+			continue
+		}
+		var value bytes.Buffer
+		if err := gob.NewEncoder(&value).Encode(persistedValue{Value: item.Value}); err != nil {
+			continue
+		}
+		items = append(items, persistedItem{
+			Key:           key,
+			Value:         value.Bytes(),
+			Expiration:    item.Expiration,
+			Created:       item.Created,
+			LastAccess:    item.LastAccess,
+			AccessCount:   item.AccessCount,
+			SlidingWindow: item.SlidingWindow,
+		})
+	}
+	c.mu.RUnlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error creating cache file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := gob.NewEncoder(tmp).Encode(items); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing cache file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing cache file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("error replacing cache file: %w", err)
+	}
+	return nil
+}
+
+// LoadFromFile adds the items saved by SaveToFile at path, keeping their
+// expiration and access times and replacing items with the same keys.
+// Expired items and values that cannot be decoded are dropped. When the
+// items do not all fit, the most recently accessed are kept. Loaded items
+// do not trigger the OnSet hook.
+func (c *Cache) LoadFromFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var items []persistedItem
+	if err := gob.NewDecoder(file).Decode(&items); err != nil {
+		return fmt.Errorf("error reading cache file %s: %w", path, err)
+	}
+
+	// Load the least recently accessed first so they are the ones evicted
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].LastAccess < items[j].LastAccess
+	})
+
+	c.mu.Lock()
+	defer c.unlock()
+
+	now := time.Now().UnixNano()
+	for _, persisted := range items {
+		if persisted.Expiration != 0 && now > persisted.Expiration {
+			continue
+		}
+		var value persistedValue
+		if err := gob.NewDecoder(bytes.NewReader(persisted.Value)).Decode(&value); err != nil {
+			continue
+		}
+
+		size := c.sizer(value.Value)
+		if c.maxBytes > 0 && size > c.maxBytes {
+			continue
+		}
+		if _, found := c.items[persisted.Key]; found {
+			c.remove(persisted.Key)
+		}
+		if err := c.makeRoom(size); err != nil {
+			return err
+		}
+
+		c.items[persisted.Key] = Item{
+			Value:         value.Value,
+			Expiration:    persisted.Expiration,
+			Created:       persisted.Created,
+			LastAccess:    persisted.LastAccess,
+			AccessCount:   persisted.AccessCount,
+			Size:          size,
+			SlidingWindow: persisted.SlidingWindow,
+		}
+		c.sizeBytes += size
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// persistedSample is a registered struct value for the round-trip tests
+type persistedSample struct {
+	Name   string
+	Values []float64
+}
+
+// unregisteredSample is never registered with gob, so it cannot be saved
+type unregisteredSample struct {
+	Name string
+}
+
+func init() {
+	gob.Register(persistedSample{})
+}
+
+func TestCacheSaveAndLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.gob")
+
+	saved := New(Options{})
+	saved.SetWithExpiration("string", "value", time.Hour)
+	saved.SetWithExpiration("struct", persistedSample{Name: "up", Values: []float64{1, 0}}, 30*time.Minute)
+	saved.SetWithExpiration("forever", 42, 0)
+	saved.SetWithSlidingExpiration("sliding", "slides", time.Hour)
+	saved.SetWithExpiration("expired", "gone", time.Millisecond)
+	saved.SetWithExpiration("unregistered", unregisteredSample{Name: "skip"}, time.Hour)
+	time.Sleep(5 * time.Millisecond)
+
+	if err := saved.SaveToFile(path); err != nil {
+		t.Fatalf("SaveToFile failed: %v", err)
+	}
+
+	loaded := New(Options{})
+	if err := loaded.LoadFromFile(path); err != nil {
+		t.Fatalf("LoadFromFile failed: %v", err)
+	}
+
+	if got := loaded.Count(); got != 4 {
+		t.Errorf("Expected 4 items to survive, got %d: %v", got, loaded.GetAllKeys())
+	}
+	if value, found := loaded.Get("string"); !found || value != "value" {
+		t.Errorf("Expected string value, got %v (found %v)", value, found)
+	}
+	if value, found := loaded.Get("struct"); !found || value.(persistedSample).Name != "up" || len(value.(persistedSample).Values) != 2 {
+		t.Errorf("Expected struct value, got %v (found %v)", value, found)
+	}
+	if value, found := loaded.Get("forever"); !found || value != 42 {
+		t.Errorf("Expected int value, got %v (found %v)", value, found)
+	}
+	if loaded.Has("expired") || loaded.Has("unregistered") {
+		t.Error("Expired and unencodable items should not be saved")
+	}
+
+	// Expirations carry over instead of restarting
+	for key, want := range map[string]time.Duration{"string": time.Hour, "struct": 30 * time.Minute} {
+		original, _ := saved.GetItem(key)
+		item, found := loaded.GetItem(key)
+		if !found || item.Expiration != original.Expiration || item.Created != original.Created {
+			t.Errorf("Expected %s to keep its expiration and creation time", key)
+		}
+		if ttl, _ := loaded.TTL(key); ttl <= 0 || ttl > want {
+			t.Errorf("Expected %s TTL within %v, got %v", key, want, ttl)
+		}
+	}
+	if ttl, found := loaded.TTL("forever"); !found || ttl != 0 {
+		t.Errorf("Expected forever to never expire, got %v", ttl)
+	}
+	if item, _ := loaded.GetItem("sliding"); item.SlidingWindow != int64(time.Hour) {
+		t.Errorf("Expected sliding window to survive, got %v", time.Duration(item.SlidingWindow))
+	}
+}
+
+func TestCacheLoadFileMaxItems(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.gob")
+
+	saved := New(Options{})
+	for _, key := range []string{"a", "b", "c", "d"} {
+		saved.Set(key, key)
+	}
+	// Reading b and d makes them the most recently used
+	time.Sleep(time.Millisecond)
+	saved.Get("b")
+	saved.Get("d")
+	if err := saved.SaveToFile(path); err != nil {
+		t.Fatalf("SaveToFile failed: %v", err)
+	}
+
+	loaded := New(Options{MaxItems: 2, EvictionPolicy: EvictLRU})
+	if err := loaded.LoadFromFile(path); err != nil {
+		t.Fatalf("LoadFromFile failed: %v", err)
+	}
+	if loaded.Count() != 2 || !loaded.Has("b") || !loaded.Has("d") {
+		t.Errorf("Expected the most recently used b and d to be kept, got %v", loaded.GetAllKeys())
+	}
+}
+
+func TestCacheLoadFileMissing(t *testing.T) {
+	err := New(Options{}).LoadFromFile(filepath.Join(t.TempDir(), "missing.gob"))
+	if !os.IsNotExist(err) {
+		t.Errorf("Expected a not-exist error, got %v", err)
+	}
+}
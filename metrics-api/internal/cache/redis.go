@@ -0,0 +1,245 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultRedisPrefix namespaces the keys a RedisCache writes so several
+// services can share one Redis instance
+const DefaultRedisPrefix = "metrics-api:cache:"
+
+// redisScanCount is how many keys each SCAN call asks Redis for
+const redisScanCount = 100
+
+// getAndCount reads KEYS[1] and increments the hits counter KEYS[2] or the
+// misses counter KEYS[3], so a Get that records stats is one round trip
+var getAndCount = redis.NewScript(`
+local value = redis.call("GET", KEYS[1])
+if value then
+	redis.call("INCR", KEYS[2])
+else
+	redis.call("INCR", KEYS[3])
+end
+return value
+`)
+
+// RedisCache is a Store backed by Redis, letting several API instances share
+// one cache. Values are encoded with gob like SaveToFile does, so Get
+// returns them with their original types; those types must be registered
+// with gob.Register, and Set rejects values that are not. Expiry and
+// eviction are left to Redis.
+type RedisCache struct {
+	client            *redis.Client
+	prefix            string
+	defaultExpiration time.Duration
+	statsEnabled      bool
+}
+
+// NewRedis connects to the Redis server at options.RedisAddr
+func NewRedis(options Options) (*RedisCache, error) {
+	if options.RedisAddr == "" {
+		return nil, fmt.Errorf("redis cache backend requires an address")
+	}
+
+	prefix := options.RedisPrefix
+	if prefix == "" {
+		prefix = DefaultRedisPrefix
+	}
+
+	c := &RedisCache{
+		client: redis.NewClient(&redis.Options{
+			Addr:     options.RedisAddr,
+			Password: options.RedisPassword,
+		}),
+		prefix:            prefix,
+		defaultExpiration: options.DefaultExpiration,
+		statsEnabled:      options.StatsEnabled,
+	}
+	if err := c.client.Ping(context.Background()).Err(); err != nil {
+		c.client.Close()
+		return nil, fmt.Errorf("failed to connect to redis at %s: %w", options.RedisAddr, err)
+	}
+	return c, nil
+}
+
+// itemKey returns the Redis key holding key's value
+func (c *RedisCache) itemKey(key string) string {
+	return c.prefix + "item:" + key
+}
+
+// statsKey returns the Redis key holding the named counter
+func (c *RedisCache) statsKey(name string) string {
+	return c.prefix + "stats:" + name
+}
+
+// Set adds an item to the cache with the default expiration
+func (c *RedisCache) Set(key string, value interface{}) error {
+	return c.SetWithExpiration(key, value, c.defaultExpiration)
+}
+
+// SetWithExpiration adds an item to the cache with a specific expiration
+func (c *RedisCache) SetWithExpiration(key string, value interface{}, duration time.Duration) error {
+	var encoded bytes.Buffer
+	if err := gob.NewEncoder(&encoded).Encode(persistedValue{Value: value}); err != nil {
+		return fmt.Errorf("failed to encode cache value for %s: %w", key, err)
+	}
+
+	if duration < 0 {
+		duration = 0
+	}
+	return c.client.Set(context.Background(), c.itemKey(key), encoded.Bytes(), duration).Err()
+}
+
+// Get retrieves an item from the cache. Redis failures are reported as misses.
+func (c *RedisCache) Get(key string) (interface{}, bool) {
+	ctx := context.Background()
+
+	var encoded []byte
+	var err error
+	if c.statsEnabled {
+		keys := []string{c.itemKey(key), c.statsKey("hits"), c.statsKey("misses")}
+		var reply interface{}
+		reply, err = getAndCount.Run(ctx, c.client, keys).Result()
+		if s, ok := reply.(string); ok {
+			encoded = []byte(s)
+		}
+	} else {
+		encoded, err = c.client.Get(ctx, c.itemKey(key)).Bytes()
+	}
+	if err != nil {
+		return nil, false
+	}
+
+	var value persistedValue
+	if err := gob.NewDecoder(bytes.NewReader(encoded)).Decode(&value); err != nil {
+		return nil, false
+	}
+	return value.Value, true
+}
+
+// Delete removes an item from the cache
+func (c *RedisCache) Delete(key string) {
+	c.client.Del(context.Background(), c.itemKey(key))
+}
+
+// Flush removes all items from the cache, leaving other keys in Redis alone
+func (c *RedisCache) Flush() {
+	keys, err := c.scan()
+	if err != nil || len(keys) == 0 {
+		return
+	}
+	c.client.Del(context.Background(), keys...)
+}
+
+// Count returns the number of items in the cache
+func (c *RedisCache) Count() int {
+	return len(c.GetAllKeys())
+}
+
+// Has checks if a key exists in the cache and is not expired
+func (c *RedisCache) Has(key string) bool {
+	n, err := c.client.Exists(context.Background(), c.itemKey(key)).Result()
+	return err == nil && n == 1
+}
+
+// GetAllKeys returns all keys in the cache
+func (c *RedisCache) GetAllKeys() []string {
+	keys, err := c.scan()
+	if err != nil {
+		return []string{}
+	}
+
+	trim := len(c.itemKey(""))
+	for i, key := range keys {
+		keys[i] = key[trim:]
+	}
+	return keys
+}
+
+// TTL returns the time to live for a cached item
+// The second return value indicates whether the key was found
+func (c *RedisCache) TTL(key string) (time.Duration, bool) {
+	ttl, err := c.client.PTTL(context.Background(), c.itemKey(key)).Result()
+	if err != nil {
+		return 0, false
+	}
+
+	// PTTL answers -2 for a missing key and -1 for one without expiration
+	switch {
+	case ttl == -2:
+		return 0, false
+	case ttl < 0:
+		return 0, true
+	}
+	return ttl, true
+}
+
+// GetStats returns the hit and miss counters shared by every instance using
+// this prefix. Evictions and cleanups happen inside Redis and are not counted.
+func (c *RedisCache) GetStats() Stats {
+	if !c.statsEnabled {
+		return Stats{}
+	}
+
+	values, err := c.client.MGet(context.Background(), c.statsKey("hits"), c.statsKey("misses")).Result()
+	if err != nil {
+		return Stats{}
+	}
+	return Stats{
+		Hits:   counterValue(values[0]),
+		Misses: counterValue(values[1]),
+	}
+}
+
+// Close closes the connections to Redis
+func (c *RedisCache) Close() error {
+	return c.client.Close()
+}
+
+// counterValue converts a counter read with MGET, treating a missing key as zero
+func counterValue(value interface{}) int64 {
+	s, ok := value.(string)
+	if !ok {
+		return 0
+	}
+	var n int64
+	fmt.Sscan(s, &n)
+	return n
+}
+
+// scan returns every item key, using SCAN so a large keyspace does not
+// block the server the way KEYS would
+func (c *RedisCache) scan() ([]string, error) {
+	// Only the trailing wildcard is a pattern; the prefix is literal
+	pattern := escapeGlob(c.itemKey("")) + "*"
+
+	keys := make([]string, 0)
+	iter := c.client.Scan(context.Background(), 0, pattern, redisScanCount).Iterator()
+	for iter.Next(context.Background()) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// escapeGlob escapes the characters Redis treats as pattern syntax
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,142 @@
+package cache
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestRedisCache(t *testing.T) {
+	mr := miniredis.RunT(t)
+
+	store, err := NewStore(Options{
+		DefaultExpiration: time.Hour,
+		StatsEnabled:      true,
+		Backend:           BackendRedis,
+		RedisAddr:         mr.Addr(),
+	})
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	rc, ok := store.(*RedisCache)
+	if !ok {
+		t.Fatalf("Expected *RedisCache, got %T", store)
+	}
+	defer rc.Close()
+
+	// Registered types round-trip with their type intact
+	sample := persistedSample{Name: "up", Values: []float64{1, 0}}
+	if err := rc.Set("key1", sample); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	value, found := rc.Get("key1")
+	if !found {
+		t.Fatal("key1 should be found")
+	}
+	if got, ok := value.(persistedSample); !ok || got.Name != "up" || len(got.Values) != 2 {
+		t.Errorf("Expected persistedSample, got %#v", value)
+	}
+
+	// Types gob cannot carry are rejected rather than stored
+	if err := rc.Set("unregistered", unregisteredSample{Name: "skip"}); err == nil {
+		t.Error("Expected error for an unregistered type")
+	}
+	if rc.Has("unregistered") {
+		t.Error("unregistered value should not be stored")
+	}
+
+	// Keys are namespaced under the prefix
+	if !mr.Exists(DefaultRedisPrefix + "item:key1") {
+		t.Errorf("Expected prefixed key in redis, got %v", mr.Keys())
+	}
+
+	if _, found := rc.Get("missing"); found {
+		t.Error("missing key should not be found")
+	}
+	if rc.Has("missing") || !rc.Has("key1") {
+		t.Error("Has should report only stored keys")
+	}
+
+	stats := rc.GetStats()
+	if stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("Expected 1 hit and 1 miss, got %+v", stats)
+	}
+
+	// TTL passes through to redis
+	if ttl, found := rc.TTL("key1"); !found || ttl <= 59*time.Minute || ttl > time.Hour {
+		t.Errorf("Expected TTL close to an hour, got %v (found %v)", ttl, found)
+	}
+	rc.SetWithExpiration("forever", 42, 0)
+	if ttl, found := rc.TTL("forever"); !found || ttl != 0 {
+		t.Errorf("Expected no expiration, got %v (found %v)", ttl, found)
+	}
+	if value, _ := rc.Get("forever"); value != 42 {
+		t.Errorf("Expected int 42, got %#v", value)
+	}
+	if _, found := rc.TTL("missing"); found {
+		t.Error("TTL of a missing key should not be found")
+	}
+
+	rc.SetWithExpiration("short", "v", time.Second)
+	mr.FastForward(2 * time.Second)
+	if rc.Has("short") {
+		t.Error("short should have expired")
+	}
+
+	keys := rc.GetAllKeys()
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != "forever" || keys[1] != "key1" {
+		t.Errorf("Expected [forever key1], got %v", keys)
+	}
+	if rc.Count() != 2 {
+		t.Errorf("Expected count 2, got %d", rc.Count())
+	}
+
+	rc.Delete("forever")
+	if rc.Has("forever") {
+		t.Error("forever should be deleted")
+	}
+
+	// Flush only removes items, leaving the stats counters and other keys
+	mr.Set("unrelated", "v")
+	rc.Flush()
+	if rc.Count() != 0 {
+		t.Errorf("Expected empty cache after flush, got %d", rc.Count())
+	}
+	if !mr.Exists("unrelated") {
+		t.Error("Flush should not remove keys outside the prefix")
+	}
+	if stats := rc.GetStats(); stats.Hits != 2 {
+		t.Errorf("Expected stats to survive flush, got %+v", stats)
+	}
+}
+
+func TestNewRedisUnreachable(t *testing.T) {
+	mr := miniredis.RunT(t)
+	addr := mr.Addr()
+	mr.Close()
+
+	if _, err := NewRedis(Options{RedisAddr: addr}); err == nil {
+		t.Error("Expected error when redis is unreachable")
+	}
+}
+
+func TestNewStore(t *testing.T) {
+	store, err := NewStore(Options{DefaultExpiration: time.Minute})
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	if _, ok := store.(*Cache); !ok {
+		t.Errorf("Expected *Cache for the default backend, got %T", store)
+	}
+
+	if _, err := NewStore(Options{Backend: BackendRedis}); err == nil {
+		t.Error("Expected error for redis backend without an address")
+	}
+
+	if _, err := NewStore(Options{Backend: "memcached"}); err == nil {
+		t.Error("Expected error for unknown backend")
+	}
+}
@@ -0,0 +1,86 @@
+// Package cachekey builds the cache keys shared by the query and metrics
+// layers. Every key starts with its kind, optionally scoped to the tenant and
+// Prometheus source carried by the context, so related entries can be found
+// by prefix:
+//
+//	[tenant:"<id>":][source:"<name>":]<kind>:<fixed fields>:"<free text>"
+//
+// Free-text components such as queries are quoted, so a query containing
+// the separator can never produce the key of a different input.
+package cachekey
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"metrics-api/internal/source"
+	"metrics-api/internal/tenant"
+)
+
+// Kinds of cached entries, used as the first key component after the tenant
+const (
+	KindInstant  = "instant"
+	KindRange    = "range"
+	KindSummary  = "summary"
+	KindLabels   = "labels"
+	KindMetadata = "metadata"
+	KindRules    = "rules"
+	KindTargets  = "targets"
+	KindNames    = "names"
+)
+
+// Prefix returns the prefix shared by every key of kind for the tenant and
+// source in ctx
+func Prefix(ctx context.Context, kind string) string {
+	prefix := kind + ":"
+	if name := source.FromContext(ctx); name != "" {
+		prefix = "source:" + strconv.Quote(name) + ":" + prefix
+	}
+	if id := tenant.FromContext(ctx); id != "" {
+		prefix = "tenant:" + strconv.Quote(id) + ":" + prefix
+	}
+	return prefix
+}
+
+// InstantKey identifies the result of an instant query evaluated at ts
+func InstantKey(ctx context.Context, query string, ts time.Time) string {
+	return fmt.Sprintf("%s%d:%q", Prefix(ctx, KindInstant), ts.Unix(), query)
+}
+
+// RangeKey identifies the result of a range query
+func RangeKey(ctx context.Context, query string, start, end time.Time, step time.Duration) string {
+	return fmt.Sprintf("%s%d:%d:%s:%q", Prefix(ctx, KindRange), start.Unix(), end.Unix(), step, query)
+}
+
+// SummaryKey identifies the summary of a metric
+func SummaryKey(ctx context.Context, metric string) string {
+	return fmt.Sprintf("%s%q", Prefix(ctx, KindSummary), metric)
+}
+
+// LabelsKey identifies the label names of a metric
+func LabelsKey(ctx context.Context, metric string) string {
+	return fmt.Sprintf("%s%q", Prefix(ctx, KindLabels), metric)
+}
+
+// MetadataKey identifies the metadata of a metric, or of every metric when
+// metric is empty
+func MetadataKey(ctx context.Context, metric string) string {
+	return fmt.Sprintf("%s%q", Prefix(ctx, KindMetadata), metric)
+}
+
+// RulesKey identifies the rule groups loaded by Prometheus
+func RulesKey(ctx context.Context) string {
+	return Prefix(ctx, KindRules)
+}
+
+// TargetsKey identifies the scrape targets known to Prometheus
+func TargetsKey(ctx context.Context) string {
+	return Prefix(ctx, KindTargets)
+}
+
+// NamesKey identifies the list of metric names
+func NamesKey(ctx context.Context) string {
+	return Prefix(ctx, KindNames)
+}
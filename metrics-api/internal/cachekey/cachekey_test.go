@@ -0,0 +1,69 @@
+package cachekey
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"metrics-api/internal/source"
+	"metrics-api/internal/tenant"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyFormats(t *testing.T) {
+	ctx := context.Background()
+	acme := tenant.NewContext(ctx, "acme")
+	start := time.Unix(1609743600, 0)
+	end := time.Unix(1609747200, 0)
+
+	assert.Equal(t, `instant:1609743600:"up"`, InstantKey(ctx, "up", start))
+	assert.Equal(t, `range:1609743600:1609747200:1m0s:"rate(x[5m])"`, RangeKey(ctx, "rate(x[5m])", start, end, time.Minute))
+	assert.Equal(t, `summary:"node_load1"`, SummaryKey(ctx, "node_load1"))
+	assert.Equal(t, `labels:"node_load1"`, LabelsKey(ctx, "node_load1"))
+	assert.Equal(t, `metadata:"node_load1"`, MetadataKey(ctx, "node_load1"))
+	assert.Equal(t, `metadata:""`, MetadataKey(ctx, ""))
+	assert.Equal(t, `rules:`, RulesKey(ctx))
+	assert.Equal(t, `targets:`, TargetsKey(ctx))
+	assert.Equal(t, `tenant:"acme":instant:1609743600:"up"`, InstantKey(acme, "up", start))
+	assert.Equal(t, `tenant:"acme":summary:`, Prefix(acme, KindSummary))
+	assert.Equal(t, `tenant:"acme":source:"eu":summary:`, Prefix(source.NewContext(acme, "eu"), KindSummary))
+}
+
+func TestKeysDoNotCollide(t *testing.T) {
+	ctx := context.Background()
+	ts := time.Unix(1609743600, 0)
+
+	keys := []string{
+		InstantKey(ctx, "up", ts),
+		InstantKey(ctx, "up", ts.Add(time.Second)),
+		InstantKey(ctx, "up:1", ts),
+		InstantKey(ctx, `up"`, ts),
+		InstantKey(tenant.NewContext(ctx, "a"), "up", ts),
+		InstantKey(tenant.NewContext(ctx, "b"), "up", ts),
+		InstantKey(tenant.NewContext(ctx, `a":instant`), "up", ts),
+		InstantKey(source.NewContext(ctx, "a"), "up", ts),
+		RangeKey(ctx, "up", ts, ts.Add(time.Hour), time.Minute),
+		RangeKey(ctx, "up", ts, ts.Add(time.Hour), 500*time.Millisecond),
+		RangeKey(ctx, "up", ts, ts.Add(time.Hour), time.Second),
+		RangeKey(ctx, "1:up", ts, ts.Add(time.Hour), time.Minute),
+		SummaryKey(ctx, "up"),
+		LabelsKey(ctx, "up"),
+		MetadataKey(ctx, "up"),
+		MetadataKey(ctx, ""),
+		RulesKey(ctx),
+		TargetsKey(ctx),
+	}
+
+	seen := make(map[string]int, len(keys))
+	for i, key := range keys {
+		if j, ok := seen[key]; ok {
+			t.Errorf("keys %d and %d collide: %s", j, i, key)
+		}
+		seen[key] = i
+	}
+
+	// Every key starts with its kind's prefix so it can be invalidated by prefix
+	assert.Regexp(t, "^"+Prefix(ctx, KindInstant), keys[0])
+	assert.Regexp(t, "^"+Prefix(ctx, KindRange), keys[8])
+}
@@ -0,0 +1,111 @@
+package collector
+
+import (
+	"context"
+	"time"
+
+	"metrics-api/internal/models"
+	"metrics-api/pkg/logger"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultPollInterval is how often the alert summary is refreshed
+const DefaultPollInterval = 30 * time.Second
+
+// maxBackoffFactor caps the retry delay at this multiple of the poll interval
+const maxBackoffFactor = 10
+
+// AlertSummaryProvider produces the alert summary exported by the collector
+type AlertSummaryProvider interface {
+	GetAlertSummary(ctx context.Context) (*models.AlertSummary, error)
+}
+
+// AlertsCollector periodically polls the alert summary and exposes it as gauges
+type AlertsCollector struct {
+	provider   AlertSummaryProvider
+	logger     logger.Logger
+	interval   time.Duration
+	firing     prometheus.Gauge
+	pending    prometheus.Gauge
+	bySeverity *prometheus.GaugeVec
+}
+
+// NewAlertsCollector creates a collector and registers its gauges with registerer
+func NewAlertsCollector(provider AlertSummaryProvider, logger logger.Logger, registerer prometheus.Registerer) *AlertsCollector {
+	c := &AlertsCollector{
+		provider: provider,
+		logger:   logger,
+		interval: DefaultPollInterval,
+		firing: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "dashboard_alerts_firing",
+			Help: "Number of alerts currently firing",
+		}),
+		pending: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "dashboard_alerts_pending",
+			Help: "Number of alerts currently pending",
+		}),
+		bySeverity: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "dashboard_alerts_by_severity",
+			Help: "Number of alerts grouped by severity",
+		}, []string{"severity"}),
+	}
+
+	registerer.MustRegister(c.firing, c.pending, c.bySeverity)
+	return c
+}
+
+// WithInterval sets the poll interval
+func (c *AlertsCollector) WithInterval(interval time.Duration) *AlertsCollector {
+	c.interval = interval
+	return c
+}
+
+// Poll fetches the alert summary once and updates the gauges
+func (c *AlertsCollector) Poll(ctx context.Context) error {
+	summary, err := c.provider.GetAlertSummary(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.firing.Set(float64(summary.FiringCount))
+	c.pending.Set(float64(summary.PendingCount))
+
+	// Drop severities that no longer have alerts
+	c.bySeverity.Reset()
+	for _, sc := range summary.SeverityBreakdown {
+		c.bySeverity.WithLabelValues(sc.Severity).Set(float64(sc.Count))
+	}
+
+	return nil
+}
+
+// Run polls until ctx is cancelled, backing off exponentially while the
+// provider keeps failing
+func (c *AlertsCollector) Run(ctx context.Context) error {
+	delay := c.interval
+	maxDelay := c.interval * maxBackoffFactor
+
+	for {
+		if err := c.Poll(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			delay *= 2
+			if delay > maxDelay {
+				delay = maxDelay
+			}
+			c.logger.Warnf("Failed to poll alert summary, retrying in %s: %v", delay, err)
+		} else {
+			delay = c.interval
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"metrics-api/internal/models"
+	"metrics-api/pkg/logger"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockSummaryProvider returns a fixed summary and counts calls
+type mockSummaryProvider struct {
+	summary *models.AlertSummary
+	err     error
+	calls   int64
+}
+
+func (m *mockSummaryProvider) GetAlertSummary(ctx context.Context) (*models.AlertSummary, error) {
+	atomic.AddInt64(&m.calls, 1)
+	return m.summary, m.err
+}
+
+func (m *mockSummaryProvider) Calls() int64 {
+	return atomic.LoadInt64(&m.calls)
+}
+
+// Test that the gauges reflect the summary after a poll cycle
+func TestAlertsCollectorRun(t *testing.T) {
+	provider := &mockSummaryProvider{
+		summary: &models.AlertSummary{
+			FiringCount:  3,
+			PendingCount: 2,
+			SeverityBreakdown: []models.SeverityCount{
+				{Severity: "critical", Count: 1},
+				{Severity: "warning", Count: 4},
+			},
+		},
+	}
+	c := NewAlertsCollector(provider, logger.NewTestLogger(), prometheus.NewRegistry()).
+		WithInterval(10 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- c.Run(ctx) }()
+
+	assert.Eventually(t, func() bool { return provider.Calls() >= 2 }, time.Second, 5*time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("collector did not stop after context cancellation")
+	}
+
+	assert.Equal(t, float64(3), testutil.ToFloat64(c.firing))
+	assert.Equal(t, float64(2), testutil.ToFloat64(c.pending))
+	assert.Equal(t, float64(1), testutil.ToFloat64(c.bySeverity.WithLabelValues("critical")))
+	assert.Equal(t, float64(4), testutil.ToFloat64(c.bySeverity.WithLabelValues("warning")))
+}
+
+// Test that a failing provider leaves the previous values in place
+func TestAlertsCollectorPollError(t *testing.T) {
+	provider := &mockSummaryProvider{summary: &models.AlertSummary{FiringCount: 5}}
+	c := NewAlertsCollector(provider, logger.NewTestLogger(), prometheus.NewRegistry())
+
+	assert.NoError(t, c.Poll(context.Background()))
+
+	provider.err = errors.New("upstream unavailable")
+	assert.Error(t, c.Poll(context.Background()))
+	assert.Equal(t, float64(5), testutil.ToFloat64(c.firing))
+}
@@ -1,94 +1,799 @@
 package config
 
 import (
+	"bytes"
+	"compress/gzip"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"net/netip"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/BurntSushi/toml"
 	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
 )
 
 // Config holds all configuration for the application
 type Config struct {
-	Server     ServerConfig
-	Prometheus PrometheusConfig
-	Logging    LoggingConfig
-	Cache      CacheConfig
+	Server      ServerConfig      `yaml:"server" toml:"server"`
+	Prometheus  PrometheusConfig  `yaml:"prometheus" toml:"prometheus"`
+	Logging     LoggingConfig     `yaml:"logging" toml:"logging"`
+	Cache       CacheConfig       `yaml:"cache" toml:"cache"`
+	Health      HealthConfig      `yaml:"health" toml:"health"`
+	Metrics     MetricsConfig     `yaml:"metrics" toml:"metrics"`
+	Compression CompressionConfig `yaml:"compression" toml:"compression"`
+	CORS        CORSConfig        `yaml:"cors" toml:"cors"`
+	RateLimit   RateLimitConfig   `yaml:"rate_limit" toml:"rate_limit"`
+	Alerts      AlertsConfig      `yaml:"alerts" toml:"alerts"`
+	Auth        AuthConfig        `yaml:"auth" toml:"auth"`
+	Vault       VaultConfig       `yaml:"vault" toml:"vault"`
 }
 
 // ServerConfig holds HTTP server configuration
 type ServerConfig struct {
-	Port                int
-	ReadTimeoutSeconds  int
-	WriteTimeoutSeconds int
-	IdleTimeoutSeconds  int
+	Port                int `yaml:"port" toml:"port"`
+	ReadTimeoutSeconds  int `yaml:"read_timeout_seconds" toml:"read_timeout_seconds"`
+	WriteTimeoutSeconds int `yaml:"write_timeout_seconds" toml:"write_timeout_seconds"`
+	IdleTimeoutSeconds  int `yaml:"idle_timeout_seconds" toml:"idle_timeout_seconds"`
+	// MaxBodyBytes caps request bodies as sent over the wire
+	MaxBodyBytes int64 `yaml:"max_body_bytes" toml:"max_body_bytes"`
+	// MaxDecompressedBodyBytes caps gzip-encoded request bodies once decompressed
+	MaxDecompressedBodyBytes int64 `yaml:"max_decompressed_body_bytes" toml:"max_decompressed_body_bytes"`
+	// StreamHeartbeatInterval is how long a streaming response may stay
+	// silent before a keepalive comment is sent
+	StreamHeartbeatInterval time.Duration `yaml:"stream_heartbeat_interval" toml:"stream_heartbeat_interval"`
+	// StreamMaxDuration is how long a streaming response may stay open
+	// before the server ends it
+	StreamMaxDuration time.Duration `yaml:"stream_max_duration" toml:"stream_max_duration"`
+	// StreamChunkDuration is the span of each sub-range a streamed range
+	// query is split into
+	StreamChunkDuration time.Duration `yaml:"stream_chunk_duration" toml:"stream_chunk_duration"`
+	// DrainTimeout is how long shutdown waits for in-flight requests to
+	// finish, and ShutdownTimeout how long it then waits for the server's
+	// connections to close
+	DrainTimeout    time.Duration `yaml:"drain_timeout" toml:"drain_timeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	// MaxBatchQueries caps the number of queries in one batch request
+	MaxBatchQueries int `yaml:"max_batch_queries" toml:"max_batch_queries"`
+	// BatchMaxConcurrency is how many queries of one batch run at the same
+	// time
+	BatchMaxConcurrency int `yaml:"batch_max_concurrency" toml:"batch_max_concurrency"`
+	// QueryHistorySize is how many executed queries are kept for the
+	// query history endpoint
+	QueryHistorySize int `yaml:"query_history_size" toml:"query_history_size"`
+	// ErrorDetail is "full" to return upstream error text to clients or
+	// "sanitized" to log it and return a generic message and request ID
+	ErrorDetail string `yaml:"error_detail" toml:"error_detail"`
+	// EnablePprof serves the Go profiler under /debug to users with the
+	// admin role; it requires a JWT secret to authenticate them
+	EnablePprof bool `yaml:"enable_pprof" toml:"enable_pprof"`
+	// GenerateOpenAPI writes the OpenAPI description of the API to
+	// openapi.yaml on startup
+	GenerateOpenAPI bool `yaml:"generate_openapi" toml:"generate_openapi"`
 }
 
 // PrometheusConfig holds Prometheus client configuration
 type PrometheusConfig struct {
-	URL           string
-	TimeoutSeconds int
-	MaxQueryPoints int
+	URL                 string `yaml:"url" toml:"url"`
+	TimeoutSeconds      int    `yaml:"timeout_seconds" toml:"timeout_seconds"`
+	MaxQueryPoints      int    `yaml:"max_query_points" toml:"max_query_points"`
+	MaxLabelValueLength int    `yaml:"max_label_value_length" toml:"max_label_value_length"`
+	UserAgent           string `yaml:"user_agent" toml:"user_agent"`
+	ErrorHistory        int    `yaml:"error_history" toml:"error_history"`
+	// QueryCostWarnThreshold is the estimated number of data points above
+	// which query validation warns about a query's cost
+	QueryCostWarnThreshold int `yaml:"query_cost_warn_threshold" toml:"query_cost_warn_threshold"`
+	// AdditionalURLs are further Prometheus servers, such as per-region
+	// instances, consulted alongside URL by cross-target endpoints
+	AdditionalURLs []string `yaml:"additional_urls" toml:"additional_urls"`
+	// URLs are interchangeable Prometheus servers, such as an HA pair; when
+	// set, the first replaces URL and requests fail over between them in
+	// FailoverStrategy order, skipping failed ones for FailoverCooldown
+	URLs             []string      `yaml:"urls" toml:"urls"`
+	FailoverStrategy string        `yaml:"failover_strategy" toml:"failover_strategy"`
+	FailoverCooldown time.Duration `yaml:"failover_cooldown" toml:"failover_cooldown"`
+	// Sources are independent Prometheus backends by name, such as one per
+	// region, that requests select with ?source=. Requests naming none use
+	// the server at URL, known as DefaultSource. Every source shares the
+	// credentials and TLS settings below.
+	Sources       map[string]string `yaml:"sources" toml:"sources"`
+	DefaultSource string            `yaml:"default_source" toml:"default_source"`
+	// TransportRetries is how often idempotent requests failing at the
+	// transport layer are retried, starting after TransportRetryBackoff
+	TransportRetries      int           `yaml:"transport_retries" toml:"transport_retries"`
+	TransportRetryBackoff time.Duration `yaml:"transport_retry_backoff" toml:"transport_retry_backoff"`
+	// RetryMaxAttempts is how many times a request failing with a network
+	// error or one of RetryStatusCodes is attempted, backing off from
+	// RetryInitialBackoff up to RetryMaxBackoff; 1 disables retries
+	RetryMaxAttempts    int           `yaml:"retry_max_attempts" toml:"retry_max_attempts"`
+	RetryInitialBackoff time.Duration `yaml:"retry_initial_backoff" toml:"retry_initial_backoff"`
+	RetryMaxBackoff     time.Duration `yaml:"retry_max_backoff" toml:"retry_max_backoff"`
+	RetryStatusCodes    []string      `yaml:"retry_status_codes" toml:"retry_status_codes"`
+	// MinStep is the finest step a range query may use; zero allows any.
+	// MinStepPolicy is "clamp" to raise finer steps or "reject" to fail them
+	MinStep       time.Duration `yaml:"min_step" toml:"min_step"`
+	MinStepPolicy string        `yaml:"min_step_policy" toml:"min_step_policy"`
+	// CircuitBreakerThreshold is how many consecutive failures open the
+	// circuit breaker, zero to disable it; it half-opens after the cooldown
+	// and closes after CircuitBreakerSuccesses successful probes
+	CircuitBreakerThreshold int           `yaml:"circuit_breaker_threshold" toml:"circuit_breaker_threshold"`
+	CircuitBreakerCooldown  time.Duration `yaml:"circuit_breaker_cooldown" toml:"circuit_breaker_cooldown"`
+	CircuitBreakerSuccesses int           `yaml:"circuit_breaker_successes" toml:"circuit_breaker_successes"`
+	// BearerToken or Username and Password authenticate every request;
+	// Headers are extra "Name=Value" pairs such as a tenant ID
+	BearerToken string   `yaml:"bearer_token" toml:"bearer_token"`
+	Username    string   `yaml:"username" toml:"username"`
+	Password    string   `yaml:"password" toml:"password"`
+	Headers     []string `yaml:"headers" toml:"headers"`
+	// TLSCAFile adds a CA bundle for verifying Prometheus; TLSCertFile and
+	// TLSKeyFile present a client certificate for mutual TLS
+	TLSCAFile             string `yaml:"tls_ca_file" toml:"tls_ca_file"`
+	TLSCertFile           string `yaml:"tls_cert_file" toml:"tls_cert_file"`
+	TLSKeyFile            string `yaml:"tls_key_file" toml:"tls_key_file"`
+	TLSInsecureSkipVerify bool   `yaml:"tls_insecure_skip_verify" toml:"tls_insecure_skip_verify"`
 }
 
 // LoggingConfig holds logging configuration
 type LoggingConfig struct {
-	Level  string
-	Format string
+	Level  string `yaml:"level" toml:"level"`
+	Format string `yaml:"format" toml:"format"`
+
+	// Caller adds the calling file and line to every entry
+	Caller bool `yaml:"caller" toml:"caller"`
+	// StacktraceLevel is the lowest level whose entries include a stacktrace
+	StacktraceLevel string `yaml:"stacktrace_level" toml:"stacktrace_level"`
 }
 
 // CacheConfig holds cache configuration
 type CacheConfig struct {
-	Enabled     bool
-	TTLSeconds  int
-	MaxSizeItems int
+	Enabled      bool `yaml:"enabled" toml:"enabled"`
+	TTLSeconds   int  `yaml:"ttl_seconds" toml:"ttl_seconds"`
+	MaxSizeItems int  `yaml:"max_size_items" toml:"max_size_items"`
+	// PersistPath is a file the cache is loaded from on start and saved to
+	// on shutdown; empty keeps the cache in memory only
+	PersistPath string `yaml:"persist_path" toml:"persist_path"`
+	// Backend is where Prometheus responses are cached: "memory" keeps them
+	// in this process, "redis" shares them between instances through the
+	// server at RedisAddr
+	Backend       string `yaml:"backend" toml:"backend"`
+	RedisAddr     string `yaml:"redis_addr" toml:"redis_addr"`
+	RedisPassword string `yaml:"redis_password" toml:"redis_password"`
+	// RedisPrefix namespaces the cache's Redis keys; empty uses the
+	// cache package default
+	RedisPrefix string `yaml:"redis_prefix" toml:"redis_prefix"`
+}
+
+// HealthConfig holds health probe timeouts and thresholds
+type HealthConfig struct {
+	DetailedTimeout  time.Duration `yaml:"detailed_timeout" toml:"detailed_timeout"`
+	ReadinessTimeout time.Duration `yaml:"readiness_timeout" toml:"readiness_timeout"`
+	CheckTimeout     time.Duration `yaml:"check_timeout" toml:"check_timeout"`
+	// CheckInterval is how often the checks run in the background for the
+	// detailed health and readiness probes to report; zero runs them on
+	// every probe instead
+	CheckInterval time.Duration `yaml:"check_interval" toml:"check_interval"`
+	// MemoryThreshold is the share of the memory obtained from the OS above
+	// which the service reports itself degraded
+	MemoryThreshold float64 `yaml:"memory_threshold" toml:"memory_threshold"`
+	// GoroutineThreshold is the number of goroutines above which the service
+	// reports itself degraded
+	GoroutineThreshold int `yaml:"goroutine_threshold" toml:"goroutine_threshold"`
+}
+
+// AlertsConfig holds alert feed configuration
+type AlertsConfig struct {
+	// PollInterval is how often live alert feeds check Prometheus for changes
+	PollInterval time.Duration `yaml:"poll_interval" toml:"poll_interval"`
+}
+
+// AuthConfig holds API authentication configuration
+type AuthConfig struct {
+	// JWTSecret is the key bearer tokens are signed with
+	JWTSecret string `yaml:"jwt_secret" toml:"jwt_secret"`
+	// Enabled requires one of APIKeys in the X-API-Key header of every API
+	// request except the health probes
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// APIKeys maps each user ID to the API key that authenticates it
+	APIKeys map[string]string `yaml:"api_keys" toml:"api_keys"`
+}
+
+// VaultConfig locates a KV version 2 secret in HashiCorp Vault whose keys
+// override secret fields; see vaultSecretFields for the keys read. The
+// secret is read only when Address and SecretPath are set.
+type VaultConfig struct {
+	Address string `yaml:"address" toml:"address"`
+	Token   string `yaml:"token" toml:"token"`
+	// MountPath is where the KV engine is mounted, "secret" by default
+	MountPath  string `yaml:"mount_path" toml:"mount_path"`
+	SecretPath string `yaml:"secret_path" toml:"secret_path"`
+}
+
+// CompressionConfig holds response compression configuration
+type CompressionConfig struct {
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// Level is the gzip level from 1 to 9; other values use the gzip default
+	Level int `yaml:"level" toml:"level"`
+	// ContentTypes are the media types to compress, e.g. "application/json" or "text/*"
+	ContentTypes []string `yaml:"content_types" toml:"content_types"`
+	// MinSize is the smallest response body in bytes that is compressed
+	MinSize int `yaml:"min_size" toml:"min_size"`
+}
+
+// CORSConfig holds cross-origin request configuration
+type CORSConfig struct {
+	// AllowedOrigins lists the origins browsers may call the API from; "*"
+	// allows every origin
+	AllowedOrigins []string `yaml:"allowed_origins" toml:"allowed_origins"`
+	// AllowedMethods and AllowedHeaders use the middleware defaults when empty
+	AllowedMethods   []string `yaml:"allowed_methods" toml:"allowed_methods"`
+	AllowedHeaders   []string `yaml:"allowed_headers" toml:"allowed_headers"`
+	AllowCredentials bool     `yaml:"allow_credentials" toml:"allow_credentials"`
+	// MaxAgeSecs is how long browsers may cache a preflight response
+	MaxAgeSecs int `yaml:"max_age_secs" toml:"max_age_secs"`
+}
+
+// RateLimitConfig holds per-client request rate limiting configuration
+type RateLimitConfig struct {
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// RequestsPerSecond is the sustained rate each client IP may send at,
+	// with bursts of up to Burst requests
+	RequestsPerSecond float64 `yaml:"requests_per_second" toml:"requests_per_second"`
+	Burst             int     `yaml:"burst" toml:"burst"`
+	// CleanupInterval is how long an idle client's limiter is kept
+	CleanupInterval time.Duration `yaml:"cleanup_interval" toml:"cleanup_interval"`
+	// TrustedProxies are the IPs or CIDRs of the proxies whose
+	// X-Forwarded-For is believed; other clients are limited by their own
+	// address
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies"`
+}
+
+// MetricsConfig holds metric discovery configuration
+type MetricsConfig struct {
+	// HiddenPatterns are regexes of metric names left out of listings and
+	// suggestions; hidden metrics can still be queried directly
+	HiddenPatterns []string `yaml:"hidden_patterns" toml:"hidden_patterns"`
+
+	// StalenessThreshold is how old a metric's newest sample may be before
+	// the metric is reported as stale
+	StalenessThreshold time.Duration `yaml:"staleness_threshold" toml:"staleness_threshold"`
+
+	// ScrapeInterval is the expected scrape interval used to detect gaps
+	ScrapeInterval time.Duration `yaml:"scrape_interval" toml:"scrape_interval"`
+
+	// AnomalyWindow is the span of the rolling window anomalies are
+	// detected against
+	AnomalyWindow time.Duration `yaml:"anomaly_window" toml:"anomaly_window"`
+
+	// MaxSeriesPerMetric is the series count above which a metric is
+	// logged as high cardinality; zero disables the warning
+	MaxSeriesPerMetric int `yaml:"max_series_per_metric" toml:"max_series_per_metric"`
+}
+
+// defaultConfig returns the configuration used where neither a config file
+// nor an environment variable sets a value
+func defaultConfig() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Port:                     8080,
+			ReadTimeoutSeconds:       5,
+			WriteTimeoutSeconds:      10,
+			IdleTimeoutSeconds:       120,
+			MaxBodyBytes:             1 << 20,
+			MaxDecompressedBodyBytes: 10 << 20,
+			MaxBatchQueries:          50,
+			BatchMaxConcurrency:      5,
+			QueryHistorySize:         1000,
+			StreamHeartbeatInterval:  15 * time.Second,
+			StreamMaxDuration:        time.Hour,
+			StreamChunkDuration:      6 * time.Hour,
+			DrainTimeout:             10 * time.Second,
+			ShutdownTimeout:          5 * time.Second,
+			ErrorDetail:              "full",
+		},
+		Prometheus: PrometheusConfig{
+			URL:                     "http://prometheus:9090",
+			TimeoutSeconds:          30,
+			MaxQueryPoints:          11000,
+			QueryCostWarnThreshold:  5000,
+			MaxLabelValueLength:     256,
+			ErrorHistory:            50,
+			FailoverStrategy:        "primary",
+			DefaultSource:           "default",
+			FailoverCooldown:        30 * time.Second,
+			TransportRetries:        2,
+			TransportRetryBackoff:   100 * time.Millisecond,
+			RetryMaxAttempts:        3,
+			RetryInitialBackoff:     200 * time.Millisecond,
+			RetryMaxBackoff:         5 * time.Second,
+			RetryStatusCodes:        []string{"429", "502", "503", "504"},
+			MinStepPolicy:           "clamp",
+			CircuitBreakerCooldown:  30 * time.Second,
+			CircuitBreakerSuccesses: 1,
+		},
+		Logging: LoggingConfig{
+			Level:           "info",
+			Format:          "json",
+			Caller:          true,
+			StacktraceLevel: "error",
+		},
+		Cache: CacheConfig{
+			Enabled:      true,
+			TTLSeconds:   60,
+			MaxSizeItems: 1000,
+			Backend:      "memory",
+		},
+		Health: HealthConfig{
+			DetailedTimeout:    5 * time.Second,
+			ReadinessTimeout:   2 * time.Second,
+			CheckTimeout:       5 * time.Second,
+			CheckInterval:      15 * time.Second,
+			MemoryThreshold:    0.9,
+			GoroutineThreshold: 10000,
+		},
+		Metrics: MetricsConfig{
+			StalenessThreshold: 5 * time.Minute,
+			ScrapeInterval:     time.Minute,
+			AnomalyWindow:      time.Hour,
+		},
+		Compression: CompressionConfig{
+			Enabled:      true,
+			Level:        gzip.DefaultCompression,
+			ContentTypes: []string{"application/json", "text/*"},
+			MinSize:      1024,
+		},
+		CORS: CORSConfig{
+			AllowedOrigins:   []string{"*"},
+			AllowCredentials: true,
+			MaxAgeSecs:       3600,
+		},
+		RateLimit: RateLimitConfig{
+			RequestsPerSecond: 10,
+			Burst:             20,
+			CleanupInterval:   10 * time.Minute,
+		},
+		Alerts: AlertsConfig{
+			PollInterval: 15 * time.Second,
+		},
+		Vault: VaultConfig{
+			MountPath: "secret",
+		},
+	}
 }
 
-// Load loads configuration from environment variables
+// Load loads configuration from the file named by CONFIG_FILE, if set and
+// present, then lets environment variables override individual fields
 func Load() (*Config, error) {
 	// Load .env file if it exists
 	_ = godotenv.Load()
+
+	base := defaultConfig()
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := decodeFile(path, base); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
 	
 	config := &Config{
 		Server: ServerConfig{
-			Port:                getEnvAsInt("SERVER_PORT", 8080),
-			ReadTimeoutSeconds:  getEnvAsInt("SERVER_READ_TIMEOUT", 5),
-			WriteTimeoutSeconds: getEnvAsInt("SERVER_WRITE_TIMEOUT", 10),
-			IdleTimeoutSeconds:  getEnvAsInt("SERVER_IDLE_TIMEOUT", 120),
+			Port:                     getEnvAsInt("SERVER_PORT", base.Server.Port),
+			ReadTimeoutSeconds:       getEnvAsInt("SERVER_READ_TIMEOUT", base.Server.ReadTimeoutSeconds),
+			WriteTimeoutSeconds:      getEnvAsInt("SERVER_WRITE_TIMEOUT", base.Server.WriteTimeoutSeconds),
+			IdleTimeoutSeconds:       getEnvAsInt("SERVER_IDLE_TIMEOUT", base.Server.IdleTimeoutSeconds),
+			MaxBodyBytes:             int64(getEnvAsInt("SERVER_MAX_BODY_BYTES", int(base.Server.MaxBodyBytes))),
+			MaxDecompressedBodyBytes: int64(getEnvAsInt("SERVER_MAX_DECOMPRESSED_BODY_BYTES", int(base.Server.MaxDecompressedBodyBytes))),
+			MaxBatchQueries:          getEnvAsInt("SERVER_MAX_BATCH_QUERIES", base.Server.MaxBatchQueries),
+			BatchMaxConcurrency:      getEnvAsInt("BATCH_MAX_CONCURRENCY", base.Server.BatchMaxConcurrency),
+			QueryHistorySize:         getEnvAsInt("SERVER_QUERY_HISTORY_SIZE", base.Server.QueryHistorySize),
+			StreamHeartbeatInterval:  getEnvAsDuration("SERVER_STREAM_HEARTBEAT_INTERVAL", base.Server.StreamHeartbeatInterval),
+			StreamMaxDuration:        getEnvAsDuration("SERVER_STREAM_MAX_DURATION", base.Server.StreamMaxDuration),
+			StreamChunkDuration:      getEnvAsDuration("STREAM_CHUNK_DURATION", base.Server.StreamChunkDuration),
+			DrainTimeout:             getEnvAsDuration("SERVER_DRAIN_TIMEOUT", base.Server.DrainTimeout),
+			ShutdownTimeout:          getEnvAsDuration("SERVER_SHUTDOWN_TIMEOUT", base.Server.ShutdownTimeout),
+			ErrorDetail:              getEnv("SERVER_ERROR_DETAIL", base.Server.ErrorDetail),
+			EnablePprof:              getEnvAsBool("ENABLE_PPROF", base.Server.EnablePprof),
+			GenerateOpenAPI:          getEnvAsBool("GENERATE_OPENAPI", base.Server.GenerateOpenAPI),
 		},
 		Prometheus: PrometheusConfig{
-			URL:            getEnv("PROMETHEUS_URL", "http://prometheus:9090"),
-			TimeoutSeconds: getEnvAsInt("PROMETHEUS_TIMEOUT", 30),
-			MaxQueryPoints: getEnvAsInt("PROMETHEUS_MAX_QUERY_POINTS", 11000),
+			URL:                     getEnv("PROMETHEUS_URL", base.Prometheus.URL),
+			TimeoutSeconds:          getEnvAsInt("PROMETHEUS_TIMEOUT", base.Prometheus.TimeoutSeconds),
+			MaxQueryPoints:          getEnvAsInt("PROMETHEUS_MAX_QUERY_POINTS", base.Prometheus.MaxQueryPoints),
+			QueryCostWarnThreshold:  getEnvAsInt("PROMETHEUS_QUERY_COST_WARN_THRESHOLD", base.Prometheus.QueryCostWarnThreshold),
+			MaxLabelValueLength:     getEnvAsInt("PROMETHEUS_MAX_LABEL_VALUE_LENGTH", base.Prometheus.MaxLabelValueLength),
+			UserAgent:               getEnv("PROMETHEUS_USER_AGENT", base.Prometheus.UserAgent),
+			ErrorHistory:            getEnvAsInt("PROMETHEUS_ERROR_HISTORY", base.Prometheus.ErrorHistory),
+			AdditionalURLs:          getEnvAsSlice("PROMETHEUS_ADDITIONAL_URLS", base.Prometheus.AdditionalURLs),
+			URLs:                    getEnvAsSlice("PROMETHEUS_URLS", base.Prometheus.URLs),
+			FailoverStrategy:        getEnv("PROMETHEUS_FAILOVER_STRATEGY", base.Prometheus.FailoverStrategy),
+			FailoverCooldown:        getEnvAsDuration("PROMETHEUS_FAILOVER_COOLDOWN", base.Prometheus.FailoverCooldown),
+			Sources:                 getEnvAsMap("PROMETHEUS_SOURCES", base.Prometheus.Sources),
+			DefaultSource:           getEnv("PROMETHEUS_DEFAULT_SOURCE", base.Prometheus.DefaultSource),
+			TransportRetries:        getEnvAsInt("PROMETHEUS_TRANSPORT_RETRIES", base.Prometheus.TransportRetries),
+			TransportRetryBackoff:   getEnvAsDuration("PROMETHEUS_TRANSPORT_RETRY_BACKOFF", base.Prometheus.TransportRetryBackoff),
+			RetryMaxAttempts:        getEnvAsInt("PROMETHEUS_RETRY_MAX_ATTEMPTS", base.Prometheus.RetryMaxAttempts),
+			RetryInitialBackoff:     getEnvAsDuration("PROMETHEUS_RETRY_INITIAL_BACKOFF", base.Prometheus.RetryInitialBackoff),
+			RetryMaxBackoff:         getEnvAsDuration("PROMETHEUS_RETRY_MAX_BACKOFF", base.Prometheus.RetryMaxBackoff),
+			RetryStatusCodes:        getEnvAsSlice("PROMETHEUS_RETRY_STATUS_CODES", base.Prometheus.RetryStatusCodes),
+			MinStep:                 getEnvAsDuration("PROMETHEUS_MIN_STEP", base.Prometheus.MinStep),
+			MinStepPolicy:           getEnv("PROMETHEUS_MIN_STEP_POLICY", base.Prometheus.MinStepPolicy),
+			CircuitBreakerThreshold: getEnvAsInt("PROMETHEUS_CIRCUIT_BREAKER_THRESHOLD", base.Prometheus.CircuitBreakerThreshold),
+			CircuitBreakerCooldown:  getEnvAsDuration("PROMETHEUS_CIRCUIT_BREAKER_COOLDOWN", base.Prometheus.CircuitBreakerCooldown),
+			CircuitBreakerSuccesses: getEnvAsInt("PROMETHEUS_CIRCUIT_BREAKER_SUCCESS_THRESHOLD", base.Prometheus.CircuitBreakerSuccesses),
+			BearerToken:             getEnv("PROMETHEUS_BEARER_TOKEN", base.Prometheus.BearerToken),
+			Username:                getEnv("PROMETHEUS_USERNAME", base.Prometheus.Username),
+			Password:                getEnv("PROMETHEUS_PASSWORD", base.Prometheus.Password),
+			Headers:                 getEnvAsSlice("PROMETHEUS_HEADERS", base.Prometheus.Headers),
+			TLSCAFile:               getEnv("PROMETHEUS_TLS_CA_FILE", base.Prometheus.TLSCAFile),
+			TLSCertFile:             getEnv("PROMETHEUS_TLS_CERT_FILE", base.Prometheus.TLSCertFile),
+			TLSKeyFile:              getEnv("PROMETHEUS_TLS_KEY_FILE", base.Prometheus.TLSKeyFile),
+			TLSInsecureSkipVerify:   getEnvAsBool("PROMETHEUS_TLS_INSECURE_SKIP_VERIFY", base.Prometheus.TLSInsecureSkipVerify),
 		},
 		Logging: LoggingConfig{
-			Level:  getEnv("LOG_LEVEL", "info"),
-			Format: getEnv("LOG_FORMAT", "json"),
+			Level:           getEnv("LOG_LEVEL", base.Logging.Level),
+			Format:          getEnv("LOG_FORMAT", base.Logging.Format),
+			Caller:          getEnvAsBool("LOG_CALLER", base.Logging.Caller),
+			StacktraceLevel: getEnv("LOG_STACKTRACE_LEVEL", base.Logging.StacktraceLevel),
 		},
 		Cache: CacheConfig{
-			Enabled:     getEnvAsBool("CACHE_ENABLED", true),
-			TTLSeconds:  getEnvAsInt("CACHE_TTL", 60),
-			MaxSizeItems: getEnvAsInt("CACHE_MAX_SIZE", 1000),
+			Enabled:      getEnvAsBool("CACHE_ENABLED", base.Cache.Enabled),
+			TTLSeconds:   getEnvAsInt("CACHE_TTL", base.Cache.TTLSeconds),
+			MaxSizeItems: getEnvAsInt("CACHE_MAX_SIZE", base.Cache.MaxSizeItems),
+			PersistPath:  getEnv("CACHE_PERSIST_PATH", base.Cache.PersistPath),
+			Backend:       getEnv("CACHE_BACKEND", base.Cache.Backend),
+			RedisAddr:     getEnv("CACHE_REDIS_ADDR", base.Cache.RedisAddr),
+			RedisPassword: getEnv("CACHE_REDIS_PASSWORD", base.Cache.RedisPassword),
+			RedisPrefix:   getEnv("CACHE_REDIS_PREFIX", base.Cache.RedisPrefix),
+		},
+		Health: HealthConfig{
+			DetailedTimeout:    getEnvAsDuration("HEALTH_DETAILED_TIMEOUT", base.Health.DetailedTimeout),
+			ReadinessTimeout:   getEnvAsDuration("HEALTH_READINESS_TIMEOUT", base.Health.ReadinessTimeout),
+			CheckTimeout:       getEnvAsDuration("HEALTH_CHECK_TIMEOUT", base.Health.CheckTimeout),
+			CheckInterval:      getEnvAsDuration("HEALTH_CHECK_INTERVAL", base.Health.CheckInterval),
+			MemoryThreshold:    getEnvAsFloat("HEALTH_MEMORY_THRESHOLD", base.Health.MemoryThreshold),
+			GoroutineThreshold: getEnvAsInt("HEALTH_GOROUTINE_THRESHOLD", base.Health.GoroutineThreshold),
+		},
+		Metrics: MetricsConfig{
+			HiddenPatterns:     getEnvAsSlice("METRICS_HIDDEN_PATTERNS", base.Metrics.HiddenPatterns),
+			StalenessThreshold: getEnvAsDuration("METRICS_STALENESS_THRESHOLD", base.Metrics.StalenessThreshold),
+			ScrapeInterval:     getEnvAsDuration("METRICS_SCRAPE_INTERVAL", base.Metrics.ScrapeInterval),
+			AnomalyWindow:      getEnvAsDuration("METRICS_ANOMALY_WINDOW", base.Metrics.AnomalyWindow),
+			MaxSeriesPerMetric: getEnvAsInt("METRICS_MAX_SERIES_PER_METRIC", base.Metrics.MaxSeriesPerMetric),
+		},
+		Compression: CompressionConfig{
+			Enabled:      getEnvAsBool("COMPRESSION_ENABLED", base.Compression.Enabled),
+			Level:        getEnvAsInt("COMPRESSION_LEVEL", base.Compression.Level),
+			ContentTypes: getEnvAsSlice("COMPRESSION_CONTENT_TYPES", base.Compression.ContentTypes),
+			MinSize:      getEnvAsInt("COMPRESSION_MIN_SIZE", base.Compression.MinSize),
+		},
+		CORS: CORSConfig{
+			AllowedOrigins:   getEnvAsSlice("CORS_ALLOWED_ORIGINS", base.CORS.AllowedOrigins),
+			AllowedMethods:   getEnvAsSlice("CORS_ALLOWED_METHODS", base.CORS.AllowedMethods),
+			AllowedHeaders:   getEnvAsSlice("CORS_ALLOWED_HEADERS", base.CORS.AllowedHeaders),
+			AllowCredentials: getEnvAsBool("CORS_ALLOW_CREDENTIALS", base.CORS.AllowCredentials),
+			MaxAgeSecs:       getEnvAsInt("CORS_MAX_AGE_SECS", base.CORS.MaxAgeSecs),
+		},
+		RateLimit: RateLimitConfig{
+			Enabled:           getEnvAsBool("RATE_LIMIT_ENABLED", base.RateLimit.Enabled),
+			RequestsPerSecond: getEnvAsFloat("RATE_LIMIT_REQUESTS_PER_SECOND", base.RateLimit.RequestsPerSecond),
+			Burst:             getEnvAsInt("RATE_LIMIT_BURST", base.RateLimit.Burst),
+			CleanupInterval:   getEnvAsDuration("RATE_LIMIT_CLEANUP_INTERVAL", base.RateLimit.CleanupInterval),
+			TrustedProxies:    getEnvAsSlice("RATE_LIMIT_TRUSTED_PROXIES", base.RateLimit.TrustedProxies),
+		},
+		Alerts: AlertsConfig{
+			PollInterval: getEnvAsDuration("ALERTS_POLL_INTERVAL", base.Alerts.PollInterval),
+		},
+		Auth: AuthConfig{
+			JWTSecret: getEnv("JWT_SECRET", base.Auth.JWTSecret),
+			Enabled:   getEnvAsBool("AUTH_ENABLED", base.Auth.Enabled),
+			APIKeys:   getEnvAsMap("AUTH_API_KEYS", base.Auth.APIKeys),
+		},
+		Vault: VaultConfig{
+			Address:    getEnv("VAULT_ADDR", base.Vault.Address),
+			Token:      getEnv("VAULT_TOKEN", base.Vault.Token),
+			MountPath:  getEnv("VAULT_MOUNT_PATH", base.Vault.MountPath),
+			SecretPath: getEnv("VAULT_SECRET_PATH", base.Vault.SecretPath),
 		},
 	}
 	
+	if config.Vault.Address != "" && config.Vault.SecretPath != "" {
+		if err := loadVaultSecrets(config); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(config.Prometheus.URLs) > 0 {
+		config.Prometheus.URL = config.Prometheus.URLs[0]
+	}
+
 	return config, validateConfig(config)
 }
 
+// LoadFromFile loads configuration from a YAML, JSON or TOML file, chosen by its
+// extension, with defaults for the fields it leaves out. Environment
+// variables are not consulted.
+func LoadFromFile(path string) (*Config, error) {
+	config := defaultConfig()
+	if err := decodeFile(path, config); err != nil {
+		return nil, err
+	}
+
+	if len(config.Prometheus.URLs) > 0 {
+		config.Prometheus.URL = config.Prometheus.URLs[0]
+	}
+
+	return config, validateConfig(config)
+}
+
+// decodeFile decodes the YAML, JSON or TOML file at path over cfg, so only
+// the fields the file sets are changed. Unknown keys are rejected to catch
+// typos.
+func decodeFile(path string, cfg *Config) error {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext != ".yaml" && ext != ".yml" && ext != ".json" && ext != ".toml" {
+		return fmt.Errorf("unsupported config file %s: extension must be .yaml, .yml, .json or .toml", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %w", err)
+	}
+
+	if ext == ".toml" {
+		meta, err := toml.Decode(string(data), cfg)
+		if err != nil {
+			return fmt.Errorf("error parsing config file %s: %w", path, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("error parsing config file %s: unknown key %s", path, undecoded[0])
+		}
+		return nil
+	}
+
+	// JSON is valid YAML, so it shares the YAML decoder and field names
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
 // validateConfig validates the configuration
 func validateConfig(cfg *Config) error {
 	if cfg.Server.Port <= 0 {
 		return fmt.Errorf("server port must be positive")
 	}
+
+	if cfg.Server.MaxBodyBytes <= 0 {
+		return fmt.Errorf("server max body size must be positive")
+	}
+
+	if cfg.Server.MaxDecompressedBodyBytes <= 0 {
+		return fmt.Errorf("server max decompressed body size must be positive")
+	}
+
+	if cfg.Server.StreamHeartbeatInterval <= 0 {
+		return fmt.Errorf("server stream heartbeat interval must be positive")
+	}
+
+	if cfg.Server.StreamMaxDuration <= 0 {
+		return fmt.Errorf("server stream max duration must be positive")
+	}
+
+	if cfg.Server.StreamChunkDuration <= 0 {
+		return fmt.Errorf("stream chunk duration must be positive")
+	}
+
+	if cfg.Server.DrainTimeout <= 0 || cfg.Server.ShutdownTimeout <= 0 {
+		return fmt.Errorf("server drain and shutdown timeouts must be positive")
+	}
+
+	if cfg.Server.ErrorDetail != "full" && cfg.Server.ErrorDetail != "sanitized" {
+		return fmt.Errorf("server error detail must be full or sanitized")
+	}
+
+	if cfg.Server.EnablePprof && cfg.Auth.JWTSecret == "" {
+		return fmt.Errorf("pprof requires a JWT secret to authenticate admins")
+	}
+
+	if cfg.Auth.Enabled && len(cfg.Auth.APIKeys) == 0 {
+		return fmt.Errorf("auth requires at least one API key")
+	}
+	keyUsers := make(map[string]string, len(cfg.Auth.APIKeys))
+	for user, key := range cfg.Auth.APIKeys {
+		if user == "" || key == "" {
+			return fmt.Errorf("invalid API key for user %q", user)
+		}
+		if other, ok := keyUsers[key]; ok {
+			return fmt.Errorf("users %q and %q share an API key", other, user)
+		}
+		keyUsers[key] = user
+	}
+
+	if cfg.Server.MaxBatchQueries <= 0 {
+		return fmt.Errorf("server max batch queries must be positive")
+	}
+
+	if cfg.Server.BatchMaxConcurrency <= 0 {
+		return fmt.Errorf("batch max concurrency must be positive")
+	}
+
+	if cfg.Server.QueryHistorySize <= 0 {
+		return fmt.Errorf("server query history size must be positive")
+	}
 	
 	if cfg.Prometheus.URL == "" {
 		return fmt.Errorf("prometheus URL cannot be empty")
 	}
 	
+	if cfg.Prometheus.DefaultSource == "" {
+		return fmt.Errorf("prometheus default source name cannot be empty")
+	}
+
+	for name, address := range cfg.Prometheus.Sources {
+		if name == "" || name == cfg.Prometheus.DefaultSource {
+			return fmt.Errorf("invalid prometheus source name %q", name)
+		}
+		if address == "" {
+			return fmt.Errorf("prometheus source %q has no URL", name)
+		}
+	}
+
 	if cfg.Prometheus.TimeoutSeconds <= 0 {
 		return fmt.Errorf("prometheus timeout must be positive")
 	}
+
+	if cfg.Prometheus.MaxLabelValueLength <= 0 {
+		return fmt.Errorf("prometheus max label value length must be positive")
+	}
+
+	if cfg.Prometheus.QueryCostWarnThreshold < 0 {
+		return fmt.Errorf("prometheus query cost warn threshold cannot be negative")
+	}
+
+	if cfg.Prometheus.ErrorHistory < 0 {
+		return fmt.Errorf("prometheus error history cannot be negative")
+	}
+
+	if cfg.Prometheus.FailoverStrategy != "primary" && cfg.Prometheus.FailoverStrategy != "round-robin" {
+		return fmt.Errorf("prometheus failover strategy must be primary or round-robin")
+	}
+
+	if cfg.Prometheus.FailoverCooldown <= 0 {
+		return fmt.Errorf("prometheus failover cooldown must be positive")
+	}
+
+	if cfg.Prometheus.TransportRetries < 0 {
+		return fmt.Errorf("prometheus transport retries cannot be negative")
+	}
+
+	if cfg.Prometheus.TransportRetries > 0 && cfg.Prometheus.TransportRetryBackoff <= 0 {
+		return fmt.Errorf("prometheus transport retry backoff must be positive")
+	}
+
+	if cfg.Prometheus.RetryMaxAttempts < 1 {
+		return fmt.Errorf("prometheus retry max attempts must be at least 1")
+	}
+
+	if cfg.Prometheus.RetryMaxAttempts > 1 && (cfg.Prometheus.RetryInitialBackoff <= 0 || cfg.Prometheus.RetryMaxBackoff < cfg.Prometheus.RetryInitialBackoff) {
+		return fmt.Errorf("prometheus retry backoff must be positive and no more than the max backoff")
+	}
+
+	if _, err := cfg.Prometheus.ParseRetryStatusCodes(); err != nil {
+		return err
+	}
+
+	if cfg.Prometheus.MinStep < 0 {
+		return fmt.Errorf("prometheus min step cannot be negative")
+	}
+
+	if cfg.Prometheus.MinStepPolicy != "clamp" && cfg.Prometheus.MinStepPolicy != "reject" {
+		return fmt.Errorf("prometheus min step policy must be clamp or reject")
+	}
+
+	if cfg.Prometheus.CircuitBreakerThreshold < 0 {
+		return fmt.Errorf("prometheus circuit breaker threshold cannot be negative")
+	}
+
+	if cfg.Prometheus.CircuitBreakerThreshold > 0 && cfg.Prometheus.CircuitBreakerCooldown <= 0 {
+		return fmt.Errorf("prometheus circuit breaker cooldown must be positive")
+	}
+
+	if cfg.Prometheus.CircuitBreakerThreshold > 0 && cfg.Prometheus.CircuitBreakerSuccesses < 1 {
+		return fmt.Errorf("prometheus circuit breaker success threshold must be at least 1")
+	}
+
+	if cfg.Prometheus.BearerToken != "" && cfg.Prometheus.Username != "" {
+		return fmt.Errorf("prometheus bearer token and username are mutually exclusive")
+	}
+
+	if cfg.Prometheus.Password != "" && cfg.Prometheus.Username == "" {
+		return fmt.Errorf("prometheus password requires a username")
+	}
+
+	if _, err := cfg.Prometheus.ParseHeaders(); err != nil {
+		return err
+	}
+
+	if (cfg.Prometheus.TLSCertFile == "") != (cfg.Prometheus.TLSKeyFile == "") {
+		return fmt.Errorf("prometheus TLS client certificate and key must be set together")
+	}
+
+	switch cfg.Cache.Backend {
+	case "memory":
+	case "redis":
+		if cfg.Cache.RedisAddr == "" {
+			return fmt.Errorf("redis cache backend requires an address")
+		}
+	default:
+		return fmt.Errorf("cache backend must be memory or redis")
+	}
+
+	if cfg.Health.DetailedTimeout <= 0 || cfg.Health.ReadinessTimeout <= 0 || cfg.Health.CheckTimeout <= 0 {
+		return fmt.Errorf("health timeouts must be positive")
+	}
+
+	if cfg.Health.CheckInterval < 0 {
+		return fmt.Errorf("health check interval cannot be negative")
+	}
+
+	if cfg.Health.MemoryThreshold <= 0 || cfg.Health.MemoryThreshold > 1 {
+		return fmt.Errorf("health memory threshold must be between 0 and 1")
+	}
+
+	if cfg.Health.GoroutineThreshold <= 0 {
+		return fmt.Errorf("health goroutine threshold must be positive")
+	}
+
+	if cfg.Alerts.PollInterval < time.Second {
+		return fmt.Errorf("alerts poll interval must be at least 1s")
+	}
+
+	if cfg.Metrics.StalenessThreshold <= 0 || cfg.Metrics.ScrapeInterval <= 0 {
+		return fmt.Errorf("metric staleness threshold and scrape interval must be positive")
+	}
+
+	if cfg.Metrics.AnomalyWindow <= 0 {
+		return fmt.Errorf("metric anomaly window must be positive")
+	}
+
+	if cfg.Metrics.MaxSeriesPerMetric < 0 {
+		return fmt.Errorf("max series per metric must not be negative")
+	}
+
+	if _, err := cfg.Metrics.CompileHiddenPatterns(); err != nil {
+		return err
+	}
+
+	if len(cfg.CORS.AllowedOrigins) == 0 {
+		return fmt.Errorf("at least one CORS allowed origin is required")
+	}
+
+	if cfg.CORS.MaxAgeSecs < 0 {
+		return fmt.Errorf("CORS max age cannot be negative")
+	}
+
+	if cfg.Compression.MinSize < 0 {
+		return fmt.Errorf("compression min size cannot be negative")
+	}
+
+	if cfg.RateLimit.Enabled && (cfg.RateLimit.RequestsPerSecond <= 0 || cfg.RateLimit.Burst <= 0 || cfg.RateLimit.CleanupInterval <= 0) {
+		return fmt.Errorf("rate limit requests per second, burst and cleanup interval must be positive")
+	}
+
+	if _, err := cfg.RateLimit.ParseTrustedProxies(); err != nil {
+		return err
+	}
+
+	if cfg.Vault.SecretPath != "" && (cfg.Vault.Address == "" || cfg.Vault.MountPath == "") {
+		return fmt.Errorf("vault secret path requires a vault address and mount path")
+	}
 	
 	return nil
 }
@@ -110,6 +815,15 @@ func getEnvAsInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+// getEnvAsFloat gets an environment variable as a float or returns a default
+func getEnvAsFloat(key string, defaultValue float64) float64 {
+	valueStr := getEnv(key, "")
+	if value, err := strconv.ParseFloat(valueStr, 64); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
 // getEnvAsBool gets an environment variable as a boolean or returns a default
 func getEnvAsBool(key string, defaultValue bool) bool {
 	valueStr := getEnv(key, "")
@@ -119,12 +833,117 @@ func getEnvAsBool(key string, defaultValue bool) bool {
 	return defaultValue
 }
 
+// getEnvAsDuration gets an environment variable as a duration or returns a default
+func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
+	valueStr := getEnv(key, "")
+	if value, err := time.ParseDuration(valueStr); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvAsSlice gets a comma-separated environment variable as a slice or returns a default
+func getEnvAsSlice(key string, defaultValue []string) []string {
+	valueStr := getEnv(key, "")
+	if valueStr == "" {
+		return defaultValue
+	}
+
+	var values []string
+	for _, value := range strings.Split(valueStr, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getEnvAsMap gets a comma-separated list of "name=value" pairs as a map or
+// returns a default
+func getEnvAsMap(key string, defaultValue map[string]string) map[string]string {
+	values := getEnvAsSlice(key, nil)
+	if values == nil {
+		return defaultValue
+	}
+
+	pairs := make(map[string]string, len(values))
+	for _, value := range values {
+		name, v, _ := strings.Cut(value, "=")
+		pairs[strings.TrimSpace(name)] = strings.TrimSpace(v)
+	}
+	return pairs
+}
+
 // GetPrometheusTimeout returns the Prometheus timeout as a duration
 func (c *PrometheusConfig) GetPrometheusTimeout() time.Duration {
 	return time.Duration(c.TimeoutSeconds) * time.Second
 }
 
+// ParseHeaders splits the "Name=Value" header pairs into a map
+func (c *PrometheusConfig) ParseHeaders() (map[string]string, error) {
+	headers := make(map[string]string, len(c.Headers))
+	for _, header := range c.Headers {
+		name, value, ok := strings.Cut(header, "=")
+		if name = strings.TrimSpace(name); !ok || name == "" {
+			return nil, fmt.Errorf("invalid prometheus header %q, expected Name=Value", header)
+		}
+		headers[name] = strings.TrimSpace(value)
+	}
+	return headers, nil
+}
+
+// ParseRetryStatusCodes converts the retryable status codes to integers
+func (c *PrometheusConfig) ParseRetryStatusCodes() ([]int, error) {
+	codes := make([]int, 0, len(c.RetryStatusCodes))
+	for _, value := range c.RetryStatusCodes {
+		code, err := strconv.Atoi(value)
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid prometheus retry status code %q", value)
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// ParseTrustedProxies converts the trusted proxies to prefixes, a bare IP
+// being a prefix of one address
+func (c *RateLimitConfig) ParseTrustedProxies() ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(c.TrustedProxies))
+	for _, value := range c.TrustedProxies {
+		value = strings.TrimSpace(value)
+		if strings.Contains(value, "/") {
+			prefix, err := netip.ParsePrefix(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid rate limit trusted proxy %q", value)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate limit trusted proxy %q", value)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
 // GetCacheTTL returns the cache TTL as a duration
 func (c *CacheConfig) GetCacheTTL() time.Duration {
 	return time.Duration(c.TTLSeconds) * time.Second
-}
\ No newline at end of file
+}
+
+// CompileHiddenPatterns compiles the hidden metric patterns, anchored so each
+// must match the whole metric name
+func (c *MetricsConfig) CompileHiddenPatterns() ([]*regexp.Regexp, error) {
+	patterns := make([]*regexp.Regexp, 0, len(c.HiddenPatterns))
+	for _, pattern := range c.HiddenPatterns {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid hidden metric pattern %q: %w", pattern, err)
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns, nil
+}
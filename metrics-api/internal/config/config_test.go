@@ -1,7 +1,9 @@
 package config
 
 import (
+	"net/netip"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -24,20 +26,105 @@ func TestDefaultConfig(t *testing.T) {
 	assert.Equal(t, 5, config.Server.ReadTimeoutSeconds, "Default read timeout should be 5 seconds")
 	assert.Equal(t, 10, config.Server.WriteTimeoutSeconds, "Default write timeout should be 10 seconds")
 	assert.Equal(t, 120, config.Server.IdleTimeoutSeconds, "Default idle timeout should be 120 seconds")
+	assert.Equal(t, int64(1<<20), config.Server.MaxBodyBytes, "Default max body should be 1 MiB")
+	assert.Equal(t, int64(10<<20), config.Server.MaxDecompressedBodyBytes, "Default max decompressed body should be 10 MiB")
+	assert.Equal(t, 50, config.Server.MaxBatchQueries, "Default max batch queries should be 50")
+	assert.Equal(t, 5, config.Server.BatchMaxConcurrency, "Default batch max concurrency should be 5")
+	assert.Equal(t, 1000, config.Server.QueryHistorySize, "Default query history size should be 1000")
+	assert.Equal(t, 15*time.Second, config.Server.StreamHeartbeatInterval, "Default stream heartbeat interval should be 15s")
+	assert.Equal(t, time.Hour, config.Server.StreamMaxDuration, "Default stream max duration should be 1h")
+	assert.Equal(t, 6*time.Hour, config.Server.StreamChunkDuration, "Default stream chunk duration should be 6h")
+	assert.Equal(t, 10*time.Second, config.Server.DrainTimeout, "Default drain timeout should be 10s")
+	assert.Equal(t, 5*time.Second, config.Server.ShutdownTimeout, "Default shutdown timeout should be 5s")
+	assert.Equal(t, "full", config.Server.ErrorDetail, "Errors should be returned in full by default")
+	assert.False(t, config.Server.EnablePprof, "pprof should be disabled by default")
+	assert.False(t, config.Server.GenerateOpenAPI, "The OpenAPI spec should not be written by default")
 
 	// Check Prometheus defaults
 	assert.Equal(t, "http://prometheus:9090", config.Prometheus.URL, "Default Prometheus URL should be http://prometheus:9090")
 	assert.Equal(t, 30, config.Prometheus.TimeoutSeconds, "Default Prometheus timeout should be 30 seconds")
 	assert.Equal(t, 11000, config.Prometheus.MaxQueryPoints, "Default max query points should be 11000")
+	assert.Equal(t, 5000, config.Prometheus.QueryCostWarnThreshold, "Default query cost warn threshold should be 5000")
+	assert.Equal(t, 256, config.Prometheus.MaxLabelValueLength, "Default max label value length should be 256")
+	assert.Equal(t, "", config.Prometheus.UserAgent, "Default user agent should be empty so the build version is used")
+	assert.Empty(t, config.Prometheus.AdditionalURLs, "No additional Prometheus targets should be configured by default")
+	assert.Equal(t, 2, config.Prometheus.TransportRetries, "Default transport retries should be 2")
+	assert.Equal(t, 100*time.Millisecond, config.Prometheus.TransportRetryBackoff, "Default transport retry backoff should be 100ms")
+	assert.Zero(t, config.Prometheus.MinStep, "No minimum step should be enforced by default")
+	assert.Equal(t, "clamp", config.Prometheus.MinStepPolicy, "Default min step policy should be clamp")
+	assert.Zero(t, config.Prometheus.CircuitBreakerThreshold, "Circuit breaker should be disabled by default")
+	assert.Equal(t, 30*time.Second, config.Prometheus.CircuitBreakerCooldown, "Default circuit breaker cooldown should be 30s")
+	assert.Equal(t, 1, config.Prometheus.CircuitBreakerSuccesses, "One successful probe should close the circuit breaker by default")
+	assert.Equal(t, 3, config.Prometheus.RetryMaxAttempts, "Default retry max attempts should be 3")
+	assert.Empty(t, config.Prometheus.URLs, "Failover URLs should be unset by default")
+	assert.Equal(t, "primary", config.Prometheus.FailoverStrategy, "Default failover strategy should be primary")
+	assert.Equal(t, 30*time.Second, config.Prometheus.FailoverCooldown, "Default failover cooldown should be 30s")
+	assert.Empty(t, config.Prometheus.Sources, "No extra Prometheus sources should be configured by default")
+	assert.Equal(t, "default", config.Prometheus.DefaultSource, "Default source name should be default")
+	assert.Equal(t, 200*time.Millisecond, config.Prometheus.RetryInitialBackoff, "Default retry initial backoff should be 200ms")
+	assert.Equal(t, 5*time.Second, config.Prometheus.RetryMaxBackoff, "Default retry max backoff should be 5s")
+	codes, err := config.Prometheus.ParseRetryStatusCodes()
+	assert.NoError(t, err)
+	assert.Equal(t, []int{429, 502, 503, 504}, codes, "Default retry status codes should be 429, 502, 503 and 504")
+	assert.Empty(t, config.Prometheus.BearerToken, "No bearer token should be set by default")
+	assert.Empty(t, config.Prometheus.Username, "No username should be set by default")
+	assert.Empty(t, config.Prometheus.Headers, "No extra headers should be set by default")
+	assert.Empty(t, config.Prometheus.TLSCAFile, "No custom CA should be set by default")
+	assert.False(t, config.Prometheus.TLSInsecureSkipVerify, "TLS verification should be on by default")
 
 	// Check logging defaults
 	assert.Equal(t, "info", config.Logging.Level, "Default log level should be info")
 	assert.Equal(t, "json", config.Logging.Format, "Default log format should be json")
+	assert.True(t, config.Logging.Caller, "Caller should be enabled by default")
+	assert.Equal(t, "error", config.Logging.StacktraceLevel, "Default stacktrace level should be error")
 
 	// Check cache defaults
 	assert.Equal(t, true, config.Cache.Enabled, "Cache should be enabled by default")
 	assert.Equal(t, 60, config.Cache.TTLSeconds, "Default cache TTL should be 60 seconds")
 	assert.Equal(t, 1000, config.Cache.MaxSizeItems, "Default cache max size should be 1000 items")
+	assert.Empty(t, config.Cache.PersistPath, "Cache should not be persisted by default")
+	assert.Equal(t, "memory", config.Cache.Backend, "Cache should be kept in memory by default")
+
+	// Check health defaults
+	assert.Equal(t, 5*time.Second, config.Health.DetailedTimeout, "Default detailed health timeout should be 5 seconds")
+	assert.Equal(t, 2*time.Second, config.Health.ReadinessTimeout, "Default readiness timeout should be 2 seconds")
+	assert.Equal(t, 5*time.Second, config.Health.CheckTimeout, "Default health check timeout should be 5 seconds")
+	assert.Equal(t, 15*time.Second, config.Health.CheckInterval, "Default health check interval should be 15 seconds")
+	assert.Equal(t, 0.9, config.Health.MemoryThreshold, "Default health memory threshold should be 0.9")
+	assert.Equal(t, 10000, config.Health.GoroutineThreshold, "Default health goroutine threshold should be 10000")
+
+	// Check alerts defaults
+	assert.Equal(t, 15*time.Second, config.Alerts.PollInterval, "Default alerts poll interval should be 15 seconds")
+
+	// Check metric health defaults
+	assert.Equal(t, 5*time.Minute, config.Metrics.StalenessThreshold, "Default staleness threshold should be 5 minutes")
+	assert.Equal(t, time.Minute, config.Metrics.ScrapeInterval, "Default scrape interval should be 1 minute")
+	assert.Equal(t, time.Hour, config.Metrics.AnomalyWindow, "Default anomaly window should be 1 hour")
+	assert.Equal(t, 0, config.Metrics.MaxSeriesPerMetric, "Cardinality alerts should be disabled by default")
+
+	// Check compression defaults
+	assert.True(t, config.Compression.Enabled, "Compression should be enabled by default")
+	assert.Equal(t, -1, config.Compression.Level, "Default compression level should be the gzip default")
+	assert.Equal(t, []string{"application/json", "text/*"}, config.Compression.ContentTypes, "Default compressed types should be JSON and text")
+	assert.Equal(t, 1024, config.Compression.MinSize, "Responses under 1KB should not be compressed by default")
+
+	// Check CORS defaults
+	assert.Equal(t, []string{"*"}, config.CORS.AllowedOrigins, "Every origin should be allowed by default")
+	assert.True(t, config.CORS.AllowCredentials, "Credentials should be allowed by default")
+	assert.Equal(t, 3600, config.CORS.MaxAgeSecs, "Default preflight max age should be an hour")
+
+	// Check rate limit defaults
+	assert.False(t, config.RateLimit.Enabled, "Rate limiting should be disabled by default")
+	assert.Equal(t, 10.0, config.RateLimit.RequestsPerSecond, "Default rate limit should be 10 requests per second")
+	assert.Equal(t, 20, config.RateLimit.Burst, "Default rate limit burst should be 20")
+	assert.Equal(t, 10*time.Minute, config.RateLimit.CleanupInterval, "Default rate limiter cleanup interval should be 10 minutes")
+	assert.Empty(t, config.RateLimit.TrustedProxies, "No proxies should be trusted by default")
+
+	// Check auth and Vault defaults
+	assert.Empty(t, config.Auth.JWTSecret, "No JWT secret should be set by default")
+	assert.False(t, config.Auth.Enabled, "API key auth should be disabled by default")
+	assert.Empty(t, config.Vault.Address, "Vault should not be used by default")
+	assert.Equal(t, "secret", config.Vault.MountPath, "Default Vault mount path should be secret")
 }
 
 // TestEnvironmentOverrides tests that environment variables correctly override defaults
@@ -58,6 +145,11 @@ func TestEnvironmentOverrides(t *testing.T) {
 	os.Setenv("CACHE_ENABLED", "false")
 	os.Setenv("CACHE_TTL", "120")
 	os.Setenv("CACHE_MAX_SIZE", "2000")
+	os.Setenv("HEALTH_DETAILED_TIMEOUT", "3s")
+	os.Setenv("HEALTH_READINESS_TIMEOUT", "500ms")
+	os.Setenv("HEALTH_CHECK_TIMEOUT", "1s")
+	os.Setenv("METRICS_STALENESS_THRESHOLD", "2m")
+	os.Setenv("METRICS_SCRAPE_INTERVAL", "15s")
 
 	// Cleanup environment after test
 	defer clearEnvironmentVars()
@@ -86,6 +178,15 @@ func TestEnvironmentOverrides(t *testing.T) {
 	assert.Equal(t, false, config.Cache.Enabled, "Cache enabled should be overridden by environment")
 	assert.Equal(t, 120, config.Cache.TTLSeconds, "Cache TTL should be overridden by environment")
 	assert.Equal(t, 2000, config.Cache.MaxSizeItems, "Cache max size should be overridden by environment")
+
+	// Check health settings
+	assert.Equal(t, 3*time.Second, config.Health.DetailedTimeout, "Detailed health timeout should be overridden by environment")
+	assert.Equal(t, 500*time.Millisecond, config.Health.ReadinessTimeout, "Readiness timeout should be overridden by environment")
+	assert.Equal(t, time.Second, config.Health.CheckTimeout, "Health check timeout should be overridden by environment")
+
+	// Check metric health settings
+	assert.Equal(t, 2*time.Minute, config.Metrics.StalenessThreshold, "Staleness threshold should be overridden by environment")
+	assert.Equal(t, 15*time.Second, config.Metrics.ScrapeInterval, "Scrape interval should be overridden by environment")
 }
 
 // TestInvalidConfig tests validation of the configuration
@@ -112,6 +213,170 @@ func TestInvalidConfig(t *testing.T) {
 	assert.Nil(t, config, "Config should be nil when validation fails")
 }
 
+// TestHiddenMetricPatterns tests parsing and validation of hidden metric patterns
+func TestHiddenMetricPatterns(t *testing.T) {
+	clearEnvironmentVars()
+	defer clearEnvironmentVars()
+
+	os.Setenv("METRICS_HIDDEN_PATTERNS", "go_.*, process_.*,scrape_.*")
+	config, err := Load()
+	require.NoError(t, err, "Load() should accept valid hidden metric patterns")
+	assert.Equal(t, []string{"go_.*", "process_.*", "scrape_.*"}, config.Metrics.HiddenPatterns)
+
+	patterns, err := config.Metrics.CompileHiddenPatterns()
+	require.NoError(t, err)
+	require.Len(t, patterns, 3)
+	assert.True(t, patterns[0].MatchString("go_goroutines"), "Pattern should match the whole metric name")
+	assert.False(t, patterns[0].MatchString("http_go_requests"), "Pattern should be anchored")
+
+	os.Setenv("METRICS_HIDDEN_PATTERNS", "go_(")
+	_, err = Load()
+	assert.Error(t, err, "Load() should return an error with an invalid hidden metric pattern")
+}
+
+// TestRateLimitTrustedProxies tests parsing and validation of the proxies
+// whose X-Forwarded-For the rate limiter believes
+func TestRateLimitTrustedProxies(t *testing.T) {
+	clearEnvironmentVars()
+	defer clearEnvironmentVars()
+
+	os.Setenv("RATE_LIMIT_TRUSTED_PROXIES", "10.0.0.0/8, 192.168.1.10,::1")
+	config, err := Load()
+	require.NoError(t, err, "Load() should accept valid trusted proxies")
+
+	prefixes, err := config.RateLimit.ParseTrustedProxies()
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.168.1.10/32"),
+		netip.MustParsePrefix("::1/128"),
+	}, prefixes)
+
+	os.Setenv("RATE_LIMIT_TRUSTED_PROXIES", "10.0.0.0/33")
+	_, err = Load()
+	assert.Error(t, err, "Load() should return an error with an invalid trusted proxy")
+}
+
+// TestPrometheusSources tests parsing and validation of named Prometheus sources
+func TestPrometheusSources(t *testing.T) {
+	clearEnvironmentVars()
+	defer clearEnvironmentVars()
+
+	os.Setenv("PROMETHEUS_DEFAULT_SOURCE", "us")
+	os.Setenv("PROMETHEUS_SOURCES", "eu=http://prom-eu:9090, apac = http://prom-apac:9090")
+	config, err := Load()
+	require.NoError(t, err, "Load() should accept valid Prometheus sources")
+	assert.Equal(t, "us", config.Prometheus.DefaultSource)
+	assert.Equal(t, map[string]string{
+		"eu":   "http://prom-eu:9090",
+		"apac": "http://prom-apac:9090",
+	}, config.Prometheus.Sources)
+
+	os.Setenv("PROMETHEUS_SOURCES", "eu")
+	_, err = Load()
+	assert.Error(t, err, "Load() should return an error for a source without a URL")
+
+	os.Setenv("PROMETHEUS_SOURCES", "us=http://prom-us:9090")
+	_, err = Load()
+	assert.Error(t, err, "Load() should return an error for a source named like the default source")
+}
+
+// TestPrometheusCredentials tests reading and validation of Prometheus
+// credentials and TLS files
+func TestPrometheusCredentials(t *testing.T) {
+	clearEnvironmentVars()
+	defer clearEnvironmentVars()
+
+	os.Setenv("PROMETHEUS_USERNAME", "grafana")
+	os.Setenv("PROMETHEUS_PASSWORD", "secret")
+	os.Setenv("PROMETHEUS_HEADERS", "X-Scope-OrgID=team-a, X-Token=abc==")
+	config, err := Load()
+	require.NoError(t, err, "Load() should accept basic auth with headers")
+	assert.Equal(t, "grafana", config.Prometheus.Username)
+	assert.Equal(t, "secret", config.Prometheus.Password)
+
+	headers, err := config.Prometheus.ParseHeaders()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"X-Scope-OrgID": "team-a", "X-Token": "abc=="}, headers)
+
+	os.Setenv("PROMETHEUS_BEARER_TOKEN", "token")
+	_, err = Load()
+	assert.Error(t, err, "Load() should reject a bearer token together with basic auth")
+
+	os.Unsetenv("PROMETHEUS_BEARER_TOKEN")
+	os.Setenv("PROMETHEUS_HEADERS", "X-Scope-OrgID")
+	_, err = Load()
+	assert.Error(t, err, "Load() should reject a header without a value")
+
+	os.Unsetenv("PROMETHEUS_HEADERS")
+	os.Setenv("PROMETHEUS_TLS_CERT_FILE", "/etc/prometheus/client.crt")
+	_, err = Load()
+	assert.Error(t, err, "Load() should reject a client certificate without a key")
+}
+
+func TestPrometheusFailoverURLs(t *testing.T) {
+	clearEnvironmentVars()
+	defer clearEnvironmentVars()
+
+	os.Setenv("PROMETHEUS_URL", "http://ignored:9090")
+	os.Setenv("PROMETHEUS_URLS", "http://prom-a:9090, http://prom-b:9090")
+	os.Setenv("PROMETHEUS_FAILOVER_STRATEGY", "round-robin")
+	config, err := Load()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"http://prom-a:9090", "http://prom-b:9090"}, config.Prometheus.URLs)
+	assert.Equal(t, "http://prom-a:9090", config.Prometheus.URL, "The first failover URL should replace PROMETHEUS_URL")
+	assert.Equal(t, "round-robin", config.Prometheus.FailoverStrategy)
+
+	os.Setenv("PROMETHEUS_FAILOVER_STRATEGY", "random")
+	_, err = Load()
+	assert.Error(t, err, "Load() should reject an unknown failover strategy")
+}
+
+// TestCacheBackend tests selecting the Redis cache backend
+func TestCacheBackend(t *testing.T) {
+	clearEnvironmentVars()
+	defer clearEnvironmentVars()
+
+	os.Setenv("CACHE_BACKEND", "redis")
+	_, err := Load()
+	assert.Error(t, err, "Load() should require an address for the redis backend")
+
+	os.Setenv("CACHE_REDIS_ADDR", "redis:6379")
+	os.Setenv("CACHE_REDIS_PREFIX", "dashboard:")
+	config, err := Load()
+	require.NoError(t, err)
+	assert.Equal(t, "redis", config.Cache.Backend)
+	assert.Equal(t, "redis:6379", config.Cache.RedisAddr)
+	assert.Equal(t, "dashboard:", config.Cache.RedisPrefix)
+
+	os.Setenv("CACHE_BACKEND", "memcached")
+	_, err = Load()
+	assert.Error(t, err, "Load() should reject an unknown cache backend")
+}
+
+// TestAPIKeys tests loading and validating the API keys
+func TestAPIKeys(t *testing.T) {
+	clearEnvironmentVars()
+	defer clearEnvironmentVars()
+
+	os.Setenv("AUTH_ENABLED", "true")
+	_, err := Load()
+	assert.Error(t, err, "Load() should require an API key when auth is enabled")
+
+	os.Setenv("AUTH_API_KEYS", "grafana=abc123==, ci=def456")
+	config, err := Load()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"grafana": "abc123==", "ci": "def456"}, config.Auth.APIKeys)
+
+	os.Setenv("AUTH_API_KEYS", "grafana=abc123,ci=abc123")
+	_, err = Load()
+	assert.Error(t, err, "Load() should reject users sharing an API key")
+
+	os.Setenv("AUTH_API_KEYS", "grafana=")
+	_, err = Load()
+	assert.Error(t, err, "Load() should reject an empty API key")
+}
+
 // TestNonNumericEnvVars tests handling of non-numeric values in numeric environment variables
 func TestNonNumericEnvVars(t *testing.T) {
 	// Clear environment variables first
@@ -207,26 +472,251 @@ func TestOverrideSubset(t *testing.T) {
 }
 
 // Helper function to clear all environment variables used by the config
+// writeConfigFile writes content to a file of the given name in a temporary
+// directory and returns its path
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+// TestConfigFile tests that a partial config file sets its fields, leaves
+// the rest at their defaults and is overridden by environment variables
+func TestConfigFile(t *testing.T) {
+	clearEnvironmentVars()
+	defer clearEnvironmentVars()
+
+	files := map[string]string{
+		"config.yaml": `
+server:
+  port: 9000
+  stream_max_duration: 30m
+prometheus:
+  url: http://prometheus-file:9090
+  headers: ["X-Scope-OrgID=team-a"]
+logging:
+  level: debug
+`,
+		"config.json": `{
+  "server": {"port": 9000, "stream_max_duration": "30m"},
+  "prometheus": {"url": "http://prometheus-file:9090", "headers": ["X-Scope-OrgID=team-a"]},
+  "logging": {"level": "debug"}
+}`,
+		"config.toml": `
+[server]
+port = 9000
+stream_max_duration = "30m"
+
+[prometheus]
+url = "http://prometheus-file:9090"
+headers = ["X-Scope-OrgID=team-a"]
+
+[logging]
+level = "debug"
+`,
+	}
+
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			clearEnvironmentVars()
+			path := writeConfigFile(t, name, content)
+
+			config, err := LoadFromFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, 9000, config.Server.Port)
+			assert.Equal(t, 30*time.Minute, config.Server.StreamMaxDuration)
+			assert.Equal(t, "http://prometheus-file:9090", config.Prometheus.URL)
+			assert.Equal(t, []string{"X-Scope-OrgID=team-a"}, config.Prometheus.Headers)
+			assert.Equal(t, "debug", config.Logging.Level)
+			assert.Equal(t, 5, config.Server.ReadTimeoutSeconds, "Fields left out of the file should keep their defaults")
+			assert.Equal(t, "json", config.Logging.Format, "Fields left out of the file should keep their defaults")
+
+			os.Setenv("CONFIG_FILE", path)
+			os.Setenv("SERVER_PORT", "9100")
+			os.Setenv("LOG_FORMAT", "console")
+			config, err = Load()
+			require.NoError(t, err)
+			assert.Equal(t, 9100, config.Server.Port, "Environment variables should override the file")
+			assert.Equal(t, "console", config.Logging.Format, "Environment variables should override the defaults")
+			assert.Equal(t, "http://prometheus-file:9090", config.Prometheus.URL, "File values should be kept without an override")
+			assert.Equal(t, 30*time.Minute, config.Server.StreamMaxDuration, "File values should be kept without an override")
+		})
+	}
+}
+
+// TestConfigFileMissing tests that a CONFIG_FILE that does not exist is
+// optional for Load but an error for LoadFromFile
+func TestConfigFileMissing(t *testing.T) {
+	clearEnvironmentVars()
+	defer clearEnvironmentVars()
+
+	path := filepath.Join(t.TempDir(), "missing.yaml")
+	os.Setenv("CONFIG_FILE", path)
+	config, err := Load()
+	require.NoError(t, err, "A missing config file should fall back to defaults")
+	assert.Equal(t, 8080, config.Server.Port)
+
+	_, err = LoadFromFile(path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+// TestConfigFileInvalid tests that malformed files, unknown keys and
+// unsupported extensions are reported
+func TestConfigFileInvalid(t *testing.T) {
+	clearEnvironmentVars()
+	defer clearEnvironmentVars()
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		message string
+	}{
+		{name: "malformed yaml", file: "config.yaml", content: "server:\n  port: [9000\n", message: "error parsing config file"},
+		{name: "wrong type", file: "config.yml", content: "server:\n  port: nine\n", message: "error parsing config file"},
+		{name: "unknown yaml key", file: "config.yaml", content: "server:\n  prot: 9000\n", message: "prot"},
+		{name: "malformed toml", file: "config.toml", content: "[server\nport = 9000\n", message: "error parsing config file"},
+		{name: "unknown toml key", file: "config.toml", content: "[server]\nprot = 9000\n", message: "unknown key server.prot"},
+		{name: "malformed json", file: "config.json", content: `{"server": {"port": 9000}`, message: "error parsing config file"},
+		{name: "unknown json key", file: "config.json", content: `{"server": {"prot": 9000}}`, message: "prot"},
+		{name: "unsupported extension", file: "config.ini", content: "[server]\nport = 9000\n", message: "extension must be .yaml, .yml, .json or .toml"},
+		{name: "invalid value", file: "config.yaml", content: "server:\n  port: -1\n", message: "server port must be positive"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfigFile(t, tt.file, tt.content)
+			_, err := LoadFromFile(path)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.message)
+
+			os.Setenv("CONFIG_FILE", path)
+			_, err = Load()
+			assert.Error(t, err, "Load() should report the same error")
+		})
+	}
+}
+
 func clearEnvironmentVars() {
+	// Config file
+	os.Unsetenv("CONFIG_FILE")
+
+	// Auth and Vault config
+	os.Unsetenv("JWT_SECRET")
+	os.Unsetenv("AUTH_ENABLED")
+	os.Unsetenv("AUTH_API_KEYS")
+	os.Unsetenv("VAULT_ADDR")
+	os.Unsetenv("VAULT_TOKEN")
+	os.Unsetenv("VAULT_MOUNT_PATH")
+	os.Unsetenv("VAULT_SECRET_PATH")
+
 	// Server config
 	os.Unsetenv("SERVER_PORT")
 	os.Unsetenv("SERVER_READ_TIMEOUT")
 	os.Unsetenv("SERVER_WRITE_TIMEOUT")
 	os.Unsetenv("SERVER_IDLE_TIMEOUT")
+	os.Unsetenv("SERVER_MAX_BODY_BYTES")
+	os.Unsetenv("SERVER_MAX_DECOMPRESSED_BODY_BYTES")
+	os.Unsetenv("SERVER_MAX_BATCH_QUERIES")
+	os.Unsetenv("BATCH_MAX_CONCURRENCY")
+	os.Unsetenv("SERVER_QUERY_HISTORY_SIZE")
+	os.Unsetenv("SERVER_STREAM_HEARTBEAT_INTERVAL")
+	os.Unsetenv("SERVER_STREAM_MAX_DURATION")
+	os.Unsetenv("SERVER_DRAIN_TIMEOUT")
+	os.Unsetenv("SERVER_SHUTDOWN_TIMEOUT")
+	os.Unsetenv("STREAM_CHUNK_DURATION")
+	os.Unsetenv("SERVER_ERROR_DETAIL")
+	os.Unsetenv("ENABLE_PPROF")
+	os.Unsetenv("GENERATE_OPENAPI")
 
 	// Prometheus config
 	os.Unsetenv("PROMETHEUS_URL")
 	os.Unsetenv("PROMETHEUS_TIMEOUT")
 	os.Unsetenv("PROMETHEUS_MAX_QUERY_POINTS")
+	os.Unsetenv("PROMETHEUS_QUERY_COST_WARN_THRESHOLD")
+	os.Unsetenv("PROMETHEUS_MAX_LABEL_VALUE_LENGTH")
+	os.Unsetenv("PROMETHEUS_USER_AGENT")
+	os.Unsetenv("PROMETHEUS_ERROR_HISTORY")
+	os.Unsetenv("PROMETHEUS_ADDITIONAL_URLS")
+	os.Unsetenv("PROMETHEUS_TRANSPORT_RETRIES")
+	os.Unsetenv("PROMETHEUS_TRANSPORT_RETRY_BACKOFF")
+	os.Unsetenv("PROMETHEUS_MIN_STEP")
+	os.Unsetenv("PROMETHEUS_MIN_STEP_POLICY")
+	os.Unsetenv("PROMETHEUS_CIRCUIT_BREAKER_THRESHOLD")
+	os.Unsetenv("PROMETHEUS_CIRCUIT_BREAKER_COOLDOWN")
+	os.Unsetenv("PROMETHEUS_CIRCUIT_BREAKER_SUCCESS_THRESHOLD")
+	os.Unsetenv("PROMETHEUS_RETRY_MAX_ATTEMPTS")
+	os.Unsetenv("PROMETHEUS_URLS")
+	os.Unsetenv("PROMETHEUS_FAILOVER_STRATEGY")
+	os.Unsetenv("PROMETHEUS_FAILOVER_COOLDOWN")
+	os.Unsetenv("PROMETHEUS_SOURCES")
+	os.Unsetenv("PROMETHEUS_DEFAULT_SOURCE")
+	os.Unsetenv("PROMETHEUS_RETRY_INITIAL_BACKOFF")
+	os.Unsetenv("PROMETHEUS_RETRY_MAX_BACKOFF")
+	os.Unsetenv("PROMETHEUS_RETRY_STATUS_CODES")
+	os.Unsetenv("PROMETHEUS_BEARER_TOKEN")
+	os.Unsetenv("PROMETHEUS_USERNAME")
+	os.Unsetenv("PROMETHEUS_PASSWORD")
+	os.Unsetenv("PROMETHEUS_HEADERS")
+	os.Unsetenv("PROMETHEUS_TLS_CA_FILE")
+	os.Unsetenv("PROMETHEUS_TLS_CERT_FILE")
+	os.Unsetenv("PROMETHEUS_TLS_KEY_FILE")
+	os.Unsetenv("PROMETHEUS_TLS_INSECURE_SKIP_VERIFY")
 
 	// Logging config
 	os.Unsetenv("LOG_LEVEL")
 	os.Unsetenv("LOG_FORMAT")
+	os.Unsetenv("LOG_CALLER")
+	os.Unsetenv("LOG_STACKTRACE_LEVEL")
 
 	// Cache config
 	os.Unsetenv("CACHE_ENABLED")
 	os.Unsetenv("CACHE_TTL")
 	os.Unsetenv("CACHE_MAX_SIZE")
+	os.Unsetenv("CACHE_PERSIST_PATH")
+	os.Unsetenv("CACHE_BACKEND")
+	os.Unsetenv("CACHE_REDIS_ADDR")
+	os.Unsetenv("CACHE_REDIS_PASSWORD")
+	os.Unsetenv("CACHE_REDIS_PREFIX")
+
+	// Health config
+	os.Unsetenv("HEALTH_DETAILED_TIMEOUT")
+	os.Unsetenv("HEALTH_READINESS_TIMEOUT")
+	os.Unsetenv("HEALTH_CHECK_TIMEOUT")
+	os.Unsetenv("HEALTH_CHECK_INTERVAL")
+	os.Unsetenv("HEALTH_MEMORY_THRESHOLD")
+	os.Unsetenv("HEALTH_GOROUTINE_THRESHOLD")
+
+	// Alerts config
+	os.Unsetenv("ALERTS_POLL_INTERVAL")
+
+	// Metrics config
+	os.Unsetenv("METRICS_HIDDEN_PATTERNS")
+	os.Unsetenv("METRICS_STALENESS_THRESHOLD")
+	os.Unsetenv("METRICS_SCRAPE_INTERVAL")
+	os.Unsetenv("METRICS_ANOMALY_WINDOW")
+	os.Unsetenv("METRICS_MAX_SERIES_PER_METRIC")
+
+	// Compression config
+	os.Unsetenv("COMPRESSION_ENABLED")
+	os.Unsetenv("COMPRESSION_LEVEL")
+	os.Unsetenv("COMPRESSION_CONTENT_TYPES")
+	os.Unsetenv("COMPRESSION_MIN_SIZE")
+
+	// CORS config
+	os.Unsetenv("CORS_ALLOWED_ORIGINS")
+	os.Unsetenv("CORS_ALLOWED_METHODS")
+	os.Unsetenv("CORS_ALLOWED_HEADERS")
+	os.Unsetenv("CORS_ALLOW_CREDENTIALS")
+	os.Unsetenv("CORS_MAX_AGE_SECS")
+
+	// Rate limit config
+	os.Unsetenv("RATE_LIMIT_ENABLED")
+	os.Unsetenv("RATE_LIMIT_REQUESTS_PER_SECOND")
+	os.Unsetenv("RATE_LIMIT_BURST")
+	os.Unsetenv("RATE_LIMIT_CLEANUP_INTERVAL")
+	os.Unsetenv("RATE_LIMIT_TRUSTED_PROXIES")
 }
 
 // TestDotEnvLoading tests loading configuration from a .env file
@@ -266,4 +756,23 @@ LOG_LEVEL=debug
 	assert.Equal(t, 7000, config.Server.Port, "Server port should be loaded from .env file")
 	assert.Equal(t, "http://prometheus-test:9090", config.Prometheus.URL, "Prometheus URL should be loaded from .env file")
 	assert.Equal(t, "debug", config.Logging.Level, "Log level should be loaded from .env file")
-}
\ No newline at end of file
+}
+
+// TestReloadable tests splitting configuration changes into live and
+// restart-only settings
+func TestReloadable(t *testing.T) {
+	current := defaultConfig()
+	next := defaultConfig()
+	next.Logging.Level = "debug"
+	next.Cache.TTLSeconds = 120
+	next.Server.Port = 9000
+	next.Prometheus.Headers = []string{"X-Scope-OrgID=team-a"}
+
+	live, restart := current.Reloadable(next)
+	assert.Equal(t, []string{"logging.level", "cache.ttl_seconds"}, live)
+	assert.Equal(t, []string{"server.port", "prometheus.headers"}, restart)
+
+	live, restart = current.Reloadable(defaultConfig())
+	assert.Empty(t, live, "Identical configurations should have no changes")
+	assert.Empty(t, restart, "Identical configurations should have no changes")
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// liveSettings are the settings a running server applies when the
+// configuration is reloaded, by their dotted file keys
+var liveSettings = map[string]bool{
+	"logging.level":              true,
+	"logging.format":             true,
+	"cache.ttl_seconds":          true,
+	"prometheus.timeout_seconds": true,
+}
+
+// Reloadable compares c with next, a freshly loaded configuration, and
+// returns the dotted file keys of the settings that changed, split into
+// those a running server applies and those that need a restart
+func (c *Config) Reloadable(next *Config) (live, restart []string) {
+	for _, setting := range changedSettings("", reflect.ValueOf(*c), reflect.ValueOf(*next)) {
+		if liveSettings[setting] {
+			live = append(live, setting)
+		} else {
+			restart = append(restart, setting)
+		}
+	}
+	return live, restart
+}
+
+// changedSettings lists the leaf fields that differ between the structs a
+// and b, named by their YAML keys under prefix
+func changedSettings(prefix string, a, b reflect.Value) []string {
+	var changed []string
+	for i := 0; i < a.NumField(); i++ {
+		field := a.Type().Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		name = prefix + name
+
+		if field.Type.Kind() == reflect.Struct {
+			changed = append(changed, changedSettings(name+".", a.Field(i), b.Field(i))...)
+		} else if !reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
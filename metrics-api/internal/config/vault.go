@@ -0,0 +1,76 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// vaultTimeout bounds reading the secret from Vault at startup
+const vaultTimeout = 10 * time.Second
+
+// vaultSecretFields maps the keys read from the Vault secret to the fields
+// they set. Every value must be a string; other keys in the secret are
+// ignored, so it can be shared with other services.
+//
+//	jwt_secret              Auth.JWTSecret
+//	prometheus_bearer_token Prometheus.BearerToken
+//	prometheus_username     Prometheus.Username
+//	prometheus_password     Prometheus.Password
+var vaultSecretFields = map[string]func(cfg *Config) *string{
+	"jwt_secret":              func(cfg *Config) *string { return &cfg.Auth.JWTSecret },
+	"prometheus_bearer_token": func(cfg *Config) *string { return &cfg.Prometheus.BearerToken },
+	"prometheus_username":     func(cfg *Config) *string { return &cfg.Prometheus.Username },
+	"prometheus_password":     func(cfg *Config) *string { return &cfg.Prometheus.Password },
+}
+
+// loadVaultSecrets reads the KV version 2 secret cfg.Vault points at and
+// sets the fields of the keys it holds, overriding the environment and
+// config file
+func loadVaultSecrets(cfg *Config) error {
+	vaultConfig := vault.DefaultConfig()
+	if vaultConfig.Error != nil {
+		return fmt.Errorf("error configuring vault client: %w", vaultConfig.Error)
+	}
+	vaultConfig.Address = cfg.Vault.Address
+	vaultConfig.Timeout = vaultTimeout
+
+	client, err := vault.NewClient(vaultConfig)
+	if err != nil {
+		return fmt.Errorf("error creating vault client: %w", err)
+	}
+	if cfg.Vault.Token != "" {
+		client.SetToken(cfg.Vault.Token)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), vaultTimeout)
+	defer cancel()
+
+	secret, err := client.KVv2(cfg.Vault.MountPath).Get(ctx, cfg.Vault.SecretPath)
+	if err != nil {
+		return fmt.Errorf("error reading vault secret %s/%s: %w", cfg.Vault.MountPath, cfg.Vault.SecretPath, err)
+	}
+
+	// Keys are applied in order so the first bad one is always reported
+	keys := make([]string, 0, len(secret.Data))
+	for key := range secret.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		field, ok := vaultSecretFields[key]
+		if !ok {
+			continue
+		}
+		value, ok := secret.Data[key].(string)
+		if !ok {
+			return fmt.Errorf("vault secret %s/%s: value of %s must be a string", cfg.Vault.MountPath, cfg.Vault.SecretPath, key)
+		}
+		*field(cfg) = value
+	}
+	return nil
+}
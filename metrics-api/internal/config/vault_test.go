@@ -0,0 +1,110 @@
+package config
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newVaultServer responds as a Vault KV version 2 engine mounted at kv,
+// serving data at kv/metrics-api to requests with the token root
+func newVaultServer(t *testing.T, data map[string]interface{}) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Header.Get("X-Vault-Token") != "root" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		if r.Method != http.MethodGet || r.URL.Path != "/v1/kv/data/metrics-api" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"data":     data,
+				"metadata": map[string]interface{}{"version": 3},
+			},
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// setVaultEnv points Load at the secret served by newVaultServer
+func setVaultEnv(server *httptest.Server) {
+	os.Setenv("VAULT_ADDR", server.URL)
+	os.Setenv("VAULT_TOKEN", "root")
+	os.Setenv("VAULT_MOUNT_PATH", "kv")
+	os.Setenv("VAULT_SECRET_PATH", "metrics-api")
+}
+
+// TestVaultSecrets tests that Load merges the Vault secret over the
+// environment, ignoring keys it does not know
+func TestVaultSecrets(t *testing.T) {
+	clearEnvironmentVars()
+	defer clearEnvironmentVars()
+
+	setVaultEnv(newVaultServer(t, map[string]interface{}{
+		"jwt_secret":          "vault-jwt-secret",
+		"prometheus_username": "grafana",
+		"prometheus_password": "vault-password",
+		"database_url":        "postgres://elsewhere",
+	}))
+	os.Setenv("JWT_SECRET", "env-jwt-secret")
+	os.Setenv("LOG_LEVEL", "debug")
+
+	config, err := Load()
+	require.NoError(t, err)
+	assert.Equal(t, "vault-jwt-secret", config.Auth.JWTSecret, "Vault should override the environment")
+	assert.Equal(t, "grafana", config.Prometheus.Username)
+	assert.Equal(t, "vault-password", config.Prometheus.Password)
+	assert.Empty(t, config.Prometheus.BearerToken, "Fields missing from the secret should be left alone")
+	assert.Equal(t, "debug", config.Logging.Level, "Other settings should still come from the environment")
+}
+
+// TestVaultSecretsErrors tests that failing to read the secret fails Load
+func TestVaultSecretsErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  map[string]interface{}
+		setup func()
+	}{
+		{
+			name:  "permission denied",
+			setup: func() { os.Setenv("VAULT_TOKEN", "wrong") },
+		},
+		{
+			name:  "missing secret",
+			setup: func() { os.Setenv("VAULT_SECRET_PATH", "other-service") },
+		},
+		{
+			name: "non-string value",
+			data: map[string]interface{}{"jwt_secret": 42},
+		},
+		{
+			name:  "no address",
+			setup: func() { os.Unsetenv("VAULT_ADDR") },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnvironmentVars()
+			defer clearEnvironmentVars()
+
+			setVaultEnv(newVaultServer(t, tt.data))
+			if tt.setup != nil {
+				tt.setup()
+			}
+			_, err := Load()
+			assert.Error(t, err)
+		})
+	}
+}
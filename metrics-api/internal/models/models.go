@@ -7,10 +7,17 @@ import (
 
 // Common errors
 var (
-	ErrInvalidQuery      = errors.New("invalid query")
-	ErrInvalidTimeRange  = errors.New("invalid time range")
-	ErrMetricNotFound    = errors.New("metric not found")
-	ErrTooManyDataPoints = errors.New("query would return too many data points")
+	ErrInvalidQuery       = errors.New("invalid query")
+	ErrInvalidTimeRange   = errors.New("invalid time range")
+	ErrMetricNotFound     = errors.New("metric not found")
+	ErrTooManyDataPoints  = errors.New("query would return too many data points")
+	ErrInvalidLabelValue  = errors.New("invalid label value")
+	ErrSavedQueryNotFound = errors.New("saved query not found")
+	ErrBaselineNotFound   = errors.New("baseline not found")
+	ErrExportJobNotFound  = errors.New("export job not found")
+	ErrTooManyExports     = errors.New("too many export jobs are running")
+	ErrBatchTooLarge      = errors.New("batch exceeds the maximum number of queries")
+	ErrStepTooSmall       = errors.New("step is below the minimum")
 )
 
 // QueryResponse represents the response from an instant query
@@ -19,13 +26,58 @@ type QueryResponse struct {
 	QueryTime time.Time   `json:"query_time"`
 	Status    string      `json:"status"`
 	Data      []DataPoint `json:"data"`
+	Version   string      `json:"version,omitempty"`
+	Delta     bool        `json:"delta,omitempty"`
+	Removed   []SeriesRef `json:"removed,omitempty"`
+	// TotalCount is the number of series before Limit was applied, and
+	// Truncated reports whether any were cut
+	TotalCount int  `json:"total_count"`
+	Truncated  bool `json:"truncated,omitempty"`
 }
 
-// DataPoint represents a single data point from a query
-type DataPoint struct {
+// QueryTable is an instant query result laid out as a table, one row per
+// series with a column for each label seen across all series and the value last
+type QueryTable struct {
+	Columns []string        `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+// BatchQueryParams is a set of instant queries executed in one request
+type BatchQueryParams struct {
+	Queries []InstantQueryParams `json:"queries"`
+}
+
+// RangeBatchParams is a set of range queries executed in one request
+type RangeBatchParams struct {
+	Queries []RangeBatchQuery `json:"queries"`
+}
+
+// RangeBatchQuery is a range query of a batch, identified by an ID chosen by
+// the client such as the dashboard panel it feeds
+type RangeBatchQuery struct {
+	ID string `json:"id"`
+	RangeQueryParams
+}
+
+// RangeBatchResult is the outcome of one range query of a batch
+type RangeBatchResult struct {
+	ID     string              `json:"id"`
+	Status string              `json:"status"`
+	Data   *RangeQueryResponse `json:"data,omitempty"`
+	Error  string              `json:"error,omitempty"`
+}
+
+// SeriesRef identifies a series without any of its values
+type SeriesRef struct {
 	MetricName string            `json:"metric_name"`
 	Labels     map[string]string `json:"labels"`
-	Value      float64           `json:"value"`
+}
+
+// DataPoint represents a single data point from a query
+type DataPoint struct {
+	MetricName string            `json:"metric_name" example:"up"`
+	Labels     map[string]string `json:"labels" example:"{\"job\":\"prometheus\",\"instance\":\"localhost:9090\"}"`
+	Value      float64           `json:"value" example:"1"`
 	Timestamp  time.Time         `json:"timestamp"`
 }
 type TopMetric struct {
@@ -34,6 +86,12 @@ type TopMetric struct {
     SampleRate  float64 `json:"sample_rate"`
 }
 
+// MetricCardinality is the number of series of a metric
+type MetricCardinality struct {
+	Name        string `json:"name"`
+	Cardinality int64  `json:"cardinality"`
+}
+
 // RangeQueryResponse represents the response from a range query
 type RangeQueryResponse struct {
 	Query  string       `json:"query"`
@@ -42,6 +100,53 @@ type RangeQueryResponse struct {
 	Step   time.Duration `json:"step"`
 	Status string       `json:"status"`
 	Series []TimeSeries `json:"series"`
+	// Warnings explains adjustments made to the request, such as a raised step
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// Formats a metric export can be downloaded in
+const (
+	ExportFormatJSON = "json"
+	ExportFormatCSV  = "csv"
+)
+
+// ExportRequest asks for the raw samples of a metric over a time range
+type ExportRequest struct {
+	Metric string        `json:"metric"`
+	Format string        `json:"format"`
+	Start  time.Time     `json:"start"`
+	End    time.Time     `json:"end"`
+	Step   time.Duration `json:"step"`
+}
+
+// ExportJobStatus is the lifecycle state of an export job
+type ExportJobStatus string
+
+const (
+	ExportJobRunning   ExportJobStatus = "running"
+	ExportJobCompleted ExportJobStatus = "completed"
+	ExportJobFailed    ExportJobStatus = "failed"
+	ExportJobCancelled ExportJobStatus = "cancelled"
+)
+
+// ExportJob tracks a range export running in the background
+type ExportJob struct {
+	ID          string              `json:"id"`
+	Query       string              `json:"query"`
+	Start       time.Time           `json:"start"`
+	End         time.Time           `json:"end"`
+	Step        time.Duration       `json:"step"`
+	Status      ExportJobStatus     `json:"status"`
+	ChunksDone  int                 `json:"chunks_done"`
+	ChunksTotal int                 `json:"chunks_total"`
+	Progress    float64             `json:"progress"`
+	Error       string              `json:"error,omitempty"`
+	CreatedAt   time.Time           `json:"created_at"`
+	FinishedAt  *time.Time          `json:"finished_at,omitempty"`
+	Result      *RangeQueryResponse `json:"result,omitempty"`
+
+	// Err is the error behind Error, kept for logging and never serialized
+	Err error `json:"-"`
 }
 
 // TimeSeries represents a time series of data points
@@ -59,16 +164,36 @@ type TimeValuePair struct {
 
 // InstantQueryParams represents parameters for an instant query
 type InstantQueryParams struct {
-	Query string    `json:"query"`
-	Time  time.Time `json:"time"`
+	Query        string    `json:"query" example:"up"`
+	Time         time.Time `json:"time"`
+	Diff         bool      `json:"diff,omitempty"`
+	SinceVersion string    `json:"since_version,omitempty"`
+	Limit        int       `json:"limit,omitempty"`
+	BypassCache  bool      `json:"-"`
+	KeepName     bool      `json:"-"`
 }
 
 // RangeQueryParams represents the parameters for a range query
 type RangeQueryParams struct {
-	Query string    `json:"query"`
-	Start time.Time `json:"start"`
-	End   time.Time `json:"end"`
-	Step  string    `json:"step"`
+	Query       string    `json:"query" example:"rate(http_requests_total[5m])"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Step        string    `json:"step" example:"1m"`
+	BypassCache bool      `json:"-"`
+	KeepName    bool      `json:"-"`
+	// AutoSplit runs a range with more points than allowed as consecutive
+	// sub-queries and merges their results instead of rejecting it
+	AutoSplit bool `json:"auto_split,omitempty"`
+}
+
+// QueryHistoryEntry records one executed query
+type QueryHistoryEntry struct {
+	Query       string    `json:"query"`
+	Type        string    `json:"type"`
+	ExecutedAt  time.Time `json:"executed_at"`
+	DurationMs  int64     `json:"duration_ms"`
+	ResultCount int       `json:"result_count"`
+	Error       bool      `json:"error"`
 }
 
 // QueryValidation represents the result of validating a query
@@ -76,13 +201,63 @@ type QueryValidation struct {
 	Query   string `json:"query"`
 	Valid   bool   `json:"valid"`
 	Message string `json:"message"`
+	// EstimatedSeries is how many series the query returns at its
+	// evaluation time, and EstimatedPoints how many samples that makes over
+	// the whole range
+	EstimatedSeries int64    `json:"estimated_series"`
+	EstimatedPoints int64    `json:"estimated_points"`
+	CostWarnings    []string `json:"cost_warnings,omitempty"`
+	// SyntaxError locates the first PromQL syntax error, nil when the
+	// query parses
+	SyntaxError *QuerySyntaxError `json:"syntax_error,omitempty"`
+}
+
+// QuerySyntaxError is where a query fails to parse. Line and Column are
+// 1-based; Start and End are the byte offsets of the offending text.
+type QuerySyntaxError struct {
+	Message string `json:"message"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Start   int    `json:"start"`
+	End     int    `json:"end"`
+}
+
+// SavedQuery is a named, reusable PromQL query
+type SavedQuery struct {
+	Name        string    `json:"name" example:"request-rate"`
+	Query       string    `json:"query" example:"sum(rate(http_requests_total[5m]))"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	// LastUsed is when the query was last run, nil if it never was
+	LastUsed *time.Time `json:"last_used,omitempty"`
+	// Parameters make the query a template whose {name} placeholders are
+	// filled in when it runs
+	Parameters []TemplateParameter `json:"parameters,omitempty"`
+}
+
+// QueryTemplate is a reusable query pattern such as rate({metric}[{window}])
+// whose placeholders are filled in at execution time
+type QueryTemplate struct {
+	Name       string              `json:"name"`
+	Template   string              `json:"template"`
+	Parameters []TemplateParameter `json:"parameters"`
+}
+
+// TemplateParameter is a placeholder of a query template
+type TemplateParameter struct {
+	Name string `json:"name"`
+	// Default is used when no value is given; without one the parameter is
+	// required
+	Default string `json:"default,omitempty"`
+	// Regex must match the whole value
+	Regex string `json:"regex,omitempty"`
 }
 
 // Alert represents a Prometheus alert
 type Alert struct {
-	Name        string            `json:"name"`
-	State       string            `json:"state"`
-	Severity    string            `json:"severity"`
+	Name        string            `json:"name" example:"HighErrorRate"`
+	State       string            `json:"state" example:"firing"`
+	Severity    string            `json:"severity" example:"critical"`
 	Labels      map[string]string `json:"labels"`
 	Annotations map[string]string `json:"annotations"`
 	Summary     string            `json:"summary"`
@@ -109,20 +284,132 @@ type AlertSummary struct {
 	LastUpdated       time.Time       `json:"last_updated"`
 }
 
+// AlertDiff lists how the current alerts differ from an earlier snapshot
+type AlertDiff struct {
+	Added   []Alert `json:"added,omitempty"`
+	Removed []Alert `json:"removed,omitempty"`
+	// Changed holds alerts present in both snapshots whose state changed
+	Changed []Alert `json:"changed,omitempty"`
+}
+
+// AlertFeedMessage is a message of the live alert feed: a snapshot of the
+// current alerts, an update with the changes since the previous message, or
+// an error
+type AlertFeedMessage struct {
+	Type   string  `json:"type"`
+	Alerts []Alert `json:"alerts,omitempty"`
+	AlertDiff
+	Error     string    `json:"error,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// MetricStreamMessage is a message of a live metric stream: the result of
+// the streamed query at Timestamp, or an error
+type MetricStreamMessage struct {
+	Timestamp time.Time   `json:"ts"`
+	Data      []DataPoint `json:"data"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// FlappingAlert is an alert that changed state repeatedly within a window
+type FlappingAlert struct {
+	Name           string            `json:"name"`
+	State          string            `json:"state"`
+	Severity       string            `json:"severity"`
+	Labels         map[string]string `json:"labels"`
+	Transitions    int               `json:"transitions"`
+	LastTransition time.Time         `json:"last_transition"`
+}
+
 // SeverityCount represents the count of alerts by severity
 type SeverityCount struct {
 	Severity string `json:"severity"`
 	Count    int    `json:"count"`
 }
 
+// RuleType is the kind of a Prometheus rule
+type RuleType string
+
+const (
+	RuleTypeAlerting  RuleType = "alerting"
+	RuleTypeRecording RuleType = "recording"
+)
+
+// RuleGroup is a group of alerting and recording rules evaluated together
+type RuleGroup struct {
+	Name     string  `json:"name"`
+	File     string  `json:"file"`
+	Interval float64 `json:"interval"`
+	Rules    []Rule  `json:"rules"`
+}
+
+// Rule is an alerting or recording rule. Duration, annotations, state and
+// the active alert count only apply to alerting rules.
+type Rule struct {
+	Name           string            `json:"name"`
+	Type           RuleType          `json:"type"`
+	Query          string            `json:"query"`
+	Duration       float64           `json:"duration,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	Annotations    map[string]string `json:"annotations,omitempty"`
+	State          string            `json:"state,omitempty"`
+	ActiveAlerts   int               `json:"active_alerts,omitempty"`
+	Health         string            `json:"health"`
+	LastError      string            `json:"last_error,omitempty"`
+	EvaluationTime float64           `json:"evaluation_time"`
+	LastEvaluation time.Time         `json:"last_evaluation"`
+}
+
+// MetricMetadata describes a metric's type, help text and unit as reported
+// by its exporters
+type MetricMetadata struct {
+	Metric string `json:"metric"`
+	Type   string `json:"type"`
+	Help   string `json:"help"`
+	Unit   string `json:"unit,omitempty"`
+}
+
 // MetricSummary represents a summary of a metric
 type MetricSummary struct {
-	Name        string        `json:"name"`
-	Labels      []string      `json:"labels"`
-	Cardinality int           `json:"cardinality"`
-	Stats       MetricStats   `json:"stats"`
-	LastUpdated time.Time     `json:"last_updated"`
+	Name        string         `json:"name"`
+	Type        string         `json:"type,omitempty"`
+	Help        string         `json:"help,omitempty"`
+	Unit        string         `json:"unit,omitempty"`
+	Labels      []string       `json:"labels"`
+	Cardinality int            `json:"cardinality"`
+	Stats       MetricStats    `json:"stats"`
+	LastUpdated time.Time      `json:"last_updated"`
 	Samples     []MetricSample `json:"samples"`
+	// Warnings flag values that are easy to misread, such as a raw counter
+	Warnings       []string `json:"warnings,omitempty"`
+	SuggestedQuery string   `json:"suggested_query,omitempty"`
+}
+
+// MetricBaseline is a saved metric summary used as a point of comparison
+type MetricBaseline struct {
+	Name    string        `json:"name"`
+	Metric  string        `json:"metric"`
+	SavedAt time.Time     `json:"saved_at"`
+	Summary MetricSummary `json:"summary"`
+}
+
+// MetricSummaryDelta holds the current minus the baseline value of each
+// numeric summary field
+type MetricSummaryDelta struct {
+	Cardinality int     `json:"cardinality"`
+	Min         float64 `json:"min"`
+	Max         float64 `json:"max"`
+	Avg         float64 `json:"avg"`
+}
+
+// BaselineComparison compares the current summary of a metric with a baseline
+type BaselineComparison struct {
+	Name     string             `json:"name"`
+	Metric   string             `json:"metric"`
+	SavedAt  time.Time          `json:"saved_at"`
+	Current  MetricSummary      `json:"current"`
+	Baseline MetricSummary      `json:"baseline"`
+	Delta    MetricSummaryDelta `json:"delta"`
 }
 
 // MetricStats represents statistical information about a metric
@@ -151,6 +438,79 @@ type MetricHealth struct {
 	CheckedAt   time.Time `json:"checked_at"`
 }
 
+// JobHealth summarises the scrape targets of a single job
+type JobHealth struct {
+	Job     string `json:"job"`
+	Targets int    `json:"targets"`
+	Up      int    `json:"up"`
+	Down    int    `json:"down"`
+}
+
+// MetricQuantile is a quantile computed over the current samples of a metric
+type MetricQuantile struct {
+	Metric   string          `json:"metric"`
+	Quantile float64         `json:"quantile"`
+	By       []string        `json:"by,omitempty"`
+	Results  []QuantileGroup `json:"results"`
+}
+
+// QuantileGroup is the quantile of one group of series and the number of
+// samples it was computed from
+type QuantileGroup struct {
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  float64           `json:"value"`
+	Count  int               `json:"count"`
+}
+
+// Anomaly is a sample whose value lies more standard deviations from the
+// rolling mean of its series than the detection threshold
+type Anomaly struct {
+	Labels    map[string]string `json:"labels,omitempty"`
+	Timestamp time.Time         `json:"timestamp"`
+	Value     float64           `json:"value"`
+	ZScore    float64           `json:"z_score"`
+	Expected  float64           `json:"expected"`
+	StdDev    float64           `json:"std_dev"`
+}
+
+// TargetsResult holds the scrape targets known to Prometheus
+type TargetsResult struct {
+	Active  []Target `json:"active"`
+	Dropped []Target `json:"dropped"`
+}
+
+// Target is a scrape target. Dropped targets only carry their discovered
+// labels.
+type Target struct {
+	DiscoveredLabels   map[string]string `json:"discovered_labels"`
+	Labels             map[string]string `json:"labels,omitempty"`
+	ScrapePool         string            `json:"scrape_pool,omitempty"`
+	ScrapeURL          string            `json:"scrape_url,omitempty"`
+	Health             string            `json:"health,omitempty"`
+	LastError          string            `json:"last_error,omitempty"`
+	LastScrape         time.Time         `json:"last_scrape"`
+	LastScrapeDuration float64           `json:"last_scrape_duration,omitempty"`
+}
+
+// TSDBStatus represents head block and cardinality statistics of the Prometheus TSDB
+type TSDBStatus struct {
+	HeadSeries         int               `json:"head_series"`
+	HeadChunks         int               `json:"head_chunks"`
+	HeadLabelPairs     int               `json:"head_label_pairs"`
+	MinTime            time.Time         `json:"min_time"`
+	MaxTime            time.Time         `json:"max_time"`
+	SeriesByMetric     []CardinalityStat `json:"series_by_metric"`
+	LabelValuesByLabel []CardinalityStat `json:"label_values_by_label"`
+	SeriesByLabelValue []CardinalityStat `json:"series_by_label_value"`
+	MemoryBytesByLabel []CardinalityStat `json:"memory_bytes_by_label"`
+}
+
+// CardinalityStat represents a single named cardinality figure
+type CardinalityStat struct {
+	Name  string `json:"name"`
+	Value uint64 `json:"value"`
+}
+
 // HealthStatus represents the overall health status of the system
 type HealthStatus struct {
 	Status    string            `json:"status"`
@@ -159,4 +519,8 @@ type HealthStatus struct {
 	Timestamp time.Time         `json:"timestamp"`
 	Checks    map[string]string `json:"checks"`
 	Details   map[string]any    `json:"details,omitempty"`
+	// SystemInfo describes the Go runtime and its memory use
+	SystemInfo map[string]any `json:"system_info,omitempty"`
+	// Warning names the failing checks when the status is degraded
+	Warning string `json:"warning,omitempty"`
 }
@@ -0,0 +1,70 @@
+package prometheus
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Auth holds the credentials sent to Prometheus on every request. A bearer
+// token takes precedence over basic auth; Headers are sent as given, such as
+// a tenant header for Cortex or Mimir.
+type Auth struct {
+	BearerToken string
+	Username    string
+	Password    string
+	Headers     map[string]string
+}
+
+// WithAuth sets the credentials sent to every Prometheus target
+func WithAuth(auth Auth) ClientOption {
+	return func(o *clientOptions) {
+		o.auth = auth
+	}
+}
+
+// Validate reports credentials that cannot be sent together
+func (a Auth) Validate() error {
+	if a.BearerToken != "" && a.Username != "" {
+		return fmt.Errorf("bearer token and basic auth are mutually exclusive")
+	}
+	if a.Password != "" && a.Username == "" {
+		return fmt.Errorf("basic auth password requires a username")
+	}
+	return nil
+}
+
+// empty reports whether no credentials are configured
+func (a Auth) empty() bool {
+	return a.BearerToken == "" && a.Username == "" && len(a.Headers) == 0
+}
+
+// authTransport adds credentials to outbound requests
+type authTransport struct {
+	auth Auth
+	next http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the caller's request
+	req = req.Clone(req.Context())
+	for name, value := range t.auth.Headers {
+		req.Header.Set(name, value)
+	}
+
+	switch {
+	case t.auth.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+t.auth.BearerToken)
+	case t.auth.Username != "":
+		req.SetBasicAuth(t.auth.Username, t.auth.Password)
+	}
+	return t.next.RoundTrip(req)
+}
+
+// withAuth wraps next with an authTransport when credentials are configured
+func withAuth(auth Auth, next http.RoundTripper) http.RoundTripper {
+	if auth.empty() {
+		return next
+	}
+	return &authTransport{auth: auth, next: next}
+}
@@ -0,0 +1,70 @@
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// CheckStep is the outcome of one stage of an on-demand connectivity check
+type CheckStep struct {
+	Name      string  `json:"name"`
+	Success   bool    `json:"success"`
+	LatencyMs float64 `json:"latency_ms"`
+	Detail    string  `json:"detail,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// CheckConnectivity calls the build info, query and label APIs of the
+// primary Prometheus server in turn, reporting the outcome and latency of
+// each so a bad URL, TLS setup or credentials can be told apart. Every step
+// runs even if an earlier one fails, and neither the cache nor the error
+// history is touched.
+func (c *Client) CheckConnectivity(ctx context.Context) []CheckStep {
+	steps := []struct {
+		name string
+		run  func(ctx context.Context) (string, error)
+	}{
+		{"buildinfo", func(ctx context.Context) (string, error) {
+			info, err := c.api.Buildinfo(ctx)
+			if err != nil {
+				return "", err
+			}
+			return "Prometheus " + info.Version, nil
+		}},
+		{"query", func(ctx context.Context) (string, error) {
+			value, _, err := c.api.Query(ctx, "1", time.Now())
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("query 1 returned %s", value.Type()), nil
+		}},
+		{"labels", func(ctx context.Context) (string, error) {
+			names, _, err := c.api.LabelNames(ctx, nil, time.Time{}, time.Time{})
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%d label names", len(names)), nil
+		}},
+	}
+
+	results := make([]CheckStep, 0, len(steps))
+	for _, step := range steps {
+		stepCtx, cancel := context.WithTimeout(ctx, c.Timeout())
+		start := time.Now()
+		detail, err := step.run(stepCtx)
+		cancel()
+
+		result := CheckStep{
+			Name:      step.name,
+			Success:   err == nil,
+			LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+			Detail:    detail,
+		}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results
+}
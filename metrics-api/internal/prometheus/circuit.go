@@ -0,0 +1,191 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting Prometheus while the
+// circuit breaker is open
+var ErrCircuitOpen = errors.New("prometheus circuit breaker is open")
+
+// Circuit breaker states reported by Client.CircuitState
+const (
+	CircuitDisabled = "disabled"
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// CircuitBreakerConfig configures a circuit breaker. It opens after
+// FailureThreshold consecutive failures, half-opens once HalfOpenTimeout has
+// passed, and closes again after SuccessThreshold consecutive successful
+// probes. A zero FailureThreshold disables the breaker.
+type CircuitBreakerConfig struct {
+	FailureThreshold int
+	SuccessThreshold int
+	HalfOpenTimeout  time.Duration
+}
+
+// WithCircuitBreaker makes the client fail fast with ErrCircuitOpen after
+// failureThreshold consecutive failed requests, allowing a single probe
+// request through once cooldown has passed; a zero threshold disables it
+func WithCircuitBreaker(failureThreshold int, cooldown time.Duration) ClientOption {
+	return WithCircuitBreakerConfig(CircuitBreakerConfig{
+		FailureThreshold: failureThreshold,
+		SuccessThreshold: 1,
+		HalfOpenTimeout:  cooldown,
+	})
+}
+
+// WithCircuitBreakerConfig is WithCircuitBreaker with a success threshold
+func WithCircuitBreakerConfig(config CircuitBreakerConfig) ClientOption {
+	return func(o *clientOptions) {
+		o.circuit = config
+	}
+}
+
+// CircuitState reports the circuit breaker state for the primary target
+func (c *Client) CircuitState() string {
+	if len(c.targets) == 0 || c.targets[0].breaker == nil {
+		return CircuitDisabled
+	}
+	return c.targets[0].breaker.State()
+}
+
+// circuitBreaker counts consecutive failures against one target. A closed
+// breaker lets every request through; an open one rejects them until the
+// cooldown passes, then half-opens to send probes one at a time until enough
+// succeed to close it or one fails and opens it again.
+type circuitBreaker struct {
+	mu               sync.Mutex
+	threshold        int
+	successThreshold int
+	cooldown         time.Duration
+	state            string
+	failures         int
+	successes        int
+	openedAt         time.Time
+	probing          bool
+}
+
+// newCircuitBreaker returns a breaker for config, or nil when it is disabled
+func newCircuitBreaker(config CircuitBreakerConfig) *circuitBreaker {
+	if config.FailureThreshold <= 0 {
+		return nil
+	}
+	successThreshold := config.SuccessThreshold
+	if successThreshold < 1 {
+		successThreshold = 1
+	}
+	return &circuitBreaker{
+		threshold:        config.FailureThreshold,
+		successThreshold: successThreshold,
+		cooldown:         config.HalfOpenTimeout,
+		state:            CircuitClosed,
+	}
+}
+
+// wrap guards next with the breaker; a nil breaker leaves next unchanged
+func (b *circuitBreaker) wrap(next http.RoundTripper) http.RoundTripper {
+	if b == nil {
+		return next
+	}
+	return &circuitTransport{breaker: b, next: next}
+}
+
+// State returns the current state, reporting an open breaker whose cooldown
+// has passed as half-open
+func (b *circuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == CircuitOpen && time.Since(b.openedAt) >= b.cooldown {
+		return CircuitHalfOpen
+	}
+	return b.state
+}
+
+// allow reports whether a request may be sent, claiming the probe slot when
+// the breaker half-opens
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case CircuitClosed:
+		return nil
+	case CircuitOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return ErrCircuitOpen
+		}
+		b.state = CircuitHalfOpen
+		b.successes = 0
+	}
+
+	// Half-open: only one probe at a time
+	if b.probing {
+		return ErrCircuitOpen
+	}
+	b.probing = true
+	return nil
+}
+
+// record updates the breaker with the outcome of an allowed request
+func (b *circuitBreaker) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if success {
+		b.failures = 0
+		if b.state == CircuitHalfOpen {
+			if b.successes++; b.successes < b.successThreshold {
+				return
+			}
+		}
+		b.state = CircuitClosed
+		return
+	}
+
+	b.failures++
+	if b.state == CircuitHalfOpen || b.failures >= b.threshold {
+		b.state = CircuitOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// release gives up a probe slot without recording an outcome, for requests
+// the caller cancelled
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	b.probing = false
+	b.mu.Unlock()
+}
+
+// circuitTransport guards a target with a circuit breaker. Transport errors,
+// timeouts and 5xx responses count as failures; rejected queries (4xx) show
+// Prometheus is up and count as successes.
+type circuitTransport struct {
+	breaker *circuitBreaker
+	next    http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *circuitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.breaker.allow(); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if errors.Is(err, context.Canceled) {
+		// The client gave up; that says nothing about Prometheus
+		t.breaker.release()
+		return resp, err
+	}
+	t.breaker.record(err == nil && resp.StatusCode < http.StatusInternalServerError)
+	return resp, err
+}
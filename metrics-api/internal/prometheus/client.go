@@ -2,16 +2,23 @@ package prometheus
 
 import (
 	"context"
+	"encoding/gob"
 	"fmt"
 	"metrics-api/internal/cache"
+	"metrics-api/internal/cachekey"
+	"metrics-api/internal/models"
 	"metrics-api/pkg/logger"
 	"net/http"
+	"sort"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/api"
 	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/prometheus/common/model"
+	promvalue "github.com/prometheus/prometheus/model/value"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type PrometheusAPI interface {
@@ -24,10 +31,16 @@ type PrometheusAPI interface {
 
 // Client represents a Prometheus client wrapper
 type Client struct {
-	api       v1.API
-	timeout   time.Duration
-	logger logger.Logger
-	cache  *cache.Cache
+	api     v1.API
+	timeout atomic.Int64 // a time.Duration; see WithTimeout
+	logger  logger.Logger
+	cache   *cache.Cache
+	store   cache.Store // where responses are cached; see WithStore
+	errors  *errorLog
+	targets []target
+	retry   RetryConfig
+	retries atomic.Int64
+	tracer  trace.Tracer
 }
 
 // QueryResult represents the result of a Prometheus query
@@ -45,6 +58,16 @@ type RangeQueryResult struct {
 	Values     []TimeValuePair
 }
 
+// Responses are kept in the cache, so register them with gob for
+// Cache.SaveToFile and the Redis backend
+func init() {
+	gob.Register([]QueryResult{})
+	gob.Register([]RangeQueryResult{})
+	gob.Register([]models.MetricMetadata{})
+	gob.Register([]models.RuleGroup{})
+	gob.Register(&models.TargetsResult{})
+}
+
 // TimeValuePair represents a single time-value pair in a range query result
 type TimeValuePair struct {
 	Timestamp time.Time
@@ -70,43 +93,193 @@ type Alert struct {
 	Value       float64
 }
 
+// ClientOption configures how the client talks to Prometheus
+type ClientOption func(*clientOptions)
+
+type clientOptions struct {
+	userAgent         string
+	errorHistory      int
+	additionalTargets []string
+
+	transportRetries      int
+	transportRetryBackoff time.Duration
+
+	circuit CircuitBreakerConfig
+	retry   RetryConfig
+
+	auth Auth
+	tls  TLSConfig
+
+	tracer trace.Tracer
+
+	store cache.Store
+}
+
+// WithUserAgent sets the User-Agent header sent on every request
+func WithUserAgent(userAgent string) ClientOption {
+	return func(o *clientOptions) {
+		o.userAgent = userAgent
+	}
+}
+
+// WithErrorHistory sets how many failed queries are kept for diagnostics;
+// zero disables the history
+func WithErrorHistory(size int) ClientOption {
+	return func(o *clientOptions) {
+		o.errorHistory = size
+	}
+}
+
+// WithStore caches responses in store instead of the client's cache, such
+// as a RedisCache shared between instances. Cache still returns the
+// in-memory cache.
+func WithStore(store cache.Store) ClientOption {
+	return func(o *clientOptions) {
+		o.store = store
+	}
+}
+
+// responseStore returns the store responses are cached in: store when set,
+// otherwise c, or nil when neither is
+func responseStore(c *cache.Cache, store cache.Store) cache.Store {
+	if store != nil {
+		return store
+	}
+	if c == nil {
+		// A nil *Cache in the interface would not compare equal to nil
+		return nil
+	}
+	return c
+}
+
+// UserAgent returns the default User-Agent for a build version
+func UserAgent(version string) string {
+	return "metrics-api/" + version
+}
+
+// userAgentTransport sets a fixed User-Agent on outbound requests
+type userAgentTransport struct {
+	userAgent string
+	next      http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the caller's request
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.next.RoundTrip(req)
+}
+
 // NewClient creates a new Prometheus client
-func NewClient(url string, logger logger.Logger, cache *cache.Cache) (*Client, error) {
-	client, err := api.NewClient(api.Config{
-		Address: url,
-	})
+func NewClient(url string, logger logger.Logger, cache *cache.Cache, opts ...ClientOption) (*Client, error) {
+	options := clientOptions{
+		userAgent:             UserAgent("dev"),
+		errorHistory:          DefaultErrorHistory,
+		transportRetries:      DefaultTransportRetries,
+		transportRetryBackoff: DefaultTransportRetryBackoff,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if err := options.auth.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid Prometheus credentials: %w", err)
+	}
+	transport, err := baseTransport(options.tls)
 	if err != nil {
-		return nil, fmt.Errorf("error creating Prometheus client: %w", err)
+		return nil, fmt.Errorf("invalid Prometheus TLS configuration: %w", err)
+	}
+
+	targets := make([]target, 0, 1+len(options.additionalTargets))
+	for _, address := range append([]string{url}, options.additionalTargets...) {
+		roundTripper, breaker := targetTransport(options, transport)
+		client, err := api.NewClient(api.Config{
+			Address:      address,
+			RoundTripper: &statusTransport{next: roundTripper},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error creating Prometheus client for %s: %w", address, err)
+		}
+		targets = append(targets, target{address: address, api: v1.NewAPI(client), breaker: breaker})
 	}
 
-	return &Client{
-		api:     v1.NewAPI(client),
-		timeout: 30 * time.Second,
+	c := &Client{
+		api:     targets[0].api,
 		logger:  logger,
 		cache:   cache,
-	}, nil
+		store:   responseStore(cache, options.store),
+		errors:  newErrorLog(options.errorHistory),
+		targets: targets,
+		retry:   options.retry,
+		tracer:  options.tracer,
+	}
+	c.timeout.Store(int64(30 * time.Second))
+	return c, nil
+}
+
+// targetTransport builds the round tripper chain for one Prometheus server
+// on top of transport, returning it with the server's circuit breaker
+func targetTransport(options clientOptions, transport http.RoundTripper) (http.RoundTripper, *circuitBreaker) {
+	var roundTripper http.RoundTripper = &userAgentTransport{
+		userAgent: options.userAgent,
+		next: withAuth(options.auth, &retryTransport{
+			maxRetries: options.transportRetries,
+			backoff:    options.transportRetryBackoff,
+			next:       transport,
+		}),
+	}
+
+	// The breaker sits outside the retries so one exhausted request
+	// counts as a single failure
+	breaker := newCircuitBreaker(options.circuit)
+	return breaker.wrap(roundTripper), breaker
 }
 
-// WithTimeout sets the client timeout for queries
+// WithTimeout sets the client timeout for queries. It may be changed while
+// queries run, taking effect for the ones started afterwards.
 func (c *Client) WithTimeout(timeout time.Duration) *Client {
-	c.timeout = timeout
+	c.timeout.Store(int64(timeout))
 	return c
 }
 
+// Timeout returns the client timeout for queries
+func (c *Client) Timeout() time.Duration {
+	return time.Duration(c.timeout.Load())
+}
+
+// Cache returns the cache shared by the client's queries, or nil when
+// caching is disabled
+func (c *Client) Cache() *cache.Cache {
+	return c.cache
+}
+
+// RecentErrors returns the most recent failed queries, newest first
+func (c *Client) RecentErrors() []QueryError {
+	return c.errors.recent()
+}
+
 // Query performs an instant query against Prometheus
 func (c *Client) Query(ctx context.Context, query string, ts time.Time) ([]QueryResult, error) {
 	if query == "" {
 		return nil, fmt.Errorf("empty query")
 	}
 
-	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout())
 	defer cancel()
 
 	c.logger.Debug("executing query", "query", query, "timestamp", ts)
-	
-	value, warnings, err := c.api.Query(ctx, query, ts)
+
+	ctx, span := c.startQuerySpan(ctx, "prometheus.query", query)
+	var value model.Value
+	var warnings v1.Warnings
+	err := c.doQuery(ctx, query, func(ctx context.Context) (err error) {
+		value, warnings, err = c.api.Query(ctx, query, ts)
+		return err
+	})
 	if err != nil {
+		span.end(0, err)
 		c.logger.Error("query failed", "query", query, "error", err)
+		c.errors.record(query, err)
 		return nil, fmt.Errorf("error querying Prometheus: %w", err)
 	}
 
@@ -117,10 +290,12 @@ func (c *Client) Query(ctx context.Context, query string, ts time.Time) ([]Query
 	}
 
 	if value == nil {
+		span.end(0, nil)
 		return []QueryResult{}, nil
 	}
 
 	results, err := parseQueryResponse(value)
+	span.end(len(results), err)
 	if err != nil {
 		c.logger.Error("failed to parse query response", "error", err)
 		return nil, fmt.Errorf("error parsing query response: %w", err)
@@ -131,11 +306,19 @@ func (c *Client) Query(ctx context.Context, query string, ts time.Time) ([]Query
 
 // QueryRange performs a range query against Prometheus
 func (c *Client) QueryRange(ctx context.Context, query string, r v1.Range) ([]RangeQueryResult, error) {
-	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout())
 	defer cancel()
 
-	value, warnings, err := c.api.QueryRange(ctx, query, r)
+	ctx, span := c.startQuerySpan(ctx, "prometheus.query_range", query)
+	var value model.Value
+	var warnings v1.Warnings
+	err := c.doQuery(ctx, query, func(ctx context.Context) (err error) {
+		value, warnings, err = c.api.QueryRange(ctx, query, r)
+		return err
+	})
 	if err != nil {
+		span.end(0, err)
+		c.errors.record(query, err)
 		return nil, fmt.Errorf("error querying Prometheus range: %w", err)
 	}
 
@@ -146,15 +329,24 @@ func (c *Client) QueryRange(ctx context.Context, query string, r v1.Range) ([]Ra
 		}
 	}
 
-	return parseRangeQueryResponse(value)
+	results, skipped, err := parseMatrixResponse(value)
+	span.end(len(results), err)
+	if skipped > 0 {
+		c.logger.Warn("skipped malformed range query data", "query", query, "skipped", skipped)
+	}
+	return results, err
 }
 
 // GetAlerts gets the current alerts from Prometheus
 func (c *Client) GetAlerts(ctx context.Context) ([]Alert, error) {
-	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout())
 	defer cancel()
 
-	alertsResult, err := c.api.Alerts(ctx)
+	var alertsResult v1.AlertsResult
+	err := c.doQuery(ctx, "alerts", func(ctx context.Context) (err error) {
+		alertsResult, err = c.api.Alerts(ctx)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("error getting alerts from Prometheus: %w", err)
 	}
@@ -203,10 +395,14 @@ func (c *Client) GetAlerts(ctx context.Context) ([]Alert, error) {
 
 // GetMetrics gets a list of metric names from Prometheus
 func (c *Client) GetMetrics(ctx context.Context) ([]string, error) {
-	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout())
 	defer cancel()
 
-	metrics, _, err := c.api.LabelValues(ctx, "__name__", []string{}, time.Time{}, time.Time{})
+	var metrics model.LabelValues
+	err := c.doQuery(ctx, "metric names", func(ctx context.Context) (err error) {
+		metrics, _, err = c.api.LabelValues(ctx, "__name__", []string{}, time.Time{}, time.Time{})
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("error getting metrics from Prometheus: %w", err)
 	}
@@ -221,10 +417,14 @@ func (c *Client) GetMetrics(ctx context.Context) ([]string, error) {
 
 // GetLabelsForMetric gets all labels for a specific metric
 func (c *Client) GetLabelsForMetric(ctx context.Context, metricName string) ([]string, error) {
-	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout())
 	defer cancel()
 
-	labels, _, err := c.api.LabelNames(ctx, []string{metricName}, time.Time{}, time.Time{})
+	var labels []string
+	err := c.doQuery(ctx, "labels of "+metricName, func(ctx context.Context) (err error) {
+		labels, _, err = c.api.LabelNames(ctx, []string{metricName}, time.Time{}, time.Time{})
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("error getting labels for metric %s: %w", metricName, err)
 	}
@@ -232,6 +432,281 @@ func (c *Client) GetLabelsForMetric(ctx context.Context, metricName string) ([]s
 	return labels, nil
 }
 
+// GetLabelValues gets the distinct values of label across the series
+// matching any of the matchers between start and end. Zero times leave
+// the range to Prometheus.
+func (c *Client) GetLabelValues(ctx context.Context, label string, matchers []string, start, end time.Time) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout())
+	defer cancel()
+
+	var values model.LabelValues
+	var warnings v1.Warnings
+	err := c.doQuery(ctx, "values of "+label, func(ctx context.Context) (err error) {
+		values, warnings, err = c.api.LabelValues(ctx, label, matchers, start, end)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting values of label %s: %w", label, err)
+	}
+
+	for _, w := range warnings {
+		c.logger.Warn("label values warning", "label", label, "warning", w)
+	}
+
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		result = append(result, string(value))
+	}
+	return result, nil
+}
+
+// GetSeries gets the label sets of every series matching any of the
+// matchers between start and end
+func (c *Client) GetSeries(ctx context.Context, matchers []string, start, end time.Time) ([]map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout())
+	defer cancel()
+
+	var series []model.LabelSet
+	var warnings v1.Warnings
+	err := c.doQuery(ctx, "series", func(ctx context.Context) (err error) {
+		series, warnings, err = c.api.Series(ctx, matchers, start, end)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting series from Prometheus: %w", err)
+	}
+
+	for _, w := range warnings {
+		c.logger.Warn("series warning", "matchers", matchers, "warning", w)
+	}
+
+	result := make([]map[string]string, 0, len(series))
+	for _, labelSet := range series {
+		result = append(result, convertLabelSet(labelSet))
+	}
+
+	return result, nil
+}
+
+// GetMetadata gets the type, help text and unit of a metric, or of every
+// metric when metric is empty, sorted by metric name. A metric whose
+// exporters disagree has one entry per distinct description. Results are
+// cached with the client's cache.
+func (c *Client) GetMetadata(ctx context.Context, metric string) ([]models.MetricMetadata, error) {
+	key := cachekey.MetadataKey(ctx, metric)
+	if c.store != nil {
+		if cached, found := c.store.Get(key); found {
+			return cached.([]models.MetricMetadata), nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout())
+	defer cancel()
+
+	var metadata map[string][]v1.Metadata
+	err := c.doQuery(ctx, "metadata of "+metric, func(ctx context.Context) (err error) {
+		metadata, err = c.api.Metadata(ctx, metric, "")
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting metadata for metric %s: %w", metric, err)
+	}
+
+	result := make([]models.MetricMetadata, 0, len(metadata))
+	for name, entries := range metadata {
+		for _, entry := range entries {
+			result = append(result, models.MetricMetadata{
+				Metric: name,
+				Type:   string(entry.Type),
+				Help:   entry.Help,
+				Unit:   entry.Unit,
+			})
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Metric < result[j].Metric
+	})
+
+	if c.store != nil {
+		c.store.Set(key, result)
+	}
+
+	return result, nil
+}
+
+// RulesCacheTTL is how long GetRules reuses the rule groups it fetched
+const RulesCacheTTL = 30 * time.Second
+
+// GetRules gets the alerting and recording rule groups loaded by Prometheus,
+// caching them for RulesCacheTTL
+func (c *Client) GetRules(ctx context.Context) ([]models.RuleGroup, error) {
+	key := cachekey.RulesKey(ctx)
+	if c.store != nil {
+		if cached, found := c.store.Get(key); found {
+			return cached.([]models.RuleGroup), nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout())
+	defer cancel()
+
+	var result v1.RulesResult
+	err := c.doQuery(ctx, "rules", func(ctx context.Context) (err error) {
+		result, err = c.api.Rules(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting rules from Prometheus: %w", err)
+	}
+
+	groups := make([]models.RuleGroup, 0, len(result.Groups))
+	for _, g := range result.Groups {
+		group := models.RuleGroup{
+			Name:     g.Name,
+			File:     g.File,
+			Interval: g.Interval,
+			Rules:    make([]models.Rule, 0, len(g.Rules)),
+		}
+		for _, r := range g.Rules {
+			switch rule := r.(type) {
+			case v1.AlertingRule:
+				group.Rules = append(group.Rules, models.Rule{
+					Name:           rule.Name,
+					Type:           models.RuleTypeAlerting,
+					Query:          rule.Query,
+					Duration:       rule.Duration,
+					Labels:         convertLabelSet(rule.Labels),
+					Annotations:    convertLabelSet(rule.Annotations),
+					State:          rule.State,
+					ActiveAlerts:   len(rule.Alerts),
+					Health:         string(rule.Health),
+					LastError:      rule.LastError,
+					EvaluationTime: rule.EvaluationTime,
+					LastEvaluation: rule.LastEvaluation,
+				})
+			case v1.RecordingRule:
+				group.Rules = append(group.Rules, models.Rule{
+					Name:           rule.Name,
+					Type:           models.RuleTypeRecording,
+					Query:          rule.Query,
+					Labels:         convertLabelSet(rule.Labels),
+					Health:         string(rule.Health),
+					LastError:      rule.LastError,
+					EvaluationTime: rule.EvaluationTime,
+					LastEvaluation: rule.LastEvaluation,
+				})
+			}
+		}
+		groups = append(groups, group)
+	}
+
+	if c.store != nil {
+		c.store.SetWithExpiration(key, groups, RulesCacheTTL)
+	}
+
+	return groups, nil
+}
+
+// TargetsCacheTTL is how long GetTargets reuses the targets it fetched
+const TargetsCacheTTL = 15 * time.Second
+
+// GetTargets gets the active and dropped scrape targets from Prometheus,
+// caching them for TargetsCacheTTL
+func (c *Client) GetTargets(ctx context.Context) (*models.TargetsResult, error) {
+	key := cachekey.TargetsKey(ctx)
+	if c.store != nil {
+		if cached, found := c.store.Get(key); found {
+			return cached.(*models.TargetsResult), nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout())
+	defer cancel()
+
+	var targets v1.TargetsResult
+	err := c.doQuery(ctx, "targets", func(ctx context.Context) (err error) {
+		targets, err = c.api.Targets(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting targets from Prometheus: %w", err)
+	}
+
+	result := &models.TargetsResult{
+		Active:  make([]models.Target, 0, len(targets.Active)),
+		Dropped: make([]models.Target, 0, len(targets.Dropped)),
+	}
+	for _, t := range targets.Active {
+		result.Active = append(result.Active, models.Target{
+			DiscoveredLabels:   t.DiscoveredLabels,
+			Labels:             convertLabelSet(t.Labels),
+			ScrapePool:         t.ScrapePool,
+			ScrapeURL:          t.ScrapeURL,
+			Health:             string(t.Health),
+			LastError:          t.LastError,
+			LastScrape:         t.LastScrape,
+			LastScrapeDuration: t.LastScrapeDuration,
+		})
+	}
+	for _, t := range targets.Dropped {
+		result.Dropped = append(result.Dropped, models.Target{DiscoveredLabels: t.DiscoveredLabels})
+	}
+
+	if c.store != nil {
+		c.store.SetWithExpiration(key, result, TargetsCacheTTL)
+	}
+
+	return result, nil
+}
+
+// TSDBStatus gets head block statistics and top cardinalities from Prometheus
+func (c *Client) TSDBStatus(ctx context.Context) (models.TSDBStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout())
+	defer cancel()
+
+	var result v1.TSDBResult
+	err := c.doQuery(ctx, "tsdb status", func(ctx context.Context) (err error) {
+		result, err = c.api.TSDB(ctx)
+		return err
+	})
+	if err != nil {
+		return models.TSDBStatus{}, fmt.Errorf("error getting TSDB status from Prometheus: %w", err)
+	}
+
+	return models.TSDBStatus{
+		HeadSeries:         result.HeadStats.NumSeries,
+		HeadChunks:         result.HeadStats.ChunkCount,
+		HeadLabelPairs:     result.HeadStats.NumLabelPairs,
+		MinTime:            time.UnixMilli(int64(result.HeadStats.MinTime)),
+		MaxTime:            time.UnixMilli(int64(result.HeadStats.MaxTime)),
+		SeriesByMetric:     convertStats(result.SeriesCountByMetricName),
+		LabelValuesByLabel: convertStats(result.LabelValueCountByLabelName),
+		SeriesByLabelValue: convertStats(result.SeriesCountByLabelValuePair),
+		MemoryBytesByLabel: convertStats(result.MemoryInBytesByLabelName),
+	}, nil
+}
+
+// convertStats converts Prometheus TSDB stats to our internal format
+func convertStats(stats []v1.Stat) []models.CardinalityStat {
+	result := make([]models.CardinalityStat, 0, len(stats))
+	for _, s := range stats {
+		result = append(result, models.CardinalityStat{
+			Name:  s.Name,
+			Value: s.Value,
+		})
+	}
+	return result
+}
+
+// convertLabelSet converts a Prometheus label set to a plain map
+func convertLabelSet(labelSet model.LabelSet) map[string]string {
+	labels := make(map[string]string, len(labelSet))
+	for name, value := range labelSet {
+		labels[string(name)] = string(value)
+	}
+	return labels
+}
+
 // parseQueryResponse converts a Prometheus query result to our internal format
 func parseQueryResponse(value model.Value) ([]QueryResult, error) {
 	if value == nil {
@@ -303,11 +778,25 @@ func parseQueryResponse(value model.Value) ([]QueryResult, error) {
 
 // parseRangeQueryResponse converts a Prometheus range query result to our internal format
 func parseRangeQueryResponse(value model.Value) ([]RangeQueryResult, error) {
+	results, _, err := parseMatrixResponse(value)
+	return results, err
+}
+
+// parseMatrixResponse converts a range query result, skipping nil series,
+// series without float samples, stale markers and out-of-order points rather
+// than failing the whole query. It also returns how many were skipped
+func parseMatrixResponse(value model.Value) ([]RangeQueryResult, int, error) {
 	var results []RangeQueryResult
+	skipped := 0
 
 	switch v := value.(type) {
 	case model.Matrix:
 		for _, stream := range v {
+			if stream == nil {
+				skipped++
+				continue
+			}
+
 			metricName := string(stream.Metric["__name__"])
 			
 			// Extract labels
@@ -318,14 +807,25 @@ func parseRangeQueryResponse(value model.Value) ([]RangeQueryResult, error) {
 				}
 			}
 			
-			// Extract values
+			// Extract values, keeping timestamps strictly increasing
 			values := make([]TimeValuePair, 0, len(stream.Values))
-			for _, pair := range stream.Values {
+			var last model.Time
+			for i, pair := range stream.Values {
+				if promvalue.IsStaleNaN(float64(pair.Value)) || (i > 0 && pair.Timestamp <= last) {
+					skipped++
+					continue
+				}
+				last = pair.Timestamp
 				values = append(values, TimeValuePair{
 					Timestamp: pair.Timestamp.Time(),
 					Value:     float64(pair.Value),
 				})
 			}
+
+			if len(values) == 0 {
+				skipped++
+				continue
+			}
 			
 			results = append(results, RangeQueryResult{
 				MetricName: metricName,
@@ -334,10 +834,10 @@ func parseRangeQueryResponse(value model.Value) ([]RangeQueryResult, error) {
 			})
 		}
 	default:
-		return nil, fmt.Errorf("unsupported result format for range query: %T", v)
+		return nil, 0, fmt.Errorf("unsupported result format for range query: %T", v)
 	}
 
-	return results, nil
+	return results, skipped, nil
 }
 
 // Example configuration
@@ -345,18 +845,27 @@ func NewPrometheusClient(config Config) (*Client, error) {
 	if config.URL == "" {
 		return nil, fmt.Errorf("prometheus URL is required")
 	}
+	if err := config.Auth.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid Prometheus credentials: %w", err)
+	}
+	tlsConfig, err := config.TLS.Build()
+	if err != nil {
+		return nil, fmt.Errorf("invalid Prometheus TLS configuration: %w", err)
+	}
 
 	// Create a custom HTTP client with timeouts
+	breaker := newCircuitBreaker(config.CircuitBreaker)
 	httpClient := &http.Client{
 		Timeout: 30 * time.Second,
-		Transport: &http.Transport{
+		Transport: &statusTransport{next: breaker.wrap(withAuth(config.Auth, &http.Transport{
 			MaxIdleConns:        10,
 			IdleConnTimeout:     30 * time.Second,
 			DisableCompression:  true,
 			DisableKeepAlives:   false,
 			MaxConnsPerHost:     10,
 			MaxIdleConnsPerHost: 10,
-		},
+			TLSClientConfig:     tlsConfig,
+		}))},
 	}
 
 	client, err := api.NewClient(api.Config{
@@ -367,12 +876,17 @@ func NewPrometheusClient(config Config) (*Client, error) {
 		return nil, fmt.Errorf("error creating prometheus client: %w", err)
 	}
 
-	return &Client{
-		api:     v1.NewAPI(client),
-		timeout: config.Timeout,
+	promAPI := v1.NewAPI(client)
+	c := &Client{
+		api:     promAPI,
 		logger:  config.Logger,
 		cache:   config.Cache,
-	}, nil
+		store:   responseStore(config.Cache, nil),
+		targets: []target{{address: config.URL, api: promAPI, breaker: breaker}},
+		retry:   config.Retry,
+	}
+	c.timeout.Store(int64(config.Timeout))
+	return c, nil
 }
 
 // Config holds the configuration for the Prometheus client
@@ -381,5 +895,10 @@ type Config struct {
 	Timeout time.Duration
 	Logger  logger.Logger
 	Cache   *cache.Cache
+	Auth    Auth
+	TLS     TLSConfig
+	// CircuitBreaker is disabled unless FailureThreshold is set
+	CircuitBreaker CircuitBreakerConfig
+	// Retry is disabled unless MaxAttempts is above one
+	Retry RetryConfig
 }
-
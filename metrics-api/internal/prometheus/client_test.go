@@ -2,19 +2,48 @@ package prometheus
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
 	"metrics-api/internal/cache"
+	"metrics-api/internal/cachekey"
+	"metrics-api/internal/models"
+	"metrics-api/internal/tenant"
+	"metrics-api/pkg/errutil"
 	"metrics-api/pkg/logger"
 
+	"github.com/alicebob/miniredis/v2"
 	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/prometheus/common/model"
+	promvalue "github.com/prometheus/prometheus/model/value"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // mockPrometheusServer creates a test server that responds with predefined Prometheus API responses
@@ -52,6 +81,19 @@ func mockPrometheusServer(t *testing.T, responses map[string]string) *httptest.S
 	}))
 }
 
+// countingPrometheusServer wraps mockPrometheusServer and counts the requests that reach it
+func countingPrometheusServer(t *testing.T, responses map[string]string) (*httptest.Server, *int64) {
+	var hits int64
+	inner := mockPrometheusServer(t, responses)
+	t.Cleanup(inner.Close)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		inner.Config.Handler.ServeHTTP(w, r)
+	}))
+	return server, &hits
+}
+
 func setupTestClient(t *testing.T, serverURL string) *Client {
 	testLogger := logger.NewTestLogger()
 	testCache := cache.New(cache.DefaultOptions())
@@ -140,7 +182,7 @@ func TestWithTimeout(t *testing.T) {
 			} else {
 				assert.NotPanics(t, func() {
 					updatedClient := client.WithTimeout(tt.timeout)
-					assert.Equal(t, tt.timeout, updatedClient.timeout)
+					assert.Equal(t, tt.timeout, updatedClient.Timeout())
 				})
 			}
 		})
@@ -392,6 +434,105 @@ func TestGetLabelsForMetric(t *testing.T) {
 	assert.Contains(t, labels, "status")
 }
 
+func TestGetLabelValues(t *testing.T) {
+	var form url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/label/job/values" {
+			http.NotFound(w, r)
+			return
+		}
+		r.ParseForm()
+		form = r.Form
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status":"success","data":["api","node","prometheus"],"warnings":["results truncated"]}`)
+	}))
+	defer server.Close()
+
+	client := setupTestClient(t, server.URL)
+
+	end := time.Unix(1700003600, 0)
+	values, err := client.GetLabelValues(context.Background(), "job", []string{`{__name__="up"}`}, end.Add(-time.Hour), end)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"api", "node", "prometheus"}, values)
+	assert.Equal(t, []string{`{__name__="up"}`}, form["match[]"])
+	assert.Equal(t, "1700000000", form.Get("start"))
+	assert.Equal(t, "1700003600", form.Get("end"))
+
+	// Without a range none is sent
+	_, err = client.GetLabelValues(context.Background(), "job", nil, time.Time{}, time.Time{})
+	require.NoError(t, err)
+	assert.Empty(t, form.Get("start"))
+	assert.Empty(t, form.Get("end"))
+}
+
+func TestGetSeries(t *testing.T) {
+	responses := map[string]string{
+		"/api/v1/series": `{
+			"status": "success",
+			"data": [
+				{"__name__": "up", "job": "prometheus", "instance": "localhost:9090"},
+				{"__name__": "up", "job": "node", "instance": "localhost:9100"}
+			]
+		}`,
+	}
+
+	server := mockPrometheusServer(t, responses)
+	defer server.Close()
+
+	client := setupTestClient(t, server.URL)
+
+	end := time.Now()
+	series, err := client.GetSeries(context.Background(), []string{`up`}, end.Add(-time.Hour), end)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]string{
+		{"__name__": "up", "job": "prometheus", "instance": "localhost:9090"},
+		{"__name__": "up", "job": "node", "instance": "localhost:9100"},
+	}, series)
+}
+
+func TestGetMetadata(t *testing.T) {
+	responses := map[string]string{
+		"/api/v1/metadata?limit=&metric=http_requests_total": `{
+			"status": "success",
+			"data": {
+				"http_requests_total": [{"type": "counter", "help": "Total HTTP requests.", "unit": "requests"}]
+			}
+		}`,
+		"/api/v1/metadata": `{
+			"status": "success",
+			"data": {
+				"up": [{"type": "gauge", "help": "Whether the target is up.", "unit": ""}],
+				"http_requests_total": [{"type": "counter", "help": "Total HTTP requests.", "unit": "requests"}]
+			}
+		}`,
+	}
+
+	server, hits := countingPrometheusServer(t, responses)
+	defer server.Close()
+
+	client := setupTestClient(t, server.URL)
+	ctx := context.Background()
+
+	metadata, err := client.GetMetadata(ctx, "http_requests_total")
+	require.NoError(t, err)
+	assert.Equal(t, []models.MetricMetadata{
+		{Metric: "http_requests_total", Type: "counter", Help: "Total HTTP requests.", Unit: "requests"},
+	}, metadata)
+
+	all, err := client.GetMetadata(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []models.MetricMetadata{
+		{Metric: "http_requests_total", Type: "counter", Help: "Total HTTP requests.", Unit: "requests"},
+		{Metric: "up", Type: "gauge", Help: "Whether the target is up."},
+	}, all)
+
+	// Repeated lookups are served from the cache
+	_, err = client.GetMetadata(ctx, "http_requests_total")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), atomic.LoadInt64(hits))
+}
+
 func TestParseQueryResponse(t *testing.T) {
 	timestamp := time.Unix(1609746000, 0)
 
@@ -524,6 +665,46 @@ func TestParseRangeQueryResponse(t *testing.T) {
 	assert.Nil(t, results)
 }
 
+// TestParseMatrixResponseSkipsMalformed tests that malformed series and points are skipped and counted
+func TestParseMatrixResponseSkipsMalformed(t *testing.T) {
+	matrixValue := model.Matrix{
+		{
+			Metric: model.Metric{"__name__": "http_requests_total", "job": "api"},
+			Values: []model.SamplePair{
+				{Timestamp: 1609746000000, Value: 1},
+				{Timestamp: 1609746060000, Value: 2},
+			},
+		},
+		nil,
+		{
+			Metric: model.Metric{"__name__": "http_requests_total", "job": "web"},
+			Values: []model.SamplePair{
+				{Timestamp: 1609746000000, Value: 5},
+				{Timestamp: 1609746000000, Value: 6},
+				{Timestamp: 1609746060000, Value: model.SampleValue(math.Float64frombits(promvalue.StaleNaN))},
+				{Timestamp: 1609746120000, Value: 7},
+			},
+		},
+		{
+			Metric: model.Metric{"__name__": "http_requests_total", "job": "histogram"},
+		},
+	}
+
+	results, skipped, err := parseMatrixResponse(matrixValue)
+	require.NoError(t, err)
+	assert.Equal(t, 4, skipped, "nil series, duplicate point, stale marker and empty series should be skipped")
+	require.Len(t, results, 2)
+
+	assert.Equal(t, "api", results[0].Labels["job"])
+	assert.Len(t, results[0].Values, 2)
+
+	assert.Equal(t, "web", results[1].Labels["job"])
+	if assert.Len(t, results[1].Values, 2) {
+		assert.Equal(t, 5.0, results[1].Values[0].Value)
+		assert.Equal(t, 7.0, results[1].Values[1].Value)
+	}
+}
+
 // TestClientErrors tests error handling in the client
 func TestClientErrors(t *testing.T) {
 	// Create a server that always returns errors
@@ -700,3 +881,936 @@ func TestQueryWithWarnings(t *testing.T) {
 	assert.Len(t, results, 1)
 	assert.Equal(t, "up", results[0].MetricName)
 }
+
+// TestTenantCacheIsolation tests that cached query results are scoped per tenant
+func TestTenantCacheIsolation(t *testing.T) {
+	responses := map[string]string{
+		"/api/v1/query": `{
+			"status": "success",
+			"data": {
+				"resultType": "vector",
+				"result": [
+					{
+						"metric": {"__name__": "up", "instance": "localhost:9090", "job": "prometheus"},
+						"value": [1609746000, "1"]
+					}
+				]
+			}
+		}`,
+	}
+
+	server, hits := countingPrometheusServer(t, responses)
+	defer server.Close()
+
+	client := setupTestClient(t, server.URL)
+	timestamp := time.Unix(1609746000, 0)
+
+	tenantA := tenant.NewContext(context.Background(), "org-a")
+	tenantB := tenant.NewContext(context.Background(), "org-b")
+
+	// First query for tenant A reaches Prometheus
+	_, err := client.ExecuteInstantQuery(tenantA, "up", timestamp)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), atomic.LoadInt64(hits))
+
+	// Repeating the query for tenant A is served from cache
+	_, err = client.ExecuteInstantQuery(tenantA, "up", timestamp)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), atomic.LoadInt64(hits))
+
+	// The identical query for tenant B must not reuse tenant A's entry
+	_, err = client.ExecuteInstantQuery(tenantB, "up", timestamp)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), atomic.LoadInt64(hits))
+
+	// Without a tenant the key is unscoped and also distinct
+	_, err = client.ExecuteInstantQuery(context.Background(), "up", timestamp)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), atomic.LoadInt64(hits))
+	assert.True(t, client.cache.Has(cachekey.InstantKey(context.Background(), "up", timestamp)))
+}
+
+// TestClientWithStore tests that responses are cached in the store set with
+// WithStore, so clients sharing a Redis cache reach Prometheus once
+func TestClientWithStore(t *testing.T) {
+	responses := map[string]string{
+		"/api/v1/query": `{
+			"status": "success",
+			"data": {
+				"resultType": "vector",
+				"result": [
+					{
+						"metric": {"__name__": "up", "job": "prometheus"},
+						"value": [1609746000, "1"]
+					}
+				]
+			}
+		}`,
+	}
+
+	server, hits := countingPrometheusServer(t, responses)
+	defer server.Close()
+
+	mr := miniredis.RunT(t)
+	newClient := func() *Client {
+		store, err := cache.NewRedis(cache.Options{DefaultExpiration: time.Minute, RedisAddr: mr.Addr()})
+		require.NoError(t, err)
+		t.Cleanup(func() { store.Close() })
+
+		client, err := NewClient(server.URL, logger.NewTestLogger(), cache.New(cache.DefaultOptions()), WithStore(store))
+		require.NoError(t, err)
+		return client
+	}
+	first, second := newClient(), newClient()
+	timestamp := time.Unix(1609746000, 0)
+
+	results, err := first.ExecuteInstantQuery(context.Background(), "up", timestamp)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), atomic.LoadInt64(hits))
+
+	cached, err := second.ExecuteInstantQuery(context.Background(), "up", timestamp)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), atomic.LoadInt64(hits), "the second client should be served from redis")
+	assert.Equal(t, results, cached)
+	assert.Equal(t, 0, first.Cache().Count(), "the in-memory cache should be unused")
+}
+
+// TestQueryTimeoutOverride tests that a per-query timeout cuts a slow query
+// short of the client's timeout
+func TestQueryTimeoutOverride(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client := setupTestClient(t, server.URL)
+	require.Equal(t, 30*time.Second, client.Timeout())
+
+	start := time.Now()
+	_, err := client.ExecuteInstantQuery(context.Background(), "up", time.Now(),
+		WithQueryTimeout(50*time.Millisecond), WithCacheBypass())
+	require.Error(t, err)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 2*time.Second)
+
+	start = time.Now()
+	r := v1.Range{Start: start.Add(-time.Hour), End: start, Step: time.Minute}
+	_, err = client.ExecuteRangeQuery(context.Background(), "up", r,
+		WithQueryTimeout(50*time.Millisecond), WithCacheBypass())
+	require.Error(t, err)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 2*time.Second)
+
+	// An earlier deadline on the caller's context still wins
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	_, err = client.ExecuteInstantQuery(ctx, "up", time.Now(), WithQueryTimeout(10*time.Second), WithCacheBypass())
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
+
+// TestTSDBStatus tests parsing of the TSDB status endpoint
+func TestTSDBStatus(t *testing.T) {
+	responses := map[string]string{
+		"/api/v1/status/tsdb": `{
+			"status": "success",
+			"data": {
+				"headStats": {
+					"numSeries": 508,
+					"numLabelPairs": 1234,
+					"chunkCount": 937,
+					"minTime": 1591516800000,
+					"maxTime": 1598896800143
+				},
+				"seriesCountByMetricName": [
+					{"name": "net_conntrack_dialer_conn_failed_total", "value": 20},
+					{"name": "prometheus_http_request_duration_seconds_bucket", "value": 20}
+				],
+				"labelValueCountByLabelName": [
+					{"name": "__name__", "value": 211}
+				],
+				"memoryInBytesByLabelName": [
+					{"name": "__name__", "value": 8266}
+				],
+				"seriesCountByLabelValuePair": [
+					{"name": "job=prometheus", "value": 425}
+				]
+			}
+		}`,
+	}
+
+	server := mockPrometheusServer(t, responses)
+	defer server.Close()
+
+	client := setupTestClient(t, server.URL)
+
+	status, err := client.TSDBStatus(context.Background())
+	require.NoError(t, err)
+
+	// Check head stats
+	assert.Equal(t, 508, status.HeadSeries)
+	assert.Equal(t, 937, status.HeadChunks)
+	assert.Equal(t, 1234, status.HeadLabelPairs)
+	assert.Equal(t, int64(1591516800000), status.MinTime.UnixMilli())
+	assert.Equal(t, int64(1598896800143), status.MaxTime.UnixMilli())
+
+	// Check top cardinality entries
+	require.Len(t, status.SeriesByMetric, 2)
+	assert.Equal(t, "net_conntrack_dialer_conn_failed_total", status.SeriesByMetric[0].Name)
+	assert.Equal(t, uint64(20), status.SeriesByMetric[0].Value)
+	require.Len(t, status.LabelValuesByLabel, 1)
+	assert.Equal(t, uint64(211), status.LabelValuesByLabel[0].Value)
+	require.Len(t, status.SeriesByLabelValue, 1)
+	assert.Equal(t, "job=prometheus", status.SeriesByLabelValue[0].Name)
+	require.Len(t, status.MemoryBytesByLabel, 1)
+	assert.Equal(t, uint64(8266), status.MemoryBytesByLabel[0].Value)
+}
+
+func TestUserAgent(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []ClientOption
+		want string
+	}{
+		{name: "default", want: "metrics-api/dev"},
+		{name: "configured", opts: []ClientOption{WithUserAgent("dashboard-prod/1.2.3")}, want: "dashboard-prod/1.2.3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got atomic.Value
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got.Store(r.Header.Get("User-Agent"))
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`))
+			}))
+			defer server.Close()
+
+			client, err := NewClient(server.URL, logger.NewTestLogger(), cache.New(cache.DefaultOptions()), tt.opts...)
+			require.NoError(t, err)
+
+			_, err = client.Query(context.Background(), "up", time.Now())
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got.Load())
+		})
+	}
+}
+
+func TestTracing(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.FormValue("query") == "bad" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"status":"error","errorType":"bad_data","error":"parse error"}`))
+			return
+		}
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[` +
+			`{"metric":{"__name__":"up","job":"a"},"value":[1609746000,"1"]},` +
+			`{"metric":{"__name__":"up","job":"b"},"value":[1609746000,"0"]}]}}`))
+	}))
+	defer server.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	tracer := provider.Tracer("test")
+
+	client, err := NewClient(server.URL, logger.NewTestLogger(), nil, WithTracer(tracer))
+	require.NoError(t, err)
+
+	ctx, parent := tracer.Start(context.Background(), "request")
+	_, err = client.Query(ctx, "up", time.Now())
+	require.NoError(t, err)
+	_, err = client.ExecuteInstantQuery(ctx, "bad", time.Now(), WithoutCache())
+	require.Error(t, err)
+	parent.End()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+
+	attrs := func(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+		m := make(map[attribute.Key]attribute.Value)
+		for _, kv := range span.Attributes() {
+			m[kv.Key] = kv.Value
+		}
+		return m
+	}
+
+	ok := spans[0]
+	assert.Equal(t, "prometheus.query", ok.Name())
+	assert.Equal(t, parent.SpanContext().SpanID(), ok.Parent().SpanID())
+	assert.Equal(t, parent.SpanContext().TraceID(), ok.SpanContext().TraceID())
+	assert.Equal(t, trace.SpanKindClient, ok.SpanKind())
+	assert.Equal(t, "prometheus", attrs(ok)[SystemAttribute].AsString())
+	assert.Equal(t, "up", attrs(ok)[QueryAttribute].AsString())
+	assert.Equal(t, int64(2), attrs(ok)[ResultCountAttribute].AsInt64())
+	assert.Contains(t, attrs(ok), attribute.Key(DurationAttribute))
+	assert.Equal(t, codes.Unset, ok.Status().Code)
+
+	failed := spans[1]
+	assert.Equal(t, parent.SpanContext().SpanID(), failed.Parent().SpanID())
+	assert.Equal(t, "bad", attrs(failed)[QueryAttribute].AsString())
+	assert.Equal(t, codes.Error, failed.Status().Code)
+	assert.NotEmpty(t, failed.Events(), "the error should be recorded")
+}
+
+func TestTracingDisabled(t *testing.T) {
+	server := mockPrometheusServer(t, map[string]string{
+		"/api/v1/query": `{"status":"success","data":{"resultType":"vector","result":[]}}`,
+	})
+	defer server.Close()
+
+	// Without WithTracer, and with a client built outside NewClient
+	client, err := NewQueryClient(server.URL, logger.NewTestLogger(), nil)
+	require.NoError(t, err)
+	_, err = client.ExecuteInstantQuery(context.Background(), "up", time.Now())
+	assert.NoError(t, err)
+}
+
+func TestRecentErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"status":"error","errorType":"bad_data","error":"cannot parse %s"}`, r.FormValue("query"))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL, logger.NewTestLogger(), cache.New(cache.DefaultOptions()), WithErrorHistory(3))
+	require.NoError(t, err)
+	assert.Empty(t, client.RecentErrors())
+
+	for i := 1; i <= 5; i++ {
+		_, err := client.Query(context.Background(), fmt.Sprintf("q%d", i), time.Now())
+		require.Error(t, err)
+	}
+
+	recent := client.RecentErrors()
+	require.Len(t, recent, 3)
+	for i, query := range []string{"q5", "q4", "q3"} {
+		assert.Equal(t, query, recent[i].Query)
+		assert.Contains(t, recent[i].Error, "cannot parse "+query)
+		assert.False(t, recent[i].Time.IsZero())
+	}
+
+	disabled, err := NewClient(server.URL, logger.NewTestLogger(), nil, WithErrorHistory(0))
+	require.NoError(t, err)
+	_, err = disabled.Query(context.Background(), "up", time.Now())
+	require.Error(t, err)
+	assert.Empty(t, disabled.RecentErrors())
+}
+
+func TestGetMetricsAllTargets(t *testing.T) {
+	labelValues := func(names string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"status":"success","data":[%s]}`, names)
+		}))
+	}
+	east := labelValues(`"up","http_requests_total","east_only"`)
+	defer east.Close()
+	west := labelValues(`"up","http_requests_total","west_only"`)
+	defer west.Close()
+
+	client, err := NewClient(east.URL, logger.NewTestLogger(), nil, WithAdditionalTargets(west.URL))
+	require.NoError(t, err)
+	assert.Equal(t, []string{east.URL, west.URL}, client.Targets())
+
+	metrics, err := client.GetMetricsAllTargets(context.Background())
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"up", "http_requests_total", "east_only"}, metrics[east.URL])
+	assert.ElementsMatch(t, []string{"up", "http_requests_total", "west_only"}, metrics[west.URL])
+	assert.Equal(t, []string{"east_only", "http_requests_total", "up", "west_only"}, metrics[MergedTargets])
+
+	// An unreachable target is reported without failing the others
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	down.Close()
+
+	client, err = NewClient(east.URL, logger.NewTestLogger(), nil, WithAdditionalTargets(down.URL))
+	require.NoError(t, err)
+
+	metrics, err = client.GetMetricsAllTargets(context.Background())
+	partial, ok := errutil.AsMulti(err)
+	require.True(t, ok, "expected a partial failure, got %v", err)
+	assert.Contains(t, partial.Errors(), down.URL)
+	assert.NotContains(t, metrics, down.URL)
+	assert.Equal(t, []string{"east_only", "http_requests_total", "up"}, metrics[MergedTargets])
+}
+
+// flakyTransport fails the first failures requests with a connection reset
+type flakyTransport struct {
+	failures int
+	calls    int
+}
+
+func (t *flakyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.calls++
+	if t.calls <= t.failures {
+		return nil, syscall.ECONNRESET
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader("ok")),
+		Request:    req,
+	}, nil
+}
+
+func TestRetryTransport(t *testing.T) {
+	flaky := &flakyTransport{failures: 1}
+	transport := &retryTransport{maxRetries: 2, backoff: time.Millisecond, next: flaky}
+
+	req := httptest.NewRequest(http.MethodGet, "http://prometheus/api/v1/label/__name__/values", nil)
+	resp, err := transport.RoundTrip(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 2, flaky.calls, "GET should be retried once after the reset")
+
+	// Requests with a body are sent exactly once
+	flaky = &flakyTransport{failures: 1}
+	transport.next = flaky
+	req = httptest.NewRequest(http.MethodPost, "http://prometheus/api/v1/query", strings.NewReader("query=up"))
+	_, err = transport.RoundTrip(req)
+	assert.ErrorIs(t, err, syscall.ECONNRESET)
+	assert.Equal(t, 1, flaky.calls, "POST should not be retried")
+
+	// The retry count is bounded
+	flaky = &flakyTransport{failures: 5}
+	transport.next = flaky
+	req = httptest.NewRequest(http.MethodGet, "http://prometheus/api/v1/labels", nil)
+	_, err = transport.RoundTrip(req)
+	assert.ErrorIs(t, err, syscall.ECONNRESET)
+	assert.Equal(t, 3, flaky.calls, "GET should be attempted once plus two retries")
+}
+
+func TestClientRetry(t *testing.T) {
+	retry := RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+
+	tests := []struct {
+		name     string
+		statuses []int
+		wantErr  bool
+		wantHits int32
+	}{
+		{
+			name:     "recovers after unavailable",
+			statuses: []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+			wantHits: 3,
+		},
+		{
+			name:     "recovers after rate limit",
+			statuses: []int{http.StatusTooManyRequests},
+			wantHits: 2,
+		},
+		{
+			name:     "gives up after max attempts",
+			statuses: []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway},
+			wantErr:  true,
+			wantHits: 3,
+		},
+		{
+			name:     "bad query is not retried",
+			statuses: []int{http.StatusBadRequest},
+			wantErr:  true,
+			wantHits: 1,
+		},
+		{
+			name:     "server error outside retryable codes is not retried",
+			statuses: []int{http.StatusInternalServerError},
+			wantErr:  true,
+			wantHits: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				if n := int(hits.Add(1)); n <= len(tt.statuses) {
+					w.WriteHeader(tt.statuses[n-1])
+					fmt.Fprint(w, `{"status":"error","errorType":"bad_data","error":"failed"}`)
+					return
+				}
+				fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[]}}`)
+			}))
+			defer server.Close()
+
+			client, err := NewClient(server.URL, logger.NewTestLogger(), nil, WithRetry(retry))
+			require.NoError(t, err)
+
+			_, err = client.Query(context.Background(), "up", time.Now())
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantHits, hits.Load())
+			assert.Equal(t, int64(tt.wantHits-1), client.Stats().RetryAttempts)
+		})
+	}
+
+	t.Run("network errors are retried", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+
+		client, err := NewClient(server.URL, logger.NewTestLogger(), nil,
+			WithTransportRetries(0, 0), WithRetry(retry))
+		require.NoError(t, err)
+
+		_, err = client.GetMetrics(context.Background())
+		assert.Error(t, err)
+		assert.Equal(t, int64(2), client.Stats().RetryAttempts)
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		var hits atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits.Add(1)
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		client, err := NewClient(server.URL, logger.NewTestLogger(), nil)
+		require.NoError(t, err)
+
+		_, err = client.Query(context.Background(), "up", time.Now())
+		assert.Error(t, err)
+		assert.Equal(t, int32(1), hits.Load())
+		assert.Zero(t, client.Stats().RetryAttempts)
+	})
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var down atomic.Bool
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if down.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status":"success","data":["up"]}`)
+	}))
+	defer server.Close()
+
+	cooldown := 50 * time.Millisecond
+	client, err := NewClient(server.URL, logger.NewTestLogger(), nil,
+		WithTransportRetries(0, 0), WithCircuitBreaker(3, cooldown))
+	require.NoError(t, err)
+	assert.Equal(t, CircuitClosed, client.CircuitState())
+
+	// Consecutive failures trip the breaker
+	down.Store(true)
+	for i := 0; i < 3; i++ {
+		_, err := client.GetMetrics(context.Background())
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, ErrCircuitOpen)
+	}
+	assert.Equal(t, CircuitOpen, client.CircuitState())
+
+	// While open, concurrent requests fail fast without reaching Prometheus
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.GetMetrics(context.Background())
+			assert.ErrorIs(t, err, ErrCircuitOpen)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(3), hits.Load())
+
+	// A failed probe after the cooldown opens the breaker again
+	time.Sleep(cooldown + 10*time.Millisecond)
+	assert.Equal(t, CircuitHalfOpen, client.CircuitState())
+	_, err = client.GetMetrics(context.Background())
+	assert.NotErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, CircuitOpen, client.CircuitState())
+	assert.Equal(t, int32(4), hits.Load())
+
+	// Once Prometheus recovers, the next probe closes it
+	down.Store(false)
+	time.Sleep(cooldown + 10*time.Millisecond)
+	metrics, err := client.GetMetrics(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"up"}, metrics)
+	assert.Equal(t, CircuitClosed, client.CircuitState())
+
+	// Without the option the breaker is disabled
+	client, err = NewClient(server.URL, logger.NewTestLogger(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, CircuitDisabled, client.CircuitState())
+}
+
+func TestCircuitBreakerRejectedQueriesCountAsSuccess(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"status":"error","errorType":"bad_data","error":"parse error"}`)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL, logger.NewTestLogger(), nil, WithCircuitBreaker(1, time.Minute))
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, err := client.Query(context.Background(), "up{", time.Now())
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, ErrCircuitOpen)
+	}
+	assert.Equal(t, CircuitClosed, client.CircuitState())
+}
+
+func TestCircuitBreakerSuccessThreshold(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Prometheus fails from the third request until the sixth
+		if n := hits.Add(1); n >= 3 && n <= 5 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		resultType := "vector"
+		if strings.HasSuffix(r.URL.Path, "query_range") {
+			resultType = "matrix"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":%q,"result":[]}}`, resultType)
+	}))
+	defer server.Close()
+
+	timeout := 50 * time.Millisecond
+	client, err := NewPrometheusClient(Config{
+		URL:     server.URL,
+		Timeout: time.Second,
+		Logger:  logger.NewTestLogger(),
+		CircuitBreaker: CircuitBreakerConfig{
+			FailureThreshold: 2,
+			SuccessThreshold: 2,
+			HalfOpenTimeout:  timeout,
+		},
+	})
+	require.NoError(t, err)
+
+	r := v1.Range{Start: time.Now().Add(-time.Minute), End: time.Now(), Step: 15 * time.Second}
+	for i := 0; i < 2; i++ {
+		_, err := client.Query(context.Background(), "up", time.Now())
+		require.NoError(t, err)
+	}
+
+	// Two failures mid-sequence open the breaker
+	for i := 0; i < 2; i++ {
+		_, err := client.QueryRange(context.Background(), "up", r)
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, ErrCircuitOpen)
+	}
+	assert.Equal(t, CircuitOpen, client.CircuitState())
+
+	_, err = client.Query(context.Background(), "up", time.Now())
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	_, err = client.QueryRange(context.Background(), "up", r)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, int32(4), hits.Load())
+
+	// A failed probe opens it again
+	time.Sleep(timeout + 10*time.Millisecond)
+	_, err = client.Query(context.Background(), "up", time.Now())
+	assert.NotErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, CircuitOpen, client.CircuitState())
+
+	// One successful probe is not enough to close it
+	time.Sleep(timeout + 10*time.Millisecond)
+	_, err = client.Query(context.Background(), "up", time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, CircuitHalfOpen, client.CircuitState())
+
+	_, err = client.QueryRange(context.Background(), "up", r)
+	require.NoError(t, err)
+	assert.Equal(t, CircuitClosed, client.CircuitState())
+	assert.Equal(t, int32(7), hits.Load())
+}
+
+// countingServer responds with status and body and counts its requests
+func countingServer(t *testing.T, status int, body string) (*httptest.Server, *atomic.Int32) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return server, &hits
+}
+
+func TestMultiClientFailover(t *testing.T) {
+	const vector = `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"__name__":"up"},"value":[1609746000,"1"]}]}}`
+
+	primary, primaryHits := countingServer(t, http.StatusServiceUnavailable, `{"status":"error","errorType":"unavailable","error":"down"}`)
+
+	// The secondary serves Prometheus under a path prefix
+	var secondaryHits atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/prom/api/v1/query", func(w http.ResponseWriter, r *http.Request) {
+		secondaryHits.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, vector)
+	})
+	secondary := httptest.NewServer(mux)
+	defer secondary.Close()
+
+	cooldown := 50 * time.Millisecond
+	client, err := NewMultiClient([]Config{
+		{URL: primary.URL, Timeout: time.Second, Logger: logger.NewTestLogger()},
+		{URL: secondary.URL + "/prom"},
+	}, StrategyPrimary, cooldown)
+	require.NoError(t, err)
+	assert.Equal(t, []string{primary.URL, secondary.URL + "/prom"}, client.Targets())
+
+	results, err := client.Query(context.Background(), "up", time.Now())
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, int32(1), primaryHits.Load())
+	assert.Equal(t, int32(1), secondaryHits.Load())
+
+	// The failed primary is skipped while it cools down
+	_, err = client.Query(context.Background(), "up", time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), primaryHits.Load())
+	assert.Equal(t, int32(2), secondaryHits.Load())
+	assert.Equal(t, []string{secondary.URL + "/prom"}, client.Available())
+
+	// and tried first again once the cooldown has passed
+	time.Sleep(cooldown + 10*time.Millisecond)
+	assert.Len(t, client.Available(), 2)
+	_, err = client.Query(context.Background(), "up", time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), primaryHits.Load())
+	assert.Equal(t, int32(3), secondaryHits.Load())
+}
+
+func TestMultiClientPrimaryUnreachable(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	secondary, secondaryHits := countingServer(t, http.StatusOK, `{"status":"success","data":["up"]}`)
+
+	client, err := NewMultiClient([]Config{
+		{URL: down.URL, Logger: logger.NewTestLogger()},
+		{URL: secondary.URL},
+	}, StrategyPrimary, time.Minute, WithTransportRetries(0, 0))
+	require.NoError(t, err)
+
+	metrics, err := client.GetMetrics(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"up"}, metrics)
+	assert.Equal(t, int32(1), secondaryHits.Load())
+	assert.Equal(t, []string{secondary.URL}, client.Available())
+}
+
+func TestMultiClientRejectedQueryDoesNotFailOver(t *testing.T) {
+	primary, primaryHits := countingServer(t, http.StatusBadRequest, `{"status":"error","errorType":"bad_data","error":"parse error"}`)
+	secondary, secondaryHits := countingServer(t, http.StatusOK, `{"status":"success","data":{"resultType":"vector","result":[]}}`)
+
+	client, err := NewMultiClient([]Config{
+		{URL: primary.URL, Logger: logger.NewTestLogger()},
+		{URL: secondary.URL},
+	}, StrategyPrimary, time.Minute)
+	require.NoError(t, err)
+
+	_, err = client.Query(context.Background(), "up{", time.Now())
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), primaryHits.Load())
+	assert.Zero(t, secondaryHits.Load())
+	assert.Len(t, client.Available(), 2)
+}
+
+func TestMultiClientRoundRobin(t *testing.T) {
+	const labels = `{"status":"success","data":["up"]}`
+	first, firstHits := countingServer(t, http.StatusOK, labels)
+	second, secondHits := countingServer(t, http.StatusOK, labels)
+
+	client, err := NewMultiClient([]Config{
+		{URL: first.URL, Logger: logger.NewTestLogger()},
+		{URL: second.URL},
+	}, StrategyRoundRobin, time.Minute)
+	require.NoError(t, err)
+
+	for i := 0; i < 4; i++ {
+		_, err := client.GetMetrics(context.Background())
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int32(2), firstHits.Load())
+	assert.Equal(t, int32(2), secondHits.Load())
+
+	_, err = NewMultiClient([]Config{{URL: first.URL}}, "random", time.Minute)
+	assert.Error(t, err, "unknown strategies should be rejected")
+}
+
+func TestClientAuth(t *testing.T) {
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status":"success","data":["up"]}`)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name          string
+		auth          Auth
+		authorization string
+	}{
+		{
+			name:          "bearer token",
+			auth:          Auth{BearerToken: "s3cret"},
+			authorization: "Bearer s3cret",
+		},
+		{
+			name:          "basic auth",
+			auth:          Auth{Username: "grafana", Password: "pass"},
+			authorization: "Basic Z3JhZmFuYTpwYXNz",
+		},
+		{
+			name:          "no credentials",
+			authorization: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewClient(server.URL, logger.NewTestLogger(), nil, WithAuth(tt.auth))
+			require.NoError(t, err)
+
+			_, err = client.GetMetrics(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, tt.authorization, header.Get("Authorization"))
+		})
+	}
+
+	t.Run("custom headers", func(t *testing.T) {
+		client, err := NewClient(server.URL, logger.NewTestLogger(), nil, WithAuth(Auth{
+			BearerToken: "s3cret",
+			Headers:     map[string]string{"X-Scope-OrgID": "team-a"},
+		}), WithAdditionalTargets(server.URL))
+		require.NoError(t, err)
+
+		_, err = client.GetMetricsAllTargets(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "team-a", header.Get("X-Scope-OrgID"))
+		assert.Equal(t, "Bearer s3cret", header.Get("Authorization"))
+	})
+
+	t.Run("config client", func(t *testing.T) {
+		client, err := NewPrometheusClient(Config{
+			URL:     server.URL,
+			Timeout: time.Second,
+			Logger:  logger.NewTestLogger(),
+			Auth:    Auth{BearerToken: "from-config"},
+		})
+		require.NoError(t, err)
+
+		_, err = client.GetMetrics(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "Bearer from-config", header.Get("Authorization"))
+	})
+
+	_, err := NewClient(server.URL, logger.NewTestLogger(), nil, WithAuth(Auth{BearerToken: "t", Username: "u"}))
+	assert.Error(t, err, "bearer token and basic auth together should be rejected")
+}
+
+// writeClientCert writes a self-signed client certificate and key to dir
+func writeClientCert(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "metrics-api"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile = filepath.Join(dir, "client.crt")
+	keyFile = filepath.Join(dir, "client.key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}
+
+func TestClientTLS(t *testing.T) {
+	var peerCerts atomic.Int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peerCerts.Store(int32(len(r.TLS.PeerCertificates)))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status":"success","data":["up"]}`)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	// The rejected handshake below would otherwise be logged
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	dir := t.TempDir()
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, caPEM, 0o600))
+	certFile, keyFile := writeClientCert(t, dir)
+
+	reach := func(config TLSConfig) error {
+		client, err := NewClient(server.URL, logger.NewTestLogger(), nil, WithTransportRetries(0, 0), WithTLS(config))
+		require.NoError(t, err)
+		_, err = client.GetMetrics(context.Background())
+		return err
+	}
+
+	// The self-signed server is rejected without its CA
+	assert.ErrorContains(t, reach(TLSConfig{}), "certificate")
+
+	assert.NoError(t, reach(TLSConfig{CAPEM: caPEM}), "CA from PEM bytes")
+	assert.NoError(t, reach(TLSConfig{CAFile: caFile}), "CA from file")
+	assert.NoError(t, reach(TLSConfig{InsecureSkipVerify: true}), "verification skipped")
+	assert.Zero(t, peerCerts.Load(), "no client certificate should be sent by default")
+
+	assert.NoError(t, reach(TLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}), "mutual TLS")
+	assert.Equal(t, int32(1), peerCerts.Load(), "the client certificate should be presented")
+
+	// Bad files are reported when the client is created
+	_, err := NewClient(server.URL, logger.NewTestLogger(), nil, WithTLS(TLSConfig{CAFile: filepath.Join(dir, "missing.pem")}))
+	assert.ErrorContains(t, err, "failed to load CA cert")
+	_, err = NewClient(server.URL, logger.NewTestLogger(), nil, WithTLS(TLSConfig{CAPEM: []byte("not a certificate")}))
+	assert.ErrorContains(t, err, "failed to load CA cert")
+	_, err = NewClient(server.URL, logger.NewTestLogger(), nil, WithTLS(TLSConfig{CertFile: certFile}))
+	assert.ErrorContains(t, err, "must be set together")
+	_, err = NewClient(server.URL, logger.NewTestLogger(), nil, WithTLS(TLSConfig{CertFile: caFile, KeyFile: keyFile}))
+	assert.ErrorContains(t, err, "failed to load client cert")
+}
+
+func TestSources(t *testing.T) {
+	us := setupTestClient(t, "http://prom-us:9090")
+	eu := setupTestClient(t, "http://prom-eu:9090")
+
+	_, err := NewSources("apac", map[string]*Client{"us": us, "eu": eu})
+	assert.Error(t, err, "the default source must have a client")
+
+	sources, err := NewSources("us", map[string]*Client{"us": us, "eu": eu})
+	require.NoError(t, err)
+	assert.Same(t, us, sources.Default())
+
+	client, ok := sources.Get("eu")
+	assert.True(t, ok)
+	assert.Same(t, eu, client)
+	_, ok = sources.Get("apac")
+	assert.False(t, ok)
+
+	ctx := context.Background()
+	assert.Same(t, us, FromContext(ctx, us), "requests without a source use the fallback")
+	assert.Same(t, eu, FromContext(NewContext(ctx, "eu", eu), us))
+}
@@ -0,0 +1,69 @@
+package prometheus
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultErrorHistory is how many failed queries a client remembers by default
+const DefaultErrorHistory = 50
+
+// QueryError records a query that failed against Prometheus
+type QueryError struct {
+	Query string    `json:"query"`
+	Error string    `json:"error"`
+	Time  time.Time `json:"time"`
+}
+
+// errorLog is a bounded, thread-safe ring buffer of recent query errors
+type errorLog struct {
+	mu      sync.Mutex
+	entries []QueryError
+	next    int
+	full    bool
+}
+
+// newErrorLog creates an error log holding at most capacity entries
+func newErrorLog(capacity int) *errorLog {
+	if capacity <= 0 {
+		return nil
+	}
+	return &errorLog{entries: make([]QueryError, capacity)}
+}
+
+// record adds a failed query, overwriting the oldest entry when full
+func (l *errorLog) record(query string, err error) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries[l.next] = QueryError{Query: query, Error: err.Error(), Time: time.Now()}
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// recent returns the recorded errors, newest first
+func (l *errorLog) recent() []QueryError {
+	if l == nil {
+		return []QueryError{}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	count := l.next
+	if l.full {
+		count = len(l.entries)
+	}
+
+	recent := make([]QueryError, 0, count)
+	for i := 1; i <= count; i++ {
+		recent = append(recent, l.entries[(l.next-i+len(l.entries))%len(l.entries)])
+	}
+	return recent
+}
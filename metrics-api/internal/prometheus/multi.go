@@ -0,0 +1,281 @@
+package prometheus
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"metrics-api/pkg/logger"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// Strategy decides which endpoint of a MultiClient a request tries first
+type Strategy string
+
+const (
+	// StrategyPrimary always tries the first endpoint, falling back to the
+	// others in order when it fails
+	StrategyPrimary Strategy = "primary"
+	// StrategyRoundRobin starts each request at the next endpoint in turn
+	// to spread the load
+	StrategyRoundRobin Strategy = "round-robin"
+)
+
+// DefaultCooldownPeriod is how long a failed endpoint is skipped by default
+const DefaultCooldownPeriod = 30 * time.Second
+
+// MultiClient is a Client backed by several Prometheus endpoints serving
+// the same data, such as an HA pair. Each request goes to the endpoints in
+// strategy order until one answers without a network error or 5xx status.
+// An endpoint that fails is skipped for the cooldown period while any other
+// endpoint is available.
+type MultiClient struct {
+	*Client
+	failover *failoverTransport
+}
+
+var _ PrometheusAPI = (*MultiClient)(nil)
+
+// NewMultiClient creates a client failing over between the endpoints in
+// configs. Each config supplies the URL, credentials, TLS settings and
+// circuit breaker of its endpoint; the timeout, logger, cache and retries of
+// the first one apply to the client as a whole. Of opts, only the user agent,
+// error history and transport retry options are used.
+func NewMultiClient(configs []Config, strategy Strategy, cooldown time.Duration, opts ...ClientOption) (*MultiClient, error) {
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("at least one Prometheus endpoint is required")
+	}
+	switch strategy {
+	case "":
+		strategy = StrategyPrimary
+	case StrategyPrimary, StrategyRoundRobin:
+	default:
+		return nil, fmt.Errorf("unknown failover strategy %q", strategy)
+	}
+	if cooldown <= 0 {
+		cooldown = DefaultCooldownPeriod
+	}
+
+	options := clientOptions{
+		userAgent:             UserAgent("dev"),
+		errorHistory:          DefaultErrorHistory,
+		transportRetries:      DefaultTransportRetries,
+		transportRetryBackoff: DefaultTransportRetryBackoff,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	failover := &failoverTransport{
+		strategy: strategy,
+		cooldown: cooldown,
+		logger:   configs[0].Logger,
+	}
+	targets := make([]target, 0, len(configs))
+	for _, config := range configs {
+		endpoint, err := url.Parse(config.URL)
+		if err != nil || endpoint.Host == "" {
+			return nil, fmt.Errorf("invalid Prometheus URL %q", config.URL)
+		}
+		if err := config.Auth.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid Prometheus credentials for %s: %w", config.URL, err)
+		}
+		transport, err := baseTransport(config.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Prometheus TLS configuration for %s: %w", config.URL, err)
+		}
+
+		endpointOptions := options
+		endpointOptions.auth = config.Auth
+		endpointOptions.circuit = config.CircuitBreaker
+		roundTripper, breaker := targetTransport(endpointOptions, transport)
+		failover.endpoints = append(failover.endpoints, &failoverEndpoint{url: endpoint, next: roundTripper})
+
+		// Each endpoint also gets a target of its own, so the *AllTargets
+		// methods and CircuitState see every endpoint
+		client, err := api.NewClient(api.Config{
+			Address:      config.URL,
+			RoundTripper: &statusTransport{next: roundTripper},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error creating Prometheus client for %s: %w", config.URL, err)
+		}
+		targets = append(targets, target{address: config.URL, api: v1.NewAPI(client), breaker: breaker})
+	}
+	failover.base = failover.endpoints[0].url
+
+	client, err := api.NewClient(api.Config{
+		Address:      configs[0].URL,
+		RoundTripper: &statusTransport{next: failover},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating Prometheus client: %w", err)
+	}
+
+	timeout := configs[0].Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	multi := &MultiClient{
+		Client: &Client{
+			api:     v1.NewAPI(client),
+			logger:  configs[0].Logger,
+			cache:   configs[0].Cache,
+			store:   responseStore(configs[0].Cache, options.store),
+			errors:  newErrorLog(options.errorHistory),
+			targets: targets,
+			retry:   configs[0].Retry,
+			tracer:  options.tracer,
+		},
+		failover: failover,
+	}
+	multi.timeout.Store(int64(timeout))
+	return multi, nil
+}
+
+// Available returns the addresses of the endpoints that are not cooling
+// down after a failure, in configuration order
+func (m *MultiClient) Available() []string {
+	return m.failover.available()
+}
+
+// failoverEndpoint is one Prometheus server behind a failoverTransport
+type failoverEndpoint struct {
+	url  *url.URL
+	next http.RoundTripper
+	// downUntil is when a failed endpoint may be tried first again; it is
+	// guarded by the transport's mutex
+	downUntil time.Time
+}
+
+// failoverTransport sends each request to the first endpoint that answers,
+// rewriting the URL the API client built against base
+type failoverTransport struct {
+	base      *url.URL
+	endpoints []*failoverEndpoint
+	strategy  Strategy
+	cooldown  time.Duration
+	logger    logger.Logger
+
+	mu   sync.Mutex
+	next int
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *failoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	endpoints := t.order()
+
+	var resp *http.Response
+	var err error
+	for i, endpoint := range endpoints {
+		attempt := req.Clone(req.Context())
+		attempt.URL = t.rewrite(req.URL, endpoint.url)
+		attempt.Host = ""
+		if i > 0 && req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return resp, err
+			}
+			if attempt.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err = endpoint.next.RoundTrip(attempt)
+		if req.Context().Err() != nil {
+			// The caller gave up, which says nothing about the endpoint
+			return resp, err
+		}
+		if err == nil && resp.StatusCode < http.StatusInternalServerError {
+			t.markUp(endpoint)
+			return resp, nil
+		}
+		t.markDown(endpoint)
+
+		if i < len(endpoints)-1 {
+			status := 0
+			if resp != nil {
+				status = resp.StatusCode
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+			t.logger.Warn("Prometheus endpoint failed, trying the next one",
+				"endpoint", endpoint.url.Redacted(), "status", status, "error", err)
+		}
+	}
+	return resp, err
+}
+
+// order returns the endpoints in the order a request tries them: those that
+// are available in strategy order, then those still cooling down
+func (t *failoverTransport) order() []*failoverEndpoint {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	start := 0
+	if t.strategy == StrategyRoundRobin {
+		start = t.next
+		t.next = (t.next + 1) % len(t.endpoints)
+	}
+
+	now := time.Now()
+	available := make([]*failoverEndpoint, 0, len(t.endpoints))
+	var cooling []*failoverEndpoint
+	for i := range t.endpoints {
+		endpoint := t.endpoints[(start+i)%len(t.endpoints)]
+		if now.Before(endpoint.downUntil) {
+			cooling = append(cooling, endpoint)
+			continue
+		}
+		available = append(available, endpoint)
+	}
+	return append(available, cooling...)
+}
+
+// available returns the addresses of the endpoints not cooling down
+func (t *failoverTransport) available() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	addresses := make([]string, 0, len(t.endpoints))
+	for _, endpoint := range t.endpoints {
+		if !now.Before(endpoint.downUntil) {
+			addresses = append(addresses, endpoint.url.String())
+		}
+	}
+	return addresses
+}
+
+// markDown skips endpoint for the cooldown period
+func (t *failoverTransport) markDown(endpoint *failoverEndpoint) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	endpoint.downUntil = time.Now().Add(t.cooldown)
+}
+
+// markUp makes endpoint available again after it answered
+func (t *failoverTransport) markUp(endpoint *failoverEndpoint) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	endpoint.downUntil = time.Time{}
+}
+
+// rewrite moves u, built against the base endpoint, onto endpoint, keeping
+// the path below the base's path prefix and the query
+func (t *failoverTransport) rewrite(u, endpoint *url.URL) *url.URL {
+	rewritten := *u
+	rewritten.Scheme = endpoint.Scheme
+	rewritten.Host = endpoint.Host
+	rewritten.User = endpoint.User
+	rewritten.Path = strings.TrimSuffix(endpoint.Path, "/") +
+		strings.TrimPrefix(u.Path, strings.TrimSuffix(t.base.Path, "/"))
+	rewritten.RawPath = ""
+	return &rewritten
+}
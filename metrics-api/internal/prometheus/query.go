@@ -2,42 +2,74 @@ package prometheus
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
 
 	"metrics-api/internal/cache"
+	"metrics-api/internal/cachekey"
+	"metrics-api/internal/models"
 	"metrics-api/pkg/logger" // Adjust path as needed
 
 	"github.com/prometheus/client_golang/api"
 	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
 )
 
+// DefaultMaxLabelValueLength is the longest label value accepted for injection
+// when no explicit limit is configured
+const DefaultMaxLabelValueLength = 256
+
+// safeLabelValuePattern restricts injected label values to characters that
+// cannot terminate a string literal or start a new expression
+var safeLabelValuePattern = regexp.MustCompile(`^[\w\-.:/@+ ]*$`)
+
 // QueryOption allows for functional parameter pattern
 type QueryOption func(*QueryOptions)
 
 // QueryOptions holds all configurable options for queries
 type QueryOptions struct {
-	Timeout      time.Duration
-	CacheTTL     time.Duration
-	UseCache     bool
-	Labels       map[string]string
-	SkipSanitize bool
+	// Timeout overrides the client's timeout for a single query when set
+	Timeout             time.Duration
+	CacheTTL            time.Duration
+	UseCache            bool
+	BypassCache         bool
+	Labels              map[string]string
+	MaxLabelValueLength int
+	SkipSanitize        bool
 }
 
 // defaultQueryOptions provides sensible defaults
 var defaultQueryOptions = QueryOptions{
-	Timeout:  30 * time.Second,
-	CacheTTL: 60 * time.Second,
-	UseCache: true,
+	CacheTTL:            60 * time.Second,
+	UseCache:            true,
+	MaxLabelValueLength: DefaultMaxLabelValueLength,
 }
 
-// WithTimeout sets a custom timeout for the query
-func WithTimeout(timeout time.Duration) QueryOption {
+// DefaultQueryTimeout bounds a query when neither the query nor the client
+// sets a timeout
+const DefaultQueryTimeout = 30 * time.Second
+
+// WithQueryTimeout overrides the client's timeout for a single query. A
+// deadline already on the caller's context still applies if it is earlier.
+func WithQueryTimeout(timeout time.Duration) QueryOption {
 	return func(o *QueryOptions) {
 		o.Timeout = timeout
 	}
 }
 
+// WithTimeout sets a custom timeout for the query
+//
+// Deprecated: use WithQueryTimeout.
+func WithTimeout(timeout time.Duration) QueryOption {
+	return WithQueryTimeout(timeout)
+}
+
 // WithCacheTTL sets a custom cache TTL for the query results
 func WithCacheTTL(ttl time.Duration) QueryOption {
 	return func(o *QueryOptions) {
@@ -52,6 +84,13 @@ func WithoutCache() QueryOption {
 	}
 }
 
+// WithCacheBypass skips the cache lookup but still stores the fresh result
+func WithCacheBypass() QueryOption {
+	return func(o *QueryOptions) {
+		o.BypassCache = true
+	}
+}
+
 // WithLabels adds additional label filters to the query
 func WithLabels(labels map[string]string) QueryOption {
 	return func(o *QueryOptions) {
@@ -59,6 +98,13 @@ func WithLabels(labels map[string]string) QueryOption {
 	}
 }
 
+// WithMaxLabelValueLength limits the length of injected label values
+func WithMaxLabelValueLength(maxLength int) QueryOption {
+	return func(o *QueryOptions) {
+		o.MaxLabelValueLength = maxLength
+	}
+}
+
 // WithoutSanitize skips query sanitization
 func WithoutSanitize() QueryOption {
 	return func(o *QueryOptions) {
@@ -79,9 +125,22 @@ func NewQueryClient(url string, logger logger.Logger, cache *cache.Cache) (*Clie
 		api:    v1.NewAPI(client),
 		logger: logger,
 		cache:  cache,
+		store:  responseStore(cache, nil),
 	}, nil
 }
 
+// queryTimeout returns the timeout of a query run with options
+func (c *Client) queryTimeout(options QueryOptions) time.Duration {
+	switch {
+	case options.Timeout > 0:
+		return options.Timeout
+	case c.Timeout() > 0:
+		return c.Timeout()
+	default:
+		return DefaultQueryTimeout
+	}
+}
+
 // ExecuteInstantQuery performs an instant query against Prometheus
 func (c *Client) ExecuteInstantQuery(ctx context.Context, query string, ts time.Time, opts ...QueryOption) ([]QueryResult, error) {
 	options := defaultQueryOptions
@@ -99,28 +158,44 @@ func (c *Client) ExecuteInstantQuery(ctx context.Context, query string, ts time.
 
 	// Apply additional label filters if provided
 	if len(options.Labels) > 0 {
-		query = applyLabelFilters(query, options.Labels)
+		if err := ValidateLabelValues(options.Labels, options.MaxLabelValueLength); err != nil {
+			return nil, err
+		}
+
+		var err error
+		query, err = InjectLabels(query, options.Labels)
+		if err != nil {
+			return nil, fmt.Errorf("invalid query: %w", err)
+		}
 	}
 
-	cacheKey := fmt.Sprintf("instant:%s:%d", query, ts.Unix())
-	
+	cacheKey := cachekey.InstantKey(ctx, query, ts)
+
 	// Check cache first if enabled
-	if options.UseCache {
-		if cached, found := c.cache.Get(cacheKey); found {
+	if options.UseCache && !options.BypassCache && c.store != nil {
+		if cached, found := c.store.Get(cacheKey); found {
 			c.logger.Debug("cache hit for query", "query", query)
 			return cached.([]QueryResult), nil
 		}
 	}
 
 	// Set up timeout context
-	queryCtx, cancel := context.WithTimeout(ctx, options.Timeout)
+	queryCtx, cancel := context.WithTimeout(ctx, c.queryTimeout(options))
 	defer cancel()
 
 	// Execute query
 	c.logger.Debug("executing instant query", "query", query)
-	result, warnings, err := c.api.Query(queryCtx, query, ts)
+	queryCtx, span := c.startQuerySpan(queryCtx, "prometheus.query", query)
+	var result model.Value
+	var warnings v1.Warnings
+	err := c.doQuery(queryCtx, query, func(ctx context.Context) (err error) {
+		result, warnings, err = c.api.Query(ctx, query, ts)
+		return err
+	})
 	if err != nil {
+		span.end(0, err)
 		c.logger.Error("instant query failed", "query", query, "error", err)
+		c.errors.record(query, err)
 		return nil, fmt.Errorf("prometheus query failed: %w", err)
 	}
 
@@ -131,13 +206,14 @@ func (c *Client) ExecuteInstantQuery(ctx context.Context, query string, ts time.
 
 	// Parse result
 	queryResult, err := parseQueryResponse(result)
+	span.end(len(queryResult), err)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse query result: %w", err)
 	}
 
 	// Cache result if enabled
-	if options.UseCache {
-		c.cache.Set(cacheKey, queryResult)
+	if options.UseCache && c.store != nil {
+		c.store.Set(cacheKey, queryResult)
 	}
 
 	return queryResult, nil
@@ -165,21 +241,29 @@ func (c *Client) ExecuteRangeQuery(
 
 	// Apply additional label filters if provided
 	if len(options.Labels) > 0 {
-		query = applyLabelFilters(query, options.Labels)
+		if err := ValidateLabelValues(options.Labels, options.MaxLabelValueLength); err != nil {
+			return nil, err
+		}
+
+		var err error
+		query, err = InjectLabels(query, options.Labels)
+		if err != nil {
+			return nil, fmt.Errorf("invalid query: %w", err)
+		}
 	}
 
-	cacheKey := fmt.Sprintf("range:%s:%d:%d:%d", query, r.Start.Unix(), r.End.Unix(), int(r.Step.Seconds()))
-	
+	cacheKey := cachekey.RangeKey(ctx, query, r.Start, r.End, r.Step)
+
 	// Check cache first if enabled
-	if options.UseCache {
-		if cached, found := c.cache.Get(cacheKey); found {
+	if options.UseCache && !options.BypassCache && c.store != nil {
+		if cached, found := c.store.Get(cacheKey); found {
 			c.logger.Debug("cache hit for range query", "query", query)
 			return cached.([]RangeQueryResult), nil
 		}
 	}
 
 	// Set up timeout context
-	queryCtx, cancel := context.WithTimeout(ctx, options.Timeout)
+	queryCtx, cancel := context.WithTimeout(ctx, c.queryTimeout(options))
 	defer cancel()
 
 	// Execute query
@@ -189,14 +273,22 @@ func (c *Client) ExecuteRangeQuery(
 		"end", r.End.Format(time.RFC3339),
 		"step", r.Step.String())
 
-	result, warnings, err := c.api.QueryRange(queryCtx, query, r)
+	queryCtx, span := c.startQuerySpan(queryCtx, "prometheus.query_range", query)
+	var result model.Value
+	var warnings v1.Warnings
+	err := c.doQuery(queryCtx, query, func(ctx context.Context) (err error) {
+		result, warnings, err = c.api.QueryRange(ctx, query, r)
+		return err
+	})
 	if err != nil {
+		span.end(0, err)
 		c.logger.Error("range query failed",
 			"query", query,
 			"start", r.Start.Format(time.RFC3339),
 			"end", r.End.Format(time.RFC3339),
 			"step", r.Step.String(),
 			"error", err)
+		c.errors.record(query, err)
 		return nil, fmt.Errorf("prometheus range query failed: %w", err)
 	}
 
@@ -206,14 +298,18 @@ func (c *Client) ExecuteRangeQuery(
 	}
 
 	// Parse result
-	queryResult, err := parseRangeQueryResponse(result)
+	queryResult, skipped, err := parseMatrixResponse(result)
+	span.end(len(queryResult), err)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse range query result: %w", err)
 	}
+	if skipped > 0 {
+		c.logger.Warn("skipped malformed range query data", "query", query, "skipped", skipped)
+	}
 
 	// Cache result if enabled
-	if options.UseCache {
-		c.cache.Set(cacheKey, queryResult)
+	if options.UseCache && c.store != nil {
+		c.store.Set(cacheKey, queryResult)
 	}
 
 	return queryResult, nil
@@ -255,7 +351,15 @@ func (c *Client) GetMetricSeries(ctx context.Context, metric string, duration ti
 
 	// Apply additional label filters if provided
 	if len(options.Labels) > 0 {
-		query = applyLabelFilters(query, options.Labels)
+		if err := ValidateLabelValues(options.Labels, options.MaxLabelValueLength); err != nil {
+			return nil, err
+		}
+
+		var err error
+		query, err = InjectLabels(query, options.Labels)
+		if err != nil {
+			return nil, fmt.Errorf("invalid query: %w", err)
+		}
 	}
 
 	return c.ExecuteRangeQuery(ctx, query, r, opts...)
@@ -270,24 +374,140 @@ func sanitizeQuery(query string) (string, error) {
 	return query, nil
 }
 
-// applyLabelFilters adds label filters to a query
-func applyLabelFilters(query string, labels map[string]string) string {
-	if len(labels) == 0 {
-		return query
+// ValidateLabelValues checks that every value is no longer than maxLength
+// and only contains characters from the safe label value pattern
+func ValidateLabelValues(labels map[string]string, maxLength int) error {
+	for name, value := range labels {
+		if maxLength > 0 && len(value) > maxLength {
+			return fmt.Errorf("%w: value for %s exceeds %d characters", models.ErrInvalidLabelValue, name, maxLength)
+		}
+		if !safeLabelValuePattern.MatchString(value) {
+			return fmt.Errorf("%w: value for %s contains disallowed characters", models.ErrInvalidLabelValue, name)
+		}
 	}
+	return nil
+}
 
-	labelStr := "{"
-	first := true
-	for k, v := range labels {
-		if !first {
-			labelStr += ","
+// CheckSyntax parses query and reports any PromQL syntax error without
+// contacting Prometheus
+func CheckSyntax(query string) error {
+	if _, err := parser.ParseExpr(query); err != nil {
+		return fmt.Errorf("failed to parse query: %w", err)
+	}
+	return nil
+}
+
+// ParseSyntaxError parses query and locates its first PromQL syntax error,
+// returning nil when it parses. Prometheus is not contacted.
+func ParseSyntaxError(query string) *models.QuerySyntaxError {
+	_, err := parser.ParseExpr(query)
+	if err == nil {
+		return nil
+	}
+
+	syntaxErr := &models.QuerySyntaxError{Message: err.Error(), Line: 1, Column: 1}
+	var parseErrs parser.ParseErrors
+	if !errors.As(err, &parseErrs) || len(parseErrs) == 0 {
+		return syntaxErr
+	}
+
+	first := parseErrs[0]
+	syntaxErr.Message = first.Err.Error()
+	syntaxErr.Start = min(max(int(first.PositionRange.Start), 0), len(query))
+	syntaxErr.End = min(max(int(first.PositionRange.End), syntaxErr.Start), len(query))
+
+	before := query[:syntaxErr.Start]
+	syntaxErr.Line = strings.Count(before, "\n") + 1
+	syntaxErr.Column = syntaxErr.Start - strings.LastIndex(before, "\n")
+	return syntaxErr
+}
+
+// IsRejectedQuery reports whether Prometheus refused to evaluate a query
+// as malformed or failed while evaluating it, rather than being unavailable
+func IsRejectedQuery(err error) bool {
+	var apiErr *v1.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.Type == v1.ErrBadData || apiErr.Type == v1.ErrExec
+}
+
+// SeriesCountQuery returns a query counting the series query returns when
+// evaluated at a single instant, or false when it returns a scalar or string
+func SeriesCountQuery(query string) (string, bool, error) {
+	expr, err := parser.ParseExpr(query)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to parse query: %w", err)
+	}
+
+	// The expression is re-rendered so a trailing comment cannot swallow the
+	// closing parenthesis
+	switch expr.Type() {
+	case parser.ValueTypeVector:
+		return "count(" + expr.String() + ")", true, nil
+	case parser.ValueTypeMatrix:
+		return "count(count_over_time(" + expr.String() + "))", true, nil
+	default:
+		return "", false, nil
+	}
+}
+
+// InjectLabels parses query and adds an equality matcher for each label to
+// every vector and matrix selector, returning the rewritten query
+func InjectLabels(query string, injected map[string]string) (string, error) {
+	expr, err := parser.ParseExpr(query)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse query: %w", err)
+	}
+
+	if len(injected) == 0 {
+		return expr.String(), nil
+	}
+
+	// Build matchers in a stable order so rewritten queries are deterministic
+	names := make([]string, 0, len(injected))
+	for name := range injected {
+		if !model.LabelName(name).IsValidLegacy() {
+			return "", fmt.Errorf("invalid label name: %q", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	matchers := make([]*labels.Matcher, 0, len(names))
+	for _, name := range names {
+		matcher, err := labels.NewMatcher(labels.MatchEqual, name, injected[name])
+		if err != nil {
+			return "", fmt.Errorf("invalid label matcher %s: %w", name, err)
 		}
-		labelStr += fmt.Sprintf("%s=\"%s\"", k, v)
-		first = false
+		matchers = append(matchers, matcher)
 	}
-	labelStr += "}"
-	
-	return query + labelStr
+
+	parser.Inspect(expr, func(node parser.Node, _ []parser.Node) error {
+		if vs, ok := node.(*parser.VectorSelector); ok {
+			vs.LabelMatchers = appendMissingMatchers(vs.LabelMatchers, matchers)
+		}
+		return nil
+	})
+
+	return expr.String(), nil
+}
+
+// appendMissingMatchers adds each matcher that is not already present
+func appendMissingMatchers(existing, added []*labels.Matcher) []*labels.Matcher {
+	for _, m := range added {
+		present := false
+		for _, e := range existing {
+			if e.Name == m.Name && e.Type == m.Type && e.Value == m.Value {
+				present = true
+				break
+			}
+		}
+		if !present {
+			existing = append(existing, m)
+		}
+	}
+	return existing
 }
 
 // containsLabelFilters checks if a query already contains label filters
@@ -302,4 +522,4 @@ func addToExistingLabelFilters(query string, labels map[string]string) string {
 		filterStr += fmt.Sprintf(" and %s=\"%s\"", k, v)
 	}
 	return query + filterStr
-}
\ No newline at end of file
+}
@@ -0,0 +1,185 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"slices"
+	"time"
+)
+
+const (
+	// DefaultTransportRetries is how many times an idempotent request is
+	// retried after a transport error
+	DefaultTransportRetries = 2
+	// DefaultTransportRetryBackoff is the delay before the first retry; it
+	// doubles on each further attempt
+	DefaultTransportRetryBackoff = 100 * time.Millisecond
+)
+
+// DefaultRetryableStatusCodes are the responses a query is retried on when
+// RetryConfig lists none of its own
+var DefaultRetryableStatusCodes = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// RetryConfig configures how requests that fail transiently, on a
+// retryable status code or a network error, are retried. The backoff
+// doubles from InitialBackoff up to MaxBackoff with jitter applied; a
+// MaxAttempts below 2 disables retries.
+type RetryConfig struct {
+	MaxAttempts          int
+	InitialBackoff       time.Duration
+	MaxBackoff           time.Duration
+	RetryableStatusCodes []int
+}
+
+// WithRetry sets how failed requests are retried by the client methods
+func WithRetry(config RetryConfig) ClientOption {
+	return func(o *clientOptions) {
+		o.retry = config
+	}
+}
+
+// Stats holds counters on how the client has talked to Prometheus
+type Stats struct {
+	// RetryAttempts counts requests sent again after a retryable failure
+	RetryAttempts int64 `json:"retry_attempts"`
+}
+
+// Stats returns the client's counters
+func (c *Client) Stats() Stats {
+	return Stats{RetryAttempts: c.retries.Load()}
+}
+
+// retryable reports whether err, from a response with the given status or
+// from no response at all when status is zero, is worth another attempt
+func (r RetryConfig) retryable(ctx context.Context, status int, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, ErrCircuitOpen) {
+		return false
+	}
+	if status == 0 {
+		var netErr net.Error
+		return errors.As(err, &netErr)
+	}
+
+	codes := r.RetryableStatusCodes
+	if len(codes) == 0 {
+		codes = DefaultRetryableStatusCodes
+	}
+	return slices.Contains(codes, status)
+}
+
+// doQuery runs call, retrying it with backoff while it fails with a
+// retryable error; name identifies the request in logs
+func (c *Client) doQuery(ctx context.Context, name string, call func(ctx context.Context) error) error {
+	backoff := c.retry.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		status := &responseStatus{}
+		err := call(context.WithValue(ctx, responseStatusKey{}, status))
+		if err == nil || attempt >= c.retry.MaxAttempts || !c.retry.retryable(ctx, status.code, err) {
+			return err
+		}
+
+		// Equal jitter: wait between half and all of the backoff
+		wait := backoff/2 + time.Duration(rand.Int64N(int64(backoff/2)+1))
+		c.logger.Warn("retrying Prometheus request",
+			"request", name, "attempt", attempt, "status", status.code, "backoff", wait, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		c.retries.Add(1)
+
+		backoff *= 2
+		if c.retry.MaxBackoff > 0 && backoff > c.retry.MaxBackoff {
+			backoff = c.retry.MaxBackoff
+		}
+	}
+}
+
+// responseStatusKey carries a *responseStatus in a request context
+type responseStatusKey struct{}
+
+// responseStatus is the HTTP status of a request attempt, zero when no
+// response arrived
+type responseStatus struct {
+	code int
+}
+
+// statusTransport records response statuses for doQuery, since the API
+// client turns non-2xx responses into errors without the status code
+type statusTransport struct {
+	next http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *statusTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if status, ok := req.Context().Value(responseStatusKey{}).(*responseStatus); ok && resp != nil {
+		status.code = resp.StatusCode
+	}
+	return resp, err
+}
+
+// WithTransportRetries sets how often idempotent requests that fail at the
+// transport layer, such as on a connection reset, are retried and the
+// initial backoff between attempts; zero retries disables them
+func WithTransportRetries(retries int, backoff time.Duration) ClientOption {
+	return func(o *clientOptions) {
+		o.transportRetries = retries
+		o.transportRetryBackoff = backoff
+	}
+}
+
+// retryTransport retries idempotent requests that fail before a response
+// is received
+type retryTransport struct {
+	maxRetries int
+	backoff    time.Duration
+	next       http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.maxRetries <= 0 || !retryable(req) {
+		return t.next.RoundTrip(req)
+	}
+
+	backoff := t.backoff
+	for attempt := 0; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		if err == nil || attempt >= t.maxRetries {
+			return resp, err
+		}
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return nil, err
+		}
+
+		select {
+		case <-req.Context().Done():
+			return nil, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// retryable reports whether req can be sent again without side effects.
+// Requests with a body are never retried, so a POST query is not duplicated
+// and no body is replayed after being consumed.
+func retryable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody
+}
@@ -0,0 +1,60 @@
+package prometheus
+
+import (
+	"context"
+	"fmt"
+
+	"metrics-api/internal/source"
+)
+
+type contextKey string
+
+const clientKey contextKey = "prometheusClient"
+
+// Sources holds the clients of independent Prometheus backends, such as one
+// per region, by name. Requests naming no source go to the default one.
+type Sources struct {
+	defaultName string
+	clients     map[string]*Client
+}
+
+// NewSources creates a set of sources from clients, which must include
+// defaultName
+func NewSources(defaultName string, clients map[string]*Client) (*Sources, error) {
+	if clients[defaultName] == nil {
+		return nil, fmt.Errorf("default Prometheus source %q has no client", defaultName)
+	}
+	return &Sources{defaultName: defaultName, clients: clients}, nil
+}
+
+// DefaultName returns the name of the default source
+func (s *Sources) DefaultName() string {
+	return s.defaultName
+}
+
+// Default returns the client of the default source
+func (s *Sources) Default() *Client {
+	return s.clients[s.defaultName]
+}
+
+// Get returns the client of the named source
+func (s *Sources) Get(name string) (*Client, bool) {
+	client, ok := s.clients[name]
+	return client, ok
+}
+
+// NewContext returns a copy of ctx directing requests to client, the
+// client of the named source. The name also scopes cache keys, so results
+// of different sources are cached apart.
+func NewContext(ctx context.Context, name string, client *Client) context.Context {
+	return context.WithValue(source.NewContext(ctx, name), clientKey, client)
+}
+
+// FromContext returns the client stored in ctx, or fallback when the request
+// uses the default source
+func FromContext(ctx context.Context, fallback *Client) *Client {
+	if client, ok := ctx.Value(clientKey).(*Client); ok {
+		return client
+	}
+	return fallback
+}
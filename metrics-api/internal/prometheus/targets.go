@@ -0,0 +1,114 @@
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"metrics-api/pkg/errutil"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// MergedTargets is the key under which GetMetricsAllTargets returns the
+// union of every target's metric names. It cannot collide with a target
+// address.
+const MergedTargets = "*"
+
+// target is a single Prometheus server the client can fan out to
+type target struct {
+	address string
+	api     v1.API
+	breaker *circuitBreaker
+}
+
+// WithAdditionalTargets adds Prometheus servers, such as per-region
+// instances in a federated setup, that are consulted alongside the primary
+// one by the *AllTargets methods
+func WithAdditionalTargets(addresses ...string) ClientOption {
+	return func(o *clientOptions) {
+		o.additionalTargets = append(o.additionalTargets, addresses...)
+	}
+}
+
+// Targets returns the address of every configured Prometheus server, the
+// primary one first
+func (c *Client) Targets() []string {
+	addresses := make([]string, 0, len(c.targets))
+	for _, t := range c.targets {
+		addresses = append(addresses, t.address)
+	}
+	return addresses
+}
+
+// GetMetricsAllTargets gets the metric names of every configured Prometheus
+// server. The result is keyed by target address, with the sorted union
+// under MergedTargets. Targets that fail are reported in an *errutil.Multi
+// keyed by address alongside the results of the others; an error is
+// returned without results only when every target fails.
+func (c *Client) GetMetricsAllTargets(ctx context.Context) (map[string][]string, error) {
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		partial errutil.Multi
+	)
+	result := make(map[string][]string, len(c.targets)+1)
+
+	for _, t := range c.targets {
+		wg.Add(1)
+		go func(t target) {
+			defer wg.Done()
+
+			metrics, err := c.targetMetrics(ctx, t)
+			if err != nil {
+				c.logger.Warn("failed to get metrics from target", "target", t.address, "error", err)
+				partial.Add(t.address, err)
+				return
+			}
+
+			mu.Lock()
+			result[t.address] = metrics
+			mu.Unlock()
+		}(t)
+	}
+	wg.Wait()
+
+	if len(c.targets) > 0 && partial.Len() == len(c.targets) {
+		return nil, &partial
+	}
+
+	seen := make(map[string]struct{})
+	merged := []string{}
+	for _, metrics := range result {
+		for _, m := range metrics {
+			if _, ok := seen[m]; ok {
+				continue
+			}
+			seen[m] = struct{}{}
+			merged = append(merged, m)
+		}
+	}
+	sort.Strings(merged)
+	result[MergedTargets] = merged
+
+	return result, partial.ErrorOrNil()
+}
+
+// targetMetrics gets the metric names of a single target
+func (c *Client) targetMetrics(ctx context.Context, t target) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout())
+	defer cancel()
+
+	metrics, _, err := t.api.LabelValues(ctx, "__name__", []string{}, time.Time{}, time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting metrics from Prometheus: %w", err)
+	}
+
+	result := make([]string, 0, len(metrics))
+	for _, m := range metrics {
+		result = append(result, string(m))
+	}
+	return result, nil
+}
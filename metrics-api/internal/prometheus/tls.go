@@ -0,0 +1,94 @@
+package prometheus
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/prometheus/client_golang/api"
+)
+
+// TLSConfig configures HTTPS connections to Prometheus. The CA bundle adds
+// to the system roots and may be given as a file or PEM bytes; a client
+// certificate and key enable mutual TLS.
+type TLSConfig struct {
+	CAFile             string
+	CAPEM              []byte
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool
+}
+
+// WithTLS sets the TLS configuration used for every Prometheus target
+func WithTLS(config TLSConfig) ClientOption {
+	return func(o *clientOptions) {
+		o.tls = config
+	}
+}
+
+// empty reports whether the default TLS settings apply
+func (c TLSConfig) empty() bool {
+	return c.CAFile == "" && len(c.CAPEM) == 0 && c.CertFile == "" && c.KeyFile == "" && !c.InsecureSkipVerify
+}
+
+// Build loads the certificates and returns the resulting tls.Config
+func (c TLSConfig) Build() (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	if c.CAFile != "" || len(c.CAPEM) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		caPEM := c.CAPEM
+		if c.CAFile != "" {
+			if caPEM, err = os.ReadFile(c.CAFile); err != nil {
+				return nil, fmt.Errorf("failed to load CA cert %s: %w", c.CAFile, err)
+			}
+		}
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("failed to load CA cert: no PEM certificates found")
+		}
+		config.RootCAs = pool
+	}
+
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, fmt.Errorf("client certificate and key must be set together")
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client cert: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// baseTransport returns the transport requests are finally sent over,
+// carrying the TLS settings when any are configured
+func baseTransport(config TLSConfig) (http.RoundTripper, error) {
+	if config.empty() {
+		return api.DefaultRoundTripper, nil
+	}
+
+	tlsConfig, err := config.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	transport, ok := api.DefaultRoundTripper.(*http.Transport)
+	if !ok {
+		transport = http.DefaultTransport.(*http.Transport)
+	}
+	transport = transport.Clone()
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
@@ -0,0 +1,66 @@
+package prometheus
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Span attributes recorded for every query. The system and query attributes
+// follow the OpenTelemetry database conventions, so tracing backends show the
+// spans as database calls.
+const (
+	SystemAttribute      = "db.system"
+	QueryAttribute       = "db.statement"
+	DurationAttribute    = "promql.duration_ms"
+	ResultCountAttribute = "promql.result_count"
+)
+
+// WithTracer records a span for every instant and range query, as a child
+// of the span in the query's context. Without a tracer the client does not
+// trace.
+func WithTracer(tracer trace.Tracer) ClientOption {
+	return func(o *clientOptions) {
+		o.tracer = tracer
+	}
+}
+
+// noopTracer stands in when no tracer is configured and records nothing
+var noopTracer = noop.NewTracerProvider().Tracer("")
+
+// querySpan traces a single query sent to Prometheus
+type querySpan struct {
+	span    trace.Span
+	started time.Time
+}
+
+// startQuerySpan starts a client span named name for query, returning the
+// context to send the query with
+func (c *Client) startQuerySpan(ctx context.Context, name, query string) (context.Context, querySpan) {
+	tracer := c.tracer
+	if tracer == nil {
+		tracer = noopTracer
+	}
+	ctx, span := tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String(SystemAttribute, "prometheus"),
+			attribute.String(QueryAttribute, query)))
+	return ctx, querySpan{span: span, started: time.Now()}
+}
+
+// end records the outcome of the query and ends the span
+func (s querySpan) end(results int, err error) {
+	s.span.SetAttributes(
+		attribute.Int64(DurationAttribute, time.Since(s.started).Milliseconds()),
+		attribute.Int(ResultCountAttribute, results))
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
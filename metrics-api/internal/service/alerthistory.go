@@ -0,0 +1,103 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"metrics-api/internal/models"
+)
+
+// alertStateInactive is the state of an alert that is no longer reported
+const alertStateInactive = "inactive"
+
+// AlertHistory tracks state transitions of alerts across successive snapshots
+type AlertHistory struct {
+	mu          sync.Mutex
+	retention   time.Duration
+	initialised bool
+	alerts      map[string]*trackedAlert
+}
+
+// trackedAlert is the last observed state of an alert and its recent transitions
+type trackedAlert struct {
+	alert       models.Alert
+	transitions []time.Time
+}
+
+// NewAlertHistory creates a history that forgets transitions older than retention
+func NewAlertHistory(retention time.Duration) *AlertHistory {
+	return &AlertHistory{
+		retention: retention,
+		alerts:    make(map[string]*trackedAlert),
+	}
+}
+
+// Record compares a snapshot of the current alerts with the previous one and
+// records a transition for every alert whose state changed, including alerts
+// that appeared or disappeared. The first snapshot only sets the baseline.
+func (h *AlertHistory) Record(alerts []models.Alert, at time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	seen := make(map[string]bool, len(alerts))
+	for _, alert := range alerts {
+		key := seriesKey(alert.Name, alert.Labels)
+		seen[key] = true
+
+		tracked, ok := h.alerts[key]
+		if !ok {
+			tracked = &trackedAlert{alert: models.Alert{State: alertStateInactive}}
+			h.alerts[key] = tracked
+		}
+		if h.initialised && tracked.alert.State != alert.State {
+			tracked.transitions = append(tracked.transitions, at)
+		}
+		tracked.alert = alert
+	}
+
+	for key, tracked := range h.alerts {
+		if !seen[key] && tracked.alert.State != alertStateInactive {
+			tracked.alert.State = alertStateInactive
+			tracked.transitions = append(tracked.transitions, at)
+		}
+		tracked.transitions = transitionsSince(tracked.transitions, at.Add(-h.retention))
+		if tracked.alert.State == alertStateInactive && len(tracked.transitions) == 0 {
+			delete(h.alerts, key)
+		}
+	}
+
+	h.initialised = true
+}
+
+// Flapping returns alerts with at least minTransitions transitions since since
+func (h *AlertHistory) Flapping(since time.Time, minTransitions int) []models.FlappingAlert {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	flapping := []models.FlappingAlert{}
+	for _, tracked := range h.alerts {
+		transitions := transitionsSince(tracked.transitions, since)
+		if len(transitions) == 0 || len(transitions) < minTransitions {
+			continue
+		}
+		flapping = append(flapping, models.FlappingAlert{
+			Name:           tracked.alert.Name,
+			State:          tracked.alert.State,
+			Severity:       tracked.alert.Severity,
+			Labels:         tracked.alert.Labels,
+			Transitions:    len(transitions),
+			LastTransition: transitions[len(transitions)-1],
+		})
+	}
+	return flapping
+}
+
+// transitionsSince drops the transitions before since; times are in order
+func transitionsSince(transitions []time.Time, since time.Time) []time.Time {
+	for i, at := range transitions {
+		if !at.Before(since) {
+			return transitions[i:]
+		}
+	}
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 
 	"metrics-api/internal/models"
 	"metrics-api/internal/prometheus"
+	"metrics-api/internal/source"
 	"metrics-api/pkg/logger"
 )
 
@@ -15,21 +16,29 @@ import (
 type AlertsService struct {
 	client  *prometheus.Client
 	logger  logger.Logger
+	history *AlertHistory
 }
 
 // NewAlertsService creates a new alerts service
 func NewAlertsService(client *prometheus.Client, logger logger.Logger) *AlertsService {
 	return &AlertsService{
-		client: client,
-		logger: logger,
+		client:  client,
+		logger:  logger,
+		history: NewAlertHistory(24 * time.Hour),
 	}
 }
 
+// WithHistory sets the tracker that records alert state transitions
+func (s *AlertsService) WithHistory(history *AlertHistory) *AlertsService {
+	s.history = history
+	return s
+}
+
 // GetAlerts retrieves all current alerts from Prometheus
 func (s *AlertsService) GetAlerts(ctx context.Context) ([]models.Alert, error) {
 	s.logger.Info("Retrieving current alerts")
 	
-	promAlerts, err := s.client.GetAlerts(ctx)
+	promAlerts, err := prometheus.FromContext(ctx, s.client).GetAlerts(ctx)
 	if err != nil {
 		s.logger.Errorf("Failed to get alerts: %v", err)
 		return nil, fmt.Errorf("failed to get alerts: %w", err)
@@ -81,9 +90,34 @@ func (s *AlertsService) GetAlerts(ctx context.Context) ([]models.Alert, error) {
 		return alerts[i].Name < alerts[j].Name
 	})
 	
+	// Only the default source is tracked, as alerts of several backends
+	// would read as transitions of one another
+	if source.FromContext(ctx) == "" {
+		s.history.Record(alerts, time.Now())
+	}
+
 	return alerts, nil
 }
 
+// GetFlappingAlerts reports alerts that changed state at least minTransitions
+// times within window, most transitions first. Transitions are only seen when
+// alerts are fetched, so this relies on alerts being polled regularly.
+func (s *AlertsService) GetFlappingAlerts(ctx context.Context, window time.Duration, minTransitions int) ([]models.FlappingAlert, error) {
+	if window <= 0 || minTransitions <= 0 {
+		return nil, fmt.Errorf("%w: window and minimum transitions must be positive", models.ErrInvalidQuery)
+	}
+
+	flapping := s.history.Flapping(time.Now().Add(-window), minTransitions)
+	sort.Slice(flapping, func(i, j int) bool {
+		if flapping[i].Transitions != flapping[j].Transitions {
+			return flapping[i].Transitions > flapping[j].Transitions
+		}
+		return flapping[i].Name < flapping[j].Name
+	})
+
+	return flapping, nil
+}
+
 // GetAlertGroups retrieves alerts grouped by a specified label
 func (s *AlertsService) GetAlertGroups(ctx context.Context, groupBy string) ([]models.AlertGroup, error) {
 	if groupBy == "" {
@@ -242,4 +276,32 @@ func getSeverityRank(severity string) int {
 	default:
 		return 6
 	}
-}
\ No newline at end of file
+}
+
+// DiffAlerts compares two alert snapshots, identifying alerts by name and
+// labels. Removed alerts are reported as last seen.
+func DiffAlerts(previous, current []models.Alert) models.AlertDiff {
+	before := make(map[string]models.Alert, len(previous))
+	for _, alert := range previous {
+		before[seriesKey(alert.Name, alert.Labels)] = alert
+	}
+
+	var diff models.AlertDiff
+	for _, alert := range current {
+		key := seriesKey(alert.Name, alert.Labels)
+		last, ok := before[key]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, alert)
+		case last.State != alert.State:
+			diff.Changed = append(diff.Changed, alert)
+		}
+		delete(before, key)
+	}
+	for _, alert := range previous {
+		if _, ok := before[seriesKey(alert.Name, alert.Labels)]; ok {
+			diff.Removed = append(diff.Removed, alert)
+		}
+	}
+	return diff
+}
@@ -0,0 +1,263 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"metrics-api/internal/cache"
+	"metrics-api/internal/models"
+	"metrics-api/internal/prometheus"
+	"metrics-api/pkg/logger"
+
+	"github.com/google/uuid"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// MaxExportJobs bounds the export jobs kept for polling. StartExport rejects
+// new exports while that many are running.
+const MaxExportJobs = 100
+
+// exportFailedMessage is the Error of a failed job; the cause is in Err
+const exportFailedMessage = "Export query failed"
+
+// ExportService runs large range queries in the background, one chunk at a time
+type ExportService struct {
+	client      *prometheus.Client
+	logger      logger.Logger
+	jobs        *cache.Cache
+	running     atomic.Int64
+	chunkPoints int
+	maxPoints   int
+}
+
+// exportJob is the mutable state behind a models.ExportJob
+type exportJob struct {
+	mu     sync.Mutex
+	job    models.ExportJob
+	cancel context.CancelFunc
+}
+
+// snapshot returns a copy of the job that is safe to hand to callers
+func (j *exportJob) snapshot() models.ExportJob {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.job
+}
+
+// NewExportService creates a new export service
+func NewExportService(client *prometheus.Client, logger logger.Logger) *ExportService {
+	s := &ExportService{
+		client:      client,
+		logger:      logger,
+		chunkPoints: 1000,    // Well below the Prometheus per-query limit
+		maxPoints:   1000000, // Default max points per export
+	}
+	s.jobs = cache.New(cache.Options{
+		DefaultExpiration: time.Hour,
+		CleanupInterval:   10 * time.Minute,
+		MaxItems:          MaxExportJobs,
+		EvictionPolicy:    cache.EvictOldest,
+		OnEviction:        s.dropped,
+		OnExpiry:          s.dropped,
+	})
+	return s
+}
+
+// WithChunkPoints sets how many points each chunked range query may return
+func (s *ExportService) WithChunkPoints(chunkPoints int) *ExportService {
+	s.chunkPoints = chunkPoints
+	return s
+}
+
+// StartExport validates params and starts exporting them in the background
+// from the Prometheus source ctx is directed at. The job outlives ctx.
+func (s *ExportService) StartExport(ctx context.Context, params models.RangeQueryParams) (models.ExportJob, error) {
+	if params.Query == "" {
+		return models.ExportJob{}, models.ErrInvalidQuery
+	}
+	if params.Start.IsZero() || params.End.IsZero() || !params.Start.Before(params.End) {
+		return models.ExportJob{}, models.ErrInvalidTimeRange
+	}
+
+	step, err := time.ParseDuration(params.Step)
+	if err != nil || step <= 0 {
+		return models.ExportJob{}, fmt.Errorf("%w: invalid step duration %q", models.ErrInvalidQuery, params.Step)
+	}
+
+	points := int(params.End.Sub(params.Start)/step) + 1
+	if points > s.maxPoints {
+		return models.ExportJob{}, models.ErrTooManyDataPoints
+	}
+
+	chunk := step * time.Duration(s.chunkPoints)
+	chunks := int((params.End.Sub(params.Start) + chunk - 1) / chunk)
+	if chunks == 0 {
+		chunks = 1
+	}
+
+	if s.running.Add(1) > MaxExportJobs {
+		s.running.Add(-1)
+		return models.ExportJob{}, models.ErrTooManyExports
+	}
+
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	job := &exportJob{
+		job: models.ExportJob{
+			ID:          uuid.New().String(),
+			Query:       params.Query,
+			Start:       params.Start,
+			End:         params.End,
+			Step:        step,
+			Status:      models.ExportJobRunning,
+			ChunksTotal: chunks,
+			CreatedAt:   time.Now(),
+		},
+		cancel: cancel,
+	}
+	if err := s.jobs.Set(job.job.ID, job); err != nil {
+		cancel()
+		s.running.Add(-1)
+		return models.ExportJob{}, fmt.Errorf("failed to store export job: %w", err)
+	}
+
+	s.logger.Infof("Starting export job %s for %s in %d chunks", job.job.ID, params.Query, chunks)
+	go s.run(ctx, job, chunk)
+
+	return job.snapshot(), nil
+}
+
+// GetExport returns the current state of an export job
+func (s *ExportService) GetExport(id string) (models.ExportJob, error) {
+	job, ok := s.lookup(id)
+	if !ok {
+		return models.ExportJob{}, models.ErrExportJobNotFound
+	}
+	return job.snapshot(), nil
+}
+
+// CancelExport stops a running export job
+func (s *ExportService) CancelExport(id string) (models.ExportJob, error) {
+	job, ok := s.lookup(id)
+	if !ok {
+		return models.ExportJob{}, models.ErrExportJobNotFound
+	}
+
+	job.mu.Lock()
+	if job.job.Status == models.ExportJobRunning {
+		now := time.Now()
+		job.job.Status = models.ExportJobCancelled
+		job.job.FinishedAt = &now
+	}
+	job.mu.Unlock()
+
+	job.cancel()
+	return job.snapshot(), nil
+}
+
+// dropped cancels a job the cache removed while it was still running, as
+// nobody could poll, download or cancel it any more
+func (s *ExportService) dropped(id string, value interface{}) {
+	job := value.(*exportJob)
+	if job.snapshot().Status == models.ExportJobRunning {
+		s.logger.Warnf("Cancelling export job %s removed from the job cache", id)
+		job.cancel()
+	}
+}
+
+// lookup fetches a job from the cache
+func (s *ExportService) lookup(id string) (*exportJob, bool) {
+	value, ok := s.jobs.Get(id)
+	if !ok {
+		return nil, false
+	}
+	return value.(*exportJob), true
+}
+
+// run executes the job chunk by chunk, merging series across chunks
+func (s *ExportService) run(ctx context.Context, job *exportJob, chunk time.Duration) {
+	defer s.running.Add(-1)
+	defer job.cancel()
+
+	params := job.snapshot()
+	response := &models.RangeQueryResponse{
+		Query:  params.Query,
+		Start:  params.Start,
+		End:    params.End,
+		Step:   params.Step,
+		Status: "success",
+		Series: []models.TimeSeries{},
+	}
+	index := make(map[string]int)
+
+	for i := 0; i < params.ChunksTotal; i++ {
+		// Chunks end one step before the next begins so no point is fetched twice
+		start := params.Start.Add(time.Duration(i) * chunk)
+		end := start.Add(chunk - params.Step)
+		if end.After(params.End) || i == params.ChunksTotal-1 {
+			end = params.End
+		}
+
+		results, err := prometheus.FromContext(ctx, s.client).ExecuteRangeQuery(ctx, params.Query, v1.Range{
+			Start: start,
+			End:   end,
+			Step:  params.Step,
+		}, prometheus.WithoutCache())
+		if err != nil {
+			s.finish(job, nil, err)
+			return
+		}
+
+		for _, result := range results {
+			key := seriesKey(result.MetricName, result.Labels)
+			pos, ok := index[key]
+			if !ok {
+				pos = len(response.Series)
+				index[key] = pos
+				response.Series = append(response.Series, models.TimeSeries{
+					MetricName: result.MetricName,
+					Labels:     result.Labels,
+				})
+			}
+			for _, pair := range result.Values {
+				response.Series[pos].DataPoints = append(response.Series[pos].DataPoints, models.TimeValuePair{
+					Timestamp: pair.Timestamp,
+					Value:     pair.Value,
+				})
+			}
+		}
+
+		job.mu.Lock()
+		job.job.ChunksDone = i + 1
+		job.job.Progress = float64(i+1) / float64(params.ChunksTotal)
+		job.mu.Unlock()
+	}
+
+	s.finish(job, response, nil)
+}
+
+// finish records the outcome of a job unless it was already cancelled
+func (s *ExportService) finish(job *exportJob, result *models.RangeQueryResponse, err error) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	if job.job.Status != models.ExportJobRunning {
+		return
+	}
+
+	now := time.Now()
+	job.job.FinishedAt = &now
+	if err != nil {
+		s.logger.Errorf("Export job %s failed: %v", job.job.ID, err)
+		job.job.Status = models.ExportJobFailed
+		job.job.Error = exportFailedMessage
+		job.job.Err = err
+		return
+	}
+
+	s.logger.Infof("Export job %s completed", job.job.ID)
+	job.job.Status = models.ExportJobCompleted
+	job.job.Result = result
+}
@@ -2,54 +2,152 @@ package service
 
 import (
 	"context"
+	"encoding/gob"
+	"errors"
 	"fmt"
 	"math"
+	"regexp"
 	"sort"
-	"sync"
 	"time"
 
+	"metrics-api/internal/cache"
+	"metrics-api/internal/cachekey"
 	"metrics-api/internal/models"
 	"metrics-api/internal/prometheus"
+	"metrics-api/pkg/errutil"
 	"metrics-api/pkg/logger"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
 )
 
 // MetricsService handles metrics-related operations
 type MetricsService struct {
 	client  *prometheus.Client
 	logger  logger.Logger
-	cache   map[string]cachedMetricSummary
-	cacheMu sync.RWMutex
+	summaries *cache.NamespacedCache
 	cacheTTL time.Duration
+	hidden   []*regexp.Regexp
+	baselines *cache.Cache
+	stalenessThreshold time.Duration
+	scrapeInterval     time.Duration
+	anomalyWindow      time.Duration
+	cardinalityAlert   CardinalityAlertConfig
+}
+
+// CardinalityAlertConfig reports metrics with too many series. Operators
+// wire AlertCallback into their own notification system.
+type CardinalityAlertConfig struct {
+	// MaxSeriesPerMetric is the most series a metric may have before it is
+	// reported; zero disables the alert
+	MaxSeriesPerMetric int
+
+	// AlertCallback is called with each metric over the limit and its
+	// series count
+	AlertCallback func(metricName string, count int)
 }
 
-type cachedMetricSummary struct {
-	data      models.MetricSummary
-	timestamp time.Time
+// gapWindowScrapes is how many scrape intervals the gap check looks back over
+const gapWindowScrapes = 10
+
+// DefaultAnomalyWindow is how far back the rolling mean and standard
+// deviation of anomaly detection look from each sample
+const DefaultAnomalyWindow = time.Hour
+
+// maxAnomalyPoints bounds the samples per series fetched for anomaly
+// detection; longer lookbacks use a coarser step
+const maxAnomalyPoints = 1000
+
+// summaryNamespace holds metric summaries and names in the cache shared with
+// the client
+const summaryNamespace = "metrics"
+
+// MetricNamesTTL is how long the list of metric names searched by
+// SearchMetrics is reused before it is fetched again
+const MetricNamesTTL = time.Minute
+
+// Summaries are kept in the cache shared with the client, so register them
+// with gob for cache.Cache.SaveToFile
+func init() {
+	gob.Register(models.MetricSummary{})
 }
 
 // NewMetricsService creates a new metrics service
 func NewMetricsService(client *prometheus.Client, logger logger.Logger) *MetricsService {
+	shared := client.Cache()
+	if shared == nil {
+		// The client runs uncached, so summaries get a cache of their own
+		shared = cache.New(cache.Options{})
+	}
+
 	return &MetricsService{
-		client:   client,
-		logger:   logger,
-		cache:    make(map[string]cachedMetricSummary),
-		cacheTTL: 5 * time.Minute, // Default cache TTL
+		client:    client,
+		logger:    logger,
+		summaries: shared.Namespace(summaryNamespace),
+		cacheTTL:  5 * time.Minute, // Default cache TTL
+		stalenessThreshold: 5 * time.Minute,
+		scrapeInterval:     time.Minute, // Prometheus default scrape interval
+		anomalyWindow:      DefaultAnomalyWindow,
+		baselines: cache.New(cache.Options{
+			MaxItems:       1000,
+			EvictionPolicy: cache.EvictOldest,
+		}),
 	}
 }
 
+// clientFor returns the client of the Prometheus source ctx is directed at
+func (s *MetricsService) clientFor(ctx context.Context) *prometheus.Client {
+	return prometheus.FromContext(ctx, s.client)
+}
+
 // WithCacheTTL sets the cache TTL
 func (s *MetricsService) WithCacheTTL(ttl time.Duration) *MetricsService {
 	s.cacheTTL = ttl
 	return s
 }
 
+// WithStalenessThreshold sets how old a metric's newest sample may be before
+// the metric is reported as stale
+func (s *MetricsService) WithStalenessThreshold(threshold time.Duration) *MetricsService {
+	s.stalenessThreshold = threshold
+	return s
+}
+
+// WithScrapeInterval sets the scrape interval used to detect gaps in metrics
+func (s *MetricsService) WithScrapeInterval(interval time.Duration) *MetricsService {
+	s.scrapeInterval = interval
+	return s
+}
+
+// WithAnomalyWindow sets the span of the rolling window anomalies are
+// detected against
+func (s *MetricsService) WithAnomalyWindow(window time.Duration) *MetricsService {
+	s.anomalyWindow = window
+	return s
+}
+
+// WithCardinalityAlert reports metrics with more series than the configured
+// limit whenever GetTopMetrics counts them
+func (s *MetricsService) WithCardinalityAlert(config CardinalityAlertConfig) *MetricsService {
+	s.cardinalityAlert = config
+	return s
+}
+
+// WithHiddenPatterns hides matching metric names from listings
+func (s *MetricsService) WithHiddenPatterns(patterns []*regexp.Regexp) *MetricsService {
+	s.hidden = patterns
+	return s
+}
+
 // GetMetrics retrieves the list of available metrics
 func (s *MetricsService) GetMetrics(ctx context.Context) ([]string, error) {
-	metrics, err := s.client.GetMetrics(ctx)
+	metrics, err := s.clientFor(ctx).GetMetrics(ctx)
 	if err != nil {
 		s.logger.Errorf("Failed to get metrics: %v", err)
 		return nil, fmt.Errorf("failed to get metrics: %w", err)
 	}
+
+	metrics = filterHidden(metrics, s.hidden)
 	
 	// Sort metrics for consistent output
 	sort.Strings(metrics)
@@ -57,22 +155,91 @@ func (s *MetricsService) GetMetrics(ctx context.Context) ([]string, error) {
 	return metrics, nil
 }
 
-// GetMetricSummary provides a summary of a specific metric
+// SearchMetrics returns up to maxResults visible metric names matching the
+// regular expression pattern anywhere, in order; maxResults <= 0 returns all
+// matches. The names are fetched at most once per MetricNamesTTL.
+func (s *MetricsService) SearchMetrics(ctx context.Context, pattern string, maxResults int) ([]string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid search pattern: %v", models.ErrInvalidQuery, err)
+	}
+
+	key := cachekey.NamesKey(ctx)
+	var metrics []string
+	if cached, found := s.summaries.Get(key); found {
+		metrics = cached.([]string)
+	} else {
+		metrics, err = s.GetMetrics(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.summaries.SetWithExpiration(key, metrics, MetricNamesTTL); err != nil {
+			s.logger.Warnf("Failed to cache metric names: %v", err)
+		}
+	}
+
+	matches := []string{}
+	for _, metric := range metrics {
+		if maxResults > 0 && len(matches) == maxResults {
+			break
+		}
+		if re.MatchString(metric) {
+			matches = append(matches, metric)
+		}
+	}
+	return matches, nil
+}
+
+// partialSummary carries a summary whose statistics could not all be
+// computed out of GetOrSet, which caches only complete results
+type partialSummary struct {
+	summary *models.MetricSummary
+	err     error
+}
+
+func (p *partialSummary) Error() string { return p.err.Error() }
+
+func (p *partialSummary) Unwrap() error { return p.err }
+
+// GetMetricSummary provides a summary of a specific metric.
+// If some statistics could not be computed the summary is still returned
+// together with an *errutil.Multi describing the failed sub-queries.
 func (s *MetricsService) GetMetricSummary(ctx context.Context, metricName string) (*models.MetricSummary, error) {
-	// Check cache first
-	s.cacheMu.RLock()
-	cached, exists := s.cache[metricName]
-	s.cacheMu.RUnlock()
-	
-	if exists && time.Since(cached.timestamp) < s.cacheTTL {
-		s.logger.Debugf("Cache hit for metric summary: %s", metricName)
-		return &cached.data, nil
+	// A zero TTL disables summary caching
+	if s.cacheTTL <= 0 {
+		return s.buildMetricSummary(ctx, metricName)
 	}
-	
-	s.logger.Debugf("Cache miss for metric summary: %s", metricName)
-	
+
+	// Concurrent requests for a summary that is not cached share one set
+	// of queries. Partial summaries are returned but not cached.
+	key := cachekey.SummaryKey(ctx, metricName)
+	value, err := s.summaries.GetOrSet(key, s.cacheTTL, func() (interface{}, error) {
+		s.logger.Debugf("Cache miss for metric summary: %s", metricName)
+		summary, err := s.buildMetricSummary(ctx, metricName)
+		if err != nil {
+			return nil, &partialSummary{summary: summary, err: err}
+		}
+		return *summary, nil
+	})
+	var partial *partialSummary
+	if errors.As(err, &partial) {
+		return partial.summary, partial.err
+	}
+	if err != nil {
+		if value == nil {
+			return nil, err
+		}
+		s.logger.Warnf("Failed to cache summary for metric %s: %v", metricName, err)
+	}
+
+	data := value.(models.MetricSummary)
+	return &data, nil
+}
+
+// buildMetricSummary queries Prometheus for a summary of a metric
+func (s *MetricsService) buildMetricSummary(ctx context.Context, metricName string) (*models.MetricSummary, error) {
 	// Fetch labels
-	labels, err := s.client.GetLabelsForMetric(ctx, metricName)
+	labels, err := s.clientFor(ctx).GetLabelsForMetric(ctx, metricName)
 	if err != nil {
 		s.logger.Errorf("Failed to get labels for metric %s: %v", metricName, err)
 		return nil, fmt.Errorf("failed to get labels for metric %s: %w", metricName, err)
@@ -81,7 +248,7 @@ func (s *MetricsService) GetMetricSummary(ctx context.Context, metricName string
 	// Query current value (if available)
 	now := time.Now()
 	query := metricName
-	results, err := s.client.Query(ctx, query, now)
+	results, err := s.clientFor(ctx).Query(ctx, query, now)
 	if err != nil {
 		s.logger.Errorf("Failed to query metric %s: %v", metricName, err)
 		return nil, fmt.Errorf("failed to query metric %s: %w", metricName, err)
@@ -89,7 +256,7 @@ func (s *MetricsService) GetMetricSummary(ctx context.Context, metricName string
 	
 	// Get cardinality
 	cardinalityQuery := fmt.Sprintf("count(%s)", metricName)
-	cardinalityResults, err := s.client.Query(ctx, cardinalityQuery, now)
+	cardinalityResults, err := s.clientFor(ctx).Query(ctx, cardinalityQuery, now)
 	if err != nil {
 		s.logger.Errorf("Failed to get cardinality for metric %s: %v", metricName, err)
 		return nil, fmt.Errorf("failed to get cardinality for metric %s: %w", metricName, err)
@@ -110,11 +277,12 @@ func (s *MetricsService) GetMetricSummary(ctx context.Context, metricName string
 	}
 	
 	stats := models.MetricStats{}
+	var statErrs errutil.Multi
 	
 	for statName, statQuery := range statsQueries {
-		statResults, err := s.client.Query(ctx, statQuery, now)
+		statResults, err := s.clientFor(ctx).Query(ctx, statQuery, now)
 		if err != nil {
-			s.logger.Warnf("Failed to get %s for metric %s: %v", statName, metricName, err)
+			statErrs.Add(statName, err)
 			continue
 		}
 		
@@ -153,19 +321,40 @@ func (s *MetricsService) GetMetricSummary(ctx context.Context, metricName string
 		LastUpdated: now,
 		Samples:     samples,
 	}
+
+	// Metadata is descriptive only, so a summary is still useful without it
+	metadata, err := s.clientFor(ctx).GetMetadata(ctx, metricName)
+	if err != nil {
+		s.logger.Warnf("Failed to get metadata for metric %s: %v", metricName, err)
+	} else if len(metadata) > 0 {
+		summary.Type = metadata[0].Type
+		summary.Help = metadata[0].Help
+		summary.Unit = metadata[0].Unit
+		if metadata[0].Type == string(model.MetricTypeCounter) {
+			summary.Warnings = append(summary.Warnings, fmt.Sprintf(
+				"%s is a counter: its raw value only grows and resets on restart, so use rate() to see how fast it changes", metricName))
+			summary.SuggestedQuery = s.rateQuery(metricName)
+		}
+	}
 	
-	// Update cache
-	s.cacheMu.Lock()
-	s.cache[metricName] = cachedMetricSummary{
-		data:      *summary,
-		timestamp: time.Now(),
+	if !statErrs.Success() {
+		s.logger.Warnf("Failed to get stats for metric %s: %v", metricName, &statErrs)
+		return summary, &statErrs
 	}
-	s.cacheMu.Unlock()
 	
 	return summary, nil
 }
 
-// GetTopMetrics gets the top N metrics by cardinality or activity
+// rateQuery returns the per-second rate of a counter over four scrape
+// intervals, the shortest window that reliably holds two samples and rides
+// out a missed scrape; rate() also corrects for counter resets
+func (s *MetricsService) rateQuery(metricName string) string {
+	return fmt.Sprintf("rate(%s[%s])", metricName, model.Duration(4*s.scrapeInterval))
+}
+
+// GetTopMetrics gets the top N metrics by cardinality or activity.
+// Metrics whose sub-queries fail are reported in an *errutil.Multi
+// returned alongside the metrics that succeeded.
 func (s *MetricsService) GetTopMetrics(ctx context.Context, limit int) ([]models.TopMetric, error) {
 	if limit <= 0 {
 		limit = 10 // Default limit
@@ -178,12 +367,13 @@ func (s *MetricsService) GetTopMetrics(ctx context.Context, limit int) ([]models
 	
 	now := time.Now()
 	topMetrics := make([]models.TopMetric, 0, len(allMetrics))
+	var metricErrs errutil.Multi
 	
 	for _, metricName := range allMetrics {
 		cardinalityQuery := fmt.Sprintf("count(%s)", metricName)
-		results, err := s.client.Query(ctx, cardinalityQuery, now)
+		results, err := s.clientFor(ctx).Query(ctx, cardinalityQuery, now)
 		if err != nil {
-			s.logger.Warnf("Failed to get cardinality for %s: %v", metricName, err)
+			metricErrs.Add(metricName, err)
 			continue
 		}
 		
@@ -191,13 +381,12 @@ func (s *MetricsService) GetTopMetrics(ctx context.Context, limit int) ([]models
 		if len(results) > 0 {
 			cardinality = results[0].Value
 		}
+		s.checkCardinality(metricName, int(cardinality))
 		
 		// Get sample rate
 		rateQuery := fmt.Sprintf("rate(%s[5m])", metricName)
-		rateResults, err := s.client.Query(ctx, rateQuery, now)
-		if err != nil {
-			s.logger.Debugf("Rate query failed for %s: %v", metricName, err)
-		}
+		rateResults, err := s.clientFor(ctx).Query(ctx, rateQuery, now)
+		metricErrs.Add(metricName+"/rate", err)
 		
 		var sampleRate float64
 		if len(rateResults) > 0 {
@@ -228,16 +417,69 @@ func (s *MetricsService) GetTopMetrics(ctx context.Context, limit int) ([]models
 		topMetrics = topMetrics[:limit]
 	}
 	
+	if !metricErrs.Success() {
+		s.logger.Warnf("Failed to query some top metrics: %v", &metricErrs)
+		return topMetrics, &metricErrs
+	}
+	
 	return topMetrics, nil
 }
 
-// GetMetricHealth provides health information about a specific metric
+// checkCardinality calls the cardinality alert callback when metricName has
+// more series than allowed
+func (s *MetricsService) checkCardinality(metricName string, count int) {
+	alert := s.cardinalityAlert
+	if alert.MaxSeriesPerMetric <= 0 || alert.AlertCallback == nil || count <= alert.MaxSeriesPerMetric {
+		return
+	}
+	alert.AlertCallback(metricName, count)
+}
+
+// GetHighCardinalityMetrics returns the visible metrics with more than
+// threshold series, most series first. The series of all metrics are counted
+// in a single query.
+func (s *MetricsService) GetHighCardinalityMetrics(ctx context.Context, threshold int) ([]models.MetricCardinality, error) {
+	if threshold < 0 {
+		return nil, fmt.Errorf("%w: threshold must not be negative", models.ErrInvalidQuery)
+	}
+
+	query := fmt.Sprintf(`count by (__name__) ({__name__=~".+"}) > %d`, threshold)
+	results, err := s.clientFor(ctx).Query(ctx, query, time.Now())
+	if err != nil {
+		s.logger.Errorf("Failed to count series per metric: %v", err)
+		return nil, fmt.Errorf("failed to count series per metric: %w", err)
+	}
+
+	metrics := make([]models.MetricCardinality, 0, len(results))
+	for _, result := range results {
+		if isHidden(result.MetricName, s.hidden) {
+			continue
+		}
+		metrics = append(metrics, models.MetricCardinality{
+			Name:        result.MetricName,
+			Cardinality: int64(result.Value),
+		})
+	}
+
+	sort.Slice(metrics, func(i, j int) bool {
+		if metrics[i].Cardinality != metrics[j].Cardinality {
+			return metrics[i].Cardinality > metrics[j].Cardinality
+		}
+		return metrics[i].Name < metrics[j].Name
+	})
+	return metrics, nil
+}
+
+// GetMetricHealth provides health information about a specific metric.
+// A metric is stale when its newest sample is older than the staleness
+// threshold, and has gaps when any series recorded fewer samples over the
+// last gapWindowScrapes scrape intervals than its scrape interval implies.
 func (s *MetricsService) GetMetricHealth(ctx context.Context, metricName string) (*models.MetricHealth, error) {
 	now := time.Now()
 	
 	// Check if metric exists
 	query := fmt.Sprintf("count(%s)", metricName)
-	results, err := s.client.Query(ctx, query, now)
+	results, err := s.clientFor(ctx).Query(ctx, query, now)
 	if err != nil {
 		s.logger.Errorf("Failed to query metric existence for %s: %v", metricName, err)
 		return nil, fmt.Errorf("failed to query metric existence: %w", err)
@@ -246,31 +488,32 @@ func (s *MetricsService) GetMetricHealth(ctx context.Context, metricName string)
 	// Check if the metric is being scraped
 	exists := len(results) > 0 && results[0].Value > 0
 	
-	// Get last scrape time
-	scrapeTimeQuery := "scrape_time_seconds{instance=~\".+\", job=~\".+\"} > 0"
-	scrapeResults, err := s.client.Query(ctx, scrapeTimeQuery, now)
+	// Age of the newest sample across all series
+	ageQuery := fmt.Sprintf("time() - max(timestamp(%s))", metricName)
+	ageResults, err := s.clientFor(ctx).Query(ctx, ageQuery, now)
 	if err != nil {
-		s.logger.Warnf("Failed to query scrape time: %v", err)
+		s.logger.Warnf("Failed to query sample age for %s: %v", metricName, err)
 		// Continue anyway as this is not critical
 	}
 	
 	var lastScraped time.Time
-	if len(scrapeResults) > 0 {
-		lastScraped = scrapeResults[0].Timestamp
+	isStale := true
+	if len(ageResults) > 0 && !math.IsNaN(ageResults[0].Value) {
+		age := time.Duration(ageResults[0].Value * float64(time.Second))
+		lastScraped = now.Add(-age)
+		isStale = age > s.stalenessThreshold
 	}
 	
-	// Check staleness
-	staleThreshold := now.Add(-5 * time.Minute)
-	isStale := lastScraped.Before(staleThreshold)
-	
-	// Check for gaps in data (if there are no samples in the last 5 minutes)
-	gapQuery := fmt.Sprintf("count_over_time(%s[5m]) > 0", metricName)
-	gapResults, err := s.client.Query(ctx, gapQuery, now)
+	// Check for gaps by comparing the sparsest series with the expected sample count
+	window := gapWindowScrapes * s.scrapeInterval
+	gapQuery := fmt.Sprintf("min(count_over_time(%s[%s]))", metricName, model.Duration(window))
+	gapResults, err := s.clientFor(ctx).Query(ctx, gapQuery, now)
 	if err != nil {
-		s.logger.Warnf("Failed to query for gaps: %v", err)
+		s.logger.Warnf("Failed to query for gaps in %s: %v", metricName, err)
 	}
 	
-	hasGaps := len(gapResults) == 0 || gapResults[0].Value == 0
+	// One missed scrape is tolerated since the window rarely aligns with scrapes
+	hasGaps := len(gapResults) == 0 || gapResults[0].Value < gapWindowScrapes-1
 	
 	health := &models.MetricHealth{
 		Name:        metricName,
@@ -283,3 +526,365 @@ func (s *MetricsService) GetMetricHealth(ctx context.Context, metricName string)
 	
 	return health, nil
 }
+
+// GetLabelValues returns the distinct values of label on the series of
+// metricName, limited to those present between start and end when set
+func (s *MetricsService) GetLabelValues(ctx context.Context, metricName, label string, start, end time.Time) ([]string, error) {
+	if !model.IsValidLegacyMetricName(metricName) {
+		return nil, fmt.Errorf("%w: invalid metric name %q", models.ErrInvalidQuery, metricName)
+	}
+	if !model.LabelName(label).IsValidLegacy() {
+		return nil, fmt.Errorf("%w: invalid label name %q", models.ErrInvalidQuery, label)
+	}
+	if !start.IsZero() && !end.IsZero() && start.After(end) {
+		return nil, fmt.Errorf("%w: start is after end", models.ErrInvalidTimeRange)
+	}
+
+	matcher := fmt.Sprintf("{__name__=%q}", metricName)
+	values, err := s.clientFor(ctx).GetLabelValues(ctx, label, []string{matcher}, start, end)
+	if err != nil {
+		s.logger.Errorf("Failed to get values of %s for %s: %v", label, metricName, err)
+		return nil, fmt.Errorf("failed to get label values: %w", err)
+	}
+	return values, nil
+}
+
+// GetJobs reports target health per scrape job, least healthy first
+func (s *MetricsService) GetJobs(ctx context.Context) ([]models.JobHealth, error) {
+	results, err := s.clientFor(ctx).Query(ctx, "up", time.Now())
+	if err != nil {
+		s.logger.Errorf("Failed to query target health: %v", err)
+		return nil, fmt.Errorf("failed to query target health: %w", err)
+	}
+
+	byJob := make(map[string]*models.JobHealth)
+	for _, result := range results {
+		name := result.Labels["job"]
+		job, ok := byJob[name]
+		if !ok {
+			job = &models.JobHealth{Job: name}
+			byJob[name] = job
+		}
+
+		job.Targets++
+		if result.Value == 1 {
+			job.Up++
+		} else {
+			job.Down++
+		}
+	}
+
+	jobs := make([]models.JobHealth, 0, len(byJob))
+	for _, job := range byJob {
+		jobs = append(jobs, *job)
+	}
+
+	// Order by the share of healthy targets, compared without division
+	sort.Slice(jobs, func(i, j int) bool {
+		left := jobs[i].Up * jobs[j].Targets
+		right := jobs[j].Up * jobs[i].Targets
+		if left != right {
+			return left < right
+		}
+		return jobs[i].Job < jobs[j].Job
+	})
+
+	return jobs, nil
+}
+
+// GetMetricQuantile computes the q-quantile of the current sample values of
+// metricName, per distinct combination of the by labels. It is meant for
+// gauges; use histogram_quantile for histograms.
+func (s *MetricsService) GetMetricQuantile(ctx context.Context, metricName string, q float64, by []string) (*models.MetricQuantile, error) {
+	if !(q > 0 && q < 1) {
+		return nil, fmt.Errorf("%w: quantile must be between 0 and 1, got %v", models.ErrInvalidQuery, q)
+	}
+	// The name is sent as the whole query, so it must not be any other PromQL
+	if !model.IsValidLegacyMetricName(metricName) {
+		return nil, fmt.Errorf("%w: invalid metric name %q", models.ErrInvalidQuery, metricName)
+	}
+	for _, name := range by {
+		if !model.LabelName(name).IsValidLegacy() {
+			return nil, fmt.Errorf("%w: invalid label name %q", models.ErrInvalidQuery, name)
+		}
+	}
+
+	results, err := s.clientFor(ctx).Query(ctx, metricName, time.Now())
+	if err != nil {
+		s.logger.Errorf("Failed to query samples of %s: %v", metricName, err)
+		return nil, fmt.Errorf("failed to query samples: %w", err)
+	}
+
+	groups := make(map[string]*models.QuantileGroup)
+	values := make(map[string][]float64)
+	for _, result := range results {
+		if math.IsNaN(result.Value) {
+			continue
+		}
+
+		var labels map[string]string
+		if len(by) > 0 {
+			labels = make(map[string]string, len(by))
+			for _, name := range by {
+				labels[name] = result.Labels[name]
+			}
+		}
+
+		key := seriesKey("", labels)
+		if _, ok := groups[key]; !ok {
+			groups[key] = &models.QuantileGroup{Labels: labels}
+		}
+		values[key] = append(values[key], result.Value)
+	}
+
+	if len(groups) == 0 {
+		return nil, models.ErrMetricNotFound
+	}
+
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	quantile := &models.MetricQuantile{
+		Metric:   metricName,
+		Quantile: q,
+		By:       by,
+		Results:  make([]models.QuantileGroup, 0, len(keys)),
+	}
+	for _, key := range keys {
+		group := groups[key]
+		group.Value = computeQuantile(q, values[key])
+		group.Count = len(values[key])
+		quantile.Results = append(quantile.Results, *group)
+	}
+
+	return quantile, nil
+}
+
+// computeQuantile returns the q-quantile of values, interpolating linearly
+// between the closest ranks like PromQL's quantile aggregation
+func computeQuantile(q float64, values []float64) float64 {
+	sort.Float64s(values)
+
+	rank := q * float64(len(values)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	weight := rank - float64(lower)
+
+	return values[lower]*(1-weight) + values[upper]*weight
+}
+
+// ExportMetric returns the raw samples of every series of req.Metric over
+// the requested range. Exports bypass the cache.
+func (s *MetricsService) ExportMetric(ctx context.Context, req models.ExportRequest) (*models.RangeQueryResponse, error) {
+	results, err := s.clientFor(ctx).QueryRange(ctx, req.Metric, v1.Range{Start: req.Start, End: req.End, Step: req.Step})
+	if err != nil {
+		s.logger.Errorf("Failed to export samples of %s: %v", req.Metric, err)
+		return nil, fmt.Errorf("failed to query samples: %w", err)
+	}
+
+	response := &models.RangeQueryResponse{
+		Query:  req.Metric,
+		Start:  req.Start,
+		End:    req.End,
+		Step:   req.Step,
+		Status: "success",
+		Series: make([]models.TimeSeries, 0, len(results)),
+	}
+	for _, result := range results {
+		series := models.TimeSeries{
+			MetricName: result.MetricName,
+			Labels:     result.Labels,
+			DataPoints: make([]models.TimeValuePair, 0, len(result.Values)),
+		}
+		for _, pair := range result.Values {
+			series.DataPoints = append(series.DataPoints, models.TimeValuePair{
+				Timestamp: pair.Timestamp,
+				Value:     pair.Value,
+			})
+		}
+		response.Series = append(response.Series, series)
+	}
+	return response, nil
+}
+
+// DetectAnomalies returns the samples of metricName over the last lookback
+// whose z-score against the rolling window before them exceeds threshold in
+// either direction, ordered by time. Results are not cached, since each call
+// should see the newest samples.
+func (s *MetricsService) DetectAnomalies(ctx context.Context, metricName string, lookback time.Duration, threshold float64) ([]models.Anomaly, error) {
+	if lookback <= 0 {
+		return nil, fmt.Errorf("%w: lookback must be positive", models.ErrInvalidQuery)
+	}
+	if !(threshold > 0) {
+		return nil, fmt.Errorf("%w: threshold must be positive", models.ErrInvalidQuery)
+	}
+
+	// Fetch one window more than the lookback so the first samples in the
+	// lookback have a full window of history
+	end := time.Now()
+	start := end.Add(-lookback - s.anomalyWindow)
+	step := s.scrapeInterval
+	if minStep := end.Sub(start) / maxAnomalyPoints; step < minStep {
+		step = minStep
+	}
+
+	results, err := s.clientFor(ctx).QueryRange(ctx, metricName, v1.Range{Start: start, End: end, Step: step})
+	if err != nil {
+		s.logger.Errorf("Failed to query samples of %s: %v", metricName, err)
+		return nil, fmt.Errorf("failed to query samples: %w", err)
+	}
+	if len(results) == 0 {
+		return nil, models.ErrMetricNotFound
+	}
+
+	from := end.Add(-lookback)
+	anomalies := []models.Anomaly{}
+	for _, result := range results {
+		for _, anomaly := range detectAnomalies(result.Values, s.anomalyWindow, threshold) {
+			if anomaly.Timestamp.Before(from) {
+				continue
+			}
+			anomaly.Labels = result.Labels
+			anomalies = append(anomalies, anomaly)
+		}
+	}
+
+	sort.SliceStable(anomalies, func(i, j int) bool {
+		return anomalies[i].Timestamp.Before(anomalies[j].Timestamp)
+	})
+	return anomalies, nil
+}
+
+// detectAnomalies scores each sample of values against the mean and
+// population standard deviation of the samples in the window before it.
+// Samples with fewer than two predecessors in the window, or whose window
+// has no variance, are not scored.
+func detectAnomalies(values []prometheus.TimeValuePair, window time.Duration, threshold float64) []models.Anomaly {
+	var anomalies []models.Anomaly
+	first := 0
+	for i, sample := range values {
+		if math.IsNaN(sample.Value) {
+			continue
+		}
+		for first < i && !values[first].Timestamp.After(sample.Timestamp.Add(-window)) {
+			first++
+		}
+
+		var sum float64
+		var count int
+		for _, prior := range values[first:i] {
+			if !math.IsNaN(prior.Value) {
+				sum += prior.Value
+				count++
+			}
+		}
+		if count < 2 {
+			continue
+		}
+		mean := sum / float64(count)
+
+		var squares float64
+		for _, prior := range values[first:i] {
+			if !math.IsNaN(prior.Value) {
+				squares += (prior.Value - mean) * (prior.Value - mean)
+			}
+		}
+		stdDev := math.Sqrt(squares / float64(count))
+		if stdDev == 0 {
+			continue
+		}
+
+		if z := (sample.Value - mean) / stdDev; math.Abs(z) > threshold {
+			anomalies = append(anomalies, models.Anomaly{
+				Timestamp: sample.Timestamp,
+				Value:     sample.Value,
+				ZScore:    z,
+				Expected:  mean,
+				StdDev:    stdDev,
+			})
+		}
+	}
+	return anomalies
+}
+
+// filterHidden drops metric names that match any of the hidden patterns
+func filterHidden(metrics []string, hidden []*regexp.Regexp) []string {
+	if len(hidden) == 0 {
+		return metrics
+	}
+
+	visible := make([]string, 0, len(metrics))
+	for _, metric := range metrics {
+		if !isHidden(metric, hidden) {
+			visible = append(visible, metric)
+		}
+	}
+	return visible
+}
+
+// isHidden reports whether name matches one of the hidden patterns
+func isHidden(name string, hidden []*regexp.Regexp) bool {
+	for _, re := range hidden {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// SaveBaseline stores the current summary of metric under name so later
+// summaries can be compared against it
+func (s *MetricsService) SaveBaseline(ctx context.Context, name, metric string) (*models.MetricBaseline, error) {
+	summary, err := s.GetMetricSummary(ctx, metric)
+	if err != nil {
+		// A partial summary would make every later comparison misleading
+		return nil, fmt.Errorf("failed to summarise metric %s for baseline: %w", metric, err)
+	}
+
+	baseline := &models.MetricBaseline{
+		Name:    name,
+		Metric:  metric,
+		SavedAt: time.Now(),
+		Summary: *summary,
+	}
+	if err := s.baselines.Set(name, baseline); err != nil {
+		return nil, fmt.Errorf("failed to store baseline %s: %w", name, err)
+	}
+
+	s.logger.Infof("Saved baseline %s for metric %s", name, metric)
+	return baseline, nil
+}
+
+// CompareWithBaseline summarises the baseline's metric now and reports the
+// change of every numeric field since the baseline was saved
+func (s *MetricsService) CompareWithBaseline(ctx context.Context, name string) (*models.BaselineComparison, error) {
+	value, ok := s.baselines.Get(name)
+	if !ok {
+		return nil, models.ErrBaselineNotFound
+	}
+	baseline := value.(*models.MetricBaseline)
+
+	current, err := s.GetMetricSummary(ctx, baseline.Metric)
+	if _, isPartial := errutil.AsMulti(err); err != nil && !isPartial {
+		return nil, err
+	}
+
+	comparison := &models.BaselineComparison{
+		Name:     baseline.Name,
+		Metric:   baseline.Metric,
+		SavedAt:  baseline.SavedAt,
+		Current:  *current,
+		Baseline: baseline.Summary,
+		Delta: models.MetricSummaryDelta{
+			Cardinality: current.Cardinality - baseline.Summary.Cardinality,
+			Min:         current.Stats.Min - baseline.Summary.Stats.Min,
+			Max:         current.Stats.Max - baseline.Summary.Stats.Max,
+			Avg:         current.Stats.Avg - baseline.Summary.Stats.Avg,
+		},
+	}
+
+	return comparison, err
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"metrics-api/internal/prometheus"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// syntheticSeries returns three hours of one-minute samples alternating
+// between 10 and 12, with the given values injected at minute offsets
+func syntheticSeries(start time.Time, injected map[int]float64) []prometheus.TimeValuePair {
+	values := make([]prometheus.TimeValuePair, 0, 180)
+	for i := 0; i < 180; i++ {
+		value := 10.0
+		if i%2 == 1 {
+			value = 12
+		}
+		if v, ok := injected[i]; ok {
+			value = v
+		}
+		values = append(values, prometheus.TimeValuePair{Timestamp: start.Add(time.Duration(i) * time.Minute), Value: value})
+	}
+	return values
+}
+
+func TestDetectAnomalies(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+
+	t.Run("steady", func(t *testing.T) {
+		assert.Empty(t, detectAnomalies(syntheticSeries(start, nil), time.Hour, 2.5))
+	})
+
+	t.Run("spikes", func(t *testing.T) {
+		anomalies := detectAnomalies(syntheticSeries(start, map[int]float64{120: 50, 150: -30}), time.Hour, 2.5)
+		require.Len(t, anomalies, 2)
+
+		spike := anomalies[0]
+		assert.Equal(t, start.Add(120*time.Minute), spike.Timestamp)
+		assert.Equal(t, 50.0, spike.Value)
+		assert.InDelta(t, 11, spike.Expected, 0.05)
+		assert.InDelta(t, 1, spike.StdDev, 0.05)
+		assert.InDelta(t, 39, spike.ZScore, 0.05)
+
+		dip := anomalies[1]
+		assert.Equal(t, start.Add(150*time.Minute), dip.Timestamp)
+		assert.Equal(t, -30.0, dip.Value)
+		assert.Less(t, dip.ZScore, -2.5, "drops should be flagged too")
+	})
+
+	t.Run("threshold", func(t *testing.T) {
+		// 15 is four standard deviations above the mean of 11
+		series := syntheticSeries(start, map[int]float64{120: 15})
+		assert.Len(t, detectAnomalies(series, time.Hour, 3.5), 1)
+		assert.Empty(t, detectAnomalies(series, time.Hour, 4.5))
+	})
+
+	t.Run("needs history", func(t *testing.T) {
+		// The spike has a single sample before it in the window
+		assert.Empty(t, detectAnomalies(syntheticSeries(start, map[int]float64{1: 1000}), time.Hour, 2.5))
+	})
+}
@@ -3,43 +3,210 @@ package service
 import (
 	"context"
 	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"metrics-api/internal/cache"
 	"metrics-api/internal/models"
 	"metrics-api/internal/prometheus"
+	"metrics-api/internal/timing"
+	"metrics-api/pkg/errutil"
 	"metrics-api/pkg/logger"
 
+	"github.com/google/uuid"
 	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"golang.org/x/sync/errgroup"
 )
 
 // QueriesService handles Prometheus query operations
 type QueriesService struct {
-	client     *prometheus.Client
-	logger     logger.Logger
-	maxPoints  int
+	client              *prometheus.Client
+	logger              logger.Logger
+	maxPoints           int
+	costWarnThreshold   int
+	maxLabelValueLength int
+	hidden              []*regexp.Regexp
+	snapshots           *cache.Cache
+	saved               *SavedQueriesService
+	history             *QueryHistory
+	maxBatchQueries     int
+	batchConcurrency    int
+	splitConcurrency    int
+	minStep             time.Duration
+	stepPolicy          string
+}
+
+// querySnapshot records the series values returned for a versioned poll
+type querySnapshot struct {
+	query  string
+	series map[string]snapshotEntry
+}
+
+type snapshotEntry struct {
+	ref   models.SeriesRef
+	value float64
 }
 
 // NewQueriesService creates a new queries service
 func NewQueriesService(client *prometheus.Client, logger logger.Logger) *QueriesService {
 	return &QueriesService{
-		client:    client,
-		logger:    logger,
-		maxPoints: 11000, // Default max points limit
+		client:              client,
+		logger:              logger,
+		maxPoints:           11000, // Default max points limit
+		costWarnThreshold:   DefaultCostWarnThreshold,
+		maxLabelValueLength: prometheus.DefaultMaxLabelValueLength,
+		maxBatchQueries:     50,
+		batchConcurrency:    4, // Queries of one batch run in parallel
+		splitConcurrency:    1, // Chunks of a split range query run in turn
+		saved:               NewMemorySavedQueriesService(logger),
+		history:             NewQueryHistory(DefaultQueryHistorySize),
+		snapshots: cache.New(cache.Options{
+			DefaultExpiration: 5 * time.Minute,
+			CleanupInterval:   time.Minute,
+			MaxItems:          1000,
+			EvictionPolicy:    cache.EvictOldest,
+		}),
 	}
 }
 
+// clientFor returns the client of the Prometheus source ctx is directed at
+func (s *QueriesService) clientFor(ctx context.Context) *prometheus.Client {
+	return prometheus.FromContext(ctx, s.client)
+}
+
 // WithMaxPoints sets the maximum number of data points allowed in a query
 func (s *QueriesService) WithMaxPoints(maxPoints int) *QueriesService {
 	s.maxPoints = maxPoints
 	return s
 }
 
+// DefaultCostWarnThreshold is the estimated number of data points above
+// which validation warns about a query's cost
+const DefaultCostWarnThreshold = 5000
+
+// WithCostWarnThreshold sets the estimated number of data points above which
+// validation warns about a query's cost; zero disables the warnings
+func (s *QueriesService) WithCostWarnThreshold(threshold int) *QueriesService {
+	s.costWarnThreshold = threshold
+	return s
+}
+
+// WithMaxLabelValueLength sets the longest label value accepted for injection
+func (s *QueriesService) WithMaxLabelValueLength(maxLength int) *QueriesService {
+	s.maxLabelValueLength = maxLength
+	return s
+}
+
+// WithMaxBatchQueries sets the largest number of queries accepted in one batch
+func (s *QueriesService) WithMaxBatchQueries(maxQueries int) *QueriesService {
+	s.maxBatchQueries = maxQueries
+	return s
+}
+
+// WithBatchConcurrency sets how many queries of a batch run at the same time
+func (s *QueriesService) WithBatchConcurrency(concurrency int) *QueriesService {
+	s.batchConcurrency = concurrency
+	return s
+}
+
+// WithSplitConcurrency sets how many chunks of an auto-split range query run
+// at the same time
+func (s *QueriesService) WithSplitConcurrency(concurrency int) *QueriesService {
+	s.splitConcurrency = concurrency
+	return s
+}
+
+// MaxRangeChunks bounds the number of sub-queries an auto-split range query
+// may be split into
+const MaxRangeChunks = 100
+
+// Policies for range queries whose step is below the minimum
+const (
+	// StepPolicyClamp raises the step to the minimum and warns in the response
+	StepPolicyClamp = "clamp"
+	// StepPolicyReject fails the query with models.ErrStepTooSmall
+	StepPolicyReject = "reject"
+)
+
+// WithMinStep sets the smallest step a range query may use and whether finer
+// steps are clamped or rejected; zero allows any step
+func (s *QueriesService) WithMinStep(minStep time.Duration, policy string) *QueriesService {
+	s.minStep = minStep
+	s.stepPolicy = policy
+	return s
+}
+
+// WithHistory replaces the history of executed queries
+func (s *QueriesService) WithHistory(history *QueryHistory) *QueriesService {
+	s.history = history
+	return s
+}
+
+// History returns up to limit of the most recently executed queries run at
+// or after since, newest first
+func (s *QueriesService) History(limit int, since time.Time) []models.QueryHistoryEntry {
+	return s.history.Recent(limit, since)
+}
+
+// ClearHistory forgets every executed query
+func (s *QueriesService) ClearHistory() {
+	s.history.Clear()
+}
+
+// recordQuery adds a query that started at start to the history
+func (s *QueriesService) recordQuery(query, queryType string, start time.Time, resultCount int, err error) {
+	s.history.Add(models.QueryHistoryEntry{
+		Query:       query,
+		Type:        queryType,
+		ExecutedAt:  start,
+		DurationMs:  time.Since(start).Milliseconds(),
+		ResultCount: resultCount,
+		Error:       err != nil,
+	})
+}
+
+// WithSavedQueries sets the service keeping saved queries and templates, in
+// place of the default one kept in memory
+func (s *QueriesService) WithSavedQueries(saved *SavedQueriesService) *QueriesService {
+	s.saved = saved
+	return s
+}
+
+// SavedQueries returns the service keeping saved queries and templates
+func (s *QueriesService) SavedQueries() *SavedQueriesService {
+	return s.saved
+}
+
+// WithHiddenPatterns hides matching metric names from query suggestions
+func (s *QueriesService) WithHiddenPatterns(patterns []*regexp.Regexp) *QueriesService {
+	s.hidden = patterns
+	return s
+}
+
 // ExecuteInstantQuery executes an instant query against Prometheus
 func (s *QueriesService) ExecuteInstantQuery(ctx context.Context, queryParams models.InstantQueryParams) (*models.QueryResponse, error) {
+	start := time.Now()
+	response, err := s.executeInstantQuery(ctx, queryParams)
+	resultCount := 0
+	if response != nil {
+		resultCount = len(response.Data)
+	}
+	s.recordQuery(queryParams.Query, "instant", start, resultCount, err)
+	return response, err
+}
+
+func (s *QueriesService) executeInstantQuery(ctx context.Context, queryParams models.InstantQueryParams) (*models.QueryResponse, error) {
 	// Validate query
 	if queryParams.Query == "" {
 		return nil, models.ErrInvalidQuery
 	}
+	if queryParams.Limit < 0 {
+		return nil, fmt.Errorf("%w: limit must not be negative", models.ErrInvalidQuery)
+	}
 
 	// Set default time to now if not provided
 	queryTime := time.Now()
@@ -50,12 +217,21 @@ func (s *QueriesService) ExecuteInstantQuery(ctx context.Context, queryParams mo
 	// Log query for debugging and audit
 	s.logger.Infof("Executing instant query: %s at %s", queryParams.Query, queryTime)
 
+	var opts []prometheus.QueryOption
+	if queryParams.BypassCache {
+		opts = append(opts, prometheus.WithCacheBypass())
+	}
+
 	// Execute query
-	results, err := s.client.Query(ctx, queryParams.Query, queryTime)
+	timings := timing.FromContext(ctx)
+	start := time.Now()
+	results, err := s.clientFor(ctx).ExecuteInstantQuery(ctx, queryParams.Query, queryTime, opts...)
+	timings.Since("prometheus", start)
 	if err != nil {
 		s.logger.Errorf("Failed to execute query %s: %v", queryParams.Query, err)
 		return nil, fmt.Errorf("failed to execute query: %w", err)
 	}
+	defer timings.Since("transform", time.Now())
 
 	// Convert to response model
 	response := &models.QueryResponse{
@@ -74,11 +250,236 @@ func (s *QueriesService) ExecuteInstantQuery(ctx context.Context, queryParams mo
 		})
 	}
 
+	response.TotalCount = len(response.Data)
+	if queryParams.Limit > 0 {
+		response.Data, response.Truncated = limitDataPoints(response.Data, queryParams.Limit)
+	}
+
+	if queryParams.Diff || queryParams.SinceVersion != "" {
+		s.applySnapshotDiff(response, queryParams.SinceVersion)
+	}
+
+	if queryParams.KeepName {
+		for i := range response.Data {
+			response.Data[i].Labels = withMetricName(response.Data[i].MetricName, response.Data[i].Labels)
+		}
+	}
+
 	return response, nil
 }
 
+// limitDataPoints orders points by value, highest first, then by series and
+// keeps the first limit, reporting whether any were dropped. NaN values sort
+// last.
+func limitDataPoints(points []models.DataPoint, limit int) ([]models.DataPoint, bool) {
+	sort.SliceStable(points, func(i, j int) bool {
+		a, b := points[i].Value, points[j].Value
+		switch {
+		case math.IsNaN(a) || math.IsNaN(b):
+			if !math.IsNaN(a) || !math.IsNaN(b) {
+				return !math.IsNaN(a)
+			}
+		case a != b:
+			return a > b
+		}
+		return seriesKey(points[i].MetricName, points[i].Labels) < seriesKey(points[j].MetricName, points[j].Labels)
+	})
+
+	if len(points) <= limit {
+		return points, false
+	}
+	return points[:limit], true
+}
+
+// ExecuteBatch runs a batch of instant queries with bounded concurrency and
+// returns their responses in request order. Batches larger than the configured
+// limit are rejected before any query runs. If some queries fail the others
+// are still returned, with nil in place of each failed response, together
+// with an *errutil.Multi keyed by query index.
+func (s *QueriesService) ExecuteBatch(ctx context.Context, queries []models.InstantQueryParams) ([]*models.QueryResponse, error) {
+	if len(queries) == 0 {
+		return nil, fmt.Errorf("%w: batch contains no queries", models.ErrInvalidQuery)
+	}
+	if len(queries) > s.maxBatchQueries {
+		return nil, fmt.Errorf("%w: got %d queries, the limit is %d", models.ErrBatchTooLarge, len(queries), s.maxBatchQueries)
+	}
+
+	responses := make([]*models.QueryResponse, len(queries))
+	var batchErrs errutil.Multi
+
+	g, gCtx := errgroup.WithContext(ctx)
+	g.SetLimit(s.batchConcurrency)
+	for i, params := range queries {
+		g.Go(func() error {
+			response, err := s.ExecuteInstantQuery(gCtx, params)
+			if err != nil {
+				batchErrs.Add(strconv.Itoa(i), err)
+				return nil
+			}
+			responses[i] = response
+			return nil
+		})
+	}
+	g.Wait()
+
+	if !batchErrs.Success() {
+		return responses, &batchErrs
+	}
+	return responses, nil
+}
+
+// ExecuteRangeBatch runs a batch of range queries with bounded concurrency
+// and returns their responses in request order. Queries without an ID are
+// given their index as one, in place; IDs must be unique. As with ExecuteBatch, failed
+// queries leave nil in their place and are reported in an *errutil.Multi,
+// keyed by query ID.
+func (s *QueriesService) ExecuteRangeBatch(ctx context.Context, queries []models.RangeBatchQuery) ([]*models.RangeQueryResponse, error) {
+	if len(queries) == 0 {
+		return nil, fmt.Errorf("%w: batch contains no queries", models.ErrInvalidQuery)
+	}
+	if len(queries) > s.maxBatchQueries {
+		return nil, fmt.Errorf("%w: got %d queries, the limit is %d", models.ErrBatchTooLarge, len(queries), s.maxBatchQueries)
+	}
+
+	seen := make(map[string]bool, len(queries))
+	for i := range queries {
+		if queries[i].ID == "" {
+			queries[i].ID = strconv.Itoa(i)
+		}
+		if seen[queries[i].ID] {
+			return nil, fmt.Errorf("%w: query ID %s is used more than once", models.ErrInvalidQuery, queries[i].ID)
+		}
+		seen[queries[i].ID] = true
+	}
+
+	responses := make([]*models.RangeQueryResponse, len(queries))
+	var batchErrs errutil.Multi
+
+	g, gCtx := errgroup.WithContext(ctx)
+	g.SetLimit(s.batchConcurrency)
+	for i, query := range queries {
+		g.Go(func() error {
+			response, err := s.ExecuteRangeQuery(gCtx, query.RangeQueryParams)
+			if err != nil {
+				batchErrs.Add(query.ID, err)
+				return nil
+			}
+			responses[i] = response
+			return nil
+		})
+	}
+	g.Wait()
+
+	if !batchErrs.Success() {
+		return responses, &batchErrs
+	}
+	return responses, nil
+}
+
+// applySnapshotDiff records the response as a new snapshot version and, when
+// sinceVersion names a live snapshot of the same query, trims the response
+// down to the series that changed or disappeared since that snapshot
+func (s *QueriesService) applySnapshotDiff(response *models.QueryResponse, sinceVersion string) {
+	current := make(map[string]snapshotEntry, len(response.Data))
+	for _, dp := range response.Data {
+		current[seriesKey(dp.MetricName, dp.Labels)] = snapshotEntry{
+			ref:   models.SeriesRef{MetricName: dp.MetricName, Labels: dp.Labels},
+			value: dp.Value,
+		}
+	}
+
+	if sinceVersion != "" {
+		if cached, found := s.snapshots.Get(sinceVersion); found {
+			previous := cached.(querySnapshot)
+			if previous.query == response.Query {
+				changed := make([]models.DataPoint, 0, len(response.Data))
+				for _, dp := range response.Data {
+					prev, existed := previous.series[seriesKey(dp.MetricName, dp.Labels)]
+					if !existed || !sameValue(prev.value, dp.Value) {
+						changed = append(changed, dp)
+					}
+				}
+
+				removed := make([]models.SeriesRef, 0)
+				for key, prev := range previous.series {
+					if _, stillPresent := current[key]; !stillPresent {
+						removed = append(removed, prev.ref)
+					}
+				}
+
+				response.Data = changed
+				response.Removed = removed
+				response.Delta = true
+			}
+		} else {
+			s.logger.Debugf("Snapshot version %s not found, returning full result", sinceVersion)
+		}
+	}
+
+	response.Version = uuid.New().String()
+	s.snapshots.Set(response.Version, querySnapshot{
+		query:  response.Query,
+		series: current,
+	})
+}
+
+// seriesKey builds a stable identifier for a series from its name and labels
+func seriesKey(metricName string, labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(metricName)
+	b.WriteString("{")
+	for i, name := range names {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, "%s=%q", name, labels[name])
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// withMetricName returns a copy of labels that also carries __name__, leaving
+// the original map untouched since it may be shared with the query cache
+func withMetricName(metricName string, labels map[string]string) map[string]string {
+	if metricName == "" {
+		return labels
+	}
+
+	named := make(map[string]string, len(labels)+1)
+	for name, value := range labels {
+		named[name] = value
+	}
+	named["__name__"] = metricName
+	return named
+}
+
+// sameValue compares sample values treating NaN as equal to itself
+func sameValue(a, b float64) bool {
+	if math.IsNaN(a) && math.IsNaN(b) {
+		return true
+	}
+	return a == b
+}
+
 // ExecuteRangeQuery executes a range query against Prometheus
 func (s *QueriesService) ExecuteRangeQuery(ctx context.Context, params models.RangeQueryParams) (*models.RangeQueryResponse, error) {
+	start := time.Now()
+	response, err := s.executeRangeQuery(ctx, params)
+	resultCount := 0
+	if response != nil {
+		resultCount = len(response.Series)
+	}
+	s.recordQuery(params.Query, "range", start, resultCount, err)
+	return response, err
+}
+
+func (s *QueriesService) executeRangeQuery(ctx context.Context, params models.RangeQueryParams) (*models.RangeQueryResponse, error) {
 	if params.Query == "" {
 		return nil, models.ErrInvalidQuery
 	}
@@ -104,11 +505,13 @@ func (s *QueriesService) ExecuteRangeQuery(ctx context.Context, params models.Ra
 		return nil, fmt.Errorf("invalid step duration: %w", err)
 	}
 
-	// Calculate number of points
-	duration := end.Sub(start)
-	points := int(duration / step)
-	if points > s.maxPoints {
-		return nil, models.ErrTooManyDataPoints
+	var warnings []string
+	if step < s.minStep {
+		if s.stepPolicy == StepPolicyReject {
+			return nil, fmt.Errorf("%w: %s is finer than %s", models.ErrStepTooSmall, step, s.minStep)
+		}
+		warnings = append(warnings, fmt.Sprintf("step %s is below the minimum of %s and was raised to it", step, s.minStep))
+		step = s.minStep
 	}
 
 	// Create Prometheus range
@@ -118,30 +521,65 @@ func (s *QueriesService) ExecuteRangeQuery(ctx context.Context, params models.Ra
 		Step:  step,
 	}
 
+	// Calculate number of points
+	duration := end.Sub(start)
+	points := int(duration / step)
+	var chunks []v1.Range
+	if points > s.maxPoints {
+		if !params.AutoSplit {
+			return nil, models.ErrTooManyDataPoints
+		}
+		chunks = splitRange(r, s.maxPoints)
+		if len(chunks) > MaxRangeChunks {
+			return nil, fmt.Errorf("%w: range needs %d queries, the limit is %d", models.ErrTooManyDataPoints, len(chunks), MaxRangeChunks)
+		}
+		warnings = append(warnings, fmt.Sprintf("range was split into %d queries of at most %d points", len(chunks), s.maxPoints))
+	}
+
 	// Execute query
 	s.logger.Infof("Executing range query: %s from %s to %s with step %s", 
 		params.Query, start.Format(time.RFC3339), end.Format(time.RFC3339), step)
 
-	results, err := s.client.QueryRange(ctx, params.Query, r)
+	var opts []prometheus.QueryOption
+	if params.BypassCache {
+		opts = append(opts, prometheus.WithCacheBypass())
+	}
+
+	timings := timing.FromContext(ctx)
+	queryStart := time.Now()
+	var results []prometheus.RangeQueryResult
+	if chunks != nil {
+		results, err = s.executeSplitRange(ctx, params.Query, chunks, opts)
+	} else {
+		results, err = s.clientFor(ctx).ExecuteRangeQuery(ctx, params.Query, r, opts...)
+	}
+	timings.Since("prometheus", queryStart)
 	if err != nil {
 		s.logger.Errorf("Failed to execute range query: %v", err)
 		return nil, fmt.Errorf("failed to execute query: %w", err)
 	}
+	defer timings.Since("transform", time.Now())
 
 	// Build response
 	response := &models.RangeQueryResponse{
-		Query:  params.Query,
-		Start:  start,
-		End:    end,
-		Step:   step,
-		Status: "success",
-		Series: make([]models.TimeSeries, 0, len(results)),
+		Query:    params.Query,
+		Start:    start,
+		End:      end,
+		Step:     step,
+		Status:   "success",
+		Series:   make([]models.TimeSeries, 0, len(results)),
+		Warnings: warnings,
 	}
 
 	for _, result := range results {
+		labels := result.Labels
+		if params.KeepName {
+			labels = withMetricName(result.MetricName, labels)
+		}
+
 		series := models.TimeSeries{
 			MetricName: result.MetricName,
-			Labels:     result.Labels,
+			Labels:     labels,
 			DataPoints: make([]models.TimeValuePair, 0, len(result.Values)),
 		}
 
@@ -158,84 +596,354 @@ func (s *QueriesService) ExecuteRangeQuery(ctx context.Context, params models.Ra
 	return response, nil
 }
 
-// ValidateQuery checks if a query is valid
-func (s *QueriesService) ValidateQuery(ctx context.Context, query string) (*models.QueryValidation, error) {
-	if query == "" {
-		return &models.QueryValidation{
-			Query:   query,
-			Valid:   false,
-			Message: "Query cannot be empty",
-		}, nil
+// splitRange divides r into consecutive ranges of at most maxPoints steps.
+// Each range starts where the previous one ended, so both return the sample
+// at the shared boundary.
+func splitRange(r v1.Range, maxPoints int) []v1.Range {
+	span := time.Duration(maxPoints) * r.Step
+	var chunks []v1.Range
+	for start := r.Start; ; start = start.Add(span) {
+		end := start.Add(span)
+		if !end.Before(r.End) {
+			return append(chunks, v1.Range{Start: start, End: r.End, Step: r.Step})
+		}
+		chunks = append(chunks, v1.Range{Start: start, End: end, Step: r.Step})
+	}
+}
+
+// executeSplitRange runs query over each chunk, at most splitConcurrency at
+// a time, and merges the results by series in chunk order, dropping samples
+// at or before the last one already merged for that series
+func (s *QueriesService) executeSplitRange(ctx context.Context, query string, chunks []v1.Range, opts []prometheus.QueryOption) ([]prometheus.RangeQueryResult, error) {
+	chunkResults := make([][]prometheus.RangeQueryResult, len(chunks))
+
+	g, gCtx := errgroup.WithContext(ctx)
+	g.SetLimit(s.splitConcurrency)
+	for i, chunk := range chunks {
+		g.Go(func() error {
+			results, err := s.clientFor(gCtx).ExecuteRangeQuery(gCtx, query, chunk, opts...)
+			if err != nil {
+				return fmt.Errorf("chunk %d of %d: %w", i+1, len(chunks), err)
+			}
+			chunkResults[i] = results
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	var merged []prometheus.RangeQueryResult
+	index := make(map[string]int)
+	for _, results := range chunkResults {
+		for _, result := range results {
+			key := seriesKey(result.MetricName, result.Labels)
+			i, seen := index[key]
+			if !seen {
+				index[key] = len(merged)
+				merged = append(merged, prometheus.RangeQueryResult{
+					MetricName: result.MetricName,
+					Labels:     result.Labels,
+					// Copied, as appending must not touch cached results
+					Values: append([]prometheus.TimeValuePair(nil), result.Values...),
+				})
+				continue
+			}
+
+			values := merged[i].Values
+			for _, pair := range result.Values {
+				if len(values) > 0 && !pair.Timestamp.After(values[len(values)-1].Timestamp) {
+					continue
+				}
+				values = append(values, pair)
+			}
+			merged[i].Values = values
+		}
+	}
+	return merged, nil
+}
+
+// ValidateQuery checks the syntax of a query without contacting Prometheus.
+// With dryRun it also estimates the cost of evaluating the query at a single
+// instant, which has Prometheus check it too; an error is returned only when
+// that dry run cannot reach Prometheus.
+func (s *QueriesService) ValidateQuery(ctx context.Context, query string, dryRun bool) (*models.QueryValidation, error) {
+	return s.validateQuery(ctx, query, time.Now(), 1, dryRun)
+}
+
+// ValidateRangeQuery checks if a range query is valid and, with dryRun,
+// estimates its cost over the whole range, without running the query over
+// the range
+func (s *QueriesService) ValidateRangeQuery(ctx context.Context, params models.RangeQueryParams, dryRun bool) (*models.QueryValidation, error) {
+	end := time.Now()
+	if !params.End.IsZero() {
+		end = params.End
+	}
+	start := end.Add(-1 * time.Hour)
+	if !params.Start.IsZero() {
+		start = params.Start
+	}
+
+	invalid := func(message string) (*models.QueryValidation, error) {
+		return &models.QueryValidation{Query: params.Query, Message: message}, nil
+	}
+	if start.After(end) {
+		return invalid("Start time must not be after end time")
+	}
+	step, err := time.ParseDuration(params.Step)
+	if err != nil || step <= 0 {
+		return invalid(fmt.Sprintf("Invalid step duration: %q", params.Step))
+	}
+	steps := int(end.Sub(start) / step)
+	if steps > s.maxPoints && !params.AutoSplit {
+		return invalid(fmt.Sprintf("Range has %d steps, more than the limit of %d", steps, s.maxPoints))
 	}
 
-	// Try to execute with minimal time range
-	now := time.Now()
-	_, err := s.client.Query(ctx, query, now)
+	return s.validateQuery(ctx, params.Query, end, int64(steps)+1, dryRun)
+}
+
+// validateQuery parses query, locating any syntax error. With dryRun it
+// then estimates the cost as the number of series the query returns at the
+// given time, counted by Prometheus, times the samples per series. Queries
+// estimated above maxPoints are invalid; those above the cost warning
+// threshold are valid with a warning.
+func (s *QueriesService) validateQuery(ctx context.Context, query string, at time.Time, samplesPerSeries int64, dryRun bool) (*models.QueryValidation, error) {
+	validation := &models.QueryValidation{Query: query}
+	if query == "" {
+		validation.Message = "Query cannot be empty"
+		return validation, nil
+	}
 
-	validation := &models.QueryValidation{
-		Query: query,
-		Valid: err == nil,
+	if syntaxErr := prometheus.ParseSyntaxError(query); syntaxErr != nil {
+		validation.SyntaxError = syntaxErr
+		validation.Message = fmt.Sprintf("Syntax error at line %d, column %d: %s",
+			syntaxErr.Line, syntaxErr.Column, syntaxErr.Message)
+		return validation, nil
+	}
+	if !dryRun {
+		validation.Valid = true
+		validation.Message = "Query is valid"
+		return validation, nil
 	}
 
+	countQuery, returnsSeries, err := prometheus.SeriesCountQuery(query)
 	if err != nil {
 		validation.Message = fmt.Sprintf("Query validation failed: %v", err)
-	} else {
-		validation.Message = "Query is valid"
+		return validation, nil
+	}
+
+	validation.EstimatedSeries = 1
+	if returnsSeries {
+		// Counting the series evaluates the query, so Prometheus checks it
+		// too, yet only a single sample comes back
+		results, err := s.clientFor(ctx).Query(ctx, countQuery, at)
+		if err != nil {
+			if !prometheus.IsRejectedQuery(err) {
+				return nil, err
+			}
+			validation.EstimatedSeries = 0
+			validation.Message = fmt.Sprintf("Query validation failed: %v", err)
+			return validation, nil
+		}
+		validation.EstimatedSeries = 0
+		if len(results) > 0 {
+			validation.EstimatedSeries = int64(results[0].Value)
+		}
 	}
+	validation.EstimatedPoints = validation.EstimatedSeries * samplesPerSeries
 
+	if validation.EstimatedPoints > int64(s.maxPoints) {
+		validation.Message = fmt.Sprintf("Query would return an estimated %d data points, more than the limit of %d",
+			validation.EstimatedPoints, s.maxPoints)
+		return validation, nil
+	}
+	if s.costWarnThreshold > 0 && validation.EstimatedPoints > int64(s.costWarnThreshold) {
+		validation.CostWarnings = append(validation.CostWarnings,
+			fmt.Sprintf("query is estimated to return %d data points, above the warning threshold of %d",
+				validation.EstimatedPoints, s.costWarnThreshold))
+	}
+
+	validation.Valid = true
+	validation.Message = "Query is valid"
 	return validation, nil
 }
 
-// GetQuerySuggestions attempts to provide helpful query suggestions
-func (s *QueriesService) GetQuerySuggestions(ctx context.Context, prefix string, limit int) ([]string, error) {
-	if limit <= 0 {
-		limit = 10 // Default limit
+// RenderTemplate fills in the placeholders of the saved template with the
+// given name. Missing parameters take their default; every value must match
+// its parameter's regex and the rendered query must pass a ValidateQuery
+// dry run.
+func (s *QueriesService) RenderTemplate(ctx context.Context, name string, params map[string]string) (string, error) {
+	template, err := s.saved.GetTemplate(ctx, name)
+	if err != nil {
+		return "", err
+	}
+
+	values := make(map[string]string, len(template.Parameters))
+	for _, param := range template.Parameters {
+		value, ok := params[param.Name]
+		if !ok {
+			value = param.Default
+		}
+		if value == "" {
+			return "", fmt.Errorf("%w: parameter %s is required", models.ErrInvalidQuery, param.Name)
+		}
+
+		pattern, err := parameterPattern(param)
+		if err != nil {
+			return "", fmt.Errorf("%w: invalid regex for parameter %s: %v", models.ErrInvalidQuery, param.Name, err)
+		}
+		if !pattern.MatchString(value) {
+			return "", fmt.Errorf("%w: value of parameter %s does not match %s", models.ErrInvalidQuery, param.Name, pattern)
+		}
+		values[param.Name] = value
+	}
+	for param := range params {
+		if _, ok := values[param]; !ok {
+			return "", fmt.Errorf("%w: template %s has no parameter %s", models.ErrInvalidQuery, name, param)
+		}
 	}
 
-	// Get all metrics as a starting point for suggestions
-	metrics, err := s.client.GetMetrics(ctx)
+	// A single pass, so placeholders within values are never expanded
+	rendered := templatePlaceholder.ReplaceAllStringFunc(template.Template, func(placeholder string) string {
+		return values[placeholder[1:len(placeholder)-1]]
+	})
+
+	validation, err := s.ValidateQuery(ctx, rendered, true)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get metrics for suggestions: %w", err)
+		return "", err
 	}
+	if !validation.Valid {
+		return "", fmt.Errorf("%w: %s", models.ErrInvalidQuery, validation.Message)
+	}
+	return rendered, nil
+}
 
-	// Basic suggestions based on common patterns
-	suggestions := []string{
-		"rate(http_requests_total[5m])",
-		"sum by (instance) (node_cpu_seconds_total)",
-		"histogram_quantile(0.95, sum by(le) (rate(http_request_duration_seconds_bucket[5m])))",
-		"up",
-		"node_memory_MemFree_bytes / node_memory_MemTotal_bytes * 100",
+// PreviewQuery returns the query that would run once the given label
+// filters are injected, without executing it
+func (s *QueriesService) PreviewQuery(query string, labels map[string]string) (string, error) {
+	if query == "" {
+		return "", models.ErrInvalidQuery
 	}
 
-	// Add metrics that match the prefix
-	for _, metric := range metrics {
-		if len(suggestions) >= limit {
-			break
-		}
-		
-		// Basic prefix match
-		if len(prefix) == 0 || (len(prefix) > 0 && startsWith(metric, prefix)) {
-			suggestions = append(suggestions, metric)
-		}
+	if err := prometheus.ValidateLabelValues(labels, s.maxLabelValueLength); err != nil {
+		return "", err
 	}
 
-	// Ensure we don't exceed the limit
-	if len(suggestions) > limit {
-		suggestions = suggestions[:limit]
+	rewritten, err := prometheus.InjectLabels(query, labels)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", models.ErrInvalidQuery, err)
+	}
+
+	return rewritten, nil
+}
+
+// Operators supported by CombineInstant
+const (
+	CombineAdd = "add"
+	CombineSub = "sub"
+	CombineDiv = "div"
+	CombineMul = "mul"
+)
+
+// CombineOption configures CombineInstant
+type CombineOption func(*combineOptions)
+
+type combineOptions struct {
+	time             time.Time
+	includeUnmatched bool
+}
+
+// CombineAt evaluates both queries at t instead of now
+func CombineAt(t time.Time) CombineOption {
+	return func(o *combineOptions) {
+		o.time = t
 	}
+}
 
-	return suggestions, nil
+// CombineIncludeUnmatched reports series present on only one side as NaN
+// instead of dropping them
+func CombineIncludeUnmatched(include bool) CombineOption {
+	return func(o *combineOptions) {
+		o.includeUnmatched = include
+	}
 }
 
-// Helper function to check if a string starts with a prefix
-func startsWith(s, prefix string) bool {
-	if len(prefix) > len(s) {
-		return false
+// CombineInstant evaluates queryA and queryB at the same instant and applies
+// op to every pair of series with identical label sets
+func (s *QueriesService) CombineInstant(ctx context.Context, queryA, queryB string, op string, opts ...CombineOption) ([]models.DataPoint, error) {
+	if queryA == "" || queryB == "" {
+		return nil, models.ErrInvalidQuery
+	}
+
+	apply, ok := combineOps[op]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported operator %q", models.ErrInvalidQuery, op)
+	}
+
+	options := combineOptions{time: time.Now()}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	s.logger.Infof("Combining instant queries %s %s %s at %s", queryA, op, queryB, options.time)
+
+	resultsA, err := s.clientFor(ctx).ExecuteInstantQuery(ctx, queryA, options.time)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query %s: %w", queryA, err)
+	}
+	resultsB, err := s.clientFor(ctx).ExecuteInstantQuery(ctx, queryB, options.time)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query %s: %w", queryB, err)
+	}
+
+	// Series are aligned on labels only, as PromQL drops the metric name
+	// from the result of a binary operation
+	valuesB := make(map[string]float64, len(resultsB))
+	for _, result := range resultsB {
+		valuesB[seriesKey("", result.Labels)] = result.Value
+	}
+
+	combined := make([]models.DataPoint, 0, len(resultsA))
+	matched := make(map[string]bool, len(resultsA))
+	for _, result := range resultsA {
+		key := seriesKey("", result.Labels)
+		valueB, found := valuesB[key]
+		if !found && !options.includeUnmatched {
+			continue
+		}
+
+		value := math.NaN()
+		if found {
+			value = apply(result.Value, valueB)
+			matched[key] = true
+		}
+		combined = append(combined, models.DataPoint{
+			Labels:    result.Labels,
+			Value:     value,
+			Timestamp: options.time,
+		})
 	}
-	for i := 0; i < len(prefix); i++ {
-		if s[i] != prefix[i] {
-			return false
+
+	if options.includeUnmatched {
+		for _, result := range resultsB {
+			if matched[seriesKey("", result.Labels)] {
+				continue
+			}
+			combined = append(combined, models.DataPoint{
+				Labels:    result.Labels,
+				Value:     math.NaN(),
+				Timestamp: options.time,
+			})
 		}
 	}
-	return true
+
+	return combined, nil
+}
+
+// combineOps maps CombineInstant operators to their arithmetic
+var combineOps = map[string]func(a, b float64) float64{
+	CombineAdd: func(a, b float64) float64 { return a + b },
+	CombineSub: func(a, b float64) float64 { return a - b },
+	CombineDiv: func(a, b float64) float64 { return a / b },
+	CombineMul: func(a, b float64) float64 { return a * b },
 }
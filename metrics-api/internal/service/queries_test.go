@@ -0,0 +1,479 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"metrics-api/internal/cache"
+	"metrics-api/internal/models"
+	"metrics-api/internal/prometheus"
+	"metrics-api/pkg/logger"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newRangeServer serves range queries with two series whose value at each
+// step is its Unix timestamp, recording the ranges asked for
+func newRangeServer(t *testing.T) (*prometheus.Client, func() []string) {
+	var mu sync.Mutex
+	var ranges []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start, _ := strconv.ParseFloat(r.FormValue("start"), 64)
+		end, _ := strconv.ParseFloat(r.FormValue("end"), 64)
+		step, _ := strconv.ParseFloat(r.FormValue("step"), 64)
+
+		mu.Lock()
+		ranges = append(ranges, fmt.Sprintf("%.0f-%.0f", start, end))
+		mu.Unlock()
+
+		var values []string
+		for ts := start; ts <= end; ts += step {
+			values = append(values, fmt.Sprintf(`[%.0f,"%.0f"]`, ts, ts))
+		}
+		var series []string
+		for _, instance := range []string{"a", "b"} {
+			series = append(series, fmt.Sprintf(`{"metric":{"__name__":"up","instance":%q},"values":[%s]}`,
+				instance, strings.Join(values, ",")))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"matrix","result":[%s]}}`, strings.Join(series, ","))
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := prometheus.NewClient(server.URL, logger.NewTestLogger(), cache.New(cache.Options{}))
+	require.NoError(t, err)
+	return client, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), ranges...)
+	}
+}
+
+func TestExecuteRangeQueryAutoSplit(t *testing.T) {
+	ctx := context.Background()
+	start := time.Unix(1700000000, 0)
+	params := models.RangeQueryParams{
+		Query:       "up",
+		Start:       start,
+		End:         start.Add(25 * time.Minute),
+		Step:        "1m",
+		BypassCache: true,
+	}
+
+	t.Run("rejected without auto split", func(t *testing.T) {
+		client, _ := newRangeServer(t)
+		queries := NewQueriesService(client, logger.NewTestLogger()).WithMaxPoints(10)
+
+		_, err := queries.ExecuteRangeQuery(ctx, params)
+		assert.ErrorIs(t, err, models.ErrTooManyDataPoints)
+	})
+
+	for _, concurrency := range []int{1, 3} {
+		t.Run(fmt.Sprintf("concurrency %d", concurrency), func(t *testing.T) {
+			client, ranges := newRangeServer(t)
+			queries := NewQueriesService(client, logger.NewTestLogger()).
+				WithMaxPoints(10).
+				WithSplitConcurrency(concurrency)
+
+			split := params
+			split.AutoSplit = true
+			response, err := queries.ExecuteRangeQuery(ctx, split)
+			require.NoError(t, err)
+
+			// 25 steps of at most 10 each
+			assert.ElementsMatch(t, []string{
+				"1700000000-1700000600",
+				"1700000600-1700001200",
+				"1700001200-1700001500",
+			}, ranges())
+			assert.Len(t, response.Warnings, 1)
+
+			require.Len(t, response.Series, 2)
+			for _, series := range response.Series {
+				require.Len(t, series.DataPoints, 26, "boundary points appear once")
+				for i, point := range series.DataPoints {
+					expected := start.Add(time.Duration(i) * time.Minute)
+					assert.True(t, expected.Equal(point.Timestamp), "point %d of %s at %s", i, series.Labels["instance"], point.Timestamp)
+					assert.Equal(t, float64(expected.Unix()), point.Value)
+				}
+			}
+		})
+	}
+
+	t.Run("too many chunks", func(t *testing.T) {
+		client, ranges := newRangeServer(t)
+		queries := NewQueriesService(client, logger.NewTestLogger()).WithMaxPoints(1)
+
+		huge := params
+		huge.AutoSplit = true
+		huge.End = start.Add(time.Duration(MaxRangeChunks+1) * time.Minute)
+		_, err := queries.ExecuteRangeQuery(ctx, huge)
+		assert.ErrorIs(t, err, models.ErrTooManyDataPoints)
+		assert.Empty(t, ranges())
+	})
+}
+
+func TestQueryHistory(t *testing.T) {
+	base := time.Unix(1700000000, 0)
+	entry := func(i int) models.QueryHistoryEntry {
+		return models.QueryHistoryEntry{Query: fmt.Sprintf("q%d", i), ExecutedAt: base.Add(time.Duration(i) * time.Minute)}
+	}
+	queries := func(entries []models.QueryHistoryEntry) []string {
+		names := make([]string, 0, len(entries))
+		for _, e := range entries {
+			names = append(names, e.Query)
+		}
+		return names
+	}
+
+	history := NewQueryHistory(3)
+	assert.Empty(t, history.Recent(0, time.Time{}))
+
+	for i := 1; i <= 5; i++ {
+		history.Add(entry(i))
+	}
+	// The oldest entries are overwritten once the buffer is full
+	assert.Equal(t, []string{"q5", "q4", "q3"}, queries(history.Recent(0, time.Time{})))
+	assert.Equal(t, []string{"q5", "q4"}, queries(history.Recent(2, time.Time{})))
+	assert.Equal(t, []string{"q5", "q4"}, queries(history.Recent(10, base.Add(4*time.Minute))))
+
+	history.Clear()
+	assert.Empty(t, history.Recent(0, time.Time{}))
+	history.Add(entry(6))
+	assert.Equal(t, []string{"q6"}, queries(history.Recent(0, time.Time{})))
+}
+
+func TestQueryHistoryRecordsQueries(t *testing.T) {
+	ctx := context.Background()
+	client, _ := newRangeServer(t)
+	queries := NewQueriesService(client, logger.NewTestLogger()).
+		WithHistory(NewQueryHistory(50)).
+		WithMaxPoints(10)
+
+	start := time.Unix(1700000000, 0)
+	_, err := queries.ExecuteRangeQuery(ctx, models.RangeQueryParams{Query: "up", Start: start, End: start.Add(5 * time.Minute), Step: "1m"})
+	require.NoError(t, err)
+	_, err = queries.ExecuteRangeQuery(ctx, models.RangeQueryParams{Query: "up", Start: start, End: start.Add(time.Hour), Step: "1m"})
+	assert.ErrorIs(t, err, models.ErrTooManyDataPoints)
+
+	history := queries.History(0, time.Time{})
+	if assert.Len(t, history, 2) {
+		assert.True(t, history[0].Error)
+		assert.Equal(t, "range", history[1].Type)
+		assert.Equal(t, "up", history[1].Query)
+		assert.Equal(t, 2, history[1].ResultCount)
+		assert.False(t, history[1].Error)
+	}
+
+	// Concurrent queries and readers must not race; run with -race
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				queries.ExecuteInstantQuery(ctx, models.InstantQueryParams{})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				queries.History(10, time.Time{})
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, queries.History(0, time.Time{}), 50)
+
+	queries.ClearHistory()
+	assert.Empty(t, queries.History(0, time.Time{}))
+}
+
+func TestValidateQueryCost(t *testing.T) {
+	ctx := context.Background()
+
+	// Each metric has a fixed number of series, reported to count() queries
+	seriesCounts := map[string]int{"cheap_metric": 5, "medium_metric": 100, "expensive_metric": 50000}
+	var mu sync.Mutex
+	var executed []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.FormValue("query")
+		mu.Lock()
+		executed = append(executed, query)
+		mu.Unlock()
+
+		result := "[]"
+		for metric, count := range seriesCounts {
+			if strings.Contains(query, metric) {
+				result = fmt.Sprintf(`[{"metric":{},"value":[1700000000,"%d"]}]`, count)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":%s}}`, result)
+	}))
+	defer server.Close()
+
+	client, err := prometheus.NewClient(server.URL, logger.NewTestLogger(), cache.New(cache.Options{}))
+	require.NoError(t, err)
+	queries := NewQueriesService(client, logger.NewTestLogger()).
+		WithMaxPoints(11000).
+		WithCostWarnThreshold(5000)
+
+	start := time.Unix(1700000000, 0)
+	tests := []struct {
+		name     string
+		validate func() (*models.QueryValidation, error)
+		valid    bool
+		series   int64
+		points   int64
+		warnings int
+		executed []string
+	}{
+		{
+			name: "cheap instant query",
+			validate: func() (*models.QueryValidation, error) {
+				return queries.ValidateQuery(ctx, "rate(cheap_metric[5m])", true)
+			},
+			valid: true, series: 5, points: 5,
+			executed: []string{"count(rate(cheap_metric[5m]))"},
+		},
+		{
+			name: "medium range query",
+			validate: func() (*models.QueryValidation, error) {
+				return queries.ValidateRangeQuery(ctx, models.RangeQueryParams{
+					Query: "medium_metric", Start: start, End: start.Add(time.Hour), Step: "1m",
+				}, true)
+			},
+			// 100 series of 61 samples each
+			valid: true, series: 100, points: 6100, warnings: 1,
+			executed: []string{"count(medium_metric)"},
+		},
+		{
+			name:     "expensive instant query",
+			validate: func() (*models.QueryValidation, error) { return queries.ValidateQuery(ctx, "expensive_metric", true) },
+			valid:    false, series: 50000, points: 50000,
+			executed: []string{"count(expensive_metric)"},
+		},
+		{
+			name: "range selector",
+			validate: func() (*models.QueryValidation, error) {
+				return queries.ValidateQuery(ctx, "cheap_metric[5m]", true)
+			},
+			valid: true, series: 5, points: 5,
+			executed: []string{"count(count_over_time(cheap_metric[5m]))"},
+		},
+		{
+			name:     "scalar",
+			validate: func() (*models.QueryValidation, error) { return queries.ValidateQuery(ctx, "1 + 1", true) },
+			valid:    true, series: 1, points: 1,
+		},
+		{
+			name:     "syntax error",
+			validate: func() (*models.QueryValidation, error) { return queries.ValidateQuery(ctx, "sum(cheap_metric", true) },
+		},
+		{
+			name: "too many steps",
+			validate: func() (*models.QueryValidation, error) {
+				return queries.ValidateRangeQuery(ctx, models.RangeQueryParams{
+					Query: "cheap_metric", Start: start, End: start.Add(24 * time.Hour), Step: "1s",
+				}, true)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			executed = nil
+			mu.Unlock()
+
+			validation, err := tt.validate()
+			require.NoError(t, err)
+			assert.Equal(t, tt.valid, validation.Valid, validation.Message)
+			assert.Equal(t, tt.series, validation.EstimatedSeries)
+			assert.Equal(t, tt.points, validation.EstimatedPoints)
+			assert.Len(t, validation.CostWarnings, tt.warnings)
+
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Equal(t, tt.executed, executed, "only the series count is run against Prometheus")
+		})
+	}
+}
+
+func TestValidateQuerySyntax(t *testing.T) {
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	var executed []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		executed = append(executed, r.FormValue("query"))
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(r.FormValue("query"), "node_load1") {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			fmt.Fprint(w, `{"status":"error","errorType":"execution","error":"found duplicate series for the match group"}`)
+			return
+		}
+		if strings.Contains(r.FormValue("query"), "broken_metric") {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"status":"error","errorType":"internal","error":"storage is down"}`)
+			return
+		}
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1700000000,"3"]}]}}`)
+	}))
+	defer server.Close()
+
+	client, err := prometheus.NewClient(server.URL, logger.NewTestLogger(), cache.New(cache.Options{}))
+	require.NoError(t, err)
+	queries := NewQueriesService(client, logger.NewTestLogger())
+
+	tests := []struct {
+		name     string
+		query    string
+		valid    bool
+		syntax   *models.QuerySyntaxError
+		contains string
+	}{
+		{
+			name:  "valid",
+			query: "sum by (job) (rate(http_requests_total[5m]))",
+			valid: true,
+		},
+		{
+			// The missing parenthesis is reported at the end of the query
+			name:   "unbalanced parentheses",
+			query:  "sum(rate(http_requests_total[5m])",
+			syntax: &models.QuerySyntaxError{Message: "unclosed left parenthesis", Line: 1, Column: 34, Start: 33, End: 33},
+		},
+		{
+			name:   "error on a later line",
+			query:  "sum(\n  rate(http_requests_total[5x])\n)",
+			syntax: &models.QuerySyntaxError{Line: 2, Column: 28, Start: 32},
+		},
+		{
+			// Parses fine even though no sample can fall in a negative window
+			name:  "odd but parseable",
+			query: "rate(http_requests_total[1s] offset -5m) * on() group_left vector(1)",
+			valid: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validation, err := queries.ValidateQuery(ctx, tt.query, false)
+			require.NoError(t, err)
+			assert.Equal(t, tt.valid, validation.Valid, validation.Message)
+			assert.Zero(t, validation.EstimatedSeries)
+
+			if tt.syntax == nil {
+				assert.Nil(t, validation.SyntaxError)
+				return
+			}
+			require.NotNil(t, validation.SyntaxError)
+			assert.Equal(t, tt.syntax.Line, validation.SyntaxError.Line)
+			assert.Equal(t, tt.syntax.Column, validation.SyntaxError.Column)
+			assert.Equal(t, tt.syntax.Start, validation.SyntaxError.Start)
+			if tt.syntax.Message != "" {
+				assert.Equal(t, tt.syntax.Message, validation.SyntaxError.Message)
+				assert.Equal(t, tt.syntax.End, validation.SyntaxError.End)
+			}
+			assert.Contains(t, validation.Message, fmt.Sprintf("line %d, column %d", tt.syntax.Line, tt.syntax.Column))
+		})
+	}
+
+	mu.Lock()
+	assert.Empty(t, executed, "static validation must not contact Prometheus")
+	mu.Unlock()
+
+	t.Run("dry run", func(t *testing.T) {
+		validation, err := queries.ValidateQuery(ctx, "up", true)
+		require.NoError(t, err)
+		assert.True(t, validation.Valid)
+		assert.Equal(t, int64(3), validation.EstimatedSeries)
+
+		// Prometheus rejecting the query makes it invalid
+		validation, err = queries.ValidateQuery(ctx, "up * on(instance) node_load1", true)
+		require.NoError(t, err)
+		assert.False(t, validation.Valid)
+		assert.Nil(t, validation.SyntaxError)
+		assert.Contains(t, validation.Message, "duplicate series")
+
+		// Prometheus failing is an error, not an invalid query
+		_, err = queries.ValidateQuery(ctx, "broken_metric", true)
+		assert.Error(t, err)
+
+		// Syntax errors are caught before the dry run
+		mu.Lock()
+		executed = nil
+		mu.Unlock()
+		validation, err = queries.ValidateQuery(ctx, "sum(up", true)
+		require.NoError(t, err)
+		assert.NotNil(t, validation.SyntaxError)
+		mu.Lock()
+		assert.Empty(t, executed)
+		mu.Unlock()
+	})
+}
+
+func TestExecuteInstantQueryLimit(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[
+			{"metric":{"__name__":"up","instance":"a"},"value":[1700000000,"1"]},
+			{"metric":{"__name__":"up","instance":"b"},"value":[1700000000,"NaN"]},
+			{"metric":{"__name__":"up","instance":"c"},"value":[1700000000,"3"]},
+			{"metric":{"__name__":"up","instance":"d"},"value":[1700000000,"2"]},
+			{"metric":{"__name__":"up","instance":"e"},"value":[1700000000,"3"]}
+		]}}`)
+	}))
+	defer server.Close()
+
+	client, err := prometheus.NewClient(server.URL, logger.NewTestLogger(), cache.New(cache.Options{}))
+	require.NoError(t, err)
+	queries := NewQueriesService(client, logger.NewTestLogger())
+
+	instances := func(response *models.QueryResponse) []string {
+		var names []string
+		for _, dp := range response.Data {
+			names = append(names, dp.Labels["instance"])
+		}
+		return names
+	}
+
+	response, err := queries.ExecuteInstantQuery(ctx, models.InstantQueryParams{Query: "up", Limit: 2})
+	require.NoError(t, err)
+	// Equal values are ordered by series
+	assert.Equal(t, []string{"c", "e"}, instances(response))
+	assert.True(t, response.Truncated)
+	assert.Equal(t, 5, response.TotalCount)
+
+	response, err = queries.ExecuteInstantQuery(ctx, models.InstantQueryParams{Query: "up", Limit: 5})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c", "e", "d", "a", "b"}, instances(response), "NaN sorts last")
+	assert.False(t, response.Truncated)
+	assert.Equal(t, 5, response.TotalCount)
+
+	// Without a limit the order from Prometheus is kept
+	response, err = queries.ExecuteInstantQuery(ctx, models.InstantQueryParams{Query: "up"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, instances(response))
+	assert.False(t, response.Truncated)
+	assert.Equal(t, 5, response.TotalCount)
+
+	_, err = queries.ExecuteInstantQuery(ctx, models.InstantQueryParams{Query: "up", Limit: -1})
+	assert.ErrorIs(t, err, models.ErrInvalidQuery)
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"metrics-api/internal/models"
+)
+
+// DefaultQueryHistorySize is how many executed queries are remembered by
+// default
+const DefaultQueryHistorySize = 1000
+
+// QueryHistory remembers the most recently executed queries in a fixed-size
+// ring buffer, overwriting the oldest entry once full. It is kept in memory
+// only, as it is written on every query.
+type QueryHistory struct {
+	mu      sync.RWMutex
+	entries []models.QueryHistoryEntry
+	next    int
+	full    bool
+}
+
+// NewQueryHistory creates a history holding at most size entries
+func NewQueryHistory(size int) *QueryHistory {
+	if size <= 0 {
+		size = DefaultQueryHistorySize
+	}
+	return &QueryHistory{entries: make([]models.QueryHistoryEntry, size)}
+}
+
+// Add records an executed query
+func (h *QueryHistory) Add(entry models.QueryHistoryEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.entries[h.next] = entry
+	h.next = (h.next + 1) % len(h.entries)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// Recent returns up to limit entries executed at or after since, newest
+// first. A limit of zero or less returns every matching entry.
+func (h *QueryHistory) Recent(limit int, since time.Time) []models.QueryHistoryEntry {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	count := h.next
+	if h.full {
+		count = len(h.entries)
+	}
+
+	recent := []models.QueryHistoryEntry{}
+	for i := 1; i <= count; i++ {
+		if limit > 0 && len(recent) >= limit {
+			break
+		}
+		entry := h.entries[(h.next-i+len(h.entries))%len(h.entries)]
+		if entry.ExecutedAt.Before(since) {
+			continue
+		}
+		recent = append(recent, entry)
+	}
+	return recent
+}
+
+// Clear forgets every recorded query
+func (h *QueryHistory) Clear() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	clear(h.entries)
+	h.next = 0
+	h.full = false
+}
@@ -0,0 +1,270 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"metrics-api/internal/cache"
+	"metrics-api/internal/models"
+	"metrics-api/internal/prometheus"
+	"metrics-api/pkg/logger"
+)
+
+// savedQueryNamePattern keeps saved query names safe to use in URL paths
+var savedQueryNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// templatePlaceholder matches a {name} placeholder of a query template. PromQL
+// label matchers always hold an operator, so they never match.
+var templatePlaceholder = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// DefaultTemplateParameterRegex restricts the values of template parameters
+// without a regex of their own to metric and label names, numbers and
+// durations, which cannot change the structure of the query
+const DefaultTemplateParameterRegex = `[A-Za-z0-9_:.]+`
+
+// SavedQueryStore persists saved queries by name. Get and Delete return
+// models.ErrSavedQueryNotFound for unknown names.
+type SavedQueryStore interface {
+	Put(ctx context.Context, saved models.SavedQuery) error
+	Get(ctx context.Context, name string) (*models.SavedQuery, error)
+	List(ctx context.Context) ([]models.SavedQuery, error)
+	Delete(ctx context.Context, name string) error
+}
+
+// savedQueryKeyPrefix starts the cache key of every saved query
+const savedQueryKeyPrefix = "sq:"
+
+// CacheSavedQueryStore keeps saved queries in a cache store under keys
+// prefixed with sq:, without expiry. Queries are stored as JSON strings so
+// any backend returns them intact. The store must not evict or be flushed
+// for other reasons, so it should not share a bounded query cache.
+type CacheSavedQueryStore struct {
+	cache cache.Store
+}
+
+// NewCacheSavedQueryStore creates a saved query store on c
+func NewCacheSavedQueryStore(c cache.Store) *CacheSavedQueryStore {
+	return &CacheSavedQueryStore{cache: c}
+}
+
+// Put stores saved, replacing any query with the same name
+func (s *CacheSavedQueryStore) Put(ctx context.Context, saved models.SavedQuery) error {
+	encoded, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	return s.cache.SetWithExpiration(savedQueryKeyPrefix+saved.Name, string(encoded), 0)
+}
+
+// Get returns the saved query with the given name
+func (s *CacheSavedQueryStore) Get(ctx context.Context, name string) (*models.SavedQuery, error) {
+	value, ok := s.cache.Get(savedQueryKeyPrefix + name)
+	if !ok {
+		return nil, models.ErrSavedQueryNotFound
+	}
+	encoded, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("saved query %s has unexpected type %T", name, value)
+	}
+
+	var saved models.SavedQuery
+	if err := json.Unmarshal([]byte(encoded), &saved); err != nil {
+		return nil, fmt.Errorf("error decoding saved query %s: %w", name, err)
+	}
+	return &saved, nil
+}
+
+// List returns every saved query in no particular order
+func (s *CacheSavedQueryStore) List(ctx context.Context) ([]models.SavedQuery, error) {
+	var queries []models.SavedQuery
+	for _, key := range s.cache.GetAllKeys() {
+		name, ok := strings.CutPrefix(key, savedQueryKeyPrefix)
+		if !ok {
+			continue
+		}
+		saved, err := s.Get(ctx, name)
+		if err != nil {
+			// Deleted since the keys were listed
+			continue
+		}
+		queries = append(queries, *saved)
+	}
+	return queries, nil
+}
+
+// Delete removes the saved query with the given name
+func (s *CacheSavedQueryStore) Delete(ctx context.Context, name string) error {
+	key := savedQueryKeyPrefix + name
+	if !s.cache.Has(key) {
+		return models.ErrSavedQueryNotFound
+	}
+	s.cache.Delete(key)
+	return nil
+}
+
+// SavedQueriesService manages named, reusable PromQL queries
+type SavedQueriesService struct {
+	store  SavedQueryStore
+	logger logger.Logger
+}
+
+// NewSavedQueriesService creates a saved queries service on store
+func NewSavedQueriesService(store SavedQueryStore, logger logger.Logger) *SavedQueriesService {
+	return &SavedQueriesService{
+		store:  store,
+		logger: logger,
+	}
+}
+
+// NewMemorySavedQueriesService creates a saved queries service kept in a
+// cache of its own in this process
+func NewMemorySavedQueriesService(logger logger.Logger) *SavedQueriesService {
+	return NewSavedQueriesService(NewCacheSavedQueryStore(cache.New(cache.Options{})), logger)
+}
+
+// Save validates and stores a named query, replacing any existing query with
+// the same name
+func (s *SavedQueriesService) Save(ctx context.Context, name, query, description string) error {
+	if !savedQueryNamePattern.MatchString(name) {
+		return fmt.Errorf("%w: name must match %s", models.ErrInvalidQuery, savedQueryNamePattern)
+	}
+	if query == "" {
+		return models.ErrInvalidQuery
+	}
+	if err := prometheus.CheckSyntax(query); err != nil {
+		return fmt.Errorf("%w: %v", models.ErrInvalidQuery, err)
+	}
+
+	saved := models.SavedQuery{
+		Name:        name,
+		Query:       query,
+		Description: description,
+		CreatedAt:   time.Now(),
+	}
+	if err := s.store.Put(ctx, saved); err != nil {
+		return fmt.Errorf("failed to save query %s: %w", name, err)
+	}
+
+	s.logger.Infof("Saved query %s: %s", name, query)
+	return nil
+}
+
+// SaveTemplate validates and stores a query template as a saved query
+// holding its parameters, replacing any existing query with the same name
+func (s *SavedQueriesService) SaveTemplate(ctx context.Context, template models.QueryTemplate) error {
+	if !savedQueryNamePattern.MatchString(template.Name) {
+		return fmt.Errorf("%w: name must match %s", models.ErrInvalidQuery, savedQueryNamePattern)
+	}
+	if template.Template == "" {
+		return models.ErrInvalidQuery
+	}
+
+	declared := make(map[string]bool, len(template.Parameters))
+	for _, param := range template.Parameters {
+		if declared[param.Name] {
+			return fmt.Errorf("%w: parameter %s is declared twice", models.ErrInvalidQuery, param.Name)
+		}
+		declared[param.Name] = true
+
+		pattern, err := parameterPattern(param)
+		if err != nil {
+			return fmt.Errorf("%w: invalid regex for parameter %s: %v", models.ErrInvalidQuery, param.Name, err)
+		}
+		if param.Default != "" && !pattern.MatchString(param.Default) {
+			return fmt.Errorf("%w: default of parameter %s does not match its regex", models.ErrInvalidQuery, param.Name)
+		}
+	}
+	for _, match := range templatePlaceholder.FindAllStringSubmatch(template.Template, -1) {
+		if !declared[match[1]] {
+			return fmt.Errorf("%w: placeholder {%s} has no parameter", models.ErrInvalidQuery, match[1])
+		}
+	}
+
+	saved := models.SavedQuery{
+		Name:       template.Name,
+		Query:      template.Template,
+		CreatedAt:  time.Now(),
+		Parameters: template.Parameters,
+	}
+	if err := s.store.Put(ctx, saved); err != nil {
+		return fmt.Errorf("failed to save template %s: %w", template.Name, err)
+	}
+
+	s.logger.Infof("Saved query template %s: %s", template.Name, template.Template)
+	return nil
+}
+
+// GetTemplate returns the saved query with the given name as a template; a
+// query saved without parameters is a template without placeholders
+func (s *SavedQueriesService) GetTemplate(ctx context.Context, name string) (*models.QueryTemplate, error) {
+	saved, err := s.store.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &models.QueryTemplate{
+		Name:       saved.Name,
+		Template:   saved.Query,
+		Parameters: saved.Parameters,
+	}, nil
+}
+
+// parameterPattern compiles the regex of param, anchored so it must match
+// the whole value
+func parameterPattern(param models.TemplateParameter) (*regexp.Regexp, error) {
+	expr := param.Regex
+	if expr == "" {
+		expr = DefaultTemplateParameterRegex
+	}
+	return regexp.Compile(`^(?:` + expr + `)$`)
+}
+
+// Get returns the saved query with the given name
+func (s *SavedQueriesService) Get(ctx context.Context, name string) (*models.SavedQuery, error) {
+	return s.store.Get(ctx, name)
+}
+
+// List returns all saved queries ordered by name
+func (s *SavedQueriesService) List(ctx context.Context) ([]models.SavedQuery, error) {
+	queries, err := s.store.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list saved queries: %w", err)
+	}
+	if queries == nil {
+		queries = []models.SavedQuery{}
+	}
+	sort.Slice(queries, func(i, j int) bool {
+		return queries[i].Name < queries[j].Name
+	})
+	return queries, nil
+}
+
+// Delete removes the saved query with the given name
+func (s *SavedQueriesService) Delete(ctx context.Context, name string) error {
+	if err := s.store.Delete(ctx, name); err != nil {
+		return err
+	}
+	s.logger.Infof("Deleted saved query %s", name)
+	return nil
+}
+
+// Use returns the saved query with the given name for running it, recording
+// the current time as when it was last used
+func (s *SavedQueriesService) Use(ctx context.Context, name string) (*models.SavedQuery, error) {
+	saved, err := s.store.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	saved.LastUsed = &now
+	if err := s.store.Put(ctx, *saved); err != nil {
+		// Running the query matters more than the usage record
+		s.logger.Warnf("Failed to record use of saved query %s: %v", name, err)
+	}
+	return saved, nil
+}
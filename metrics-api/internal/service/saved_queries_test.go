@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"metrics-api/internal/cache"
+	"metrics-api/internal/models"
+	"metrics-api/internal/prometheus"
+	"metrics-api/pkg/logger"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSavedQueriesService(t *testing.T) {
+	ctx := context.Background()
+	shared := cache.New(cache.Options{})
+	shared.Set("instant:0:\"up\"", "unrelated")
+	saved := NewSavedQueriesService(NewCacheSavedQueryStore(shared), logger.NewTestLogger())
+
+	require.NoError(t, saved.Save(ctx, "b-errors", `rate(errors_total[5m])`, "Error rate"))
+	require.NoError(t, saved.Save(ctx, "a-up", "up", ""))
+	assert.True(t, shared.Has("sq:a-up"))
+
+	// Names, queries and syntax are validated
+	assert.ErrorIs(t, saved.Save(ctx, "bad name", "up", ""), models.ErrInvalidQuery)
+	assert.ErrorIs(t, saved.Save(ctx, "empty", "", ""), models.ErrInvalidQuery)
+	assert.ErrorIs(t, saved.Save(ctx, "broken", "sum(up", ""), models.ErrInvalidQuery)
+
+	queries, err := saved.List(ctx)
+	require.NoError(t, err)
+	if assert.Len(t, queries, 2, "only sq: keys are saved queries") {
+		assert.Equal(t, "a-up", queries[0].Name)
+		assert.Equal(t, "Error rate", queries[1].Description)
+	}
+
+	query, err := saved.Get(ctx, "a-up")
+	require.NoError(t, err)
+	assert.Nil(t, query.LastUsed)
+
+	_, err = saved.Use(ctx, "a-up")
+	require.NoError(t, err)
+	query, err = saved.Get(ctx, "a-up")
+	require.NoError(t, err)
+	assert.NotNil(t, query.LastUsed)
+
+	require.NoError(t, saved.Delete(ctx, "a-up"))
+	_, err = saved.Get(ctx, "a-up")
+	assert.ErrorIs(t, err, models.ErrSavedQueryNotFound)
+	assert.ErrorIs(t, saved.Delete(ctx, "a-up"), models.ErrSavedQueryNotFound)
+	_, err = saved.Use(ctx, "missing")
+	assert.ErrorIs(t, err, models.ErrSavedQueryNotFound)
+}
+
+func TestRenderTemplate(t *testing.T) {
+	ctx := context.Background()
+
+	// Prometheus rejects whatever does not parse, like the real one
+	var executed []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.FormValue("query")
+		executed = append(executed, query)
+		w.Header().Set("Content-Type", "application/json")
+		if err := prometheus.CheckSyntax(query); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"status":"error","errorType":"bad_data","error":%q}`, err.Error())
+			return
+		}
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`))
+	}))
+	defer server.Close()
+
+	client, err := prometheus.NewClient(server.URL, logger.NewTestLogger(), cache.New(cache.Options{}))
+	require.NoError(t, err)
+	queries := NewQueriesService(client, logger.NewTestLogger())
+	saved := queries.SavedQueries()
+
+	require.NoError(t, saved.SaveTemplate(ctx, models.QueryTemplate{
+		Name:     "rate",
+		Template: `sum by (job) (rate({metric}{job="{job}"}[{window}]))`,
+		Parameters: []models.TemplateParameter{
+			{Name: "metric"},
+			{Name: "job", Regex: `[a-z-]+`},
+			{Name: "window", Default: "5m", Regex: `[0-9]+[smhd]`},
+		},
+	}))
+
+	// Templates are validated when saved
+	assert.ErrorIs(t, saved.SaveTemplate(ctx, models.QueryTemplate{
+		Name: "undeclared", Template: "rate({metric}[5m])",
+	}), models.ErrInvalidQuery)
+	assert.ErrorIs(t, saved.SaveTemplate(ctx, models.QueryTemplate{
+		Name: "bad-regex", Template: "{metric}",
+		Parameters: []models.TemplateParameter{{Name: "metric", Regex: "("}},
+	}), models.ErrInvalidQuery)
+	assert.ErrorIs(t, saved.SaveTemplate(ctx, models.QueryTemplate{
+		Name: "bad-default", Template: "{metric}",
+		Parameters: []models.TemplateParameter{{Name: "metric", Default: "a b"}},
+	}), models.ErrInvalidQuery)
+
+	t.Run("missing parameter uses default", func(t *testing.T) {
+		query, err := queries.RenderTemplate(ctx, "rate", map[string]string{
+			"metric": "http_requests_total",
+			"job":    "api",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, `sum by (job) (rate(http_requests_total{job="api"}[5m]))`, query)
+		assert.Equal(t, "count("+query+")", executed[len(executed)-1], "rendered query is validated")
+	})
+
+	t.Run("missing parameter without default", func(t *testing.T) {
+		_, err := queries.RenderTemplate(ctx, "rate", map[string]string{"job": "api"})
+		assert.ErrorIs(t, err, models.ErrInvalidQuery)
+	})
+
+	t.Run("regex mismatch", func(t *testing.T) {
+		_, err := queries.RenderTemplate(ctx, "rate", map[string]string{
+			"metric": "up",
+			"job":    "api",
+			"window": "five minutes",
+		})
+		assert.ErrorIs(t, err, models.ErrInvalidQuery)
+	})
+
+	t.Run("unknown parameter", func(t *testing.T) {
+		_, err := queries.RenderTemplate(ctx, "rate", map[string]string{
+			"metric": "up",
+			"job":    "api",
+			"extra":  "x",
+		})
+		assert.ErrorIs(t, err, models.ErrInvalidQuery)
+	})
+
+	t.Run("injection attempts", func(t *testing.T) {
+		before := len(executed)
+		for _, params := range []map[string]string{
+			// Breaking out of the selector
+			{"metric": `up) or vector(1`, "job": "api"},
+			// Closing the label matcher, which only a partial regex match would allow
+			{"metric": "up", "job": `api"} or up{job="x`},
+			// Smuggling in a placeholder for another parameter
+			{"metric": "{job}", "job": "api"},
+			{"metric": "up", "job": "api", "window": "5m] or vector(1) #"},
+		} {
+			_, err := queries.RenderTemplate(ctx, "rate", params)
+			assert.ErrorIs(t, err, models.ErrInvalidQuery, "params %v", params)
+		}
+		assert.Len(t, executed, before, "rejected values never reach Prometheus")
+	})
+
+	t.Run("rendered query fails validation", func(t *testing.T) {
+		require.NoError(t, saved.SaveTemplate(ctx, models.QueryTemplate{
+			Name:       "loose",
+			Template:   "sum({expr})",
+			Parameters: []models.TemplateParameter{{Name: "expr", Regex: `.+`}},
+		}))
+		_, err := queries.RenderTemplate(ctx, "loose", map[string]string{"expr": "up[5m"})
+		assert.ErrorIs(t, err, models.ErrInvalidQuery)
+
+		// Values are substituted once, never expanded again
+		query, err := queries.RenderTemplate(ctx, "loose", map[string]string{"expr": `label_replace(up, "x", "{expr}", "", "")`})
+		require.NoError(t, err)
+		assert.Equal(t, `sum(label_replace(up, "x", "{expr}", "", ""))`, query)
+	})
+
+	t.Run("unknown template", func(t *testing.T) {
+		_, err := queries.RenderTemplate(ctx, "missing", nil)
+		assert.ErrorIs(t, err, models.ErrSavedQueryNotFound)
+	})
+}
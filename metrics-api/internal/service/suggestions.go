@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// DefaultSuggestionLimit is how many suggestions are returned when the
+// caller does not ask for a number
+const DefaultSuggestionLimit = 10
+
+// promqlAggregations are the aggregation operators, which PromQL parses as
+// keywords rather than functions
+var promqlAggregations = []string{
+	"avg", "bottomk", "count", "count_values", "group", "limitk", "limit_ratio",
+	"max", "min", "quantile", "stddev", "stdvar", "sum", "topk",
+}
+
+var (
+	// trailingNamePattern matches the metric or function name being typed
+	// at the end of a query
+	trailingNamePattern = regexp.MustCompile(`[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	// functionNamePattern matches names that could be the start of a
+	// function or aggregation
+	functionNamePattern = regexp.MustCompile(`^[a-z_]+$`)
+	// labelNamePattern matches a label name being typed, possibly empty
+	labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$|^$`)
+)
+
+// GetQuerySuggestions completes the name being typed at the end of prefix,
+// returning prefix with the name completed. Inside a selector such as
+// `http_requests_total{jo` the label names of that metric are suggested;
+// otherwise functions and aggregations when the name looks like one,
+// followed by metric names. Names starting with what was typed rank above
+// names only containing it.
+func (s *QueriesService) GetQuerySuggestions(ctx context.Context, prefix string, limit int) ([]string, error) {
+	if limit <= 0 {
+		limit = DefaultSuggestionLimit
+	}
+
+	if open := strings.LastIndex(prefix, "{"); open >= 0 && !strings.Contains(prefix[open:], "}") {
+		return s.labelSuggestions(ctx, prefix, open, limit)
+	}
+
+	name := trailingNamePattern.FindString(prefix)
+	head := prefix[:len(prefix)-len(name)]
+
+	var functionsPrefixed, functionsContained []string
+	if functionNamePattern.MatchString(name) {
+		functionsPrefixed, functionsContained = matchNames(promqlFunctionNames(), name)
+	}
+
+	metrics, err := s.clientFor(ctx).GetMetrics(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get metrics for suggestions: %w", err)
+	}
+	metrics = filterHidden(metrics, s.hidden)
+	sort.Strings(metrics)
+	metricsPrefixed, metricsContained := matchNames(metrics, name)
+
+	suggestions := make([]string, 0, limit)
+	add := func(names []string, suffix string) {
+		for _, name := range names {
+			if len(suggestions) == limit {
+				return
+			}
+			suggestions = append(suggestions, head+name+suffix)
+		}
+	}
+	add(functionsPrefixed, "(")
+	add(metricsPrefixed, "")
+	add(functionsContained, "(")
+	add(metricsContained, "")
+	return suggestions, nil
+}
+
+// labelSuggestions completes the label name of the matcher being typed in
+// the selector opened at prefix[open], using the labels of the metric
+// before it
+func (s *QueriesService) labelSuggestions(ctx context.Context, prefix string, open, limit int) ([]string, error) {
+	metric := trailingNamePattern.FindString(strings.TrimRight(prefix[:open], " "))
+	if metric == "" || isHidden(metric, s.hidden) {
+		return []string{}, nil
+	}
+
+	// Nothing is suggested within a quoted value or after the label name
+	selector := prefix[open+1:]
+	if strings.Count(selector, `"`)%2 == 1 {
+		return []string{}, nil
+	}
+	name := strings.TrimLeft(selector[strings.LastIndex(selector, ",")+1:], " ")
+	if !labelNamePattern.MatchString(name) {
+		return []string{}, nil
+	}
+	head := prefix[:len(prefix)-len(name)]
+
+	labels, err := s.clientFor(ctx).GetLabelsForMetric(ctx, metric)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get labels for suggestions: %w", err)
+	}
+	names := make([]string, 0, len(labels))
+	for _, label := range labels {
+		if label != "__name__" {
+			names = append(names, label)
+		}
+	}
+	sort.Strings(names)
+	prefixed, contained := matchNames(names, name)
+
+	suggestions := make([]string, 0, limit)
+	for _, label := range append(prefixed, contained...) {
+		if len(suggestions) == limit {
+			break
+		}
+		suggestions = append(suggestions, head+label)
+	}
+	return suggestions, nil
+}
+
+// matchNames splits the names starting with typed from those containing it
+// elsewhere, keeping their order
+func matchNames(names []string, typed string) (prefixed, contained []string) {
+	for _, name := range names {
+		switch {
+		case strings.HasPrefix(name, typed):
+			prefixed = append(prefixed, name)
+		case strings.Contains(name, typed):
+			contained = append(contained, name)
+		}
+	}
+	return prefixed, contained
+}
+
+// promqlFunctionNames returns the sorted names of the PromQL functions and
+// aggregations, leaving out experimental functions unless they are enabled
+func promqlFunctionNames() []string {
+	names := make([]string, 0, len(parser.Functions)+len(promqlAggregations))
+	for name, function := range parser.Functions {
+		if !function.Experimental || parser.EnableExperimentalFunctions {
+			names = append(names, name)
+		}
+	}
+	names = append(names, promqlAggregations...)
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"metrics-api/internal/cache"
+	"metrics-api/internal/prometheus"
+	"metrics-api/pkg/logger"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetQuerySuggestions(t *testing.T) {
+	ctx := context.Background()
+
+	promMux := http.NewServeMux()
+	promMux.HandleFunc("/api/v1/label/__name__/values", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status":"success","data":["go_goroutines","http_request_duration_seconds_bucket","http_requests_total","node_cpu_seconds_total","process_cpu_seconds_total","rate_limited_total","up"]}`)
+	})
+	promMux.HandleFunc("/api/v1/labels", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("match[]") != "http_requests_total" {
+			fmt.Fprint(w, `{"status":"success","data":["__name__","instance","job"]}`)
+			return
+		}
+		fmt.Fprint(w, `{"status":"success","data":["__name__","code","handler","instance","job","method"]}`)
+	})
+	server := httptest.NewServer(promMux)
+	defer server.Close()
+
+	client, err := prometheus.NewClient(server.URL, logger.NewTestLogger(), cache.New(cache.Options{}))
+	require.NoError(t, err)
+	queries := NewQueriesService(client, logger.NewTestLogger()).
+		WithHiddenPatterns([]*regexp.Regexp{regexp.MustCompile("^(?:go_.*)$")})
+
+	tests := []struct {
+		name     string
+		prefix   string
+		limit    int
+		expected []string
+	}{
+		{
+			name:     "function",
+			prefix:   "histogram_q",
+			expected: []string{"histogram_quantile("},
+		},
+		{
+			name:     "prefix before substring",
+			prefix:   "rat",
+			limit:    3,
+			expected: []string{"rate(", "rate_limited_total", "irate("},
+		},
+		{
+			name:     "aggregation in an expression",
+			prefix:   "sum(rate(http_requests_total[5m])) / su",
+			limit:    1,
+			expected: []string{"sum(rate(http_requests_total[5m])) / sum("},
+		},
+		{
+			name:     "function by substring",
+			prefix:   "quantile",
+			expected: []string{"quantile(", "quantile_over_time(", "histogram_quantile("},
+		},
+		{
+			name:     "metric prefix before substring",
+			prefix:   "cpu",
+			expected: []string{"node_cpu_seconds_total", "process_cpu_seconds_total"},
+		},
+		{
+			name:     "metric",
+			prefix:   "http_req",
+			expected: []string{"http_request_duration_seconds_bucket", "http_requests_total"},
+		},
+		{
+			name:     "metric inside a function",
+			prefix:   "rate(node_",
+			expected: []string{"rate(node_cpu_seconds_total"},
+		},
+		{
+			name:     "hidden metric",
+			prefix:   "go_gor",
+			expected: []string{},
+		},
+		{
+			name:     "limit",
+			prefix:   "total",
+			limit:    2,
+			expected: []string{"http_requests_total", "node_cpu_seconds_total"},
+		},
+		{
+			name:     "label",
+			prefix:   "http_requests_total{me",
+			expected: []string{"http_requests_total{method"},
+		},
+		{
+			name:     "all labels",
+			prefix:   `sum(http_requests_total{code="200", `,
+			expected: []string{`sum(http_requests_total{code="200", code`, `sum(http_requests_total{code="200", handler`, `sum(http_requests_total{code="200", instance`, `sum(http_requests_total{code="200", job`, `sum(http_requests_total{code="200", method`},
+		},
+		{
+			name:     "label by substring",
+			prefix:   "http_requests_total{o",
+			expected: []string{"http_requests_total{code", "http_requests_total{job", "http_requests_total{method"},
+		},
+		{
+			name:     "label value",
+			prefix:   `http_requests_total{job="ap`,
+			expected: []string{},
+		},
+		{
+			name:     "label of a hidden metric",
+			prefix:   "go_goroutines{",
+			expected: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			suggestions, err := queries.GetQuerySuggestions(ctx, tt.prefix, tt.limit)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, suggestions)
+		})
+	}
+}
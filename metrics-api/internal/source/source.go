@@ -0,0 +1,25 @@
+package source
+
+import "context"
+
+type contextKey string
+
+const sourceNameKey contextKey = "sourceName"
+
+// NewContext returns a copy of ctx carrying the name of the Prometheus
+// source the request is directed at
+func NewContext(ctx context.Context, name string) context.Context {
+	if name == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, sourceNameKey, name)
+}
+
+// FromContext returns the source name stored in ctx, or an empty string
+// when the request uses the default source
+func FromContext(ctx context.Context) string {
+	if name, ok := ctx.Value(sourceNameKey).(string); ok {
+		return name
+	}
+	return ""
+}
@@ -0,0 +1,24 @@
+package tenant
+
+import "context"
+
+type contextKey string
+
+const tenantIDKey contextKey = "tenantID"
+
+// NewContext returns a copy of ctx carrying the given tenant identifier
+func NewContext(ctx context.Context, tenantID string) context.Context {
+	if tenantID == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, tenantIDKey, tenantID)
+}
+
+// FromContext returns the tenant identifier stored in ctx, or an empty
+// string when the request is not associated with a tenant
+func FromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(tenantIDKey).(string); ok {
+		return id
+	}
+	return ""
+}
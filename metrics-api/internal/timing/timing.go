@@ -0,0 +1,83 @@
+package timing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type contextKey string
+
+const timingsKey contextKey = "timings"
+
+// Timings collects named durations for a single request, reported to
+// clients in a Server-Timing header. A nil *Timings records nothing, so
+// callers can measure unconditionally.
+type Timings struct {
+	mu      sync.Mutex
+	entries []entry
+}
+
+type entry struct {
+	name     string
+	duration time.Duration
+}
+
+// New creates an empty set of timings
+func New() *Timings {
+	return &Timings{}
+}
+
+// NewContext returns a copy of ctx carrying t
+func NewContext(ctx context.Context, t *Timings) context.Context {
+	return context.WithValue(ctx, timingsKey, t)
+}
+
+// FromContext returns the timings stored in ctx, or nil when the request
+// did not ask for them
+func FromContext(ctx context.Context) *Timings {
+	t, _ := ctx.Value(timingsKey).(*Timings)
+	return t
+}
+
+// Add adds d to the duration recorded under name
+func (t *Timings) Add(name string, d time.Duration) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i := range t.entries {
+		if t.entries[i].name == name {
+			t.entries[i].duration += d
+			return
+		}
+	}
+	t.entries = append(t.entries, entry{name: name, duration: d})
+}
+
+// Since adds the time elapsed since start under name
+func (t *Timings) Since(name string, start time.Time) {
+	t.Add(name, time.Since(start))
+}
+
+// Header formats the timings as a Server-Timing header value, with
+// durations in milliseconds
+func (t *Timings) Header() string {
+	if t == nil {
+		return ""
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	metrics := make([]string, 0, len(t.entries))
+	for _, e := range t.entries {
+		metrics = append(metrics, fmt.Sprintf("%s;dur=%.3f", e.name, float64(e.duration)/float64(time.Millisecond)))
+	}
+	return strings.Join(metrics, ", ")
+}
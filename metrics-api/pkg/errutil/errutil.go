@@ -0,0 +1,103 @@
+package errutil
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Multi accumulates errors keyed by the sub-operation that produced them.
+// The zero value is ready to use and safe for concurrent use.
+type Multi struct {
+	mu   sync.Mutex
+	errs map[string]error
+}
+
+// Add records err under key. Nil errors are ignored so callers can add
+// results unconditionally.
+func (m *Multi) Add(key string, err error) {
+	if err == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.errs == nil {
+		m.errs = make(map[string]error)
+	}
+	m.errs[key] = err
+}
+
+// Len returns the number of recorded errors
+func (m *Multi) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.errs)
+}
+
+// Success reports whether no errors were recorded
+func (m *Multi) Success() bool {
+	return m.Len() == 0
+}
+
+// Errors returns the recorded error messages keyed by sub-operation,
+// suitable for an "errors" field in a response body
+func (m *Multi) Errors() map[string]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make(map[string]string, len(m.errs))
+	for k, err := range m.errs {
+		result[k] = err.Error()
+	}
+	return result
+}
+
+// Error renders all recorded errors as a single line ordered by key
+func (m *Multi) Error() string {
+	messages := m.Errors()
+
+	keys := make([]string, 0, len(messages))
+	for k := range messages {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s: %s", k, messages[k]))
+	}
+	return fmt.Sprintf("%d error(s): %s", len(parts), strings.Join(parts, "; "))
+}
+
+// Unwrap returns the recorded errors so errors.Is and errors.As can inspect them
+func (m *Multi) Unwrap() []error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make([]error, 0, len(m.errs))
+	for _, err := range m.errs {
+		result = append(result, err)
+	}
+	return result
+}
+
+// ErrorOrNil returns m as an error if any errors were recorded, otherwise nil
+func (m *Multi) ErrorOrNil() error {
+	if m.Success() {
+		return nil
+	}
+	return m
+}
+
+// AsMulti reports whether err is a partial failure and returns it if so
+func AsMulti(err error) (*Multi, bool) {
+	var multi *Multi
+	if errors.As(err, &multi) {
+		return multi, true
+	}
+	return nil, false
+}
@@ -0,0 +1,50 @@
+package errutil
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiPreservesPerKeyMessages(t *testing.T) {
+	var multi Multi
+
+	errTimeout := errors.New("context deadline exceeded")
+	multi.Add("min", errTimeout)
+	multi.Add("max", fmt.Errorf("bad data: %s", "NaN"))
+	multi.Add("avg", nil)
+
+	assert.False(t, multi.Success())
+	assert.Equal(t, 2, multi.Len())
+	assert.Equal(t, map[string]string{
+		"min": "context deadline exceeded",
+		"max": "bad data: NaN",
+	}, multi.Errors())
+
+	// Combined log line is ordered by key
+	assert.Equal(t, "2 error(s): max: bad data: NaN; min: context deadline exceeded", multi.Error())
+
+	// Wrapped errors stay inspectable
+	err := fmt.Errorf("summary: %w", multi.ErrorOrNil())
+	assert.ErrorIs(t, err, errTimeout)
+
+	found, ok := AsMulti(err)
+	require.True(t, ok)
+	assert.Equal(t, 2, found.Len())
+}
+
+func TestMultiWithoutErrorsReportsSuccess(t *testing.T) {
+	var multi Multi
+	multi.Add("min", nil)
+
+	assert.True(t, multi.Success())
+	assert.Equal(t, 0, multi.Len())
+	assert.Empty(t, multi.Errors())
+	assert.NoError(t, multi.ErrorOrNil())
+
+	_, ok := AsMulti(multi.ErrorOrNil())
+	assert.False(t, ok)
+}
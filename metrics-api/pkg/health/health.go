@@ -20,6 +20,9 @@ const (
 	StatusDown Status = "down"
 )
 
+// DefaultCheckTimeout bounds a single check when no timeout is configured
+const DefaultCheckTimeout = 5 * time.Second
+
 // Check represents a health check function
 type Check func(ctx context.Context) (Status, map[string]interface{}, error)
 
@@ -59,6 +62,13 @@ func (c *Checker) AddCheck(name string, check Check) {
 	c.checks[name] = check
 }
 
+// SetCheckTimeout changes the timeout applied to each individual check
+func (c *Checker) SetCheckTimeout(checkTimeout time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checkTimeout = checkTimeout
+}
+
 // RemoveCheck removes a named health check
 func (c *Checker) RemoveCheck(name string) {
 	c.mu.Lock()
@@ -70,6 +80,7 @@ func (c *Checker) RemoveCheck(name string) {
 func (c *Checker) RunCheck(ctx context.Context, name string) (CheckResult, bool) {
 	c.mu.RLock()
 	check, exists := c.checks[name]
+	checkTimeout := c.checkTimeout
 	c.mu.RUnlock()
 
 	if !exists {
@@ -77,7 +88,7 @@ func (c *Checker) RunCheck(ctx context.Context, name string) (CheckResult, bool)
 	}
 
 	// Create context with timeout
-	ctx, cancel := context.WithTimeout(ctx, c.checkTimeout)
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
 	defer cancel()
 
 	startTime := time.Now()
@@ -104,19 +115,20 @@ func (c *Checker) RunCheck(ctx context.Context, name string) (CheckResult, bool)
 func (c *Checker) RunChecks(ctx context.Context) (Status, map[string]CheckResult) {
 	results := make(map[string]CheckResult)
 	
-	// Create a channel to collect results from goroutines
+	// Buffered so checks still running after a timeout don't block forever
+	c.mu.RLock()
+	checkCount := len(c.checks)
+	checkTimeout := c.checkTimeout
 	resultChan := make(chan struct {
 		name   string
 		result CheckResult
-	})
+	}, checkCount)
 
 	// Run each check in its own goroutine
-	c.mu.RLock()
-	checkCount := len(c.checks)
 	for name, check := range c.checks {
 		go func(name string, check Check) {
 			// Create timeout context for this check
-			checkCtx, cancel := context.WithTimeout(ctx, c.checkTimeout)
+			checkCtx, cancel := context.WithTimeout(ctx, checkTimeout)
 			defer cancel()
 
 			startTime := time.Now()
@@ -139,26 +151,35 @@ func (c *Checker) RunChecks(ctx context.Context) (Status, map[string]CheckResult
 	}
 	c.mu.RUnlock()
 
-	// Collect all results with a timeout
-	timeout := time.After(c.checkTimeout + 100*time.Millisecond) // Add a little extra time
+	// markMissing reports every check that has not answered yet as down
+	markMissing := func(err error) {
+		c.mu.RLock()
+		for name := range c.checks {
+			if _, exists := results[name]; !exists {
+				results[name] = CheckResult{
+					Status:    StatusDown,
+					Error:     err,
+					Timestamp: time.Now(),
+				}
+			}
+		}
+		c.mu.RUnlock()
+	}
+
+	// Collect all results, giving up when either the check timeout or the
+	// caller's deadline expires
+	timeout := time.After(checkTimeout + 100*time.Millisecond) // Add a little extra time
+collect:
 	for i := 0; i < checkCount; i++ {
 		select {
 		case result := <-resultChan:
 			results[result.name] = result.result
 		case <-timeout:
-			// If we time out waiting for checks, consider any missing checks as failures
-			c.mu.RLock()
-			for name := range c.checks {
-				if _, exists := results[name]; !exists {
-					results[name] = CheckResult{
-						Status:    StatusDown,
-						Error:     fmt.Errorf("health check timed out"),
-						Timestamp: time.Now(),
-					}
-				}
-			}
-			c.mu.RUnlock()
-			i = checkCount // Exit the loop
+			markMissing(fmt.Errorf("health check timed out"))
+			break collect
+		case <-ctx.Done():
+			markMissing(fmt.Errorf("health check timed out: %w", ctx.Err()))
+			break collect
 		}
 	}
 
@@ -173,6 +194,34 @@ func (c *Checker) RunChecks(ctx context.Context) (Status, map[string]CheckResult
 	return c.determineOverallStatus(results), results
 }
 
+// StartPeriodic runs all checks right away and then every interval in the
+// background, so GetLastResults stays fresh without callers waiting on the
+// checks. It stops when ctx is cancelled; a run in progress is not
+// interrupted, so shutting down does not record the checks as failed. The
+// interval must be positive.
+func (c *Checker) StartPeriodic(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("health check interval must be positive, got %s", interval)
+	}
+
+	runCtx := context.WithoutCancel(ctx)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			c.RunChecks(runCtx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
 // GetLastResults gets the last known results for all checks
 func (c *Checker) GetLastResults() map[string]CheckResult {
 	c.resultsMu.RLock()
@@ -371,9 +420,29 @@ func GenerateHealthStatus(checker *Checker, version string, includeDetails bool)
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	return GenerateHealthStatusContext(ctx, checker, version, includeDetails)
+}
+
+// GenerateHealthStatusContext creates a consolidated health status report,
+// giving up on checks that have not finished when ctx is done
+func GenerateHealthStatusContext(ctx context.Context, checker *Checker, version string, includeDetails bool) HealthStatus {
 	// Run all health checks
 	status, results := checker.RunChecks(ctx)
 
+	return newHealthStatus(checker, version, status, results, includeDetails)
+}
+
+// GenerateHealthStatusCached creates a health status report from the last
+// results of the checks without running them, so it returns instantly. It is
+// meant for checkers kept fresh by StartPeriodic; before any check has run
+// the status is down.
+func GenerateHealthStatusCached(checker *Checker, version string, includeDetails bool) HealthStatus {
+	results := checker.GetLastResults()
+	return newHealthStatus(checker, version, checker.determineOverallStatus(results), results, includeDetails)
+}
+
+// newHealthStatus builds the report of a checker's results
+func newHealthStatus(checker *Checker, version string, status Status, results map[string]CheckResult, includeDetails bool) HealthStatus {
 	// Format checks for simple status display
 	checks := make(map[string]string)
 	for name, result := range results {
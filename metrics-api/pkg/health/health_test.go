@@ -0,0 +1,63 @@
+package health
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartPeriodic(t *testing.T) {
+	var runs atomic.Int32
+	checker := NewChecker(time.Second)
+	checker.AddCheck("fake", func(ctx context.Context) (Status, map[string]interface{}, error) {
+		runs.Add(1)
+		return StatusUp, map[string]interface{}{"ok": true}, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	interval := 20 * time.Millisecond
+	require.NoError(t, checker.StartPeriodic(ctx, interval))
+
+	time.Sleep(interval)
+	require.Eventually(t, func() bool { return runs.Load() >= 2 }, time.Second, time.Millisecond,
+		"checks should run on every tick")
+
+	results := checker.GetLastResults()
+	require.Contains(t, results, "fake")
+	assert.Equal(t, StatusUp, results["fake"].Status)
+
+	status := GenerateHealthStatusCached(checker, "v1", true)
+	assert.Equal(t, StatusUp, status.Status)
+	assert.Equal(t, map[string]string{"fake": "up"}, status.Checks)
+	assert.Equal(t, results, status.CheckDetails)
+
+	cancel()
+	time.Sleep(2 * interval)
+	stopped := runs.Load()
+	time.Sleep(3 * interval)
+	assert.Equal(t, stopped, runs.Load(), "checks should stop when the context is cancelled")
+}
+
+func TestStartPeriodicInvalidInterval(t *testing.T) {
+	checker := NewChecker(time.Second)
+	for _, interval := range []time.Duration{0, -time.Second} {
+		assert.Error(t, checker.StartPeriodic(context.Background(), interval), "interval %s", interval)
+	}
+}
+
+func TestGenerateHealthStatusCachedBeforeFirstRun(t *testing.T) {
+	checker := NewChecker(time.Second)
+	checker.AddCheck("fake", func(ctx context.Context) (Status, map[string]interface{}, error) {
+		t.Error("cached status should not run checks")
+		return StatusUp, nil, nil
+	})
+
+	status := GenerateHealthStatusCached(checker, "v1", false)
+	assert.Equal(t, StatusDown, status.Status)
+	assert.Empty(t, status.Checks)
+	assert.Nil(t, status.CheckDetails)
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
@@ -31,6 +33,9 @@ type Logger interface {
 // zapLogger implements the Logger interface with zap
 type zapLogger struct {
 	logger *zap.SugaredLogger
+	// state is shared with every logger derived from this one; it is nil
+	// for loggers that cannot be reconfigured
+	state *logState
 }
 
 // LogRequest logs API request details
@@ -55,9 +60,11 @@ func (l *zapLogger) LogRequest(method, path string, status int, duration time.Du
 func NewLogger(opts ...Option) Logger {
 	// Default configuration
 	config := &loggerConfig{
-		level:      zapcore.InfoLevel,
-		outputType: "json",
-		output:     os.Stdout,
+		level:           zapcore.InfoLevel,
+		outputType:      "json",
+		output:          os.Stdout,
+		caller:          true,
+		stacktraceLevel: zapcore.ErrorLevel,
 	}
 
 	// Apply options
@@ -65,6 +72,59 @@ func NewLogger(opts ...Option) Logger {
 		opt(config)
 	}
 
+	state := &logState{
+		config: *config,
+		level:  zap.NewAtomicLevelAt(config.level),
+	}
+	state.build()
+
+	// Create zap logger; caller lookup costs a runtime.Caller per entry
+	zapOpts := []zap.Option{zap.AddStacktrace(config.stacktraceLevel)}
+	if config.caller {
+		zapOpts = append(zapOpts, zap.AddCaller(), zap.AddCallerSkip(1))
+	}
+	logger := zap.New(&reloadableCore{state: state}, zapOpts...)
+
+	return &zapLogger{
+		logger: logger.Sugar(),
+		state:  state,
+	}
+}
+
+// Reconfigure applies opts to log and every logger derived from it. The
+// level, output type and output change at once; the caller and stacktrace
+// options only take effect when a logger is created.
+func Reconfigure(log Logger, opts ...Option) error {
+	l, ok := log.(*zapLogger)
+	if !ok || l.state == nil {
+		return fmt.Errorf("logger cannot be reconfigured")
+	}
+
+	l.state.mu.Lock()
+	defer l.state.mu.Unlock()
+
+	config := l.state.config
+	for _, opt := range opts {
+		opt(&config)
+	}
+	l.state.config = config
+	l.state.level.SetLevel(config.level)
+	l.state.build()
+	return nil
+}
+
+// logState holds the configuration a logger and the loggers derived from it
+// write with
+type logState struct {
+	mu     sync.Mutex
+	config loggerConfig
+	level  zap.AtomicLevel
+	core   atomic.Pointer[zapcore.Core]
+}
+
+// build replaces the core entries are written to with one for the current
+// configuration
+func (s *logState) build() {
 	// Create encoder config
 	encoderConfig := zapcore.EncoderConfig{
 		TimeKey:        "timestamp",
@@ -82,7 +142,7 @@ func NewLogger(opts ...Option) Logger {
 
 	// Create encoder based on output type
 	var encoder zapcore.Encoder
-	if config.outputType == "json" {
+	if s.config.outputType == "json" {
 		encoder = zapcore.NewJSONEncoder(encoderConfig)
 	} else {
 		encoder = zapcore.NewConsoleEncoder(encoderConfig)
@@ -91,21 +151,57 @@ func NewLogger(opts ...Option) Logger {
 	// Create core
 	core := zapcore.NewCore(
 		encoder,
-		zapcore.AddSync(config.output),
-		config.level,
+		zapcore.AddSync(s.config.output),
+		s.level,
 	)
+	s.core.Store(&core)
+}
 
-	// Create zap logger
-	logger := zap.New(
-		core,
-		zap.AddCaller(),
-		zap.AddCallerSkip(1),
-		zap.AddStacktrace(zapcore.ErrorLevel),
-	)
+// reloadableCore writes entries to the current core of its state. Fields
+// added with With are kept here rather than encoded into a core, so they
+// survive a reconfiguration.
+type reloadableCore struct {
+	state  *logState
+	fields []zapcore.Field
+}
 
-	return &zapLogger{ // Fixed: Use type name directly
-		logger: logger.Sugar(),
+// Enabled implements zapcore.LevelEnabler
+func (c *reloadableCore) Enabled(level zapcore.Level) bool {
+	return c.state.level.Enabled(level)
+}
+
+// Level reports the minimum enabled level
+func (c *reloadableCore) Level() zapcore.Level {
+	return c.state.level.Level()
+}
+
+// With implements zapcore.Core
+func (c *reloadableCore) With(fields []zapcore.Field) zapcore.Core {
+	combined := make([]zapcore.Field, 0, len(c.fields)+len(fields))
+	combined = append(combined, c.fields...)
+	return &reloadableCore{state: c.state, fields: append(combined, fields...)}
+}
+
+// Check implements zapcore.Core
+func (c *reloadableCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
 	}
+	return checked
+}
+
+// Write implements zapcore.Core
+func (c *reloadableCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	core := *c.state.core.Load()
+	if len(c.fields) > 0 {
+		fields = append(c.fields[:len(c.fields):len(c.fields)], fields...)
+	}
+	return core.Write(entry, fields)
+}
+
+// Sync implements zapcore.Core
+func (c *reloadableCore) Sync() error {
+	return (*c.state.core.Load()).Sync()
 }
 
 func (l *zapLogger) WithFields(fields map[string]interface{}) Logger {
@@ -117,6 +213,7 @@ func (l *zapLogger) WithFields(fields map[string]interface{}) Logger {
 	newLogger := l.logger.Desugar().With(zapFields...)
 	return &zapLogger{
 		logger: newLogger.Sugar(),
+		state:  l.state,
 	}
 }
 
@@ -209,6 +306,7 @@ func (l *zapLogger) With(fields map[string]interface{}) Logger {
 	}
 	return &zapLogger{
 		logger: l.logger.With(args...),
+		state:  l.state,
 	}
 }
 
@@ -222,32 +320,61 @@ func (l *zapLogger) Sync() error {
 
 // loggerConfig holds the configuration for the logger
 type loggerConfig struct {
-	level      zapcore.Level
-	outputType string
-	output     io.Writer
+	level           zapcore.Level
+	outputType      string
+	output          io.Writer
+	caller          bool
+	stacktraceLevel zapcore.Level
 }
 
 // Option is a function that configures a loggerConfig
 type Option func(*loggerConfig)
 
+// parseLevel converts a level name to a zap level
+func parseLevel(level string) (zapcore.Level, bool) {
+	switch level {
+	case "debug":
+		return zapcore.DebugLevel, true
+	case "info":
+		return zapcore.InfoLevel, true
+	case "warn":
+		return zapcore.WarnLevel, true
+	case "error":
+		return zapcore.ErrorLevel, true
+	case "fatal":
+		return zapcore.FatalLevel, true
+	default:
+		return zapcore.InfoLevel, false
+	}
+}
+
 // WithLevel sets the log level
 func WithLevel(level string) Option {
 	return func(c *loggerConfig) {
-		switch level {
-		case "debug":
-			c.level = zapcore.DebugLevel
-		case "info":
-			c.level = zapcore.InfoLevel
-		case "warn":
-			c.level = zapcore.WarnLevel
-		case "error":
-			c.level = zapcore.ErrorLevel
-		case "fatal":
-			c.level = zapcore.FatalLevel
-		default:
+		parsed, ok := parseLevel(level)
+		if !ok {
 			fmt.Fprintf(os.Stderr, "Invalid log level '%s', defaulting to info\n", level)
-			c.level = zapcore.InfoLevel
 		}
+		c.level = parsed
+	}
+}
+
+// WithCaller sets whether entries include the calling file and line
+func WithCaller(enabled bool) Option {
+	return func(c *loggerConfig) {
+		c.caller = enabled
+	}
+}
+
+// WithStacktraceLevel sets the lowest level whose entries include a stacktrace
+func WithStacktraceLevel(level string) Option {
+	return func(c *loggerConfig) {
+		parsed, ok := parseLevel(level)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Invalid stacktrace level '%s', defaulting to error\n", level)
+			parsed = zapcore.ErrorLevel
+		}
+		c.stacktraceLevel = parsed
 	}
 }
 
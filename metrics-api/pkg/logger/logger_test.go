@@ -0,0 +1,84 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// lastEntry decodes the last JSON line written to buf
+func lastEntry(t *testing.T, buf *bytes.Buffer) map[string]any {
+	t.Helper()
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	var entry map[string]any
+	if err := json.Unmarshal(lines[len(lines)-1], &entry); err != nil {
+		t.Fatalf("decoding log entry: %v", err)
+	}
+	return entry
+}
+
+func TestWithCaller(t *testing.T) {
+	var buf bytes.Buffer
+	NewLogger(WithOutput(&buf)).Info("with caller")
+	if _, ok := lastEntry(t, &buf)["caller"]; !ok {
+		t.Errorf("expected caller field by default")
+	}
+
+	buf.Reset()
+	NewLogger(WithOutput(&buf), WithCaller(false)).Info("without caller")
+	if caller, ok := lastEntry(t, &buf)["caller"]; ok {
+		t.Errorf("expected no caller field, got %v", caller)
+	}
+}
+
+func TestWithStacktraceLevel(t *testing.T) {
+	var buf bytes.Buffer
+	log := NewLogger(WithOutput(&buf))
+	log.Warn("default warn")
+	if _, ok := lastEntry(t, &buf)["stacktrace"]; ok {
+		t.Errorf("expected no stacktrace on warn at the default level")
+	}
+	log.Error("default error")
+	if _, ok := lastEntry(t, &buf)["stacktrace"]; !ok {
+		t.Errorf("expected stacktrace on error at the default level")
+	}
+
+	buf.Reset()
+	log = NewLogger(WithOutput(&buf), WithStacktraceLevel("warn"))
+	log.Info("lowered info")
+	if _, ok := lastEntry(t, &buf)["stacktrace"]; ok {
+		t.Errorf("expected no stacktrace on info below the configured level")
+	}
+	log.Warn("lowered warn")
+	if _, ok := lastEntry(t, &buf)["stacktrace"]; !ok {
+		t.Errorf("expected stacktrace on warn at the configured level")
+	}
+}
+
+func TestReconfigure(t *testing.T) {
+	var buf bytes.Buffer
+	log := NewLogger(WithOutput(&buf), WithLevel("info"))
+	derived := log.With(map[string]interface{}{"component": "cache"})
+
+	derived.Debug("hidden")
+	if buf.Len() != 0 {
+		t.Fatalf("expected debug to be disabled, got %q", buf.String())
+	}
+
+	if err := Reconfigure(log, WithLevel("debug"), WithOutputType("console")); err != nil {
+		t.Fatalf("reconfigure: %v", err)
+	}
+	derived.Debug("shown")
+	line := buf.String()
+	if !strings.Contains(line, "shown") || !strings.Contains(line, `"component": "cache"`) {
+		t.Errorf("expected derived logger to log debug with its fields, got %q", line)
+	}
+	if strings.HasPrefix(line, "{") {
+		t.Errorf("expected console output after reconfiguring, got %q", line)
+	}
+
+	if err := Reconfigure(NewNopLogger(), WithLevel("debug")); err == nil {
+		t.Errorf("expected an error reconfiguring a nop logger")
+	}
+}
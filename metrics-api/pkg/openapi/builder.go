@@ -0,0 +1,288 @@
+// Package openapi builds an OpenAPI 3.0 description of the API from the
+// routes handlers declare, deriving request and response schemas from the
+// Go types they encode.
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Version is the OpenAPI version of the documents built
+const Version = "3.0.3"
+
+// Route describes one operation of the API
+type Route struct {
+	Method string
+	// Path is the mux path template, relative to the server URL; its
+	// {variables} become required path parameters
+	Path    string
+	Summary string
+	Tag     string
+	Query   []Param
+	// Request is a value of the JSON request body's type, nil when the
+	// operation takes no body
+	Request interface{}
+	// Response is a value of the success response body's type, nil when the
+	// response has no body or is not JSON
+	Response interface{}
+	// Status is the success status, 200 unless set
+	Status int
+	// ContentType is the media type of a response that is not JSON, such
+	// as text/event-stream
+	ContentType string
+}
+
+// Param is a query parameter of an operation
+type Param struct {
+	Name        string
+	Description string
+	// Type is the JSON schema type of the value, string unless set
+	Type     string
+	Required bool
+}
+
+// Builder collects the routes handlers declare and renders them as an
+// OpenAPI document. It is safe for concurrent use.
+type Builder struct {
+	mu          sync.Mutex
+	title       string
+	version     string
+	serverURL   string
+	errorSchema *Schema
+	paths       map[string]PathItem
+	schemas     *schemaRegistry
+}
+
+// NewBuilder creates a builder for the API with the given title and version
+func NewBuilder(title, version string) *Builder {
+	return &Builder{
+		title:   title,
+		version: version,
+		paths:   make(map[string]PathItem),
+		schemas: newSchemaRegistry(),
+	}
+}
+
+// WithServer sets the URL the route paths are relative to
+func (b *Builder) WithServer(url string) *Builder {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.serverURL = url
+	return b
+}
+
+// WithErrorResponse documents v's type as the body of every operation's
+// error responses
+func (b *Builder) WithErrorResponse(v interface{}) *Builder {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.errorSchema = b.schemas.schemaOf(v)
+	return b
+}
+
+// pathVariable matches a mux path variable with its optional pattern
+var pathVariable = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// Add documents routes, replacing earlier ones with the same method and path
+func (b *Builder) Add(routes ...Route) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, route := range routes {
+		op := &Operation{
+			Summary:   route.Summary,
+			Responses: make(map[string]*Response),
+		}
+		if route.Tag != "" {
+			op.Tags = []string{route.Tag}
+		}
+
+		for _, match := range pathVariable.FindAllStringSubmatch(route.Path, -1) {
+			op.Parameters = append(op.Parameters, Parameter{
+				Name:     match[1],
+				In:       "path",
+				Required: true,
+				Schema:   &Schema{Type: "string"},
+			})
+		}
+		for _, param := range route.Query {
+			paramType := param.Type
+			if paramType == "" {
+				paramType = "string"
+			}
+			op.Parameters = append(op.Parameters, Parameter{
+				Name:        param.Name,
+				In:          "query",
+				Description: param.Description,
+				Required:    param.Required,
+				Schema:      &Schema{Type: paramType},
+			})
+		}
+
+		if route.Request != nil {
+			op.RequestBody = &RequestBody{
+				Required: true,
+				Content: map[string]MediaType{
+					"application/json": {Schema: b.schemas.schemaOf(route.Request)},
+				},
+			}
+		}
+
+		status := route.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		response := &Response{Description: http.StatusText(status)}
+		switch {
+		case route.Response != nil:
+			response.Content = map[string]MediaType{
+				"application/json": {Schema: b.schemas.schemaOf(route.Response)},
+			}
+		case route.ContentType != "":
+			response.Content = map[string]MediaType{
+				route.ContentType: {Schema: &Schema{Type: "string"}},
+			}
+		}
+		op.Responses[strconv.Itoa(status)] = response
+		if b.errorSchema != nil {
+			op.Responses["default"] = &Response{
+				Description: "Error",
+				Content: map[string]MediaType{
+					"application/json": {Schema: b.errorSchema},
+				},
+			}
+		}
+
+		path := pathVariable.ReplaceAllString(route.Path, "{$1}")
+		if b.paths[path] == nil {
+			b.paths[path] = make(PathItem)
+		}
+		b.paths[path][strings.ToLower(route.Method)] = op
+	}
+}
+
+// Operations lists the documented operations as "METHOD path", sorted
+func (b *Builder) Operations() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var ops []string
+	for path, item := range b.paths {
+		for method := range item {
+			ops = append(ops, strings.ToUpper(method)+" "+path)
+		}
+	}
+	sort.Strings(ops)
+	return ops
+}
+
+// Document returns the OpenAPI document of the routes added so far
+func (b *Builder) Document() Document {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Copy the maps so later routes do not change the document under a caller
+	doc := Document{
+		OpenAPI: Version,
+		Info:    Info{Title: b.title, Version: b.version},
+		Paths:   make(map[string]PathItem, len(b.paths)),
+	}
+	for path, item := range b.paths {
+		copied := make(PathItem, len(item))
+		for method, op := range item {
+			copied[method] = op
+		}
+		doc.Paths[path] = copied
+	}
+	if len(b.schemas.components) > 0 {
+		doc.Components.Schemas = make(map[string]*Schema, len(b.schemas.components))
+		for name, schema := range b.schemas.components {
+			doc.Components.Schemas[name] = schema
+		}
+	}
+	if b.serverURL != "" {
+		doc.Servers = []Server{{URL: b.serverURL}}
+	}
+	return doc
+}
+
+// JSON renders the document as JSON
+func (b *Builder) JSON() ([]byte, error) {
+	return json.MarshalIndent(b.Document(), "", "  ")
+}
+
+// YAML renders the document as YAML
+func (b *Builder) YAML() ([]byte, error) {
+	return yaml.Marshal(b.Document())
+}
+
+// Document is an OpenAPI document
+type Document struct {
+	OpenAPI    string              `json:"openapi" yaml:"openapi"`
+	Info       Info                `json:"info" yaml:"info"`
+	Servers    []Server            `json:"servers,omitempty" yaml:"servers,omitempty"`
+	Paths      map[string]PathItem `json:"paths" yaml:"paths"`
+	Components Components          `json:"components" yaml:"components,omitempty"`
+}
+
+// Info names the API and its version
+type Info struct {
+	Title   string `json:"title" yaml:"title"`
+	Version string `json:"version" yaml:"version"`
+}
+
+// Server is a URL the API is served under
+type Server struct {
+	URL string `json:"url" yaml:"url"`
+}
+
+// PathItem holds the operations of a path by lower-case method
+type PathItem map[string]*Operation
+
+// Operation is a method of a path
+type Operation struct {
+	Summary     string               `json:"summary,omitempty" yaml:"summary,omitempty"`
+	Tags        []string             `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Parameters  []Parameter          `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty" yaml:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses" yaml:"responses"`
+}
+
+// Parameter is a path or query parameter of an operation
+type Parameter struct {
+	Name        string  `json:"name" yaml:"name"`
+	In          string  `json:"in" yaml:"in"`
+	Description string  `json:"description,omitempty" yaml:"description,omitempty"`
+	Required    bool    `json:"required,omitempty" yaml:"required,omitempty"`
+	Schema      *Schema `json:"schema" yaml:"schema"`
+}
+
+// RequestBody is the body an operation takes
+type RequestBody struct {
+	Required bool                 `json:"required,omitempty" yaml:"required,omitempty"`
+	Content  map[string]MediaType `json:"content" yaml:"content"`
+}
+
+// Response is a response of an operation
+type Response struct {
+	Description string               `json:"description" yaml:"description"`
+	Content     map[string]MediaType `json:"content,omitempty" yaml:"content,omitempty"`
+}
+
+// MediaType is the schema of a body in one media type
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty" yaml:"schema,omitempty"`
+}
+
+// Components holds the named schemas operations refer to
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas,omitempty" yaml:"schemas,omitempty"`
+}
@@ -0,0 +1,171 @@
+package openapi
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testLabel struct {
+	Name  string `json:"name" example:"job"`
+	Value string `json:"value"`
+}
+
+type testSeries struct {
+	Metric    string      `json:"metric" example:"up"`
+	Labels    []testLabel `json:"labels"`
+	Value     float64     `json:"value" example:"0.5"`
+	Count     int         `json:"count,omitempty" example:"3"`
+	Timestamp time.Time   `json:"timestamp"`
+	Previous  *testSeries `json:"previous,omitempty"`
+	Internal  string      `json:"-"`
+	hidden    string
+}
+
+type testError struct {
+	Error string `json:"error"`
+}
+
+func newTestBuilder() *Builder {
+	b := NewBuilder("Test API", "1.2.3").
+		WithServer("/api/v1").
+		WithErrorResponse(testError{})
+	b.Add(
+		Route{
+			Method: "GET", Path: "/series/{name}", Tag: "series",
+			Summary:  "Get a series",
+			Query:    []Param{{Name: "limit", Type: "integer", Description: "Most samples"}},
+			Response: testSeries{},
+		},
+		Route{
+			Method: "POST", Path: "/series", Tag: "series",
+			Summary: "Create a series",
+			Request: testSeries{},
+			Response: struct {
+				Series []testSeries `json:"series"`
+				Count  int          `json:"count"`
+			}{},
+			Status: 201,
+		},
+		Route{
+			Method: "DELETE", Path: "/series/{name:[a-z]+}",
+			Status: 204,
+		},
+		Route{
+			Method: "GET", Path: "/series/stream",
+			ContentType: "text/event-stream",
+		},
+	)
+	return b
+}
+
+func TestBuilderOperations(t *testing.T) {
+	b := newTestBuilder()
+
+	assert.Equal(t, []string{
+		"DELETE /series/{name}",
+		"GET /series/stream",
+		"GET /series/{name}",
+		"POST /series",
+	}, b.Operations())
+}
+
+func TestBuilderDocument(t *testing.T) {
+	doc := newTestBuilder().Document()
+
+	assert.Equal(t, Version, doc.OpenAPI)
+	assert.Equal(t, Info{Title: "Test API", Version: "1.2.3"}, doc.Info)
+	assert.Equal(t, []Server{{URL: "/api/v1"}}, doc.Servers)
+
+	get := doc.Paths["/series/{name}"]["get"]
+	require.NotNil(t, get)
+	assert.Equal(t, []string{"series"}, get.Tags)
+	assert.Equal(t, []Parameter{
+		{Name: "name", In: "path", Required: true, Schema: &Schema{Type: "string"}},
+		{Name: "limit", In: "query", Description: "Most samples", Schema: &Schema{Type: "integer"}},
+	}, get.Parameters)
+	assert.Equal(t, "#/components/schemas/testSeries", get.Responses["200"].Content["application/json"].Schema.Ref)
+	assert.Equal(t, "#/components/schemas/testError", get.Responses["default"].Content["application/json"].Schema.Ref)
+
+	// The regular expression of a path variable is not part of the path
+	del := doc.Paths["/series/{name}"]["delete"]
+	require.NotNil(t, del)
+	assert.Empty(t, del.Responses["204"].Content)
+
+	post := doc.Paths["/series"]["post"]
+	require.NotNil(t, post)
+	assert.Equal(t, "#/components/schemas/testSeries", post.RequestBody.Content["application/json"].Schema.Ref)
+	created := post.Responses["201"].Content["application/json"].Schema
+	assert.Equal(t, "object", created.Type)
+	assert.Equal(t, "array", created.Properties["series"].Type)
+	assert.Equal(t, "#/components/schemas/testSeries", created.Properties["series"].Items.Ref)
+
+	stream := doc.Paths["/series/stream"]["get"]
+	require.NotNil(t, stream)
+	assert.Contains(t, stream.Responses["200"].Content, "text/event-stream")
+}
+
+func TestSchemaFields(t *testing.T) {
+	doc := newTestBuilder().Document()
+
+	series := doc.Components.Schemas["testSeries"]
+	require.NotNil(t, series)
+	assert.ElementsMatch(t, []string{"metric", "labels", "value", "count", "timestamp", "previous"}, keys(series.Properties))
+	assert.Equal(t, &Schema{Type: "string", Example: "up"}, series.Properties["metric"])
+	assert.Equal(t, &Schema{Type: "number", Format: "double", Example: 0.5}, series.Properties["value"])
+	assert.Equal(t, &Schema{Type: "integer", Format: "int64", Example: int64(3)}, series.Properties["count"])
+	assert.Equal(t, &Schema{Type: "string", Format: "date-time"}, series.Properties["timestamp"])
+	assert.Equal(t, "#/components/schemas/testSeries", series.Properties["previous"].Ref)
+
+	label := doc.Components.Schemas["testLabel"]
+	require.NotNil(t, label)
+	assert.Equal(t, "job", label.Properties["name"].Example)
+}
+
+func TestSchemaEmbeddedFields(t *testing.T) {
+	type inner struct {
+		A string `json:"a"`
+		B string `json:"b"`
+	}
+	type outer struct {
+		inner
+		B int `json:"b"`
+	}
+
+	r := newSchemaRegistry()
+	schema := r.schemaOf(struct{ outer }{})
+
+	assert.ElementsMatch(t, []string{"a", "b"}, keys(schema.Properties))
+	assert.Equal(t, "integer", schema.Properties["b"].Type, "the outer field should shadow the embedded one")
+}
+
+func TestSpecValidates(t *testing.T) {
+	b := newTestBuilder()
+
+	for name, render := range map[string]func() ([]byte, error){
+		"json": b.JSON,
+		"yaml": b.YAML,
+	} {
+		t.Run(name, func(t *testing.T) {
+			body, err := render()
+			require.NoError(t, err)
+
+			doc, err := openapi3.NewLoader().LoadFromData(body)
+			require.NoError(t, err)
+			assert.NoError(t, doc.Validate(context.Background()))
+			assert.NotNil(t, doc.Paths.Find("/series/{name}"))
+		})
+	}
+}
+
+func keys(m map[string]*Schema) []string {
+	var names []string
+	for name := range m {
+		names = append(names, name)
+	}
+	return names
+}